@@ -16,7 +16,11 @@ package config
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/grafeas/grafeas/proto/v1beta1/common_go_proto"
 	"github.com/hashicorp/go-multierror"
 )
 
@@ -24,6 +28,125 @@ type ElasticsearchConfig struct {
 	Refresh                 RefreshOption
 	URL, Username, Password string
 	InsecureSkipVerify      bool
+	Filter                  FilterConfig
+	Dedupe                  DedupeConfig
+	Retention               RetentionConfig
+	Rollover                RolloverConfig
+	Layout                  IndexLayout
+	Enrichment              EnrichmentConfig
+	History                 HistoryConfig
+	Audit                   AuditConfig
+	Events                  EventsConfig
+	Watch                   WatchConfig
+	// UserIDMetadata is the gRPC metadata key that identifies the user making a request, such as one set by an authenticating proxy.
+	// The Grafeas API only passes the user to creates, so this fills in the user for updates and deletes.
+	UserIDMetadata string
+}
+
+// FilterConfig controls how filter expressions on List methods are handled
+type FilterConfig struct {
+	// TypeCheck enables checking filters against the Grafeas schema of the documents being listed,
+	// so that filters referencing unknown fields are rejected instead of returning no results.
+	TypeCheck bool
+	// MaxDepth, MaxClauses and MaxExpensiveClauses limit the complexity of filters, falling back to a default when unset.
+	// See filtering.Limits for what each one measures.
+	MaxDepth, MaxClauses, MaxExpensiveClauses int
+}
+
+// DedupeConfig identifies repeated occurrences by a natural key, so that reporting the same occurrence twice
+// doesn't create a duplicate
+type DedupeConfig struct {
+	// Fields are the JSON paths of the occurrence fields that make up the key, e.g. resource.uri and noteName.
+	// Occurrences are always created with a new name when no fields are set.
+	Fields []string
+	// Mode determines what happens when an occurrence with the same key already exists
+	Mode DedupeMode
+}
+
+// RetentionConfig periodically deletes occurrences that are no longer needed
+type RetentionConfig struct {
+	// Interval is how often retention runs, as a duration such as "24h". Retention is disabled when unset.
+	Interval string
+	// DryRun reports the occurrences that would be deleted without deleting them
+	DryRun bool
+	// Default is the policy for projects that aren't listed in Projects
+	Default RetentionPolicy
+	// Projects overrides the default policy, keyed by project ID
+	Projects map[string]RetentionPolicy
+}
+
+// RetentionPolicy describes which occurrences in a project to delete. Policies without a limit delete nothing.
+type RetentionPolicy struct {
+	// MaxAgeDays deletes occurrences created more than this many days ago
+	MaxAgeDays int
+	// KeepLatest deletes all but this many of the most recently created occurrences for each resource and note
+	KeepLatest int
+	// ExemptKinds are the kinds of occurrences that are never deleted, e.g. ATTESTATION
+	ExemptKinds []string
+}
+
+// RolloverConfig writes each project's occurrences to a series of indices managed by an index lifecycle policy,
+// instead of a single index. A new index is started when the current one reaches any of the limits.
+type RolloverConfig struct {
+	Enabled bool
+	// MaxAge is the longest that an index is written to, in Elasticsearch time units such as "30d"
+	MaxAge string
+	// MaxSize is the largest that an index can grow, in Elasticsearch byte units such as "50gb"
+	MaxSize string
+	// MaxDocs is the most occurrences that an index can hold
+	MaxDocs int
+}
+
+// EnrichmentConfig copies fields of each occurrence's note into the occurrence when it's written,
+// so that occurrences can be filtered by them without a second query
+type EnrichmentConfig struct {
+	// NoteFields are the JSON paths of the note fields to copy, e.g. kind and vulnerability.severity.
+	// Enrichment is disabled when no fields are set.
+	NoteFields []string
+}
+
+// HistoryConfig keeps the previous state of occurrences and notes each time they're updated or deleted,
+// so that they can be read as they were at an earlier time
+type HistoryConfig struct {
+	Enabled bool
+}
+
+// AuditConfig records every create, update and delete on projects, notes and occurrences in an audit index
+type AuditConfig struct {
+	Enabled bool
+}
+
+// EventsConfig publishes an event after every successful create, update and delete of a project, note or occurrence
+type EventsConfig struct {
+	Webhook WebhookConfig
+	Outbox  OutboxConfig
+}
+
+// WebhookConfig posts each event to a URL. Events are signed with an HMAC-SHA256 of the body when a secret is set.
+type WebhookConfig struct {
+	URL    string
+	Secret string
+	// MaxRetries is the number of times that a failed delivery is retried, with an exponential backoff
+	MaxRetries int
+	// Timeout limits each delivery attempt, e.g. 5s. It defaults to 10s.
+	Timeout string
+}
+
+// OutboxConfig writes each event to an outbox index in the same request as the change, and delivers it from there in the background,
+// so that events aren't lost if the process stops before they're published. Each event is delivered at least once.
+type OutboxConfig struct {
+	Enabled bool
+	// Interval is how often the outbox is checked for events to deliver, e.g. 5s. It defaults to 10s.
+	// Failed deliveries are retried with an exponential backoff starting from the interval.
+	Interval string
+}
+
+// WatchConfig serves a gRPC service alongside Grafeas that streams occurrences to clients as they're created or updated
+type WatchConfig struct {
+	// Address is where the service listens, e.g. :8081. The service isn't started when it's empty.
+	Address string
+	// Interval is how often each stream checks for new occurrences, e.g. 1s. It defaults to 1s.
+	Interval string
 }
 
 func (c ElasticsearchConfig) IsValid() (e error) {
@@ -34,9 +157,174 @@ func (c ElasticsearchConfig) IsValid() (e error) {
 		e = multierror.Append(e, fmt.Errorf("invalid refresh value: %s", c.Refresh))
 	}
 
+	if len(c.Dedupe.Fields) > 0 {
+		switch c.Dedupe.Mode {
+		case DedupeModeUpsert, DedupeModeReject:
+			break
+		default:
+			e = multierror.Append(e, fmt.Errorf("invalid dedupe mode: %s", c.Dedupe.Mode))
+		}
+	}
+
+	if err := c.Retention.isValid(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	if c.Rollover.Enabled {
+		if c.Rollover.MaxAge == "" && c.Rollover.MaxSize == "" && c.Rollover.MaxDocs <= 0 {
+			e = multierror.Append(e, fmt.Errorf("rollover requires a maximum age, size, or number of documents"))
+		}
+
+		// document IDs are only unique within an index, so a duplicate could be written to a newer index
+		if len(c.Dedupe.Fields) > 0 {
+			e = multierror.Append(e, fmt.Errorf("occurrence deduplication can't be used with rollover"))
+		}
+	}
+
+	switch c.Layout {
+	case "", IndexLayoutProject:
+		break
+	case IndexLayoutShared, IndexLayoutJoined:
+		// rollover manages a series of occurrence indices for each project, which the shared layouts don't have
+		if c.Rollover.Enabled {
+			e = multierror.Append(e, fmt.Errorf("rollover can't be used with the %s index layout", c.Layout))
+		}
+
+		// occurrences are routed by their note, so occurrences with the same key could be stored on different shards
+		if c.Layout == IndexLayoutJoined && len(c.Dedupe.Fields) > 0 {
+			e = multierror.Append(e, fmt.Errorf("occurrence deduplication can't be used with the joined index layout"))
+		}
+	default:
+		e = multierror.Append(e, fmt.Errorf("invalid index layout: %s", c.Layout))
+	}
+
+	for _, field := range c.Enrichment.NoteFields {
+		if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			e = multierror.Append(e, fmt.Errorf("invalid enrichment note field: %q", field))
+		}
+	}
+
+	if c.Filter.MaxDepth < 0 || c.Filter.MaxClauses < 0 || c.Filter.MaxExpensiveClauses < 0 {
+		e = multierror.Append(e, fmt.Errorf("filter limits must not be negative"))
+	}
+
+	if err := c.Events.Webhook.isValid(); err != nil {
+		e = multierror.Append(e, err)
+	}
+
+	if c.Events.Outbox.Enabled {
+		if c.Events.Webhook.URL == "" {
+			e = multierror.Append(e, fmt.Errorf("the event outbox requires a webhook"))
+		}
+
+		interval, err := c.Events.Outbox.IntervalDuration()
+		if err != nil || interval <= 0 {
+			e = multierror.Append(e, fmt.Errorf("invalid outbox interval: %s", c.Events.Outbox.Interval))
+		}
+	}
+
+	if c.Watch.Address != "" {
+		interval, err := c.Watch.IntervalDuration()
+		if err != nil || interval <= 0 {
+			e = multierror.Append(e, fmt.Errorf("invalid watch interval: %s", c.Watch.Interval))
+		}
+	}
+
+	return
+}
+
+// IntervalDuration parses the retention interval, returning zero if retention is disabled
+func (c RetentionConfig) IntervalDuration() (time.Duration, error) {
+	if c.Interval == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(c.Interval)
+}
+
+// Policy returns the retention policy for the project
+func (c RetentionConfig) Policy(projectId string) RetentionPolicy {
+	if policy, ok := c.Projects[projectId]; ok {
+		return policy
+	}
+
+	return c.Default
+}
+
+func (c RetentionConfig) isValid() (e error) {
+	interval, err := c.IntervalDuration()
+	if err != nil || interval < 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid retention interval: %s", c.Interval))
+	}
+
+	policies := map[string]RetentionPolicy{"default": c.Default}
+	for projectId, policy := range c.Projects {
+		policies[projectId] = policy
+	}
+
+	for name, policy := range policies {
+		if policy.MaxAgeDays < 0 || policy.KeepLatest < 0 {
+			e = multierror.Append(e, fmt.Errorf("retention limits for %s must not be negative", name))
+		}
+
+		for _, kind := range policy.ExemptKinds {
+			if _, ok := common_go_proto.NoteKind_value[kind]; !ok {
+				e = multierror.Append(e, fmt.Errorf("invalid exempt kind for %s: %s", name, kind))
+			}
+		}
+	}
+
+	return
+}
+
+// TimeoutDuration parses the timeout of each delivery attempt
+func (c WebhookConfig) TimeoutDuration() (time.Duration, error) {
+	if c.Timeout == "" {
+		return 10 * time.Second, nil
+	}
+
+	return time.ParseDuration(c.Timeout)
+}
+
+func (c WebhookConfig) isValid() (e error) {
+	if c.URL == "" {
+		return nil
+	}
+
+	if u, err := url.ParseRequestURI(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		e = multierror.Append(e, fmt.Errorf("invalid webhook url: %s", c.URL))
+	}
+
+	if c.MaxRetries < 0 {
+		e = multierror.Append(e, fmt.Errorf("webhook retries must not be negative"))
+	}
+
+	timeout, err := c.TimeoutDuration()
+	if err != nil || timeout <= 0 {
+		e = multierror.Append(e, fmt.Errorf("invalid webhook timeout: %s", c.Timeout))
+	}
+
 	return
 }
 
+// IntervalDuration parses how often the outbox is checked for events to deliver
+func (c OutboxConfig) IntervalDuration() (time.Duration, error) {
+	if c.Interval == "" {
+		return 10 * time.Second, nil
+	}
+
+	return time.ParseDuration(c.Interval)
+}
+
+// IntervalDuration parses how often each stream checks for new occurrences
+func (c WatchConfig) IntervalDuration() (time.Duration, error) {
+	if c.Interval == "" {
+		return time.Second, nil
+	}
+
+	return time.ParseDuration(c.Interval)
+}
+
 // RefreshOption is based on https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-refresh.html
 type RefreshOption string
 
@@ -49,3 +337,27 @@ const (
 	RefreshWaitFor = "wait_for"
 	RefreshFalse   = "false"
 )
+
+// DedupeMode is what happens when an occurrence is created with the same key as an existing occurrence
+type DedupeMode string
+
+const (
+	// DedupeModeUpsert replaces the existing occurrence, keeping its create time and setting the update time
+	DedupeModeUpsert = "upsert"
+	// DedupeModeReject returns an AlreadyExists error
+	DedupeModeReject = "reject"
+)
+
+// IndexLayout is how projects' notes and occurrences are divided between indices
+type IndexLayout string
+
+const (
+	// IndexLayoutProject creates a notes index and an occurrences index for each project. This is the default.
+	IndexLayoutProject = "project"
+	// IndexLayoutShared stores every project's notes in one index, and every project's occurrences in another.
+	// Documents are routed by project, and each project is read through a filtered alias.
+	IndexLayoutShared = "shared"
+	// IndexLayoutJoined stores every project's notes and occurrences in a single index, with each occurrence as a child of its note,
+	// so that occurrences can be filtered by the fields of their note. Each project is read through filtered aliases.
+	IndexLayoutJoined = "joined"
+)
@@ -46,8 +46,274 @@ var _ = Describe("ElasticsearchConfig", func() {
 			URL:     fake.URL(),
 			Refresh: "somethingInvalid",
 		}, true),
+		Entry("filter limits", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Filter: FilterConfig{
+				MaxDepth:            fake.Number(1, 10),
+				MaxClauses:          fake.Number(1, 10),
+				MaxExpensiveClauses: fake.Number(1, 10),
+			},
+		}, false),
+		Entry("dedupe in upsert mode", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Dedupe: DedupeConfig{
+				Fields: []string{"resource.uri", "noteName"},
+				Mode:   DedupeModeUpsert,
+			},
+		}, false),
+		Entry("dedupe in reject mode", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Dedupe: DedupeConfig{
+				Fields: []string{"resource.uri"},
+				Mode:   DedupeModeReject,
+			},
+		}, false),
+		Entry("dedupe fields without a mode", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Dedupe: DedupeConfig{
+				Fields: []string{"resource.uri"},
+			},
+		}, true),
+		Entry("negative filter limit", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Filter: FilterConfig{
+				MaxClauses: -1,
+			},
+		}, true),
+		Entry("retention policies", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Retention: RetentionConfig{
+				Interval: "24h",
+				Default: RetentionPolicy{
+					MaxAgeDays:  90,
+					ExemptKinds: []string{"ATTESTATION"},
+				},
+				Projects: map[string]RetentionPolicy{
+					"rode": {
+						KeepLatest: 5,
+					},
+				},
+			},
+		}, false),
+		Entry("invalid retention interval", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Retention: RetentionConfig{
+				Interval: "90 days",
+			},
+		}, true),
+		Entry("negative retention limit", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Retention: RetentionConfig{
+				Interval: "1h",
+				Projects: map[string]RetentionPolicy{
+					"rode": {
+						MaxAgeDays: -1,
+					},
+				},
+			},
+		}, true),
+		Entry("rollover", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Rollover: RolloverConfig{
+				Enabled: true,
+				MaxAge:  "30d",
+			},
+		}, false),
+		Entry("rollover without limits", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Rollover: RolloverConfig{
+				Enabled: true,
+			},
+		}, true),
+		Entry("rollover with dedupe", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Rollover: RolloverConfig{
+				Enabled: true,
+				MaxDocs: 1000000,
+			},
+			Dedupe: DedupeConfig{
+				Fields: []string{"resource.uri"},
+				Mode:   DedupeModeUpsert,
+			},
+		}, true),
+		Entry("unknown exempt kind", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Retention: RetentionConfig{
+				Interval: "1h",
+				Default: RetentionPolicy{
+					ExemptKinds: []string{"SIGNATURE"},
+				},
+			},
+		}, true),
+		Entry("project layout", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Layout:  IndexLayoutProject,
+		}, false),
+		Entry("shared layout", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Layout:  IndexLayoutShared,
+		}, false),
+		Entry("unknown layout", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Layout:  "tenant",
+		}, true),
+		Entry("joined layout", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Layout:  IndexLayoutJoined,
+		}, false),
+		Entry("joined layout with dedupe", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Layout:  IndexLayoutJoined,
+			Dedupe: DedupeConfig{
+				Fields: []string{"resource.uri"},
+				Mode:   DedupeModeReject,
+			},
+		}, true),
+		Entry("shared layout with rollover", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Layout:  IndexLayoutShared,
+			Rollover: RolloverConfig{
+				Enabled: true,
+				MaxAge:  "30d",
+			},
+		}, true),
+		Entry("enrichment", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Enrichment: EnrichmentConfig{
+				NoteFields: []string{"kind", "vulnerability.severity"},
+			},
+		}, false),
+		Entry("enrichment with an invalid field", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Enrichment: EnrichmentConfig{
+				NoteFields: []string{"vulnerability..severity"},
+			},
+		}, true),
+		Entry("webhook", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Events: EventsConfig{
+				Webhook: WebhookConfig{
+					URL:        fake.URL(),
+					Secret:     fake.LetterN(10),
+					MaxRetries: 3,
+					Timeout:    "5s",
+				},
+			},
+		}, false),
+		Entry("webhook with an invalid url", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Events: EventsConfig{
+				Webhook: WebhookConfig{
+					URL: "example.com/events",
+				},
+			},
+		}, true),
+		Entry("webhook with an invalid timeout", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Events: EventsConfig{
+				Webhook: WebhookConfig{
+					URL:     fake.URL(),
+					Timeout: "5 seconds",
+				},
+			},
+		}, true),
+		Entry("outbox", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Events: EventsConfig{
+				Webhook: WebhookConfig{
+					URL: fake.URL(),
+				},
+				Outbox: OutboxConfig{
+					Enabled:  true,
+					Interval: "5s",
+				},
+			},
+		}, false),
+		Entry("outbox without a webhook", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Events: EventsConfig{
+				Outbox: OutboxConfig{
+					Enabled: true,
+				},
+			},
+		}, true),
+		Entry("outbox with an invalid interval", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Events: EventsConfig{
+				Webhook: WebhookConfig{
+					URL: fake.URL(),
+				},
+				Outbox: OutboxConfig{
+					Enabled:  true,
+					Interval: "-5s",
+				},
+			},
+		}, true),
+		Entry("watch", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Watch: WatchConfig{
+				Address:  ":8081",
+				Interval: "500ms",
+			},
+		}, false),
+		Entry("watch with an invalid interval", ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: RefreshTrue,
+			Watch: WatchConfig{
+				Address:  ":8081",
+				Interval: "often",
+			},
+		}, true),
 	)
 
+	Context("RetentionConfig", func() {
+		It("should use the project's policy when there is one", func() {
+			retention := RetentionConfig{
+				Default: RetentionPolicy{MaxAgeDays: 90},
+				Projects: map[string]RetentionPolicy{
+					"rode": {KeepLatest: 1},
+				},
+			}
+
+			Expect(retention.Policy("rode")).To(Equal(RetentionPolicy{KeepLatest: 1}))
+			Expect(retention.Policy(fake.LetterN(10))).To(Equal(RetentionPolicy{MaxAgeDays: 90}))
+		})
+
+		It("should be disabled without an interval", func() {
+			interval, err := RetentionConfig{}.IntervalDuration()
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(interval).To(BeZero())
+		})
+	})
+
 	When("setting the InsecureSkipVerify boolean value", func() {
 		It("should be true when set to true", func() {
 			abc := &ElasticsearchConfig{
@@ -7,6 +7,7 @@ package mocks
 import (
 	gomock "github.com/golang/mock/gomock"
 	filtering "github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	reflect "reflect"
 )
 
@@ -33,17 +34,42 @@ func (m *MockFilterer) EXPECT() *MockFiltererMockRecorder {
 	return m.recorder
 }
 
+// ParseCheckedExpression mocks base method
+func (m *MockFilterer) ParseCheckedExpression(arg0 string, arg1 protoreflect.ProtoMessage, arg2 ...filtering.Parent) (*filtering.Query, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ParseCheckedExpression", varargs...)
+	ret0, _ := ret[0].(*filtering.Query)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ParseCheckedExpression indicates an expected call of ParseCheckedExpression
+func (mr *MockFiltererMockRecorder) ParseCheckedExpression(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseCheckedExpression", reflect.TypeOf((*MockFilterer)(nil).ParseCheckedExpression), varargs...)
+}
+
 // ParseExpression mocks base method
-func (m *MockFilterer) ParseExpression(arg0 string) (*filtering.Query, error) {
+func (m *MockFilterer) ParseExpression(arg0 string, arg1 ...filtering.Parent) (*filtering.Query, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ParseExpression", arg0)
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ParseExpression", varargs...)
 	ret0, _ := ret[0].(*filtering.Query)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ParseExpression indicates an expected call of ParseExpression
-func (mr *MockFiltererMockRecorder) ParseExpression(arg0 interface{}) *gomock.Call {
+func (mr *MockFiltererMockRecorder) ParseExpression(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseExpression", reflect.TypeOf((*MockFilterer)(nil).ParseExpression), arg0)
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseExpression", reflect.TypeOf((*MockFilterer)(nil).ParseExpression), varargs...)
 }
@@ -0,0 +1,201 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	grafeasConfig "github.com/grafeas/grafeas/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	exportCommand = "export"
+	importCommand = "import"
+)
+
+// runExport writes a project to NDJSON, either to a file or to standard output. Logs are written to standard error.
+func runExport(logger *zap.Logger, args []string) (err error) {
+	flags := flag.NewFlagSet(exportCommand, flag.ExitOnError)
+	configFile := flags.String("config", "", "Path to the Grafeas config file")
+	projectId := flags.String("project", "", "ID of the project to export")
+	filter := flags.String("filter", "", "Only export the occurrences that match this filter")
+	outputFile := flags.String("output", "", "Path to write the export to, instead of standard output")
+	_ = flags.Parse(args)
+
+	if *projectId == "" {
+		return errors.New("--project is required")
+	}
+
+	es, err := loadStorage(logger, *configFile)
+	if err != nil {
+		return err
+	}
+
+	var output io.Writer = os.Stdout
+	if *outputFile != "" {
+		file, err := os.Create(*outputFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		output = file
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	writer := bufio.NewWriter(output)
+	summary, err := es.ExportProject(ctx, *projectId, *filter, writer)
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	logger.Info("export finished", zap.Int("notes", summary.Notes), zap.Int("occurrences", summary.Occurrences), zap.Bool("complete", err == nil))
+
+	return err
+}
+
+// runImport loads NDJSON from a file or standard input. With a checkpoint file, the import saves its progress after each chunk,
+// and running the same command again resumes from there.
+func runImport(logger *zap.Logger, args []string) (err error) {
+	flags := flag.NewFlagSet(importCommand, flag.ExitOnError)
+	configFile := flags.String("config", "", "Path to the Grafeas config file")
+	inputFile := flags.String("input", "", "Path to read the import from, instead of standard input")
+	checkpointFile := flags.String("checkpoint", "", "Path to save the import's progress to, and resume it from")
+	chunkSize := flags.Int("chunk-size", 0, "Number of notes or occurrences to write in each bulk request")
+	_ = flags.Parse(args)
+
+	options := &storage.ImportOptions{ChunkSize: *chunkSize}
+	if *checkpointFile != "" {
+		if options.StartLine, err = readCheckpoint(*checkpointFile); err != nil {
+			return err
+		}
+		options.Checkpoint = func(line int) error {
+			return writeCheckpoint(*checkpointFile, line)
+		}
+
+		if options.StartLine > 1 {
+			logger.Info("resuming import", zap.Int("line", options.StartLine))
+		}
+	}
+
+	es, err := loadStorage(logger, *configFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// the indices may not exist yet when importing into a new cluster
+	if err := es.Initialize(ctx); err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if *inputFile != "" {
+		file, err := os.Open(*inputFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	summary, err := es.ImportProjects(ctx, input, options)
+	for _, importErr := range summary.Errors {
+		logger.Warn("line not imported", zap.Int("line", importErr.Line), zap.NamedError("error", importErr.Err))
+	}
+	logger.Info("import finished",
+		zap.Int("projects", summary.Projects),
+		zap.Int("notes", summary.Notes),
+		zap.Int("occurrences", summary.Occurrences),
+		zap.Int("skipped", summary.Skipped),
+		zap.Int("errors", len(summary.Errors)),
+		zap.Bool("complete", err == nil))
+	if err != nil {
+		return err
+	}
+
+	if len(summary.Errors) > 0 {
+		return fmt.Errorf("%d lines weren't imported", len(summary.Errors))
+	}
+
+	return nil
+}
+
+// readCheckpoint returns the line to resume an import from, which is the first line when there's no checkpoint yet
+func readCheckpoint(path string) (int, error) {
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	line, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid checkpoint in %s: %s", path, err)
+	}
+
+	return line, nil
+}
+
+// writeCheckpoint replaces the checkpoint through a rename, so that an interruption can't leave it partially written
+func writeCheckpoint(path string, line int) error {
+	temporaryPath := path + ".tmp"
+	if err := os.WriteFile(temporaryPath, []byte(strconv.Itoa(line)+"\n"), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryPath, path)
+}
+
+// loadStorage connects to the Elasticsearch cluster in the Grafeas config file, the same way the server does
+func loadStorage(logger *zap.Logger, configFile string) (*storage.ElasticsearchStorage, error) {
+	grafeasConfiguration, err := grafeasConfig.LoadConfig(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load config: %s", err)
+	}
+	if grafeasConfiguration.StorageType != "elasticsearch" || grafeasConfiguration.StorageConfig == nil {
+		return nil, fmt.Errorf("storage type must be 'elasticsearch', got '%s'", grafeasConfiguration.StorageType)
+	}
+
+	var c *config.ElasticsearchConfig
+	if err := grafeasConfig.ConvertGenericConfigToSpecificType(grafeasConfiguration.StorageConfig, &c); err != nil {
+		return nil, fmt.Errorf("unable to convert config for Elasticsearch: %s", err)
+	}
+	if err := c.IsValid(); err != nil {
+		return nil, err
+	}
+
+	return newElasticsearchStorage(logger, c)
+}
@@ -17,6 +17,7 @@ package main
 import (
 	"crypto/tls"
 	"encoding/json"
+	_ "expvar"
 	"fmt"
 	"log"
 	"net/http"
@@ -30,6 +31,7 @@ import (
 	"github.com/rode/grafeas-elasticsearch/go/config"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/events"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
 	"go.uber.org/zap"
 )
@@ -41,16 +43,35 @@ func main() {
 		log.Fatalf("failed to create logger: %v", err)
 	}
 
-	registerStorageTypeProvider := storage.ElasticsearchStorageTypeProviderCreator(func(c *config.ElasticsearchConfig) (*storage.ElasticsearchStorage, error) {
-		esClient, err := createESClient(logger, c.URL, c.Username, c.Password, c.InsecureSkipVerify)
-		if err != nil {
-			return nil, fmt.Errorf("failed to connect to Elasticsearch")
+	// the binary also runs one-off commands against the configured Elasticsearch cluster, e.g. `export --config config.yaml --project rode`
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case exportCommand:
+			if err := runExport(logger.Named("Export"), os.Args[2:]); err != nil {
+				logger.Fatal("export failed", zap.NamedError("error", err))
+			}
+			return
+		case importCommand:
+			if err := runImport(logger.Named("Import"), os.Args[2:]); err != nil {
+				logger.Fatal("import failed", zap.NamedError("error", err))
+			}
+			return
 		}
+	}
 
-		indexManager := indexmanager.NewIndexManager(logger.Named("IndexManager"), esClient, &indexmanager.Config{MappingsPath: "mappings", IndexPrefix: "grafeas"})
+	// expvar metrics, such as the results of applying retention policies, are served at /debug/vars
+	if metricsAddress, ok := os.LookupEnv("METRICS_ADDRESS"); ok {
+		go func() {
+			if err := http.ListenAndServe(metricsAddress, nil); err != nil {
+				logger.Error("metrics server stopped", zap.NamedError("error", err))
+			}
+		}()
+	}
 
-		return storage.NewElasticsearchStorage(logger.Named("ElasticsearchStore"), esutil.NewClient(logger, esClient), filtering.NewFilterer(), c, indexManager), nil
-	}, logger)
+	// the watch service streams new and updated occurrences on its own port, alongside the Grafeas server
+	registerStorageTypeProvider := storage.ElasticsearchStorageTypeProviderCreator(func(c *config.ElasticsearchConfig) (*storage.ElasticsearchStorage, error) {
+		return newElasticsearchStorage(logger, c)
+	}, startWatch(logger), logger)
 
 	err = grafeasStorage.RegisterStorageTypeProvider("elasticsearch", registerStorageTypeProvider)
 	if err != nil {
@@ -63,6 +84,32 @@ func main() {
 	}
 }
 
+func newElasticsearchStorage(logger *zap.Logger, c *config.ElasticsearchConfig) (*storage.ElasticsearchStorage, error) {
+	esClient, err := createESClient(logger, c.URL, c.Username, c.Password, c.InsecureSkipVerify)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Elasticsearch")
+	}
+
+	indexManager := indexmanager.NewIndexManager(logger.Named("IndexManager"), esClient, &indexmanager.Config{MappingsPath: "mappings", IndexPrefix: "grafeas"})
+
+	filterer := filtering.NewFiltererWithLimits(filtering.Limits{
+		MaxDepth:            c.Filter.MaxDepth,
+		MaxClauses:          c.Filter.MaxClauses,
+		MaxExpensiveClauses: c.Filter.MaxExpensiveClauses,
+	})
+
+	// events are only published when a webhook is configured
+	var publisher events.Publisher
+	if c.Events.Webhook.URL != "" {
+		publisher, err = events.NewWebhookPublisher(logger.Named("WebhookPublisher"), &http.Client{}, &c.Events.Webhook)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create webhook publisher: %s", err)
+		}
+	}
+
+	return storage.NewElasticsearchStorage(logger.Named("ElasticsearchStore"), esutil.NewClient(logger, esClient), filterer, c, indexManager, publisher), nil
+}
+
 func createESClient(logger *zap.Logger, elasticsearchEndpoint, username, password string, insecureSkipVerify bool) (*elasticsearch.Client, error) {
 	c, err := elasticsearch.NewClient(elasticsearch.Config{
 		Addresses: []string{
@@ -0,0 +1,105 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	grafeasConfig "github.com/grafeas/grafeas/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/watch"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// startWatch returns a hook that serves the watch service on its own port once the storage has been initialized.
+// The service uses the same TLS certificates and client certificate authentication as the Grafeas API.
+func startWatch(logger *zap.Logger) storage.InitializedFunc {
+	return func(c *config.ElasticsearchConfig, es *storage.ElasticsearchStorage) error {
+		if c.Watch.Address == "" {
+			return nil
+		}
+
+		interval, err := c.Watch.IntervalDuration()
+		if err != nil {
+			return err
+		}
+
+		// Grafeas has already parsed its flags and config by the time the storage provider is registered
+		grafeasCfg, err := grafeasConfig.LoadConfig(flag.Lookup("config").Value.String())
+		if err != nil {
+			return fmt.Errorf("failed to load Grafeas config for the watch server: %s", err)
+		}
+
+		options, err := watchServerOptions(grafeasCfg.API)
+		if err != nil {
+			return err
+		}
+
+		listener, err := net.Listen("tcp", c.Watch.Address)
+		if err != nil {
+			return fmt.Errorf("failed to listen for watch clients on %s: %s", c.Watch.Address, err)
+		}
+
+		grpcServer := grpc.NewServer(options...)
+		watch.NewServer(logger.Named("Watch"), es, interval).Register(grpcServer)
+
+		log := logger.Named("WatchServer")
+		log.Info("starting watch server", zap.String("address", c.Watch.Address), zap.Bool("tls", len(options) > 0))
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Error("watch server stopped", zap.NamedError("error", err))
+			}
+		}()
+
+		return nil
+	}
+}
+
+// watchServerOptions mirrors how Grafeas configures its API server: when a CA is configured, clients must present
+// a certificate signed by it, and the server uses the API's certificate and key. Otherwise, the server doesn't use TLS.
+func watchServerOptions(api *grafeasConfig.ServerConfig) ([]grpc.ServerOption, error) {
+	if api == nil || api.CAFile == "" {
+		return nil, nil
+	}
+
+	caCert, err := ioutil.ReadFile(api.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file for the watch server: %s", err)
+	}
+
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(caCert)
+
+	cert, err := tls.LoadX509KeyPair(api.CertFile, api.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate for the watch server: %s", err)
+	}
+
+	return []grpc.ServerOption{
+		grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    caCertPool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		})),
+	}, nil
+}
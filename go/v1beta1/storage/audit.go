@@ -0,0 +1,371 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	auditDocumentKind = "audit"
+	auditTimeField    = "time"
+)
+
+// AuditOperation is the kind of change that was made to a resource
+type AuditOperation string
+
+const (
+	AuditOperationCreate AuditOperation = "CREATE"
+	AuditOperationUpdate AuditOperation = "UPDATE"
+	AuditOperationDelete AuditOperation = "DELETE"
+)
+
+// AuditOutcome is whether a change succeeded
+type AuditOutcome string
+
+const (
+	AuditOutcomeSuccess AuditOutcome = "SUCCESS"
+	AuditOutcomeFailure AuditOutcome = "FAILURE"
+)
+
+// AuditRecord is a change that was made to a project, note or occurrence, or one that was attempted and failed
+type AuditRecord struct {
+	// ID is the document ID of the record, which is set when the record is written along with its change
+	ID        string         `json:"-"`
+	Operation AuditOperation `json:"operation"`
+	// ResourceKind is projects, notes or occurrences
+	ResourceKind string `json:"resourceKind"`
+	// Resource is the name of the project, note or occurrence. Changes that don't identify a single note or occurrence,
+	// such as a failed item in a batch create or the occurrences deleted by retention, are recorded against the project.
+	Resource string `json:"resource"`
+	// UserID is the user passed to a create, or the user set on the request context with ContextWithUserID
+	UserID  string       `json:"userId,omitempty"`
+	Time    time.Time    `json:"time"`
+	Outcome AuditOutcome `json:"outcome"`
+	// Error is the reason that a change failed
+	Error string `json:"error,omitempty"`
+	// Diff is a JSON merge patch (RFC 7386) from the previous state of the resource to its new state.
+	// Creates hold the whole resource, and deletes have no diff.
+	Diff json.RawMessage `json:"diff,omitempty"`
+	// Count is the number of occurrences deleted by retention
+	Count int `json:"count,omitempty"`
+}
+
+func (es *ElasticsearchStorage) auditEnabled() bool {
+	return es.config.Audit.Enabled
+}
+
+// audit records the outcome of a change to a single resource. Previous and current are the states of the resource before
+// and after a successful change, and either can be nil. Written is the change that was made, if the request got as far as making it,
+// and its outcome isn't recorded again when it was already recorded along with the change.
+func (es *ElasticsearchStorage) audit(ctx context.Context, log *zap.Logger, operation AuditOperation, resourceKind, resource, userID string, previous, current proto.Message, written *change, err error) {
+	if !es.auditEnabled() || written.audited(err) {
+		return
+	}
+
+	record := es.newAuditRecord(ctx, operation, resourceKind, resource, userID, err)
+	if err == nil {
+		diff, diffErr := auditDiff(previous, current)
+		if diffErr != nil {
+			log.Error("error computing audit diff", zap.Error(diffErr))
+		}
+		record.Diff = diff
+	}
+	// a step after the change failed, so the successful record that was written with it is replaced
+	if written != nil && written.auditRecord != nil {
+		record.ID = written.auditRecord.ID
+	}
+
+	es.writeAuditRecords(ctx, log, []*AuditRecord{record})
+}
+
+// withAudit adds a successful audit record for each change to the end of the items of a bulk request, so that each record is written
+// in the same request as its change. The records are returned in the order of the changes. A record that can't be encoded is logged
+// and left out of the request, and its place in the returned records is nil.
+func (es *ElasticsearchStorage) withAudit(ctx context.Context, log *zap.Logger, items []*esutil.BulkRequestItem, changes []*change) ([]*esutil.BulkRequestItem, []*AuditRecord) {
+	if !es.auditEnabled() {
+		return items, nil
+	}
+
+	records := make([]*AuditRecord, len(changes))
+	for i, c := range changes {
+		record := es.newAuditRecord(ctx, c.auditOperation, c.resourceKind, c.resource, c.userID, nil)
+		record.ID = uuid.New().String()
+		diff, err := auditDiff(c.previous, c.current)
+		if err != nil {
+			log.Error("error computing audit diff", zap.Error(err))
+		}
+		record.Diff = diff
+
+		item, err := es.auditItem(record, esutil.BULK_CREATE)
+		if err != nil {
+			log.Error("error encoding audit record", zap.Error(err), zap.Any("record", record))
+			continue
+		}
+
+		items = append(items, item)
+		records[i] = record
+	}
+
+	return items, records
+}
+
+// settleAudit handles the audit records written in a bulk request, whose changes may have failed independently of them.
+// The record of a failed change is replaced with its failure, and a record that wasn't written for a successful change is logged.
+// It returns the responses that follow those of the records.
+func (es *ElasticsearchStorage) settleAudit(ctx context.Context, log *zap.Logger, records []*AuditRecord, changeErrs []error, responses []*esutil.EsBulkResponseItem) []*esutil.EsBulkResponseItem {
+	var failed []*AuditRecord
+	for i, record := range records {
+		if record == nil {
+			continue
+		}
+
+		recordErr := bulkItemError(responses[0])
+		responses = responses[1:]
+		if changeErrs[i] == nil {
+			if recordErr != nil {
+				log.Error("error writing audit record", zap.Error(recordErr), zap.Any("record", record))
+			}
+			continue
+		}
+
+		record.Outcome = AuditOutcomeFailure
+		record.Error = changeErrs[i].Error()
+		record.Diff = nil
+		failed = append(failed, record)
+	}
+
+	es.writeAuditRecords(ctx, log, failed)
+
+	return responses
+}
+
+// auditBatch records each resource created by a batch, and each error. Errors aren't tied to a single resource,
+// so they're recorded against the project.
+func (es *ElasticsearchStorage) auditBatch(ctx context.Context, log *zap.Logger, resourceKind, projectId, userID string, created []proto.Message, errs []error) {
+	if !es.auditEnabled() {
+		return
+	}
+
+	var records []*AuditRecord
+	for _, message := range created {
+		record := es.newAuditRecord(ctx, AuditOperationCreate, resourceKind, resourceName(message), userID, nil)
+		diff, err := auditDiff(nil, message)
+		if err != nil {
+			log.Error("error computing audit diff", zap.Error(err))
+		}
+		record.Diff = diff
+
+		records = append(records, record)
+	}
+
+	for _, err := range errs {
+		records = append(records, es.newAuditRecord(ctx, AuditOperationCreate, resourceKind, fmt.Sprintf("projects/%s", projectId), userID, err))
+	}
+
+	es.writeAuditRecords(ctx, log, records)
+}
+
+// auditRetention records the occurrences that were deleted from a project by its retention policy
+func (es *ElasticsearchStorage) auditRetention(ctx context.Context, log *zap.Logger, projectId string, count int) {
+	if !es.auditEnabled() {
+		return
+	}
+
+	record := es.newAuditRecord(ctx, AuditOperationDelete, occurrencesDocumentKind, fmt.Sprintf("projects/%s", projectId), "", nil)
+	record.Count = count
+
+	es.writeAuditRecords(ctx, log, []*AuditRecord{record})
+}
+
+// writeAuditRecords indexes the records after the change has been made, so a failure is logged rather than returned.
+// A record with an ID replaces the one that was written along with its change.
+func (es *ElasticsearchStorage) writeAuditRecords(ctx context.Context, log *zap.Logger, records []*AuditRecord) {
+	var (
+		items   []*esutil.BulkRequestItem
+		encoded []*AuditRecord
+	)
+	for _, record := range records {
+		item, err := es.auditItem(record, esutil.BULK_INDEX)
+		if err != nil {
+			log.Error("error encoding audit record", zap.Error(err), zap.Any("record", record))
+			continue
+		}
+
+		items = append(items, item)
+		encoded = append(encoded, record)
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	res, err := es.client.Bulk(ctx, &esutil.BulkRequest{
+		Index:   es.auditAlias(),
+		Refresh: es.config.Refresh.String(),
+		Items:   items,
+	})
+	if err != nil {
+		log.Error("error writing audit records", zap.Error(err), zap.Any("records", encoded))
+		return
+	}
+
+	for i, item := range res.Items {
+		if err := bulkItemError(item); err != nil {
+			log.Error("error writing audit record", zap.Error(err), zap.Any("record", encoded[i]))
+		}
+	}
+}
+
+func (es *ElasticsearchStorage) auditItem(record *AuditRecord, operation esutil.EsBulkOperation) (*esutil.BulkRequestItem, error) {
+	fields, err := structFields(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return &esutil.BulkRequestItem{
+		Operation:  operation,
+		Index:      es.auditAlias(),
+		DocumentId: record.ID,
+		Fields:     fields,
+	}, nil
+}
+
+// ListAuditRecords returns up to pageSize number of audit records matching the filter, beginning with the most recent.
+// Filters refer to the JSON fields of AuditRecord, e.g. resource.startsWith("projects/rode/") && operation == "DELETE".
+func (es *ElasticsearchStorage) ListAuditRecords(ctx context.Context, filter, pageToken string, pageSize int32) ([]*AuditRecord, string, error) {
+	log := es.logger.Named("ListAuditRecords")
+	if !es.auditEnabled() {
+		return nil, "", auditDisabledError()
+	}
+
+	search := &esutil.EsSearch{
+		Sort: []esutil.EsSortField{
+			{Field: auditTimeField, Order: esutil.EsSortOrderDescending},
+		},
+	}
+
+	res, nextPageToken, err := es.genericList(ctx, log, auditDocumentKind, es.auditAlias(), filter, false, search, pageToken, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var records []*AuditRecord
+	for _, hit := range res.Hits {
+		record := &AuditRecord{}
+		if err := json.Unmarshal(hit.Source, record); err != nil {
+			return nil, "", createError(log.With(zap.String("audit record raw", string(hit.Source))), "error converting _doc to audit record", err)
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nextPageToken, nil
+}
+
+func (es *ElasticsearchStorage) newAuditRecord(ctx context.Context, operation AuditOperation, resourceKind, resource, userID string, err error) *AuditRecord {
+	record := &AuditRecord{
+		Operation:    operation,
+		ResourceKind: resourceKind,
+		Resource:     resource,
+		UserID:       es.actingUserID(ctx, userID),
+		Time:         time.Now().UTC(),
+		Outcome:      AuditOutcomeSuccess,
+	}
+	if err != nil {
+		record.Outcome = AuditOutcomeFailure
+		record.Error = err.Error()
+	}
+
+	return record
+}
+
+// auditDiff returns a JSON merge patch from the previous message to the current one, or nil if there's no current message
+func auditDiff(previous, current proto.Message) (json.RawMessage, error) {
+	currentJson, err := messageJson(current)
+	if err != nil || currentJson == nil {
+		return nil, err
+	}
+
+	previousJson, err := messageJson(previous)
+	if err != nil {
+		return nil, err
+	}
+	if previousJson == nil {
+		previousJson = []byte("{}")
+	}
+
+	return jsonpatch.CreateMergePatch(previousJson, currentJson)
+}
+
+// structFields converts a struct, such as an audit record, into the fields of a document
+func structFields(value interface{}) (map[string]interface{}, error) {
+	valueJson, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(valueJson, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// resourceName is the name of a note or occurrence
+func resourceName(message proto.Message) string {
+	if named, ok := message.(interface{ GetName() string }); ok {
+		return named.GetName()
+	}
+
+	return ""
+}
+
+// auditResource is the name of a note or occurrence, or of its project if it hasn't been named yet
+func auditResource(projectId string, message proto.Message) string {
+	if name := resourceName(message); name != "" {
+		return name
+	}
+
+	return fmt.Sprintf("projects/%s", projectId)
+}
+
+// isPresent is false for both nil interfaces and typed nil messages, such as the result of a failed create
+func isPresent(message proto.Message) bool {
+	return message != nil && proto.MessageV2(message).ProtoReflect().IsValid()
+}
+
+func auditDisabledError() error {
+	return status.Error(codes.FailedPrecondition, "audit is not enabled")
+}
+
+func (es *ElasticsearchStorage) auditIndex() string {
+	return es.indexManager.IndexName(auditDocumentKind, "")
+}
+
+func (es *ElasticsearchStorage) auditAlias() string {
+	return es.indexManager.AliasName(auditDocumentKind, "")
+}
@@ -0,0 +1,618 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+var _ = Describe("audit", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId      string
+		expectedProjectName    string
+		expectedOccurrenceId   string
+		expectedOccurrenceName string
+		expectedNoteId         string
+		expectedNoteName       string
+		expectedAuditAlias     string
+		expectedUserId         string
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	BeforeEach(func() {
+		expectedUserId = fake.LetterN(10)
+		ctx = ContextWithUserID(context.Background(), expectedUserId)
+		expectedProjectId = fake.LetterN(10)
+		expectedProjectName = fmt.Sprintf("projects/%s", expectedProjectId)
+		expectedOccurrenceId = fake.LetterN(10)
+		expectedOccurrenceName = fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, expectedOccurrenceId)
+		expectedNoteId = fake.LetterN(10)
+		expectedNoteName = fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, expectedNoteId)
+		expectedAuditAlias = "grafeas-audit"
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+			Audit: config.AuditConfig{
+				Enabled: true,
+			},
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+		indexManager.IndexNameCalls(func(documentKind string, inner string) string {
+			return fmt.Sprintf("grafeas-v1-%s-%s", inner, documentKind)
+		})
+		client.BulkCalls(func(_ context.Context, request *esutil.BulkRequest) (*esutil.EsBulkResponse, error) {
+			return bulkResponse(len(request.Items)), nil
+		})
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	searchResponse := func(messages ...proto.Message) *esutil.SearchResponse {
+		response := &esutil.SearchResponse{
+			Hits: &esutil.EsSearchResponseHits{
+				Total: &esutil.EsSearchResponseTotal{Value: len(messages)},
+			},
+		}
+		for _, message := range messages {
+			source, err := protojson.Marshal(proto.MessageV2(message))
+			Expect(err).ToNot(HaveOccurred())
+
+			response.Hits.Hits = append(response.Hits.Hits, &esutil.EsSearchResponseHit{
+				ID:     fake.LetterN(10),
+				Index:  fake.LetterN(10),
+				Source: source,
+			})
+		}
+
+		return response
+	}
+
+	// auditRecords decodes the audit records written in every bulk request, either along with a change or on their own.
+	// As in the audit index, a record that's written again with the same ID replaces the earlier one.
+	auditRecords := func() []*AuditRecord {
+		var records []*AuditRecord
+		recordsById := map[string]*AuditRecord{}
+		for i := 0; i < client.BulkCallCount(); i++ {
+			_, bulkRequest := client.BulkArgsForCall(i)
+			for _, item := range bulkRequest.Items {
+				index := item.Index
+				if index == "" {
+					index = bulkRequest.Index
+				}
+				if index != expectedAuditAlias {
+					continue
+				}
+				Expect(item.Message).To(BeNil())
+
+				fields, err := json.Marshal(item.Fields)
+				Expect(err).ToNot(HaveOccurred())
+
+				record := &AuditRecord{}
+				Expect(json.Unmarshal(fields, record)).To(Succeed())
+				record.ID = item.DocumentId
+
+				if previous, ok := recordsById[record.ID]; ok && record.ID != "" {
+					Expect(item.Operation).To(Equal(esutil.BULK_INDEX))
+					*previous = *record
+					continue
+				}
+
+				recordsById[record.ID] = record
+				records = append(records, record)
+			}
+		}
+
+		return records
+	}
+
+	Context("Initialize", func() {
+		It("should create the audit index", func() {
+			Expect(elasticsearchStorage.Initialize(ctx)).To(Succeed())
+			Expect(indexManager.CreateIndexCallCount()).To(Equal(2))
+
+			_, index, alias, documentKind := indexManager.CreateIndexArgsForCall(1)
+			Expect(index).To(Equal("grafeas-v1--audit"))
+			Expect(alias).To(Equal(expectedAuditAlias))
+			Expect(documentKind).To(Equal(auditDocumentKind))
+		})
+	})
+
+	Context("CreateOccurrence", func() {
+		var (
+			expectedOccurrence *pb.Occurrence
+			actualErr          error
+		)
+
+		BeforeEach(func() {
+			expectedOccurrence = generateTestOccurrence("")
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+		})
+
+		JustBeforeEach(func() {
+			_, actualErr = elasticsearchStorage.CreateOccurrence(ctx, expectedProjectId, "", expectedOccurrence)
+		})
+
+		It("should record the new occurrence", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+
+			records := auditRecords()
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Operation).To(Equal(AuditOperationCreate))
+			Expect(records[0].ResourceKind).To(Equal(occurrencesDocumentKind))
+			Expect(records[0].Resource).To(Equal(expectedOccurrence.Name))
+			Expect(records[0].UserID).To(Equal(expectedUserId))
+			Expect(records[0].Outcome).To(Equal(AuditOutcomeSuccess))
+			Expect(records[0].Time).To(BeTemporally("~", time.Now(), time.Minute))
+
+			diff := &pb.Occurrence{}
+			Expect(protojson.Unmarshal(records[0].Diff, proto.MessageV2(diff))).To(Succeed())
+			Expect(proto.Equal(diff, expectedOccurrence)).To(BeTrue())
+		})
+
+		It("should write the record in the same bulk request as the occurrence", func() {
+			Expect(client.BulkCallCount()).To(Equal(1))
+			Expect(client.CreateCallCount()).To(Equal(0))
+
+			_, bulkRequest := client.BulkArgsForCall(0)
+			Expect(bulkRequest.Items).To(HaveLen(2))
+			Expect(bulkRequest.Items[0].Message).ToNot(BeNil())
+			Expect(bulkRequest.Items[1].Operation).To(Equal(esutil.BULK_CREATE))
+			Expect(bulkRequest.Items[1].Index).To(Equal(expectedAuditAlias))
+			Expect(bulkRequest.Items[1].DocumentId).ToNot(BeEmpty())
+		})
+
+		When("the occurrence fails to be written", func() {
+			BeforeEach(func() {
+				client.BulkCalls(func(_ context.Context, request *esutil.BulkRequest) (*esutil.EsBulkResponse, error) {
+					response := bulkResponse(len(request.Items))
+					if request.Index != expectedAuditAlias {
+						response.Items[0].Index = nil
+						response.Items[0].Create = &esutil.EsIndexDocResponse{
+							Status: 500,
+							Error:  &esutil.EsIndexDocError{Type: fake.LetterN(10), Reason: fake.LetterN(10)},
+						}
+					}
+
+					return response, nil
+				})
+			})
+
+			It("should replace the record with the failure", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(client.BulkCallCount()).To(Equal(2))
+
+				records := auditRecords()
+				Expect(records).To(HaveLen(1))
+				Expect(records[0].Resource).To(Equal(expectedOccurrence.Name))
+				Expect(records[0].Outcome).To(Equal(AuditOutcomeFailure))
+				Expect(records[0].Error).To(ContainSubstring("500"))
+				Expect(records[0].Diff).To(BeEmpty())
+			})
+		})
+
+		When("the project does not exist", func() {
+			BeforeEach(func() {
+				client.SearchReturns(searchResponse(), nil)
+			})
+
+			It("should record the failure against the project", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.FailedPrecondition)
+
+				records := auditRecords()
+				Expect(records).To(HaveLen(1))
+				Expect(records[0].Resource).To(Equal(expectedProjectName))
+				Expect(records[0].Outcome).To(Equal(AuditOutcomeFailure))
+				Expect(records[0].Error).To(ContainSubstring("does not exist"))
+				Expect(records[0].Diff).To(BeEmpty())
+			})
+		})
+
+		When("writing the audit record fails", func() {
+			BeforeEach(func() {
+				client.BulkCalls(func(_ context.Context, request *esutil.BulkRequest) (*esutil.EsBulkResponse, error) {
+					response := bulkResponse(len(request.Items))
+					response.Items[1].Index.Status = 500
+					response.Items[1].Index.Error = &esutil.EsIndexDocError{Type: fake.LetterN(10), Reason: fake.LetterN(10)}
+
+					return response, nil
+				})
+			})
+
+			It("should still create the occurrence", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(client.BulkCallCount()).To(Equal(1))
+			})
+		})
+
+		When("audit is disabled", func() {
+			BeforeEach(func() {
+				esConfig.Audit.Enabled = false
+			})
+
+			It("should not record anything", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(client.BulkCallCount()).To(Equal(0))
+				Expect(client.CreateCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Context("CreateProject", func() {
+		BeforeEach(func() {
+			client.SearchReturns(searchResponse(), nil)
+		})
+
+		When("creating the project's indices fails after the project is written", func() {
+			BeforeEach(func() {
+				indexManager.CreateIndexReturns(errors.New("create index failed"))
+			})
+
+			It("should replace the successful record with the failure", func() {
+				_, err := elasticsearchStorage.CreateProject(ctx, expectedProjectId, generateTestProject(expectedProjectId))
+				Expect(err).To(HaveOccurred())
+
+				records := auditRecords()
+				Expect(records).To(HaveLen(1))
+				Expect(records[0].Operation).To(Equal(AuditOperationCreate))
+				Expect(records[0].Resource).To(Equal(expectedProjectName))
+				Expect(records[0].Outcome).To(Equal(AuditOutcomeFailure))
+				Expect(records[0].Error).To(ContainSubstring("create index failed"))
+			})
+		})
+	})
+
+	Context("UpdateOccurrence", func() {
+		It("should record the changed fields", func() {
+			client.SearchReturns(searchResponse(generateTestOccurrence(expectedOccurrenceName)), nil)
+
+			_, err := elasticsearchStorage.UpdateOccurrence(ctx, expectedProjectId, expectedOccurrenceId, &pb.Occurrence{
+				Remediation: "updatedvalue",
+			}, &fieldmaskpb.FieldMask{Paths: []string{"remediation"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			records := auditRecords()
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Operation).To(Equal(AuditOperationUpdate))
+			Expect(records[0].Resource).To(Equal(expectedOccurrenceName))
+
+			var diff map[string]interface{}
+			Expect(json.Unmarshal(records[0].Diff, &diff)).To(Succeed())
+			Expect(diff).To(HaveKeyWithValue("remediation", "updatedvalue"))
+			Expect(diff).To(HaveKey("updateTime"))
+			Expect(diff).ToNot(HaveKey("resource"))
+		})
+	})
+
+	Context("DeleteNote", func() {
+		It("should record the deletion without a diff", func() {
+			client.SearchReturns(searchResponse(generateTestNote(expectedNoteName)), nil)
+
+			Expect(elasticsearchStorage.DeleteNote(ctx, expectedProjectId, expectedNoteId)).To(Succeed())
+			Expect(client.DeleteCallCount()).To(Equal(0))
+
+			records := auditRecords()
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Operation).To(Equal(AuditOperationDelete))
+			Expect(records[0].ResourceKind).To(Equal(notesDocumentKind))
+			Expect(records[0].Resource).To(Equal(expectedNoteName))
+			Expect(records[0].UserID).To(Equal(expectedUserId))
+			Expect(records[0].Diff).To(BeEmpty())
+		})
+	})
+
+	Context("BatchCreateNotes", func() {
+		It("should record each created note and each error", func() {
+			existingNoteId := fake.LetterN(10)
+			notes := map[string]*pb.Note{
+				expectedNoteId: generateTestNote(""),
+				existingNoteId: generateTestNote(""),
+			}
+			expectedBatchUserId := fake.LetterN(10)
+
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+			client.MultiSearchCalls(func(ctx context.Context, request *esutil.MultiSearchRequest) (*esutil.EsMultiSearchResponse, error) {
+				response := &esutil.EsMultiSearchResponse{}
+				for _, search := range request.Searches {
+					total := 0
+					if (*search.Query.Term)["name"] == fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, existingNoteId) {
+						total = 1
+					}
+
+					response.Responses = append(response.Responses, &esutil.EsMultiSearchResponseHitsSummary{
+						Hits: &esutil.EsMultiSearchResponseHits{
+							Total: &esutil.EsSearchResponseTotal{Value: total},
+						},
+					})
+				}
+
+				return response, nil
+			})
+			client.BulkCalls(func(ctx context.Context, request *esutil.BulkRequest) (*esutil.EsBulkResponse, error) {
+				response := &esutil.EsBulkResponse{}
+				for range request.Items {
+					response.Items = append(response.Items, &esutil.EsBulkResponseItem{
+						Create: &esutil.EsIndexDocResponse{Status: 201},
+					})
+				}
+
+				return response, nil
+			})
+
+			created, errs := elasticsearchStorage.BatchCreateNotes(ctx, expectedProjectId, expectedBatchUserId, notes)
+			Expect(created).To(HaveLen(1))
+			Expect(errs).To(HaveLen(1))
+
+			_, bulkRequest := client.BulkArgsForCall(0)
+			Expect(bulkRequest.Index).To(Equal(fmt.Sprintf("grafeas-%s-notes", expectedProjectId)))
+			Expect(bulkRequest.Items).To(HaveLen(2))
+			Expect(bulkRequest.Items[1].Index).To(Equal(expectedAuditAlias))
+
+			records := auditRecords()
+			Expect(records).To(HaveLen(2))
+			Expect(records[0].Resource).To(Equal(expectedNoteName))
+			Expect(records[0].Outcome).To(Equal(AuditOutcomeSuccess))
+			Expect(records[0].UserID).To(Equal(expectedBatchUserId))
+			Expect(records[1].Resource).To(Equal(expectedProjectName))
+			Expect(records[1].Outcome).To(Equal(AuditOutcomeFailure))
+			Expect(records[1].Error).To(ContainSubstring("already exists"))
+		})
+
+		It("should replace the record of a note that fails to be created", func() {
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+			client.MultiSearchReturns(&esutil.EsMultiSearchResponse{
+				Responses: []*esutil.EsMultiSearchResponseHitsSummary{
+					{
+						Hits: &esutil.EsMultiSearchResponseHits{
+							Total: &esutil.EsSearchResponseTotal{},
+						},
+					},
+				},
+			}, nil)
+			client.BulkCalls(func(ctx context.Context, request *esutil.BulkRequest) (*esutil.EsBulkResponse, error) {
+				response := &esutil.EsBulkResponse{}
+				for range request.Items {
+					response.Items = append(response.Items, &esutil.EsBulkResponseItem{
+						Create: &esutil.EsIndexDocResponse{Status: 201},
+					})
+				}
+				if request.Index != expectedAuditAlias {
+					response.Items[0].Create = &esutil.EsIndexDocResponse{
+						Status: 500,
+						Error:  &esutil.EsIndexDocError{Type: fake.LetterN(10), Reason: fake.LetterN(10)},
+					}
+				}
+
+				return response, nil
+			})
+
+			created, errs := elasticsearchStorage.BatchCreateNotes(ctx, expectedProjectId, "", map[string]*pb.Note{
+				expectedNoteId: generateTestNote(""),
+			})
+			Expect(created).To(BeEmpty())
+			Expect(errs).To(HaveLen(1))
+
+			records := auditRecords()
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Resource).To(Equal(expectedNoteName))
+			Expect(records[0].Outcome).To(Equal(AuditOutcomeFailure))
+			Expect(records[0].Error).To(ContainSubstring("500"))
+		})
+	})
+
+	Context("DeleteProject", func() {
+		It("should record the deletion of the project", func() {
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+
+			Expect(elasticsearchStorage.DeleteProject(ctx, expectedProjectId)).To(Succeed())
+
+			records := auditRecords()
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Operation).To(Equal(AuditOperationDelete))
+			Expect(records[0].ResourceKind).To(Equal(projectDocumentKind))
+			Expect(records[0].Resource).To(Equal(expectedProjectName))
+		})
+	})
+
+	Context("retention", func() {
+		BeforeEach(func() {
+			client.DeleteByQueryReturns(&esutil.EsDeleteResponse{Deleted: 5}, nil)
+		})
+
+		It("should record the number of occurrences deleted from the project", func() {
+			_, err := elasticsearchStorage.applyProjectRetention(ctx, logger, expectedProjectId, config.RetentionPolicy{MaxAgeDays: 30})
+			Expect(err).ToNot(HaveOccurred())
+
+			records := auditRecords()
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Operation).To(Equal(AuditOperationDelete))
+			Expect(records[0].ResourceKind).To(Equal(occurrencesDocumentKind))
+			Expect(records[0].Resource).To(Equal(expectedProjectName))
+			Expect(records[0].Count).To(Equal(5))
+		})
+
+		When("it's a dry run", func() {
+			BeforeEach(func() {
+				esConfig.Retention.DryRun = true
+				client.CountReturns(5, nil)
+			})
+
+			It("should not record anything", func() {
+				_, err := elasticsearchStorage.applyProjectRetention(ctx, logger, expectedProjectId, config.RetentionPolicy{MaxAgeDays: 30})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(auditRecords()).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("writeAuditRecords", func() {
+		It("should leave out records that can't be encoded", func() {
+			records := []*AuditRecord{
+				{Resource: fake.LetterN(10), Diff: json.RawMessage("{")},
+				{Resource: fake.LetterN(10)},
+			}
+			client.BulkCalls(func(_ context.Context, request *esutil.BulkRequest) (*esutil.EsBulkResponse, error) {
+				response := bulkResponse(len(request.Items))
+				response.Items[0].Index.Error = &esutil.EsIndexDocError{Type: fake.LetterN(10), Reason: fake.LetterN(10)}
+
+				return response, nil
+			})
+
+			elasticsearchStorage.writeAuditRecords(ctx, logger, records)
+
+			Expect(client.BulkCallCount()).To(Equal(1))
+			_, bulkRequest := client.BulkArgsForCall(0)
+			Expect(bulkRequest.Items).To(HaveLen(1))
+			Expect(bulkRequest.Items[0].Fields).To(HaveKeyWithValue("resource", records[1].Resource))
+		})
+
+		It("should not write a request when no records can be encoded", func() {
+			elasticsearchStorage.writeAuditRecords(ctx, logger, []*AuditRecord{{Diff: json.RawMessage("{")}})
+
+			Expect(client.BulkCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("ListAuditRecords", func() {
+		var (
+			expectedFilter    string
+			expectedQuery     *filtering.Query
+			expectedRecord    *AuditRecord
+			expectedPageToken string
+
+			actualRecords   []*AuditRecord
+			actualPageToken string
+			actualErr       error
+		)
+
+		BeforeEach(func() {
+			expectedFilter = `operation == "DELETE"`
+			expectedQuery = &filtering.Query{
+				Term: &filtering.Term{
+					"operation": "DELETE",
+				},
+			}
+			expectedRecord = &AuditRecord{
+				Operation:    AuditOperationDelete,
+				ResourceKind: notesDocumentKind,
+				Resource:     expectedNoteName,
+				UserID:       expectedUserId,
+				Time:         time.Now().UTC().Truncate(time.Millisecond),
+				Outcome:      AuditOutcomeSuccess,
+			}
+			expectedPageToken = fake.LetterN(10)
+
+			source, err := json.Marshal(expectedRecord)
+			Expect(err).ToNot(HaveOccurred())
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: 1},
+					Hits: []*esutil.EsSearchResponseHit{
+						{Source: source},
+					},
+				},
+				NextPageToken: expectedPageToken,
+			}, nil)
+			filterer.EXPECT().ParseExpression(expectedFilter).Return(expectedQuery, nil).AnyTimes()
+		})
+
+		JustBeforeEach(func() {
+			actualRecords, actualPageToken, actualErr = elasticsearchStorage.ListAuditRecords(ctx, expectedFilter, "", 10)
+		})
+
+		It("should search the audit index, most recent first", func() {
+			Expect(client.SearchCallCount()).To(Equal(1))
+
+			_, searchRequest := client.SearchArgsForCall(0)
+			Expect(searchRequest.Index).To(Equal(expectedAuditAlias))
+			Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: auditTimeField, Order: esutil.EsSortOrderDescending},
+			}))
+		})
+
+		It("should return the records", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualRecords).To(Equal([]*AuditRecord{expectedRecord}))
+			Expect(actualPageToken).To(Equal(expectedPageToken))
+		})
+
+		When("filters are type checked", func() {
+			BeforeEach(func() {
+				esConfig.Filter.TypeCheck = true
+			})
+
+			It("should not check the filter against a message", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+			})
+		})
+
+		When("audit is disabled", func() {
+			BeforeEach(func() {
+				esConfig.Audit.Enabled = false
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.FailedPrecondition)
+				Expect(client.SearchCallCount()).To(Equal(0))
+			})
+		})
+	})
+})
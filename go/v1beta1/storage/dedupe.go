@@ -0,0 +1,216 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/uuid"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/events"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// preparedOccurrences are the occurrences that should be written, along with what's needed to write each one
+type preparedOccurrences struct {
+	occurrences []*pb.Occurrence
+	documentIds []string
+	metadata    []*DocumentMetadata
+	// previous is the existing occurrence that each one replaces in upsert mode, or nil if it's new
+	previous []*pb.Occurrence
+}
+
+// prepareOccurrences names each occurrence and sets its timestamps, returning the occurrences that should be written along with their document IDs,
+// metadata and the occurrences they replace.
+// When deduplication is enabled, occurrences are named after their natural key. An occurrence with the same key as an existing occurrence
+// either replaces it or is rejected with an AlreadyExists error, depending on the configured mode.
+// Document IDs are empty when deduplication is disabled, so that Elasticsearch generates them.
+// A replaced occurrence keeps the user that created it, and is marked as updated by the given user.
+func (es *ElasticsearchStorage) prepareOccurrences(ctx context.Context, log *zap.Logger, projectId, userID string, occurrences []*pb.Occurrence) (*preparedOccurrences, []error, error) {
+	documentIds := make([]string, len(occurrences))
+	if !es.dedupeEnabled() {
+		metadata := make([]*DocumentMetadata, len(occurrences))
+		for i, occurrence := range occurrences {
+			occurrence.Name = fmt.Sprintf("projects/%s/occurrences/%s", projectId, uuid.New().String())
+			if occurrence.CreateTime == nil {
+				occurrence.CreateTime = ptypes.TimestampNow()
+			}
+
+			metadata[i] = &DocumentMetadata{CreatedBy: userID}
+		}
+
+		return &preparedOccurrences{
+			occurrences: occurrences,
+			documentIds: documentIds,
+			metadata:    metadata,
+			previous:    make([]*pb.Occurrence, len(occurrences)),
+		}, nil, nil
+	}
+
+	for i, occurrence := range occurrences {
+		key, err := occurrenceKey(projectId, occurrence, es.config.Dedupe.Fields)
+		if err != nil {
+			return nil, nil, createError(log, "error computing occurrence key", err)
+		}
+
+		documentIds[i] = key
+		occurrence.Name = fmt.Sprintf("projects/%s/occurrences/%s", projectId, key)
+	}
+
+	res, err := es.client.MultiGet(ctx, &esutil.MultiGetRequest{
+		Index:       es.occurrencesAlias(projectId),
+		DocumentIds: documentIds,
+	})
+	if err != nil {
+		return nil, nil, createError(log, "error checking for existing occurrences in elasticsearch", err)
+	}
+
+	var (
+		prepared = &preparedOccurrences{}
+		errs     []error
+	)
+	for i, occurrence := range occurrences {
+		doc := res.Docs[i]
+		metadata := &DocumentMetadata{CreatedBy: userID}
+		var previous *pb.Occurrence
+		if !doc.Found {
+			if occurrence.CreateTime == nil {
+				occurrence.CreateTime = ptypes.TimestampNow()
+			}
+		} else if es.config.Dedupe.Mode == config.DedupeModeReject {
+			log.Debug("occurrence already exists", zap.String("occurrence", occurrence.Name))
+			errs = append(errs, status.Errorf(codes.AlreadyExists, "occurrence with the name %s already exists", occurrence.Name))
+			continue
+		} else {
+			existing := &pb.Occurrence{}
+			if err := decodeDocument(doc.Source, existing); err != nil {
+				return nil, nil, createError(log, "error unmarshalling existing occurrence", err)
+			}
+
+			existingMetadata, err := decodeMetadata(doc.Source)
+			if err != nil {
+				return nil, nil, createError(log, "error unmarshalling existing occurrence metadata", err)
+			}
+
+			occurrence.CreateTime = existing.CreateTime
+			occurrence.UpdateTime = ptypes.TimestampNow()
+			metadata = &DocumentMetadata{
+				CreatedBy: existingMetadata.CreatedBy,
+				UpdatedBy: userID,
+			}
+			previous = existing
+		}
+
+		prepared.occurrences = append(prepared.occurrences, occurrence)
+		prepared.documentIds = append(prepared.documentIds, documentIds[i])
+		prepared.metadata = append(prepared.metadata, metadata)
+		prepared.previous = append(prepared.previous, previous)
+	}
+
+	return prepared, errs, nil
+}
+
+func (es *ElasticsearchStorage) dedupeEnabled() bool {
+	return len(es.config.Dedupe.Fields) > 0
+}
+
+// occurrenceBulkOperation returns the bulk operation used to write occurrences: duplicates are replaced in upsert mode,
+// and otherwise creating a document with an ID that's already in use fails.
+func (es *ElasticsearchStorage) occurrenceBulkOperation() esutil.EsBulkOperation {
+	if es.dedupeEnabled() && es.config.Dedupe.Mode == config.DedupeModeUpsert {
+		return esutil.BULK_INDEX
+	}
+
+	return esutil.BULK_CREATE
+}
+
+// occurrenceChange is the change made by writing the occurrence, which is an update when it replaces a previous occurrence in upsert mode
+func occurrenceChange(occurrence, previous *pb.Occurrence, userID string) *change {
+	c := &change{
+		eventType:      events.Created,
+		resourceKind:   occurrencesDocumentKind,
+		resource:       occurrence.Name,
+		userID:         userID,
+		current:        occurrence,
+		auditOperation: AuditOperationCreate,
+	}
+	if previous != nil {
+		c.eventType = events.Updated
+		c.previous = previous
+	}
+
+	return c
+}
+
+// occurrenceKey hashes the project and the values of the key fields into a deterministic identifier.
+// Fields are JSON paths into the occurrence, e.g. resource.uri. Paths that pass through a list collect the value from each element,
+// and fields that aren't set contribute a null value.
+func occurrenceKey(projectId string, occurrence *pb.Occurrence, fields []string) (string, error) {
+	occurrenceJson, err := protojson.Marshal(proto.MessageV2(occurrence))
+	if err != nil {
+		return "", err
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(occurrenceJson, &document); err != nil {
+		return "", err
+	}
+
+	values := []interface{}{projectId}
+	for _, field := range fields {
+		values = append(values, fieldValue(document, strings.Split(field, ".")))
+	}
+
+	encodedValues, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(encodedValues)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func fieldValue(value interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return fieldValue(v[path[0]], path[1:])
+	case []interface{}:
+		var values []interface{}
+		for _, element := range v {
+			values = append(values, fieldValue(element, path))
+		}
+
+		return values
+	default:
+		return nil
+	}
+}
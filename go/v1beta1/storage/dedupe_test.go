@@ -0,0 +1,112 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/grafeas/grafeas/proto/v1beta1/package_go_proto"
+	"github.com/grafeas/grafeas/proto/v1beta1/vulnerability_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("occurrence deduplication", func() {
+	Context("occurrenceKey", func() {
+		var (
+			projectId  string
+			occurrence *pb.Occurrence
+			fields     []string
+		)
+
+		vulnerabilityOccurrence := func(version string) *pb.Occurrence {
+			return &pb.Occurrence{
+				Resource: &pb.Resource{
+					Uri: "https://example.com/image@sha256:abc",
+				},
+				NoteName: "projects/rode/notes/CVE-2021-1234",
+				Details: &pb.Occurrence_Vulnerability{
+					Vulnerability: &vulnerability_go_proto.Details{
+						PackageIssue: []*vulnerability_go_proto.PackageIssue{
+							{
+								AffectedLocation: &vulnerability_go_proto.VulnerabilityLocation{
+									Package: "openssl",
+									Version: &package_go_proto.Version{
+										Name: version,
+										Kind: package_go_proto.Version_NORMAL,
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			projectId = fake.LetterN(10)
+			occurrence = vulnerabilityOccurrence("1.1.1")
+			fields = []string{
+				"resource.uri",
+				"noteName",
+				"vulnerability.packageIssue.affectedLocation.package",
+				"vulnerability.packageIssue.affectedLocation.version",
+			}
+		})
+
+		It("should return the same key for occurrences with the same key fields", func() {
+			other := vulnerabilityOccurrence("1.1.1")
+			other.Remediation = fake.LetterN(10)
+
+			expectedKey, err := occurrenceKey(projectId, occurrence, fields)
+			Expect(err).ToNot(HaveOccurred())
+			actualKey, err := occurrenceKey(projectId, other, fields)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(actualKey).To(Equal(expectedKey))
+		})
+
+		It("should return a different key when a value within a list differs", func() {
+			expectedKey, err := occurrenceKey(projectId, occurrence, fields)
+			Expect(err).ToNot(HaveOccurred())
+			actualKey, err := occurrenceKey(projectId, vulnerabilityOccurrence("1.1.2"), fields)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(actualKey).ToNot(Equal(expectedKey))
+		})
+
+		It("should return a different key for another project", func() {
+			expectedKey, err := occurrenceKey(projectId, occurrence, fields)
+			Expect(err).ToNot(HaveOccurred())
+			actualKey, err := occurrenceKey(fake.LetterN(11), occurrence, fields)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(actualKey).ToNot(Equal(expectedKey))
+		})
+
+		It("should keep the value of each field separate", func() {
+			fields = []string{"resource.uri", "remediation"}
+
+			expectedKey, err := occurrenceKey(projectId, occurrence, fields)
+			Expect(err).ToNot(HaveOccurred())
+
+			occurrence.Remediation = occurrence.Resource.Uri
+			occurrence.Resource.Uri = ""
+			actualKey, err := occurrenceKey(projectId, occurrence, fields)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(actualKey).ToNot(Equal(expectedKey))
+		})
+	})
+})
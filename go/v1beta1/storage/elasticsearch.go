@@ -16,16 +16,19 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
-	"github.com/google/uuid"
 	"github.com/rode/es-index-manager/indexmanager"
 	"github.com/rode/grafeas-elasticsearch/go/config"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/events"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -43,6 +46,7 @@ const (
 	occurrencesDocumentKind = "occurrences"
 	notesDocumentKind       = "notes"
 	sortField               = "createTime"
+	scoreSortField          = "_score"
 )
 
 type ElasticsearchStorage struct {
@@ -51,20 +55,27 @@ type ElasticsearchStorage struct {
 	filterer     filtering.Filterer
 	indexManager indexmanager.IndexManager
 	logger       *zap.Logger
+	publisher    events.Publisher
+	// eventQueue holds the events waiting to be published by RunEvents
+	eventQueue chan *events.Event
 }
 
+// NewElasticsearchStorage returns the storage backend. The publisher is optional, and no events are published without one.
 func NewElasticsearchStorage(
 	logger *zap.Logger,
 	client esutil.Client,
 	filterer filtering.Filterer,
 	config *config.ElasticsearchConfig,
-	indexManager indexmanager.IndexManager) *ElasticsearchStorage {
+	indexManager indexmanager.IndexManager,
+	publisher events.Publisher) *ElasticsearchStorage {
 	return &ElasticsearchStorage{
 		client,
 		config,
 		filterer,
 		indexManager,
 		logger,
+		publisher,
+		make(chan *events.Event, eventQueueSize),
 	}
 }
 
@@ -73,15 +84,62 @@ func (es *ElasticsearchStorage) Initialize(ctx context.Context) error {
 		return err
 	}
 
-	return es.indexManager.CreateIndex(ctx, es.projectsIndex(), es.projectsAlias(), projectDocumentKind)
+	if es.config.Rollover.Enabled {
+		if err := es.initializeRollover(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := es.indexManager.CreateIndex(ctx, es.projectsIndex(), es.projectsAlias(), projectDocumentKind); err != nil {
+		return err
+	}
+
+	// projects created before rollover was enabled need a write alias before occurrences can be written to them
+	if es.config.Rollover.Enabled {
+		if err := es.migrateRolloverProjects(ctx); err != nil {
+			return err
+		}
+	}
+
+	if es.historyEnabled() {
+		if err := es.indexManager.CreateIndex(ctx, es.revisionsIndex(), es.revisionsAlias(), revisionsDocumentKind); err != nil {
+			return err
+		}
+	}
+
+	if es.auditEnabled() {
+		if err := es.indexManager.CreateIndex(ctx, es.auditIndex(), es.auditAlias(), auditDocumentKind); err != nil {
+			return err
+		}
+	}
+
+	if es.outboxEnabled() {
+		if err := es.indexManager.CreateIndex(ctx, es.outboxIndex(), es.outboxAlias(), outboxDocumentKind); err != nil {
+			return err
+		}
+	}
+
+	if es.sharedLayoutEnabled() {
+		return es.initializeSharedLayout(ctx)
+	}
+
+	return nil
 }
 
 // CreateProject creates a project document within the project index, along with two indices that can be used
 // to store notes and occurrences.
 // Additional metadata is attached to the newly created indices to help identify them as part of a Grafeas project
-func (es *ElasticsearchStorage) CreateProject(ctx context.Context, projectId string, project *prpb.Project) (*prpb.Project, error) {
+// In the shared and joined layouts, the project's aliases are added to the shared indices instead.
+func (es *ElasticsearchStorage) CreateProject(ctx context.Context, projectId string, project *prpb.Project) (created *prpb.Project, err error) {
 	projectName := fmt.Sprintf("projects/%s", projectId)
 	log := es.logger.Named("CreateProject").With(zap.String("project", projectName))
+	var written *change
+	defer func() {
+		es.audit(ctx, log, AuditOperationCreate, projectDocumentKind, projectName, "", nil, created, written, err)
+		if err == nil {
+			es.publish(ctx, log, events.Created, projectDocumentKind, projectName, "", nil, created)
+		}
+	}()
 
 	exists, err := es.doesProjectExist(ctx, log, projectId)
 	if err != nil {
@@ -93,25 +151,38 @@ func (es *ElasticsearchStorage) CreateProject(ctx context.Context, projectId str
 	}
 	project.Name = projectName
 
-	_, err = es.client.Create(ctx, &esutil.CreateRequest{
+	written = &change{
+		eventType:      events.Created,
+		resourceKind:   projectDocumentKind,
+		resource:       projectName,
+		current:        project,
+		auditOperation: AuditOperationCreate,
+	}
+	err = es.createDocument(ctx, log, &esutil.CreateRequest{
 		Index:   es.projectsAlias(),
 		Message: proto.MessageV2(project),
 		Refresh: string(es.config.Refresh),
-	})
+	}, esutil.BULK_CREATE, written)
 	if err != nil {
 		return nil, createError(log, "error creating project in elasticsearch", err)
 	}
 
-	indicesToCreate := []struct {
+	if es.sharedLayoutEnabled() {
+		if err := es.createProjectAliases(ctx, projectId); err != nil {
+			return nil, createError(log, "error creating project aliases", err)
+		}
+
+		log.Debug("created project")
+
+		return project, nil
+	}
+
+	type indexToCreate struct {
 		documentKind string
 		indexName    string
 		aliasName    string
-	}{
-		{
-			documentKind: occurrencesDocumentKind,
-			indexName:    es.occurrencesIndex(projectId),
-			aliasName:    es.occurrencesAlias(projectId),
-		},
+	}
+	indicesToCreate := []indexToCreate{
 		{
 			documentKind: notesDocumentKind,
 			indexName:    es.notesIndex(projectId),
@@ -119,6 +190,20 @@ func (es *ElasticsearchStorage) CreateProject(ctx context.Context, projectId str
 		},
 	}
 
+	if es.config.Rollover.Enabled {
+		if err := es.createRolloverIndex(ctx, projectId); err != nil {
+			return nil, createError(log, "error creating index", err)
+		}
+	} else {
+		indicesToCreate = append([]indexToCreate{
+			{
+				documentKind: occurrencesDocumentKind,
+				indexName:    es.occurrencesIndex(projectId),
+				aliasName:    es.occurrencesAlias(projectId),
+			},
+		}, indicesToCreate...)
+	}
+
 	// create indices for occurrences and notes
 	for _, settings := range indicesToCreate {
 		if err := es.indexManager.CreateIndex(ctx, settings.indexName, settings.aliasName, settings.documentKind); err != nil {
@@ -159,7 +244,7 @@ func (es *ElasticsearchStorage) ListProjects(ctx context.Context, filter string,
 	var projects []*prpb.Project
 	log := es.logger.Named("ListProjects")
 
-	res, nextPageToken, err := es.genericList(ctx, log, es.projectsAlias(), filter, false, pageToken, int32(pageSize))
+	res, nextPageToken, err := es.genericList(ctx, log, projectDocumentKind, es.projectsAlias(), filter, false, nil, pageToken, int32(pageSize))
 	if err != nil {
 		return nil, "", err
 	}
@@ -168,7 +253,7 @@ func (es *ElasticsearchStorage) ListProjects(ctx context.Context, filter string,
 		hitLogger := log.With(zap.String("project raw", string(hit.Source)))
 
 		project := &prpb.Project{}
-		err := protojson.Unmarshal(hit.Source, proto.MessageV2(project))
+		err := decodeDocument(hit.Source, project)
 		if err != nil {
 			log.Error("failed to convert _doc to project", zap.Error(err))
 			return nil, "", createError(hitLogger, "error converting _doc to project", err)
@@ -184,10 +269,20 @@ func (es *ElasticsearchStorage) ListProjects(ctx context.Context, filter string,
 
 // DeleteProject deletes the project with the given projectId from Elasticsearch
 // Note that this will always return a 500 due to a bug in Grafeas
-func (es *ElasticsearchStorage) DeleteProject(ctx context.Context, projectId string) error {
+func (es *ElasticsearchStorage) DeleteProject(ctx context.Context, projectId string) (err error) {
 	projectName := fmt.Sprintf("projects/%s", projectId)
 	log := es.logger.Named("DeleteProject").With(zap.String("project", projectName))
 	log.Debug("deleting project")
+	var (
+		previous proto.Message
+		written  *change
+	)
+	defer func() {
+		es.audit(ctx, log, AuditOperationDelete, projectDocumentKind, projectName, "", nil, nil, written, err)
+		if err == nil {
+			es.publish(ctx, log, events.Deleted, projectDocumentKind, projectName, "", previous, nil)
+		}
+	}()
 
 	search := &esutil.EsSearch{
 		Query: &filtering.Query{
@@ -197,21 +292,60 @@ func (es *ElasticsearchStorage) DeleteProject(ctx context.Context, projectId str
 		},
 	}
 
-	err := es.client.Delete(ctx, &esutil.DeleteRequest{
+	// the project's revisions are deleted along with it, so it's only needed for the event and for deleting it along with its audit record
+	var target *esutil.EsSearchResponseHit
+	if es.eventsEnabled() || es.auditEnabled() {
+		previous = &prpb.Project{}
+		if target, err = es.genericGet(ctx, log, search, es.projectsAlias(), previous); err != nil {
+			return err
+		}
+	}
+
+	written = &change{
+		eventType:      events.Deleted,
+		resourceKind:   projectDocumentKind,
+		resource:       projectName,
+		previous:       previous,
+		auditOperation: AuditOperationDelete,
+	}
+	err = es.deleteDocument(ctx, log, &esutil.DeleteRequest{
 		Index:   es.projectsAlias(),
 		Search:  search,
 		Refresh: es.config.Refresh.String(),
-	})
+	}, target, written)
 	if err != nil {
 		return createError(log, "error deleting project in elasticsearch", err)
 	}
 
 	log.Debug("project document deleted")
 
+	if es.historyEnabled() {
+		if err := es.deleteProjectRevisions(ctx, projectId); err != nil {
+			return createError(log, "error deleting project revisions", err)
+		}
+	}
+
+	if es.sharedLayoutEnabled() {
+		if err := es.deleteProjectDocuments(ctx, projectId); err != nil {
+			return createError(log, "error deleting project notes / occurrences", err)
+		}
+
+		log.Debug("project notes / occurrences deleted")
+
+		return nil
+	}
+
 	indicesToDelete := []string{
-		es.occurrencesIndex(projectId),
 		es.notesIndex(projectId),
 	}
+	if es.config.Rollover.Enabled {
+		if err := es.deleteRolloverIndices(ctx, projectId); err != nil {
+			return createError(log, "error deleting elasticsearch indices", err)
+		}
+	} else {
+		indicesToDelete = append([]string{es.occurrencesIndex(projectId)}, indicesToDelete...)
+	}
+
 	for _, index := range indicesToDelete {
 		err = es.indexManager.DeleteIndex(ctx, index)
 		if err != nil {
@@ -252,7 +386,7 @@ func (es *ElasticsearchStorage) ListOccurrences(ctx context.Context, projectId,
 	projectName := fmt.Sprintf("projects/%s", projectId)
 	log := es.logger.Named("ListOccurrences").With(zap.String("project", projectName))
 
-	res, nextPageToken, err := es.genericList(ctx, log, es.occurrencesAlias(projectId), filter, true, pageToken, pageSize)
+	res, nextPageToken, err := es.genericList(ctx, log, occurrencesDocumentKind, es.occurrencesAlias(projectId), filter, true, nil, pageToken, pageSize)
 	if err != nil {
 		return nil, "", err
 	}
@@ -262,7 +396,7 @@ func (es *ElasticsearchStorage) ListOccurrences(ctx context.Context, projectId,
 		hitLogger := log.With(zap.String("occurrence raw", string(hit.Source)))
 
 		occurrence := &pb.Occurrence{}
-		err := protojson.Unmarshal(hit.Source, proto.MessageV2(occurrence))
+		err := decodeDocument(hit.Source, occurrence)
 		if err != nil {
 			log.Error("failed to convert _doc to occurrence", zap.Error(err))
 			return nil, "", createError(hitLogger, "error converting _doc to occurrence", err)
@@ -277,8 +411,20 @@ func (es *ElasticsearchStorage) ListOccurrences(ctx context.Context, projectId,
 }
 
 // CreateOccurrence adds the specified occurrence to Elasticsearch
-func (es *ElasticsearchStorage) CreateOccurrence(ctx context.Context, projectId, userID string, occurrence *pb.Occurrence) (*pb.Occurrence, error) {
+func (es *ElasticsearchStorage) CreateOccurrence(ctx context.Context, projectId, userID string, occurrence *pb.Occurrence) (created *pb.Occurrence, err error) {
 	log := es.logger.Named("CreateOccurrence")
+	var (
+		// the occurrence replaced by this one in upsert mode
+		previous *pb.Occurrence
+		written  *change
+	)
+	defer func() {
+		es.audit(ctx, log, AuditOperationCreate, occurrencesDocumentKind, auditResource(projectId, occurrence), userID, previous, created, written, err)
+		if err == nil {
+			c := occurrenceChange(created, previous, userID)
+			es.publish(ctx, log, c.eventType, occurrencesDocumentKind, created.Name, userID, c.previous, created)
+		}
+	}()
 
 	exists, err := es.doesProjectExist(ctx, log, projectId)
 	if err != nil {
@@ -289,16 +435,29 @@ func (es *ElasticsearchStorage) CreateOccurrence(ctx context.Context, projectId,
 		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("project with ID %s does not exist", projectId))
 	}
 
-	if occurrence.CreateTime == nil {
-		occurrence.CreateTime = ptypes.TimestampNow()
+	prepared, errs, err := es.prepareOccurrences(ctx, log, projectId, es.actingUserID(ctx, userID), []*pb.Occurrence{occurrence})
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
 	}
-	occurrence.Name = fmt.Sprintf("projects/%s/occurrences/%s", projectId, uuid.New().String())
+	previous = prepared.previous[0]
 
-	_, err = es.client.Create(ctx, &esutil.CreateRequest{
-		Index:   es.occurrencesAlias(projectId),
-		Message: proto.MessageV2(occurrence),
-		Refresh: string(es.config.Refresh),
-	})
+	fields, err := es.occurrenceFields(ctx, log, projectId, prepared.occurrences, prepared.metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	written = occurrenceChange(occurrence, previous, userID)
+	err = es.createDocument(ctx, log, &esutil.CreateRequest{
+		Index:      es.occurrencesWriteAlias(projectId),
+		DocumentId: prepared.documentIds[0],
+		Message:    proto.MessageV2(occurrence),
+		Refresh:    string(es.config.Refresh),
+		Join:       es.occurrenceJoin(occurrence),
+		Fields:     fields[0],
+	}, es.occurrenceBulkOperation(), written)
 	if err != nil {
 		return nil, createError(log, "error creating occurrence in elasticsearch", err)
 	}
@@ -309,8 +468,29 @@ func (es *ElasticsearchStorage) CreateOccurrence(ctx context.Context, projectId,
 // BatchCreateOccurrences batch creates the specified occurrences in Elasticsearch.
 // This method uses the ES "_bulk" API: https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
 // This method will return all of the occurrences that were successfully created, and all of the errors that were encountered (if any)
-func (es *ElasticsearchStorage) BatchCreateOccurrences(ctx context.Context, projectId string, uID string, occurrences []*pb.Occurrence) ([]*pb.Occurrence, []error) {
+func (es *ElasticsearchStorage) BatchCreateOccurrences(ctx context.Context, projectId string, uID string, occurrences []*pb.Occurrence) (created []*pb.Occurrence, errs []error) {
 	log := es.logger.Named("BatchCreateOccurrences")
+	var (
+		// the changes made by the occurrences that were written, which are updates for those that replaced an occurrence in upsert mode
+		madeChanges []*change
+		// set when the bulk request is written along with the audit records of its occurrences, which leaves only the errors before it to audit
+		bulkAudited bool
+		preBulkErrs int
+	)
+	defer func() {
+		var messages []proto.Message
+		unaudited := errs
+		if bulkAudited {
+			unaudited = errs[:preBulkErrs]
+		} else {
+			for _, occurrence := range created {
+				messages = append(messages, occurrence)
+			}
+		}
+		es.auditBatch(ctx, log, occurrencesDocumentKind, projectId, uID, messages, unaudited)
+		es.publishBatch(ctx, log, madeChanges)
+	}()
+
 	exists, err := es.doesProjectExist(ctx, log, projectId)
 	if err != nil {
 		return nil, []error{err}
@@ -321,46 +501,82 @@ func (es *ElasticsearchStorage) BatchCreateOccurrences(ctx context.Context, proj
 	}
 	log.Debug("creating occurrences")
 
-	var bulkRequestItems []*esutil.BulkRequestItem
-	for _, occurrence := range occurrences {
-		occurrence.Name = fmt.Sprintf("projects/%s/occurrences/%s", projectId, uuid.New().String())
-		if occurrence.CreateTime == nil {
-			occurrence.CreateTime = ptypes.TimestampNow()
-		}
+	prepared, errs, err := es.prepareOccurrences(ctx, log, projectId, es.actingUserID(ctx, uID), occurrences)
+	if err != nil {
+		return nil, []error{err}
+	}
+	occurrencesToCreate := prepared.occurrences
+	if len(occurrencesToCreate) == 0 {
+		log.Debug("all occurrences already exist")
+		return nil, errs
+	}
 
+	fields, err := es.occurrenceFields(ctx, log, projectId, occurrencesToCreate, prepared.metadata)
+	if err != nil {
+		return nil, append(errs, err)
+	}
+
+	var (
+		bulkRequestItems []*esutil.BulkRequestItem
+		changes          []*change
+	)
+	for i, occurrence := range occurrencesToCreate {
 		bulkRequestItems = append(bulkRequestItems, &esutil.BulkRequestItem{
-			Operation: esutil.BULK_CREATE,
-			Message:   proto.MessageV2(occurrence),
+			Operation:  es.occurrenceBulkOperation(),
+			DocumentId: prepared.documentIds[i],
+			Message:    proto.MessageV2(occurrence),
+			Join:       es.occurrenceJoin(occurrence),
+			Fields:     fields[i],
 		})
+		changes = append(changes, occurrenceChange(occurrence, prepared.previous[i], uID))
+	}
+
+	bulkRequestItems, auditRecords := es.withAudit(ctx, log, bulkRequestItems, changes)
+	bulkRequestItems, outboxEntries, err := es.withOutbox(ctx, bulkRequestItems, changes)
+	if err != nil {
+		return nil, append(errs, createError(log, "error creating outbox entries", err))
 	}
 
 	response, err := es.client.Bulk(ctx, &esutil.BulkRequest{
-		Index:   es.occurrencesAlias(projectId),
+		Index:   es.occurrencesWriteAlias(projectId),
 		Refresh: string(es.config.Refresh),
 		Items:   bulkRequestItems,
 	})
 	if err != nil {
-		return nil, []error{
-			createError(log, "error bulk creating documents in elasticsearch", err),
-		}
+		return nil, append(errs, createError(log, "error bulk creating documents in elasticsearch", err))
 	}
+	bulkAudited = auditRecords != nil
+	preBulkErrs = len(errs)
 
 	// each indexing operation in this bulk request has its own status
 	// we need to iterate over each of the items in the response to know whether or not that particular occurrence was created successfully
-	var (
-		createdOccurrences []*pb.Occurrence
-		errs               []error
-	)
-	for i, occurrence := range occurrences {
+	var createdOccurrences []*pb.Occurrence
+	succeeded := make([]bool, len(occurrencesToCreate))
+	itemErrs := make([]error, len(occurrencesToCreate))
+	for i, occurrence := range occurrencesToCreate {
 		createItem := response.Items[i].Create
+		if createItem == nil {
+			createItem = response.Items[i].Index
+		}
 		if occErr := createItem.Error; occErr != nil {
-			errs = append(errs, createError(log, "error creating occurrence in ES", fmt.Errorf("[%d] %s: %s", createItem.Status, occErr.Type, occErr.Reason), zap.Any("occurrence", occurrence)))
+			// another occurrence with the same key was created after checking for duplicates, such as one earlier in this batch
+			if createItem.Status == http.StatusConflict {
+				itemErrs[i] = status.Errorf(codes.AlreadyExists, "occurrence with the name %s already exists", occurrence.Name)
+			} else {
+				itemErrs[i] = createError(log, "error creating occurrence in ES", fmt.Errorf("[%d] %s: %s", createItem.Status, occErr.Type, occErr.Reason), zap.Any("occurrence", occurrence))
+			}
+			errs = append(errs, itemErrs[i])
 			continue
 		}
 
+		succeeded[i] = true
 		createdOccurrences = append(createdOccurrences, occurrence)
+		madeChanges = append(madeChanges, changes[i])
 	}
 
+	responses := es.settleAudit(ctx, log, auditRecords, itemErrs, response.Items[len(occurrencesToCreate):])
+	es.settleOutbox(ctx, log, outboxEntries, succeeded, responses)
+
 	if len(errs) > 0 {
 		log.Info("errors while creating occurrences", zap.Any("errors", errs))
 
@@ -373,9 +589,19 @@ func (es *ElasticsearchStorage) BatchCreateOccurrences(ctx context.Context, proj
 }
 
 // UpdateOccurrence updates the existing occurrence with the given projectId and occurrenceId
-func (es *ElasticsearchStorage) UpdateOccurrence(ctx context.Context, projectId, occurrenceId string, o *pb.Occurrence, mask *fieldmaskpb.FieldMask) (*pb.Occurrence, error) {
+func (es *ElasticsearchStorage) UpdateOccurrence(ctx context.Context, projectId, occurrenceId string, o *pb.Occurrence, mask *fieldmaskpb.FieldMask) (updated *pb.Occurrence, err error) {
 	occurrenceName := fmt.Sprintf("projects/%s/occurrences/%s", projectId, occurrenceId)
 	log := es.logger.Named("Update Occurrence").With(zap.String("occurrence", occurrenceName))
+	var (
+		previous proto.Message
+		written  *change
+	)
+	defer func() {
+		es.audit(ctx, log, AuditOperationUpdate, occurrencesDocumentKind, occurrenceName, "", previous, updated, written, err)
+		if err == nil {
+			es.publish(ctx, log, events.Updated, occurrencesDocumentKind, occurrenceName, "", previous, updated)
+		}
+	}()
 
 	search := &esutil.EsSearch{
 		Query: &filtering.Query{
@@ -387,11 +613,13 @@ func (es *ElasticsearchStorage) UpdateOccurrence(ctx context.Context, projectId,
 
 	occurrence := &pb.Occurrence{}
 
-	targetDocumentID, err := es.genericGet(ctx, log, search, es.occurrencesAlias(projectId), occurrence)
+	target, err := es.genericGet(ctx, log, search, es.occurrencesAlias(projectId), occurrence)
 
 	if err != nil {
 		return nil, err
 	}
+	previous = proto.Clone(occurrence)
+	noteName := occurrence.NoteName
 
 	if o.UpdateTime == nil {
 		mask.Paths = append(mask.Paths, "UpdateTime")
@@ -405,12 +633,41 @@ func (es *ElasticsearchStorage) UpdateOccurrence(ctx context.Context, projectId,
 	}
 	fieldmask_utils.StructToStruct(m, o, occurrence)
 
-	_, err = es.client.Update(ctx, &esutil.UpdateRequest{
-		Index:      es.occurrencesAlias(projectId),
-		DocumentId: targetDocumentID,
+	// an occurrence is stored on the same shard as its note in the joined layout, so it can't be moved to another note
+	if es.joinedLayoutEnabled() && occurrence.NoteName != noteName {
+		return nil, status.Error(codes.InvalidArgument, "the note of an occurrence can't be changed")
+	}
+
+	// the document is replaced, so the note's fields are copied again, in case the occurrence was moved to another note
+	metadata, err := es.updatedMetadata(ctx, target.Source)
+	if err != nil {
+		return nil, createError(log, "error unmarshalling occurrence metadata", err)
+	}
+
+	fields, err := es.occurrenceFields(ctx, log, projectId, []*pb.Occurrence{occurrence}, []*DocumentMetadata{metadata})
+	if err != nil {
+		return nil, err
+	}
+
+	written = &change{
+		eventType:      events.Updated,
+		resourceKind:   occurrencesDocumentKind,
+		resource:       occurrenceName,
+		previous:       previous,
+		revision:       es.newRevision(ctx, RevisionOperationUpdate, mask),
+		current:        occurrence,
+		auditOperation: AuditOperationUpdate,
+	}
+	// the occurrence is updated in the index that it was found in, which may not be the newest when rollover is enabled
+	err = es.updateDocument(ctx, log, &esutil.UpdateRequest{
+		Index:      target.Index,
+		DocumentId: target.ID,
 		Message:    proto.MessageV2(occurrence),
 		Refresh:    es.config.Refresh.String(),
-	})
+		Routing:    es.documentRouting(projectId),
+		Join:       es.occurrenceJoin(occurrence),
+		Fields:     fields[0],
+	}, written)
 	if err != nil {
 		return nil, createError(log, "error updating occurrence in elasticsearch", err)
 	}
@@ -419,9 +676,19 @@ func (es *ElasticsearchStorage) UpdateOccurrence(ctx context.Context, projectId,
 }
 
 // DeleteOccurrence deletes the occurrence with the given projectId and occurrenceId
-func (es *ElasticsearchStorage) DeleteOccurrence(ctx context.Context, projectId, occurrenceId string) error {
+func (es *ElasticsearchStorage) DeleteOccurrence(ctx context.Context, projectId, occurrenceId string) (err error) {
 	occurrenceName := fmt.Sprintf("projects/%s/occurrences/%s", projectId, occurrenceId)
 	log := es.logger.Named("DeleteOccurrence").With(zap.String("occurrence", occurrenceName))
+	var (
+		previous proto.Message
+		written  *change
+	)
+	defer func() {
+		es.audit(ctx, log, AuditOperationDelete, occurrencesDocumentKind, occurrenceName, "", nil, nil, written, err)
+		if err == nil {
+			es.publish(ctx, log, events.Deleted, occurrencesDocumentKind, occurrenceName, "", previous, nil)
+		}
+	}()
 
 	log.Debug("deleting occurrence")
 
@@ -433,11 +700,24 @@ func (es *ElasticsearchStorage) DeleteOccurrence(ctx context.Context, projectId,
 		},
 	}
 
-	err := es.client.Delete(ctx, &esutil.DeleteRequest{
+	target, previous, err := es.getDeleted(ctx, log, search, es.occurrencesAlias(projectId), &pb.Occurrence{})
+	if err != nil {
+		return err
+	}
+
+	written = &change{
+		eventType:      events.Deleted,
+		resourceKind:   occurrencesDocumentKind,
+		resource:       occurrenceName,
+		previous:       previous,
+		revision:       es.newRevision(ctx, RevisionOperationDelete, nil),
+		auditOperation: AuditOperationDelete,
+	}
+	err = es.deleteDocument(ctx, log, &esutil.DeleteRequest{
 		Index:   es.occurrencesAlias(projectId),
 		Search:  search,
 		Refresh: es.config.Refresh.String(),
-	})
+	}, target, written)
 	if err != nil {
 		return createError(log, "error deleting occurrence in elasticsearch", err)
 	}
@@ -473,7 +753,7 @@ func (es *ElasticsearchStorage) ListNotes(ctx context.Context, projectId, filter
 	projectName := fmt.Sprintf("projects/%s", projectId)
 	log := es.logger.Named("ListNotes").With(zap.String("project", projectName))
 
-	res, nextPageToken, err := es.genericList(ctx, log, es.notesAlias(projectId), filter, true, pageToken, pageSize)
+	res, nextPageToken, err := es.genericList(ctx, log, notesDocumentKind, es.notesAlias(projectId), filter, true, nil, pageToken, pageSize)
 	if err != nil {
 		return nil, "", err
 	}
@@ -483,7 +763,7 @@ func (es *ElasticsearchStorage) ListNotes(ctx context.Context, projectId, filter
 		hitLogger := log.With(zap.String("note raw", string(hit.Source)))
 
 		note := &pb.Note{}
-		err := protojson.Unmarshal(hit.Source, proto.MessageV2(note))
+		err := decodeDocument(hit.Source, note)
 		if err != nil {
 			log.Error("failed to convert _doc to note", zap.Error(err))
 			return nil, "", createError(hitLogger, "error converting _doc to note", err)
@@ -498,9 +778,16 @@ func (es *ElasticsearchStorage) ListNotes(ctx context.Context, projectId, filter
 }
 
 // CreateNote adds the specified note
-func (es *ElasticsearchStorage) CreateNote(ctx context.Context, projectId, noteId, uID string, note *pb.Note) (*pb.Note, error) {
+func (es *ElasticsearchStorage) CreateNote(ctx context.Context, projectId, noteId, uID string, note *pb.Note) (created *pb.Note, err error) {
 	noteName := fmt.Sprintf("projects/%s/notes/%s", projectId, noteId)
 	log := es.logger.Named("CreateNote").With(zap.String("note", noteName))
+	var written *change
+	defer func() {
+		es.audit(ctx, log, AuditOperationCreate, notesDocumentKind, noteName, uID, nil, created, written, err)
+		if err == nil {
+			es.publish(ctx, log, events.Created, notesDocumentKind, noteName, uID, nil, created)
+		}
+	}()
 
 	exists, err := es.doesProjectExist(ctx, log, projectId)
 	if err != nil {
@@ -532,11 +819,22 @@ func (es *ElasticsearchStorage) CreateNote(ctx context.Context, projectId, noteI
 	}
 	note.Name = noteName
 
-	_, err = es.client.Create(ctx, &esutil.CreateRequest{
-		Index:   es.notesAlias(projectId),
-		Message: proto.MessageV2(note),
-		Refresh: string(es.config.Refresh),
-	})
+	written = &change{
+		eventType:      events.Created,
+		resourceKind:   notesDocumentKind,
+		resource:       noteName,
+		userID:         uID,
+		current:        note,
+		auditOperation: AuditOperationCreate,
+	}
+	err = es.createDocument(ctx, log, &esutil.CreateRequest{
+		Index:      es.notesAlias(projectId),
+		DocumentId: es.noteDocumentId(note),
+		Message:    proto.MessageV2(note),
+		Refresh:    string(es.config.Refresh),
+		Join:       es.noteJoin(),
+		Fields:     withMetadata(es.documentFields(projectId), &DocumentMetadata{CreatedBy: es.actingUserID(ctx, uID)}),
+	}, esutil.BULK_CREATE, written)
 	if err != nil {
 		return nil, createError(log, "error creating note in elasticsearch", err)
 	}
@@ -545,8 +843,37 @@ func (es *ElasticsearchStorage) CreateNote(ctx context.Context, projectId, noteI
 }
 
 // BatchCreateNotes batch creates the specified notes in elasticsearch.
-func (es *ElasticsearchStorage) BatchCreateNotes(ctx context.Context, projectId, uID string, notesWithNoteIds map[string]*pb.Note) ([]*pb.Note, []error) {
+func (es *ElasticsearchStorage) BatchCreateNotes(ctx context.Context, projectId, uID string, notesWithNoteIds map[string]*pb.Note) (created []*pb.Note, errs []error) {
 	log := es.logger.Named("BatchCreateNotes").With(zap.String("projectId", projectId))
+	var (
+		// set when the bulk request is written along with the audit records of its notes, which leaves only the errors before it to audit
+		bulkAudited bool
+		preBulkErrs int
+	)
+	defer func() {
+		var messages []proto.Message
+		unaudited := errs
+		if bulkAudited {
+			unaudited = errs[:preBulkErrs]
+		} else {
+			for _, note := range created {
+				messages = append(messages, note)
+			}
+		}
+		es.auditBatch(ctx, log, notesDocumentKind, projectId, uID, messages, unaudited)
+
+		var changes []*change
+		for _, note := range created {
+			changes = append(changes, &change{
+				eventType:    events.Created,
+				resourceKind: notesDocumentKind,
+				resource:     note.Name,
+				userID:       uID,
+				current:      note,
+			})
+		}
+		es.publishBatch(ctx, log, changes)
+	}()
 
 	log.Debug("creating notes")
 
@@ -590,10 +917,7 @@ func (es *ElasticsearchStorage) BatchCreateNotes(ctx context.Context, projectId,
 		}
 	}
 
-	var (
-		notesToCreate []*pb.Note
-		errs          []error
-	)
+	var notesToCreate []*pb.Note
 	for i, res := range multiSearchResponse.Responses {
 		if res.Hits.Total.Value != 0 {
 			errs = append(errs, status.Errorf(codes.AlreadyExists, "note with the name %s already exists", notes[i].Name))
@@ -607,14 +931,35 @@ func (es *ElasticsearchStorage) BatchCreateNotes(ctx context.Context, projectId,
 		return nil, errs
 	}
 
-	var bulkRequestItems []*esutil.BulkRequestItem
+	metadata := &DocumentMetadata{CreatedBy: es.actingUserID(ctx, uID)}
+	var (
+		bulkRequestItems []*esutil.BulkRequestItem
+		changes          []*change
+	)
 	for _, note := range notesToCreate {
 		bulkRequestItems = append(bulkRequestItems, &esutil.BulkRequestItem{
-			Operation: esutil.BULK_CREATE,
-			Message:   proto.MessageV2(note),
+			Operation:  esutil.BULK_CREATE,
+			DocumentId: es.noteDocumentId(note),
+			Message:    proto.MessageV2(note),
+			Join:       es.noteJoin(),
+			Fields:     withMetadata(es.documentFields(projectId), metadata),
+		})
+		changes = append(changes, &change{
+			eventType:      events.Created,
+			resourceKind:   notesDocumentKind,
+			resource:       note.Name,
+			userID:         uID,
+			current:        note,
+			auditOperation: AuditOperationCreate,
 		})
 	}
 
+	bulkRequestItems, auditRecords := es.withAudit(ctx, log, bulkRequestItems, changes)
+	bulkRequestItems, outboxEntries, err := es.withOutbox(ctx, bulkRequestItems, changes)
+	if err != nil {
+		return nil, append(errs, createError(log, "error creating outbox entries", err))
+	}
+
 	bulkResponse, err := es.client.Bulk(ctx, &esutil.BulkRequest{
 		Index:   es.notesAlias(projectId),
 		Refresh: es.config.Refresh.String(),
@@ -623,21 +968,30 @@ func (es *ElasticsearchStorage) BatchCreateNotes(ctx context.Context, projectId,
 	if err != nil {
 		return nil, append(errs, createError(log, "error bulk creating documents in elasticsearch", err))
 	}
+	bulkAudited = auditRecords != nil
+	preBulkErrs = len(errs)
 
 	// each indexing operation in this bulk request has its own status
 	// we need to iterate over each of the items in the response to know whether or not that particular note was created successfully
 	var createdNotes []*pb.Note
+	succeeded := make([]bool, len(notesToCreate))
+	itemErrs := make([]error, len(notesToCreate))
 	for i, note := range notesToCreate {
 		createItem := bulkResponse.Items[i].Create
 		if createDocError := createItem.Error; createDocError != nil {
-			errs = append(errs, createError(log, "error creating note in ES", fmt.Errorf("[%d] %s: %s", createItem.Status, createDocError.Type, createDocError.Reason), zap.Any("note", note)))
+			itemErrs[i] = createError(log, "error creating note in ES", fmt.Errorf("[%d] %s: %s", createItem.Status, createDocError.Type, createDocError.Reason), zap.Any("note", note))
+			errs = append(errs, itemErrs[i])
 			continue
 		}
 
+		succeeded[i] = true
 		createdNotes = append(createdNotes, note)
 		log.Debug(fmt.Sprintf("note %s created", note.Name))
 	}
 
+	responses := es.settleAudit(ctx, log, auditRecords, itemErrs, bulkResponse.Items[len(notesToCreate):])
+	es.settleOutbox(ctx, log, outboxEntries, succeeded, responses)
+
 	if len(errs) > 0 {
 		log.Info("errors while creating notes", zap.Any("errors", errs))
 
@@ -649,15 +1003,103 @@ func (es *ElasticsearchStorage) BatchCreateNotes(ctx context.Context, projectId,
 	return createdNotes, nil
 }
 
-// UpdateNote updates the existing note with the given pID and nID
-func (es *ElasticsearchStorage) UpdateNote(ctx context.Context, pID, nID string, n *pb.Note, mask *fieldmaskpb.FieldMask) (*pb.Note, error) {
-	return nil, nil
+// UpdateNote updates the existing note with the given projectId and noteId.
+// When enrichment is enabled, the fields copied from the note are also updated in each of its occurrences.
+// A failure to update the occurrences doesn't fail the update, since the note has already been written.
+func (es *ElasticsearchStorage) UpdateNote(ctx context.Context, projectId, noteId string, n *pb.Note, mask *fieldmaskpb.FieldMask) (updated *pb.Note, err error) {
+	noteName := fmt.Sprintf("projects/%s/notes/%s", projectId, noteId)
+	log := es.logger.Named("UpdateNote").With(zap.String("note", noteName))
+	var (
+		previous proto.Message
+		written  *change
+	)
+	defer func() {
+		es.audit(ctx, log, AuditOperationUpdate, notesDocumentKind, noteName, "", previous, updated, written, err)
+		if err == nil {
+			es.publish(ctx, log, events.Updated, notesDocumentKind, noteName, "", previous, updated)
+		}
+	}()
+
+	search := &esutil.EsSearch{
+		Query: &filtering.Query{
+			Term: &filtering.Term{
+				"name": noteName,
+			},
+		},
+	}
+
+	note := &pb.Note{}
+
+	target, err := es.genericGet(ctx, log, search, es.notesAlias(projectId), note)
+	if err != nil {
+		return nil, err
+	}
+	previous = proto.Clone(note)
+
+	if mask == nil {
+		mask = &fieldmaskpb.FieldMask{}
+	}
+
+	if n.UpdateTime == nil {
+		mask.Paths = append(mask.Paths, "UpdateTime")
+		n.UpdateTime = ptypes.TimestampNow()
+	}
+
+	m, err := fieldmask_utils.MaskFromPaths(mask.Paths, generator.CamelCase)
+	if err != nil {
+		log.Info("errors while mapping masks", zap.Any("errors", err))
+		return nil, status.Errorf(codes.InvalidArgument, "invalid update mask: %s", err)
+	}
+	fieldmask_utils.StructToStruct(m, n, note)
+	// occurrences refer to the note by name, so it can't be renamed
+	note.Name = noteName
+
+	metadata, err := es.updatedMetadata(ctx, target.Source)
+	if err != nil {
+		return nil, createError(log, "error unmarshalling note metadata", err)
+	}
+
+	written = &change{
+		eventType:      events.Updated,
+		resourceKind:   notesDocumentKind,
+		resource:       noteName,
+		previous:       previous,
+		revision:       es.newRevision(ctx, RevisionOperationUpdate, mask),
+		current:        note,
+		auditOperation: AuditOperationUpdate,
+	}
+	err = es.updateDocument(ctx, log, &esutil.UpdateRequest{
+		Index:      target.Index,
+		DocumentId: target.ID,
+		Message:    proto.MessageV2(note),
+		Refresh:    es.config.Refresh.String(),
+		Routing:    es.documentRouting(projectId),
+		Join:       es.noteJoin(),
+		Fields:     withMetadata(es.documentFields(projectId), metadata),
+	}, written)
+	if err != nil {
+		return nil, createError(log, "error updating note in elasticsearch", err)
+	}
+
+	es.updateNoteOccurrences(ctx, log, note)
+
+	return note, nil
 }
 
 // DeleteNote deletes the note with the given pID and nID
-func (es *ElasticsearchStorage) DeleteNote(ctx context.Context, projectId, noteId string) error {
+func (es *ElasticsearchStorage) DeleteNote(ctx context.Context, projectId, noteId string) (err error) {
 	noteName := fmt.Sprintf("projects/%s/notes/%s", projectId, noteId)
 	log := es.logger.Named("DeleteNote").With(zap.String("note", noteName))
+	var (
+		previous proto.Message
+		written  *change
+	)
+	defer func() {
+		es.audit(ctx, log, AuditOperationDelete, notesDocumentKind, noteName, "", nil, nil, written, err)
+		if err == nil {
+			es.publish(ctx, log, events.Deleted, notesDocumentKind, noteName, "", previous, nil)
+		}
+	}()
 
 	log.Debug("deleting note")
 
@@ -669,11 +1111,24 @@ func (es *ElasticsearchStorage) DeleteNote(ctx context.Context, projectId, noteI
 		},
 	}
 
-	err := es.client.Delete(ctx, &esutil.DeleteRequest{
+	target, previous, err := es.getDeleted(ctx, log, search, es.notesAlias(projectId), &pb.Note{})
+	if err != nil {
+		return err
+	}
+
+	written = &change{
+		eventType:      events.Deleted,
+		resourceKind:   notesDocumentKind,
+		resource:       noteName,
+		previous:       previous,
+		revision:       es.newRevision(ctx, RevisionOperationDelete, nil),
+		auditOperation: AuditOperationDelete,
+	}
+	err = es.deleteDocument(ctx, log, &esutil.DeleteRequest{
 		Index:   es.notesAlias(projectId),
 		Search:  search,
 		Refresh: es.config.Refresh.String(),
-	})
+	}, target, written)
 	if err != nil {
 		return createError(log, "error deleting note in elasticsearch", err)
 	}
@@ -696,39 +1151,77 @@ func (es *ElasticsearchStorage) GetVulnerabilityOccurrencesSummary(ctx context.C
 	return &pb.VulnerabilityOccurrencesSummary{}, nil
 }
 
-func (es *ElasticsearchStorage) genericGet(ctx context.Context, log *zap.Logger, search *esutil.EsSearch, index string, protoMessage interface{}) (string, error) {
+// genericGet unmarshals the first document matching the search into protoMessage, returning the hit so that callers know where the document is stored
+func (es *ElasticsearchStorage) genericGet(ctx context.Context, log *zap.Logger, search *esutil.EsSearch, index string, protoMessage interface{}) (*esutil.EsSearchResponseHit, error) {
 	res, err := es.client.Search(ctx, &esutil.SearchRequest{
 		Index:  index,
 		Search: search,
 	})
 	if err != nil {
-		return "", createError(log, "error searching elasticsearch for document", err)
+		return nil, createError(log, "error searching elasticsearch for document", err)
 	}
 
 	if res.Hits.Total.Value == 0 {
 		log.Debug("document not found", zap.Any("search", search))
-		return "", status.Error(codes.NotFound, fmt.Sprintf("%T not found", protoMessage))
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("%T not found", protoMessage))
+	}
+
+	return res.Hits.Hits[0], decodeDocument(res.Hits.Hits[0].Source, protoMessage)
+}
+
+// getDeleted returns the document that's about to be deleted, and where it's stored, when it's needed for a revision, an event
+// or for deleting it along with its audit record. Otherwise, it returns nil.
+func (es *ElasticsearchStorage) getDeleted(ctx context.Context, log *zap.Logger, search *esutil.EsSearch, index string, message proto.Message) (*esutil.EsSearchResponseHit, proto.Message, error) {
+	if !es.historyEnabled() && !es.eventsEnabled() && !es.auditEnabled() {
+		return nil, nil, nil
 	}
 
-	return res.Hits.Hits[0].ID, protojson.Unmarshal(res.Hits.Hits[0].Source, proto.MessageV2(protoMessage))
+	target, err := es.genericGet(ctx, log, search, index, message)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return target, message, nil
 }
 
-func (es *ElasticsearchStorage) genericList(ctx context.Context, log *zap.Logger, index, filter string, sort bool, pageToken string, pageSize int32) (*esutil.EsSearchResponseHits, string, error) {
-	search := &esutil.EsSearch{}
+// decodeDocument unmarshals a document's source into the protobuf message. Fields that aren't part of the message,
+// such as the project field in the shared and joined layouts, are ignored.
+func decodeDocument(source []byte, protoMessage interface{}) error {
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(source, proto.MessageV2(protoMessage))
+}
+
+// genericList searches the index for documents matching the filter. search may be used to add options to the request,
+// and any query that it contains is applied along with the filter.
+func (es *ElasticsearchStorage) genericList(ctx context.Context, log *zap.Logger, documentKind, index, filter string, sort bool, search *esutil.EsSearch, pageToken string, pageSize int32) (*esutil.EsSearchResponseHits, string, error) {
+	if search == nil {
+		search = &esutil.EsSearch{}
+	}
+
 	if filter != "" {
 		log = log.With(zap.String("filter", filter))
-		filterQuery, err := es.filterer.ParseExpression(filter)
+		filterQuery, err := es.filterQuery(log, documentKind, filter)
 		if err != nil {
-			return nil, "", createError(log, "error while parsing filter expression", err)
+			return nil, "", err
 		}
 
-		search.Query = filterQuery
+		if search.Query == nil {
+			search.Query = filterQuery
+		} else {
+			search.Query = &filtering.Query{
+				Bool: &filtering.Bool{
+					Must:   &filtering.Must{filterQuery},
+					Filter: &filtering.Filter{search.Query},
+				},
+			}
+		}
 	}
 
 	if sort {
-		search.Sort = map[string]esutil.EsSortOrder{
-			sortField: esutil.EsSortOrderDescending,
+		// full-text searches are ranked by relevance first, with ties broken by the creation time
+		if filtering.IsScored(search.Query) {
+			search.Sort = append(search.Sort, esutil.EsSortField{Field: scoreSortField, Order: esutil.EsSortOrderDescending})
 		}
+		search.Sort = append(search.Sort, esutil.EsSortField{Field: sortField, Order: esutil.EsSortOrderDescending})
 	}
 
 	res, err := es.client.Search(ctx, &esutil.SearchRequest{
@@ -746,6 +1239,46 @@ func (es *ElasticsearchStorage) genericList(ctx context.Context, log *zap.Logger
 	return res.Hits, res.NextPageToken, nil
 }
 
+// filterQuery parses a user-supplied filter, returning an InvalidArgument error if it isn't valid
+func (es *ElasticsearchStorage) filterQuery(log *zap.Logger, documentKind, filter string) (*filtering.Query, error) {
+	query, err := es.parseFilter(documentKind, filter)
+	if err != nil {
+		var filterErr *filtering.FilterError
+		if errors.As(err, &filterErr) {
+			log.Debug("invalid filter expression", zap.Error(err))
+			return nil, invalidFilterError(filterErr)
+		}
+
+		return nil, createError(log, "error while parsing filter expression", err)
+	}
+
+	return query, nil
+}
+
+// parseFilter translates the filter into an Elasticsearch query, type checking it against the message
+// stored for the document kind if configured to do so
+func (es *ElasticsearchStorage) parseFilter(documentKind, filter string) (*filtering.Query, error) {
+	parents := es.filterParents(documentKind)
+	// audit records aren't protobuf messages, so there's nothing to check their filters against
+	if !es.config.Filter.TypeCheck || documentKind == auditDocumentKind {
+		return es.filterer.ParseExpression(filter, parents...)
+	}
+
+	var message proto.Message
+	switch documentKind {
+	case projectDocumentKind:
+		message = &prpb.Project{}
+	case occurrencesDocumentKind:
+		message = &pb.Occurrence{}
+	case notesDocumentKind:
+		message = &pb.Note{}
+	default:
+		return nil, fmt.Errorf("unable to type check filter for unknown document kind %s", documentKind)
+	}
+
+	return es.filterer.ParseCheckedExpression(filter, proto.MessageV2(message), parents...)
+}
+
 // createError is a helper function that allows you to easily log an error and return a gRPC formatted error.
 func createError(log *zap.Logger, message string, err error, fields ...zap.Field) error {
 	log.Error(message, append(fields, zap.Error(err))...)
@@ -753,6 +1286,25 @@ func createError(log *zap.Logger, message string, err error, fields ...zap.Field
 	return status.Errorf(codes.Internal, "%s: %s", message, err)
 }
 
+// invalidFilterError converts a problem with a user-supplied filter into an InvalidArgument error,
+// with a field violation for each issue so that clients can point to the offending part of the expression.
+func invalidFilterError(filterErr *filtering.FilterError) error {
+	badRequest := &errdetails.BadRequest{}
+	for _, issue := range filterErr.Issues {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "filter",
+			Description: issue.String(),
+		})
+	}
+
+	st, err := status.New(codes.InvalidArgument, filterErr.Error()).WithDetails(badRequest)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, filterErr.Error())
+	}
+
+	return st.Err()
+}
+
 func (es *ElasticsearchStorage) doesProjectExist(ctx context.Context, log *zap.Logger, projectId string) (bool, error) {
 	projectName := fmt.Sprintf("projects/%s", projectId)
 	// check if project already exists
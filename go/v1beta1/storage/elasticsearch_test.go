@@ -18,7 +18,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
 
@@ -30,10 +32,13 @@ import (
 	"github.com/rode/grafeas-elasticsearch/go/mocks"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
 
@@ -106,7 +111,7 @@ var _ = Describe("elasticsearch storage", func() {
 	})
 
 	JustBeforeEach(func() {
-		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager)
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
 	})
 
 	AfterEach(func() {
@@ -460,6 +465,35 @@ var _ = Describe("elasticsearch storage", func() {
 			})
 		})
 
+		When("filter type checking is enabled", func() {
+			BeforeEach(func() {
+				esConfig.Filter.TypeCheck = true
+				expectedQuery = &filtering.Query{
+					Term: &filtering.Term{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				}
+				expectedFilter = fake.LetterN(10)
+
+				filterer.
+					EXPECT().
+					ParseCheckedExpression(expectedFilter, gomock.Any()).
+					DoAndReturn(func(_ string, message protoreflect.ProtoMessage) (*filtering.Query, error) {
+						Expect(message.ProtoReflect().Descriptor().FullName()).To(Equal(proto.MessageV2(&prpb.Project{}).ProtoReflect().Descriptor().FullName()))
+
+						return expectedQuery, nil
+					})
+			})
+
+			It("should check the filter against the project schema and send the parsed query to elasticsearch", func() {
+				Expect(client.SearchCallCount()).To(Equal(1))
+
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
+			})
+		})
+
 		When("an invalid filter is specified", func() {
 			BeforeEach(func() {
 				expectedFilter = fake.LetterN(10)
@@ -911,6 +945,100 @@ var _ = Describe("elasticsearch storage", func() {
 				Expect(actualOccurrence).To(Equal(expectedOccurrence))
 			})
 		})
+
+		When("deduplication is enabled", func() {
+			var (
+				expectedKey              string
+				expectedMultiGetResponse *esutil.EsMultiGetResponse
+			)
+
+			BeforeEach(func() {
+				esConfig.Dedupe = config.DedupeConfig{
+					Fields: []string{"resource.uri", "noteName"},
+					Mode:   config.DedupeModeUpsert,
+				}
+
+				key, err := occurrenceKey(expectedProjectId, expectedOccurrence, esConfig.Dedupe.Fields)
+				Expect(err).ToNot(HaveOccurred())
+				expectedKey = key
+
+				expectedMultiGetResponse = &esutil.EsMultiGetResponse{
+					Docs: []*esutil.EsGetResponse{
+						{
+							Id:    expectedKey,
+							Found: false,
+						},
+					},
+				}
+				client.MultiGetReturns(expectedMultiGetResponse, nil)
+			})
+
+			It("should check for an existing occurrence with the same key", func() {
+				Expect(client.MultiGetCallCount()).To(Equal(1))
+
+				_, multiGetRequest := client.MultiGetArgsForCall(0)
+				Expect(multiGetRequest.Index).To(Equal(expectedOccurrencesAlias))
+				Expect(multiGetRequest.DocumentIds).To(ConsistOf(expectedKey))
+			})
+
+			It("should use the key as the document ID and occurrence name", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(client.CreateCallCount()).To(Equal(1))
+
+				_, createRequest := client.CreateArgsForCall(0)
+				Expect(createRequest.DocumentId).To(Equal(expectedKey))
+				Expect(actualOccurrence.Name).To(Equal(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, expectedKey)))
+				Expect(actualOccurrence.UpdateTime).To(BeNil())
+			})
+
+			When("an occurrence with the same key exists", func() {
+				var existingOccurrence *pb.Occurrence
+
+				BeforeEach(func() {
+					existingOccurrence = deepCopyOccurrence(expectedOccurrence)
+					existingOccurrence.CreateTime = timestamppb.New(time.Now().Add(-time.Hour))
+					existingJson, err := protojson.Marshal(proto.MessageV2(existingOccurrence))
+					Expect(err).ToNot(HaveOccurred())
+
+					expectedMultiGetResponse.Docs[0].Found = true
+					expectedMultiGetResponse.Docs[0].Source = existingJson
+				})
+
+				It("should replace the existing occurrence, keeping its create time", func() {
+					Expect(actualErr).ToNot(HaveOccurred())
+					Expect(client.CreateCallCount()).To(Equal(1))
+
+					_, createRequest := client.CreateArgsForCall(0)
+					Expect(createRequest.DocumentId).To(Equal(expectedKey))
+					Expect(actualOccurrence.CreateTime.AsTime()).To(Equal(existingOccurrence.CreateTime.AsTime()))
+					Expect(actualOccurrence.UpdateTime).ToNot(BeNil())
+				})
+
+				When("the dedupe mode is reject", func() {
+					BeforeEach(func() {
+						esConfig.Dedupe.Mode = config.DedupeModeReject
+					})
+
+					It("should return an error without creating the occurrence", func() {
+						Expect(actualOccurrence).To(BeNil())
+						assertErrorHasGrpcStatusCode(actualErr, codes.AlreadyExists)
+						Expect(client.CreateCallCount()).To(Equal(0))
+					})
+				})
+			})
+
+			When("checking for an existing occurrence fails", func() {
+				BeforeEach(func() {
+					client.MultiGetReturns(nil, errors.New("multiget failed"))
+				})
+
+				It("should return an error", func() {
+					Expect(actualOccurrence).To(BeNil())
+					assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+					Expect(client.CreateCallCount()).To(Equal(0))
+				})
+			})
+		})
 	})
 
 	Context("BatchCreateOccurrences", func() {
@@ -1102,6 +1230,92 @@ var _ = Describe("elasticsearch storage", func() {
 				assertErrorHasGrpcStatusCode(actualErrs[0], codes.Internal)
 			})
 		})
+
+		When("deduplication is enabled", func() {
+			var expectedMultiGetResponse *esutil.EsMultiGetResponse
+
+			BeforeEach(func() {
+				esConfig.Dedupe = config.DedupeConfig{
+					Fields: []string{"resource.uri", "noteName"},
+					Mode:   config.DedupeModeUpsert,
+				}
+
+				expectedMultiGetResponse = &esutil.EsMultiGetResponse{}
+				for _, item := range expectedBulkCreateResponse.Items {
+					expectedMultiGetResponse.Docs = append(expectedMultiGetResponse.Docs, &esutil.EsGetResponse{Found: false})
+					item.Index = item.Create
+					item.Create = nil
+				}
+				client.MultiGetReturns(expectedMultiGetResponse, nil)
+			})
+
+			It("should index each occurrence using its key as the document ID", func() {
+				Expect(actualErrs).To(BeEmpty())
+				Expect(client.BulkCallCount()).To(Equal(1))
+
+				_, bulkRequest := client.BulkArgsForCall(0)
+				Expect(bulkRequest.Items).To(HaveLen(len(expectedOccurrences)))
+				for i, item := range bulkRequest.Items {
+					key, err := occurrenceKey(expectedProjectId, expectedOccurrences[i], esConfig.Dedupe.Fields)
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(item.Operation).To(Equal(esutil.BULK_INDEX))
+					Expect(item.DocumentId).To(Equal(key))
+					Expect(actualOccurrences[i].Name).To(Equal(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, key)))
+				}
+			})
+
+			When("the dedupe mode is reject and an occurrence already exists", func() {
+				var existingIndex int
+
+				BeforeEach(func() {
+					esConfig.Dedupe.Mode = config.DedupeModeReject
+					existingIndex = fake.Number(0, len(expectedOccurrences)-1)
+					expectedMultiGetResponse.Docs[existingIndex].Found = true
+
+					expectedBulkCreateResponse.Items = expectedBulkCreateResponse.Items[1:]
+					for _, item := range expectedBulkCreateResponse.Items {
+						item.Create = item.Index
+						item.Index = nil
+					}
+				})
+
+				It("should only create the new occurrences", func() {
+					_, bulkRequest := client.BulkArgsForCall(0)
+					Expect(bulkRequest.Items).To(HaveLen(len(expectedOccurrences) - 1))
+					for _, item := range bulkRequest.Items {
+						Expect(item.Operation).To(Equal(esutil.BULK_CREATE))
+					}
+
+					Expect(actualOccurrences).To(HaveLen(len(expectedOccurrences) - 1))
+					Expect(actualErrs).To(HaveLen(1))
+					assertErrorHasGrpcStatusCode(actualErrs[0], codes.AlreadyExists)
+				})
+			})
+
+			When("two occurrences in the batch have the same key", func() {
+				BeforeEach(func() {
+					esConfig.Dedupe.Mode = config.DedupeModeReject
+					expectedOccurrences[1] = deepCopyOccurrence(expectedOccurrences[0])
+
+					for _, item := range expectedBulkCreateResponse.Items {
+						item.Create = item.Index
+						item.Index = nil
+					}
+					expectedBulkCreateResponse.Items[1].Create.Status = http.StatusConflict
+					expectedBulkCreateResponse.Items[1].Create.Error = &esutil.EsIndexDocError{
+						Type:   "version_conflict_engine_exception",
+						Reason: "document already exists",
+					}
+				})
+
+				It("should return an error for the duplicate", func() {
+					Expect(actualOccurrences).To(HaveLen(len(expectedOccurrences) - 1))
+					Expect(actualErrs).To(HaveLen(1))
+					assertErrorHasGrpcStatusCode(actualErrs[0], codes.AlreadyExists)
+				})
+			})
+		})
 	})
 
 	Context("UpdateOccurrence", func() {
@@ -1150,6 +1364,7 @@ var _ = Describe("elasticsearch storage", func() {
 					Hits: []*esutil.EsSearchResponseHit{
 						{
 							ID:     expectedDocumentId,
+							Index:  expectedOccurrencesIndex,
 							Source: occurrenceJson,
 						},
 					},
@@ -1182,7 +1397,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 			_, updateRequest := client.UpdateArgsForCall(0)
 
-			Expect(updateRequest.Index).To(Equal(expectedOccurrencesAlias))
+			Expect(updateRequest.Index).To(Equal(expectedOccurrencesIndex))
 			Expect(updateRequest.DocumentId).To(Equal(expectedDocumentId))
 
 			occurrence := proto.MessageV1(updateRequest.Message).(*grafeas_go_proto.Occurrence)
@@ -1427,8 +1642,9 @@ var _ = Describe("elasticsearch storage", func() {
 			Expect(searchRequest.Pagination.Size).To(Equal(expectedPageSize))
 			Expect(searchRequest.Pagination.Token).To(Equal(expectedPageToken))
 
-			Expect(searchRequest.Search.Sort).NotTo(BeNil())
-			Expect(searchRequest.Search.Sort[sortField]).To(Equal(esutil.EsSortOrderDescending))
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: sortField, Order: esutil.EsSortOrderDescending},
+			}))
 			Expect(searchRequest.Search.Query).To(BeNil())
 		})
 
@@ -1443,7 +1659,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 				filterer.
 					EXPECT().
-					ParseExpression(expectedFilter).
+					ParseExpression(expectedFilter, gomock.Any()).
 					Return(expectedQuery, nil)
 			})
 
@@ -1452,6 +1668,69 @@ var _ = Describe("elasticsearch storage", func() {
 
 				_, searchRequest := client.SearchArgsForCall(0)
 
+				Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
+				Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+					{Field: sortField, Order: esutil.EsSortOrderDescending},
+				}))
+			})
+		})
+
+		When("the filter is a full-text search", func() {
+			BeforeEach(func() {
+				expectedQuery = &filtering.Query{
+					MultiMatch: &filtering.MultiMatch{
+						Query: fake.LetterN(10),
+					},
+				}
+				expectedFilter = fake.LetterN(10)
+
+				filterer.
+					EXPECT().
+					ParseExpression(expectedFilter, gomock.Any()).
+					Return(expectedQuery, nil)
+			})
+
+			It("should sort by relevance, then by creation time", func() {
+				Expect(client.SearchCallCount()).To(Equal(1))
+
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
+				Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+					{Field: scoreSortField, Order: esutil.EsSortOrderDescending},
+					{Field: sortField, Order: esutil.EsSortOrderDescending},
+				}))
+
+				_, requestJson := esutil.EncodeRequest(searchRequest.Search)
+				Expect(requestJson).To(ContainSubstring(`"sort":[{"_score":"desc"},{"createTime":"desc"}]`))
+			})
+		})
+
+		When("filter type checking is enabled", func() {
+			BeforeEach(func() {
+				esConfig.Filter.TypeCheck = true
+				expectedQuery = &filtering.Query{
+					Term: &filtering.Term{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				}
+				expectedFilter = fake.LetterN(10)
+
+				filterer.
+					EXPECT().
+					ParseCheckedExpression(expectedFilter, gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ string, message protoreflect.ProtoMessage, _ ...filtering.Parent) (*filtering.Query, error) {
+						Expect(message.ProtoReflect().Descriptor().FullName()).To(Equal(proto.MessageV2(&pb.Occurrence{}).ProtoReflect().Descriptor().FullName()))
+
+						return expectedQuery, nil
+					})
+			})
+
+			It("should check the filter against the occurrence schema and send the parsed query to elasticsearch", func() {
+				Expect(client.SearchCallCount()).To(Equal(1))
+
+				_, searchRequest := client.SearchArgsForCall(0)
+
 				Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
 			})
 		})
@@ -1462,7 +1741,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 				filterer.
 					EXPECT().
-					ParseExpression(expectedFilter).
+					ParseExpression(expectedFilter, gomock.Any()).
 					Return(nil, errors.New(fake.LetterN(10)))
 			})
 
@@ -1477,6 +1756,48 @@ var _ = Describe("elasticsearch storage", func() {
 			})
 		})
 
+		When("the filterer rejects the filter as invalid", func() {
+			var expectedIssue *filtering.FilterIssue
+
+			BeforeEach(func() {
+				expectedFilter = fake.LetterN(10)
+				expectedIssue = &filtering.FilterIssue{
+					Message: fake.LetterN(10),
+					Line:    1,
+					Column:  fake.Number(1, 10),
+				}
+
+				filterer.
+					EXPECT().
+					ParseExpression(expectedFilter, gomock.Any()).
+					Return(nil, &filtering.FilterError{
+						Message: fake.LetterN(10),
+						Issues:  []*filtering.FilterIssue{expectedIssue},
+					})
+			})
+
+			It("should not send a request to elasticsearch", func() {
+				Expect(client.SearchCallCount()).To(Equal(0))
+			})
+
+			It("should return an invalid argument error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
+				Expect(actualOccurrences).To(BeNil())
+				Expect(actualNextPageToken).To(BeEmpty())
+			})
+
+			It("should describe the issue in the error details", func() {
+				details := status.Convert(actualErr).Details()
+				Expect(details).To(HaveLen(1))
+
+				badRequest, ok := details[0].(*errdetails.BadRequest)
+				Expect(ok).To(BeTrue())
+				Expect(badRequest.FieldViolations).To(HaveLen(1))
+				Expect(badRequest.FieldViolations[0].Field).To(Equal("filter"))
+				Expect(badRequest.FieldViolations[0].Description).To(Equal(expectedIssue.String()))
+			})
+		})
+
 		When("elasticsearch successfully returns occurrence(s)", func() {
 			It("should return the Grafeas occurrence(s)", func() {
 				Expect(actualOccurrences).ToNot(BeNil())
@@ -2253,7 +2574,9 @@ var _ = Describe("elasticsearch storage", func() {
 			Expect(searchRequest.Pagination.Size).To(Equal(expectedPageSize))
 			Expect(searchRequest.Pagination.Token).To(Equal(expectedPageToken))
 
-			Expect(searchRequest.Search.Sort[sortField]).To(Equal(esutil.EsSortOrderDescending))
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: sortField, Order: esutil.EsSortOrderDescending},
+			}))
 
 			Expect(searchRequest.Search.Query).To(BeNil())
 		})
@@ -2275,7 +2598,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 				filterer.
 					EXPECT().
-					ParseExpression(expectedFilter).
+					ParseExpression(expectedFilter, gomock.Any()).
 					Return(expectedQuery, nil)
 			})
 
@@ -2284,6 +2607,69 @@ var _ = Describe("elasticsearch storage", func() {
 
 				_, searchRequest := client.SearchArgsForCall(0)
 
+				Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
+				Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+					{Field: sortField, Order: esutil.EsSortOrderDescending},
+				}))
+			})
+		})
+
+		When("the filter is a full-text search", func() {
+			BeforeEach(func() {
+				expectedQuery = &filtering.Query{
+					MultiMatch: &filtering.MultiMatch{
+						Query: fake.LetterN(10),
+					},
+				}
+				expectedFilter = fake.LetterN(10)
+
+				filterer.
+					EXPECT().
+					ParseExpression(expectedFilter, gomock.Any()).
+					Return(expectedQuery, nil)
+			})
+
+			It("should sort by relevance, then by creation time", func() {
+				Expect(client.SearchCallCount()).To(Equal(1))
+
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
+				Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+					{Field: scoreSortField, Order: esutil.EsSortOrderDescending},
+					{Field: sortField, Order: esutil.EsSortOrderDescending},
+				}))
+
+				_, requestJson := esutil.EncodeRequest(searchRequest.Search)
+				Expect(requestJson).To(ContainSubstring(`"sort":[{"_score":"desc"},{"createTime":"desc"}]`))
+			})
+		})
+
+		When("filter type checking is enabled", func() {
+			BeforeEach(func() {
+				esConfig.Filter.TypeCheck = true
+				expectedQuery = &filtering.Query{
+					Term: &filtering.Term{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				}
+				expectedFilter = fake.LetterN(10)
+
+				filterer.
+					EXPECT().
+					ParseCheckedExpression(expectedFilter, gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ string, message protoreflect.ProtoMessage, _ ...filtering.Parent) (*filtering.Query, error) {
+						Expect(message.ProtoReflect().Descriptor().FullName()).To(Equal(proto.MessageV2(&pb.Note{}).ProtoReflect().Descriptor().FullName()))
+
+						return expectedQuery, nil
+					})
+			})
+
+			It("should check the filter against the note schema and send the parsed query to elasticsearch", func() {
+				Expect(client.SearchCallCount()).To(Equal(1))
+
+				_, searchRequest := client.SearchArgsForCall(0)
+
 				Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
 			})
 		})
@@ -2294,7 +2680,7 @@ var _ = Describe("elasticsearch storage", func() {
 
 				filterer.
 					EXPECT().
-					ParseExpression(expectedFilter).
+					ParseExpression(expectedFilter, gomock.Any()).
 					Return(nil, errors.New(fake.LetterN(10)))
 			})
 
@@ -2338,6 +2724,138 @@ var _ = Describe("elasticsearch storage", func() {
 		})
 	})
 
+	Context("UpdateNote", func() {
+		var (
+			expectedNoteId     string
+			expectedNoteName   string
+			expectedDocumentId string
+			notePatchData      *pb.Note
+			fieldMask          *fieldmaskpb.FieldMask
+			actualErr          error
+			actualNote         *pb.Note
+
+			expectedSearchResponse *esutil.SearchResponse
+			expectedUpdateError    error
+		)
+
+		BeforeEach(func() {
+			expectedDocumentId = fake.LetterN(10)
+			expectedNoteId = fake.LetterN(10)
+			expectedNoteName = fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, expectedNoteId)
+			notePatchData = &pb.Note{
+				ShortDescription: "updatedvalue",
+			}
+			fieldMask = &fieldmaskpb.FieldMask{
+				Paths: []string{"shortDescription"},
+			}
+
+			noteJson, err := protojson.Marshal(proto.MessageV2(generateTestNote(expectedNoteName)))
+			Expect(err).ToNot(HaveOccurred())
+
+			expectedSearchResponse = &esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{
+						Value: 1,
+					},
+					Hits: []*esutil.EsSearchResponseHit{
+						{
+							ID:     expectedDocumentId,
+							Index:  expectedNotesIndex,
+							Source: noteJson,
+						},
+					},
+				},
+			}
+			expectedUpdateError = nil
+		})
+
+		JustBeforeEach(func() {
+			client.SearchReturns(expectedSearchResponse, nil)
+			client.UpdateReturns(nil, expectedUpdateError)
+			actualNote, actualErr = elasticsearchStorage.UpdateNote(ctx, expectedProjectId, expectedNoteId, notePatchData, fieldMask)
+		})
+
+		It("should have sent a request to elasticsearch to retrieve the note document", func() {
+			Expect(client.SearchCallCount()).To(Equal(1))
+
+			_, searchRequest := client.SearchArgsForCall(0)
+
+			Expect(searchRequest.Index).To(Equal(expectedNotesAlias))
+			Expect((*searchRequest.Search.Query.Term)["name"]).To(Equal(expectedNoteName))
+		})
+
+		It("should have sent a request to elasticsearch to update the note document", func() {
+			Expect(client.UpdateCallCount()).To(Equal(1))
+
+			_, updateRequest := client.UpdateArgsForCall(0)
+
+			Expect(updateRequest.Index).To(Equal(expectedNotesIndex))
+			Expect(updateRequest.DocumentId).To(Equal(expectedDocumentId))
+			Expect(updateRequest.Refresh).To(Equal(esConfig.Refresh.String()))
+
+			note := proto.MessageV1(updateRequest.Message).(*pb.Note)
+			Expect(note.ShortDescription).To(Equal("updatedvalue"))
+		})
+
+		It("should return the updated note", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualNote.Name).To(Equal(expectedNoteName))
+			Expect(actualNote.ShortDescription).To(Equal("updatedvalue"))
+			Expect(actualNote.UpdateTime).ToNot(BeNil())
+		})
+
+		It("should not update the note's occurrences", func() {
+			Expect(client.UpdateByQueryCallCount()).To(Equal(0))
+		})
+
+		When("the update mask includes the name", func() {
+			BeforeEach(func() {
+				notePatchData.Name = fake.LetterN(10)
+				fieldMask.Paths = append(fieldMask.Paths, "name")
+			})
+
+			It("should not rename the note", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualNote.Name).To(Equal(expectedNoteName))
+			})
+		})
+
+		When("the note does not exist", func() {
+			BeforeEach(func() {
+				expectedSearchResponse.Hits.Total.Value = 0
+				expectedSearchResponse.Hits.Hits = []*esutil.EsSearchResponseHit{}
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+				Expect(client.UpdateCallCount()).To(Equal(0))
+			})
+		})
+
+		When("elasticsearch fails to update the note document", func() {
+			BeforeEach(func() {
+				expectedUpdateError = errors.New("update failed")
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			})
+		})
+
+		When("using a badly formatted field mask", func() {
+			BeforeEach(func() {
+				fieldMask = &fieldmaskpb.FieldMask{
+					Paths: []string{"shortDescription..bro"},
+				}
+			})
+
+			It("should return an invalid argument error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
+				Expect(client.UpdateCallCount()).To(Equal(0))
+			})
+		})
+	})
+
 	Context("DeleteNote", func() {
 		var (
 			actualErr        error
@@ -0,0 +1,210 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// enrichmentField holds the fields copied from an occurrence's note, which filters refer to with the same prefix, e.g. _note.kind
+	enrichmentField = "_note"
+	// enrichmentScript replaces the copied fields of each occurrence of a note that was updated
+	enrichmentScript = "ctx._source['" + enrichmentField + "'] = params.note"
+)
+
+func (es *ElasticsearchStorage) enrichmentEnabled() bool {
+	return len(es.config.Enrichment.NoteFields) > 0
+}
+
+// occurrenceFields returns the fields to add to each occurrence's document, alongside the fields of the message and its metadata.
+// When enrichment is enabled, the configured fields of each occurrence's note are copied into the document.
+// Occurrences of notes that don't exist aren't enriched.
+func (es *ElasticsearchStorage) occurrenceFields(ctx context.Context, log *zap.Logger, projectId string, occurrences []*pb.Occurrence, metadata []*DocumentMetadata) ([]map[string]interface{}, error) {
+	fields := make([]map[string]interface{}, len(occurrences))
+	for i := range occurrences {
+		fields[i] = withMetadata(es.documentFields(projectId), metadata[i])
+	}
+
+	if !es.enrichmentEnabled() {
+		return fields, nil
+	}
+
+	notes, err := es.findNotes(ctx, log, occurrences)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, occurrence := range occurrences {
+		note, ok := notes[occurrence.NoteName]
+		if !ok {
+			log.Debug("note not found, skipping enrichment", zap.String("note", occurrence.NoteName))
+			continue
+		}
+
+		noteFields, err := enrichmentFields(note, es.config.Enrichment.NoteFields)
+		if err != nil {
+			return nil, createError(log, "error copying note fields", err)
+		}
+
+		if fields[i] == nil {
+			fields[i] = map[string]interface{}{}
+		}
+		fields[i][enrichmentField] = noteFields
+	}
+
+	return fields, nil
+}
+
+// findNotes looks up the notes of the occurrences by name, which can be in any project
+func (es *ElasticsearchStorage) findNotes(ctx context.Context, log *zap.Logger, occurrences []*pb.Occurrence) (map[string]*pb.Note, error) {
+	var (
+		noteNames []string
+		searches  []*esutil.EsSearch
+	)
+	seen := map[string]bool{}
+	for _, occurrence := range occurrences {
+		if occurrence.NoteName == "" || seen[occurrence.NoteName] {
+			continue
+		}
+		seen[occurrence.NoteName] = true
+
+		noteNames = append(noteNames, occurrence.NoteName)
+		searches = append(searches, &esutil.EsSearch{
+			Query: &filtering.Query{
+				Term: &filtering.Term{
+					"name": occurrence.NoteName,
+				},
+			},
+		})
+	}
+
+	notes := map[string]*pb.Note{}
+	if len(searches) == 0 {
+		return notes, nil
+	}
+
+	res, err := es.client.MultiSearch(ctx, &esutil.MultiSearchRequest{
+		Index:    es.notesAlias(allProjects),
+		Searches: searches,
+	})
+	if err != nil {
+		return nil, createError(log, "error searching elasticsearch for the notes of occurrences", err)
+	}
+
+	for i, response := range res.Responses {
+		if response.Hits == nil || len(response.Hits.Hits) == 0 {
+			continue
+		}
+
+		note := &pb.Note{}
+		if err := decodeDocument(response.Hits.Hits[0].Source, note); err != nil {
+			return nil, createError(log, "error converting _doc to note", err)
+		}
+
+		notes[noteNames[i]] = note
+	}
+
+	return notes, nil
+}
+
+// updateNoteOccurrences copies the updated fields of the note into each of its occurrences, in every project.
+// The note has already been updated by then, so a failure is logged rather than returned, and the copies under _note stay stale
+// until the note is updated again.
+func (es *ElasticsearchStorage) updateNoteOccurrences(ctx context.Context, log *zap.Logger, note *pb.Note) {
+	if !es.enrichmentEnabled() {
+		return
+	}
+
+	noteFields, err := enrichmentFields(note, es.config.Enrichment.NoteFields)
+	if err != nil {
+		log.Error("error copying note fields, the note's occurrences weren't updated", zap.Error(err))
+		return
+	}
+
+	request := &esutil.UpdateByQueryRequest{
+		Index: es.occurrencesAlias(allProjects),
+		Query: &filtering.Query{
+			Term: &filtering.Term{
+				"noteName": note.Name,
+			},
+		},
+		Script: &esutil.EsScript{
+			Source: enrichmentScript,
+			Lang:   "painless",
+			Params: map[string]interface{}{
+				"note": noteFields,
+			},
+		},
+		Refresh: es.config.Refresh.String(),
+	}
+
+	// occurrences are stored on the same shard as their note in the joined layout
+	if es.joinedLayoutEnabled() {
+		request.Routing = note.Name
+	}
+
+	res, err := es.client.UpdateByQuery(ctx, request)
+	if err != nil {
+		log.Error("error updating the occurrences of the note in elasticsearch, their copies of the note are stale", zap.Error(err))
+		return
+	}
+	log.Debug("updated occurrences of note", zap.Int("updated", res.Updated))
+}
+
+// enrichmentFields copies the fields of the note at each JSON path, keeping their structure so that
+// vulnerability.severity is copied to _note.vulnerability.severity. Paths that pass through a list collect the value from each element.
+func enrichmentFields(note *pb.Note, paths []string) (map[string]interface{}, error) {
+	noteJson, err := protojson.Marshal(proto.MessageV2(note))
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(noteJson, &document); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	for _, path := range paths {
+		keys := strings.Split(path, ".")
+		value := fieldValue(document, keys)
+		if value == nil {
+			continue
+		}
+
+		parent := fields
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := parent[key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				parent[key] = child
+			}
+			parent = child
+		}
+		parent[keys[len(keys)-1]] = value
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,388 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/grafeas/grafeas/proto/v1beta1/common_go_proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/grafeas/grafeas/proto/v1beta1/vulnerability_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+var _ = Describe("enrichment", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId string
+		expectedNote      *pb.Note
+		expectedNoteJson  []byte
+		expectedFields    map[string]interface{}
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		expectedProjectId = fake.LetterN(10)
+
+		expectedNote = generateTestNote(fmt.Sprintf("projects/%s/notes/%s", fake.LetterN(10), fake.LetterN(10)))
+		expectedNote.Kind = common_go_proto.NoteKind_VULNERABILITY
+		expectedNote.RelatedUrl = []*common_go_proto.RelatedUrl{
+			{Url: "https://example.com/a"},
+			{Url: "https://example.com/b"},
+		}
+		expectedNote.Type = &pb.Note_Vulnerability{
+			Vulnerability: &vulnerability_go_proto.Vulnerability{
+				Severity: vulnerability_go_proto.Severity_CRITICAL,
+			},
+		}
+
+		var err error
+		expectedNoteJson, err = protojson.Marshal(proto.MessageV2(expectedNote))
+		Expect(err).ToNot(HaveOccurred())
+
+		expectedFields = map[string]interface{}{
+			"kind": "VULNERABILITY",
+			"vulnerability": map[string]interface{}{
+				"severity": "CRITICAL",
+			},
+			"relatedUrl": map[string]interface{}{
+				"url": []interface{}{"https://example.com/a", "https://example.com/b"},
+			},
+		}
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+			Enrichment: config.EnrichmentConfig{
+				NoteFields: []string{"kind", "vulnerability.severity", "relatedUrl.url", "vulnerability.cvssScore"},
+			},
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+		indexManager.IndexNameCalls(func(documentKind string, inner string) string {
+			return fmt.Sprintf("grafeas-v1-%s-%s", inner, documentKind)
+		})
+
+		projectJson, err := protojson.Marshal(proto.MessageV2(generateTestProject(expectedProjectId)))
+		Expect(err).ToNot(HaveOccurred())
+
+		client.SearchReturns(&esutil.SearchResponse{
+			Hits: &esutil.EsSearchResponseHits{
+				Total: &esutil.EsSearchResponseTotal{Value: 1},
+				Hits: []*esutil.EsSearchResponseHit{
+					{Source: projectJson},
+				},
+			},
+		}, nil)
+		client.MultiSearchReturns(&esutil.EsMultiSearchResponse{
+			Responses: []*esutil.EsMultiSearchResponseHitsSummary{
+				{
+					Hits: &esutil.EsMultiSearchResponseHits{
+						Total: &esutil.EsSearchResponseTotal{Value: 1},
+						Hits: []*esutil.EsMultiSearchResponseHit{
+							{Source: expectedNoteJson},
+						},
+					},
+				},
+			},
+		}, nil)
+		client.BulkReturns(&esutil.EsBulkResponse{
+			Items: []*esutil.EsBulkResponseItem{
+				{Create: &esutil.EsIndexDocResponse{}},
+				{Create: &esutil.EsIndexDocResponse{}},
+			},
+		}, nil)
+		client.UpdateByQueryReturns(&esutil.EsUpdateByQueryResponse{}, nil)
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("CreateOccurrence", func() {
+		var (
+			occurrence *pb.Occurrence
+			actualErr  error
+		)
+
+		BeforeEach(func() {
+			occurrence = generateTestOccurrence("")
+			occurrence.NoteName = expectedNote.Name
+		})
+
+		JustBeforeEach(func() {
+			_, actualErr = elasticsearchStorage.CreateOccurrence(ctx, expectedProjectId, "", occurrence)
+		})
+
+		It("should look up the note in every project", func() {
+			Expect(client.MultiSearchCallCount()).To(Equal(1))
+
+			_, multiSearchRequest := client.MultiSearchArgsForCall(0)
+			Expect(multiSearchRequest.Index).To(Equal("grafeas-*-notes"))
+			Expect(multiSearchRequest.Searches).To(ConsistOf(&esutil.EsSearch{
+				Query: &filtering.Query{
+					Term: &filtering.Term{
+						"name": expectedNote.Name,
+					},
+				},
+			}))
+		})
+
+		It("should copy the configured fields of the note into the occurrence", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+
+			_, createRequest := client.CreateArgsForCall(0)
+			Expect(createRequest.Fields).To(Equal(map[string]interface{}{
+				"_note": expectedFields,
+			}))
+		})
+
+		When("the note does not exist", func() {
+			BeforeEach(func() {
+				client.MultiSearchReturns(&esutil.EsMultiSearchResponse{
+					Responses: []*esutil.EsMultiSearchResponseHitsSummary{
+						{
+							Hits: &esutil.EsMultiSearchResponseHits{
+								Total: &esutil.EsSearchResponseTotal{},
+							},
+						},
+					},
+				}, nil)
+			})
+
+			It("should create the occurrence without the note's fields", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+
+				_, createRequest := client.CreateArgsForCall(0)
+				Expect(createRequest.Fields).To(BeNil())
+			})
+		})
+
+		When("looking up the note fails", func() {
+			BeforeEach(func() {
+				client.MultiSearchReturns(nil, errors.New("multi search failed"))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(client.CreateCallCount()).To(Equal(0))
+			})
+		})
+
+		When("the shared layout is enabled", func() {
+			BeforeEach(func() {
+				esConfig.Layout = config.IndexLayoutShared
+			})
+
+			It("should add the note's fields alongside the project", func() {
+				_, createRequest := client.CreateArgsForCall(0)
+				Expect(createRequest.Fields).To(Equal(map[string]interface{}{
+					"project": expectedProjectId,
+					"_note":   expectedFields,
+				}))
+			})
+		})
+
+		When("enrichment is disabled", func() {
+			BeforeEach(func() {
+				esConfig.Enrichment.NoteFields = nil
+			})
+
+			It("should not look up the note", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(client.MultiSearchCallCount()).To(Equal(0))
+
+				_, createRequest := client.CreateArgsForCall(0)
+				Expect(createRequest.Fields).To(BeNil())
+			})
+		})
+	})
+
+	Context("BatchCreateOccurrences", func() {
+		It("should look up each note once", func() {
+			occurrences := []*pb.Occurrence{generateTestOccurrence(""), generateTestOccurrence("")}
+			for _, occurrence := range occurrences {
+				occurrence.NoteName = expectedNote.Name
+			}
+
+			_, errs := elasticsearchStorage.BatchCreateOccurrences(ctx, expectedProjectId, "", occurrences)
+			Expect(errs).To(BeEmpty())
+
+			_, multiSearchRequest := client.MultiSearchArgsForCall(0)
+			Expect(multiSearchRequest.Searches).To(HaveLen(1))
+
+			_, bulkRequest := client.BulkArgsForCall(0)
+			for _, item := range bulkRequest.Items {
+				Expect(item.Fields).To(HaveKeyWithValue("_note", expectedFields))
+			}
+		})
+	})
+
+	Context("UpdateOccurrence", func() {
+		It("should copy the note's fields again", func() {
+			occurrence := generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10)))
+			occurrence.NoteName = expectedNote.Name
+			occurrenceJson, err := protojson.Marshal(proto.MessageV2(occurrence))
+			Expect(err).ToNot(HaveOccurred())
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: 1},
+					Hits: []*esutil.EsSearchResponseHit{
+						{Source: occurrenceJson},
+					},
+				},
+			}, nil)
+
+			_, err = elasticsearchStorage.UpdateOccurrence(ctx, expectedProjectId, fake.LetterN(10), generateTestOccurrence(""), &fieldmaskpb.FieldMask{Paths: []string{"Remediation"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, updateRequest := client.UpdateArgsForCall(0)
+			Expect(updateRequest.Fields).To(Equal(map[string]interface{}{
+				"_note": expectedFields,
+			}))
+		})
+	})
+
+	Context("UpdateNote", func() {
+		var (
+			expectedNoteId   string
+			expectedNoteName string
+			actualNote       *pb.Note
+			actualErr        error
+		)
+
+		BeforeEach(func() {
+			expectedNoteId = fake.LetterN(10)
+			expectedNoteName = fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, expectedNoteId)
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: 1},
+					Hits: []*esutil.EsSearchResponseHit{
+						{Source: expectedNoteJson},
+					},
+				},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			actualNote, actualErr = elasticsearchStorage.UpdateNote(ctx, expectedProjectId, expectedNoteId, &pb.Note{
+				Kind: common_go_proto.NoteKind_PACKAGE,
+			}, &fieldmaskpb.FieldMask{Paths: []string{"kind"}})
+		})
+
+		It("should update the copied fields of the note's occurrences in every project", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(client.UpdateByQueryCallCount()).To(Equal(1))
+
+			_, updateByQueryRequest := client.UpdateByQueryArgsForCall(0)
+			Expect(updateByQueryRequest.Index).To(Equal("grafeas-*-occurrences"))
+			Expect(updateByQueryRequest.Routing).To(BeEmpty())
+			Expect(updateByQueryRequest.Query).To(Equal(&filtering.Query{
+				Term: &filtering.Term{
+					"noteName": expectedNoteName,
+				},
+			}))
+
+			expectedFields["kind"] = "PACKAGE"
+			Expect(updateByQueryRequest.Script.Source).To(Equal(enrichmentScript))
+			Expect(updateByQueryRequest.Script.Params).To(Equal(map[string]interface{}{
+				"note": expectedFields,
+			}))
+		})
+
+		When("the joined layout is enabled", func() {
+			BeforeEach(func() {
+				esConfig.Layout = config.IndexLayoutJoined
+			})
+
+			It("should only update occurrences on the note's shard", func() {
+				_, updateByQueryRequest := client.UpdateByQueryArgsForCall(0)
+				Expect(updateByQueryRequest.Routing).To(Equal(expectedNoteName))
+			})
+		})
+
+		When("updating the occurrences fails", func() {
+			BeforeEach(func() {
+				client.UpdateByQueryReturns(nil, errors.New("update by query failed"))
+			})
+
+			It("should still update the note", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualNote.Kind).To(Equal(common_go_proto.NoteKind_PACKAGE))
+			})
+		})
+	})
+
+	Context("filtering occurrences", func() {
+		It("should allow filtering by the copied fields of the note", func() {
+			filter := `_note.kind == "VULNERABILITY"`
+			var actualParents []filtering.Parent
+			filterer.EXPECT().ParseExpression(filter, gomock.Any()).
+				DoAndReturn(func(_ string, parents ...filtering.Parent) (*filtering.Query, error) {
+					actualParents = parents
+					return &filtering.Query{}, nil
+				})
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{},
+				},
+			}, nil)
+
+			_, _, err := elasticsearchStorage.ListOccurrences(ctx, expectedProjectId, filter, "", 0)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(actualParents).To(HaveLen(2))
+			Expect(actualParents[1].Name).To(Equal("_note"))
+			Expect(actualParents[1].Embedded).To(BeTrue())
+		})
+	})
+})
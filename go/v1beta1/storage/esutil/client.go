@@ -22,11 +22,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
 	"go.uber.org/zap"
-	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -38,6 +39,8 @@ type CreateRequest struct {
 	Message    proto.Message
 	DocumentId string
 	Join       *EsJoin
+	// Fields are added to the document alongside the message's fields
+	Fields map[string]interface{}
 }
 
 type BulkRequest struct {
@@ -51,14 +54,20 @@ type EsBulkOperation string
 const (
 	BULK_INDEX  EsBulkOperation = "INDEX"
 	BULK_CREATE EsBulkOperation = "CREATE"
+	// BULK_DELETE deletes the document with the item's ID. The item's message and fields are ignored.
+	BULK_DELETE EsBulkOperation = "DELETE"
 )
 
 type BulkRequestItem struct {
 	Message    proto.Message
 	DocumentId string
-	Join       *EsJoin
-	Operation  EsBulkOperation
-	Routing    string
+	// Index overrides the request's index for this item
+	Index     string
+	Join      *EsJoin
+	Operation EsBulkOperation
+	Routing   string
+	// Fields are added to the document alongside the message's fields
+	Fields map[string]interface{}
 }
 
 type MultiSearchRequest struct {
@@ -93,6 +102,8 @@ type SearchPaginationOptions struct {
 type SearchResponse struct {
 	Hits          *EsSearchResponseHits
 	NextPageToken string
+	// PitId is the ID to use for the next search of a PIT that the caller opened, which may differ from the one it searched
+	PitId string
 }
 
 type UpdateRequest struct {
@@ -101,6 +112,9 @@ type UpdateRequest struct {
 	Refresh    string // TODO: use RefreshOption type
 	Message    proto.Message
 	Routing    string
+	Join       *EsJoin
+	// Fields are added to the document alongside the message's fields
+	Fields map[string]interface{}
 }
 
 type DeleteRequest struct {
@@ -110,7 +124,21 @@ type DeleteRequest struct {
 	Routing string
 }
 
+type UpdateByQueryRequest struct {
+	Index   string
+	Query   *filtering.Query
+	Script  *EsScript
+	Refresh string // TODO: use RefreshOption type
+	Routing string
+}
+
+type CountRequest struct {
+	Index string
+	Query *filtering.Query
+}
+
 const defaultPitKeepAlive = "5m"
+const esResourceAlreadyExists = "resource_already_exists_exception"
 const maxPageSize = 1000
 
 //counterfeiter:generate . Client
@@ -123,6 +151,18 @@ type Client interface {
 	MultiGet(ctx context.Context, request *MultiGetRequest) (*EsMultiGetResponse, error)
 	Update(ctx context.Context, request *UpdateRequest) (*EsIndexDocResponse, error)
 	Delete(ctx context.Context, request *DeleteRequest) error
+	DeleteByQuery(ctx context.Context, request *DeleteRequest) (*EsDeleteResponse, error)
+	UpdateByQuery(ctx context.Context, request *UpdateByQueryRequest) (*EsUpdateByQueryResponse, error)
+	Count(ctx context.Context, request *CountRequest) (int, error)
+	CreateIndex(ctx context.Context, index string, request *EsCreateIndex) error
+	PutLifecyclePolicy(ctx context.Context, name string, policy *EsLifecyclePolicy) error
+	PutComponentTemplate(ctx context.Context, name string, template *EsIndexTemplate) error
+	PutIndexTemplate(ctx context.Context, name string, template *EsIndexTemplate) error
+	DeleteIndexTemplate(ctx context.Context, name string) error
+	UpdateAliases(ctx context.Context, actions []*EsAliasAction) error
+	AliasIndices(ctx context.Context, alias string) ([]string, error)
+	OpenPointInTime(ctx context.Context, index, keepAlive string) (string, error)
+	ClosePointInTime(ctx context.Context, pitId string) error
 }
 
 type client struct {
@@ -152,29 +192,15 @@ func (c *client) Create(ctx context.Context, request *CreateRequest) (string, er
 		indexOpts = append(indexOpts, c.esClient.Index.WithDocumentID(escapeDocumentId(request.DocumentId)))
 	}
 
-	var (
-		doc []byte
-		err error
-	)
-	if request.Join != nil {
-		// marshal the protobuf message with the custom join patch.
-		// see the godoc for EsDocWithJoin for more details
-		doc, err = json.Marshal(&EsDocWithJoin{
-			Join:    request.Join,
-			Message: request.Message,
-		})
-		if err != nil {
-			return "", err
-		}
+	// marshal the protobuf message with the custom join and field patch.
+	// see the godoc for EncodeDocument for more details
+	doc, err := EncodeDocument(request.Message, request.Join, request.Fields)
+	if err != nil {
+		return "", err
+	}
 
-		if request.Join.Parent != "" {
-			indexOpts = append(indexOpts, c.esClient.Index.WithRouting(request.Join.Parent))
-		}
-	} else {
-		doc, err = protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(request.Message)
-		if err != nil {
-			return "", err
-		}
+	if request.Join != nil && request.Join.Parent != "" {
+		indexOpts = append(indexOpts, c.esClient.Index.WithRouting(request.Join.Parent))
 	}
 
 	res, err := c.esClient.Index(
@@ -215,45 +241,45 @@ func (c *client) Bulk(ctx context.Context, request *BulkRequest) (*EsBulkRespons
 			Id:    item.DocumentId,
 			Index: request.Index,
 		}
+		if item.Index != "" {
+			operationFragment.Index = item.Index
+		}
 		if item.Operation == BULK_CREATE {
 			metadata.Create = operationFragment
 		} else if item.Operation == BULK_INDEX {
 			metadata.Index = operationFragment
+		} else if item.Operation == BULK_DELETE {
+			metadata.Delete = operationFragment
 		} else {
 			return nil, fmt.Errorf("expected valid bulk operation, got %s", item.Operation)
 		}
 
-		var (
-			data []byte
-			err  error
-		)
 		if item.Join != nil {
 			if item.Routing != "" {
 				return nil, errors.New("cannot specify a routing key when using a join")
 			}
 
-			// marshal the protobuf message with the custom join patch.
-			// see the godoc for EsDocWithJoin for more details
-			data, err = json.Marshal(&EsDocWithJoin{
-				Join:    item.Join,
-				Message: item.Message,
-			})
-			if err != nil {
-				return nil, err
-			}
-
 			operationFragment.Routing = item.Join.Parent
 		} else {
 			operationFragment.Routing = item.Routing
-			data, err = protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(item.Message)
-			if err != nil {
-				return nil, err
-			}
 		}
 
 		metadataBytes, _ := json.Marshal(metadata)
 		metadataBytes = append(metadataBytes, '\n')
 
+		// a delete has no source
+		if item.Operation == BULK_DELETE {
+			body.Write(metadataBytes)
+			continue
+		}
+
+		// marshal the protobuf message with the custom join and field patch.
+		// see the godoc for EncodeDocument for more details
+		data, err := EncodeDocument(item.Message, item.Join, item.Fields)
+		if err != nil {
+			return nil, err
+		}
+
 		dataBytes := append(data, '\n')
 		body.Grow(len(metadataBytes) + len(dataBytes))
 		body.Write(metadataBytes)
@@ -314,24 +340,10 @@ func (c *client) Search(ctx context.Context, request *SearchRequest) (*SearchRes
 
 		// if no page token is specified, we need to create a new PIT
 		if request.Pagination.Token == "" {
-			res, err := c.esClient.OpenPointInTime(
-				c.esClient.OpenPointInTime.WithContext(ctx),
-				c.esClient.OpenPointInTime.WithIndex(request.Index),
-				c.esClient.OpenPointInTime.WithKeepAlive(request.Pagination.Keepalive),
-			)
+			pitId, err = c.OpenPointInTime(ctx, request.Index, request.Pagination.Keepalive)
 			if err != nil {
 				return nil, err
 			}
-			if res.IsError() {
-				return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
-			}
-
-			var pitResponse ESPitResponse
-			if err = DecodeResponse(res.Body, &pitResponse); err != nil {
-				return nil, err
-			}
-
-			pitId = pitResponse.Id
 			searchFrom = 0
 		} else {
 			// get the PIT from the provided page token
@@ -351,10 +363,11 @@ func (c *client) Search(ctx context.Context, request *SearchRequest) (*SearchRes
 			c.esClient.Search.WithSize(request.Pagination.Size),
 		)
 	} else {
-		searchOptions = append(searchOptions,
-			c.esClient.Search.WithIndex(request.Index),
-			c.esClient.Search.WithSize(maxPageSize),
-		)
+		// a search of a PIT that the caller opened can't name an index, since the PIT already determines it
+		if body.Pit == nil {
+			searchOptions = append(searchOptions, c.esClient.Search.WithIndex(request.Index))
+		}
+		searchOptions = append(searchOptions, c.esClient.Search.WithSize(maxPageSize))
 	}
 
 	encodedBody, requestJson := EncodeRequest(body)
@@ -377,6 +390,7 @@ func (c *client) Search(ctx context.Context, request *SearchRequest) (*SearchRes
 	}
 
 	response.Hits = searchResults.Hits
+	response.PitId = searchResults.PitId
 	if request.Pagination != nil {
 		nextSearchFrom := searchFrom + request.Pagination.Size
 
@@ -511,7 +525,12 @@ func (c *client) MultiGet(ctx context.Context, request *MultiGetRequest) (*EsMul
 
 func (c *client) Update(ctx context.Context, request *UpdateRequest) (*EsIndexDocResponse, error) {
 	log := c.logger.Named("Update")
-	str, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(request.Message)
+	if request.Join != nil && request.Routing != "" {
+		return nil, errors.New("cannot specify a routing key when using a join")
+	}
+
+	// the document is replaced, so the join field needs to be included again
+	str, err := EncodeDocument(request.Message, request.Join, request.Fields)
 	if err != nil {
 		return nil, err
 	}
@@ -530,6 +549,10 @@ func (c *client) Update(ctx context.Context, request *UpdateRequest) (*EsIndexDo
 		indexOpts = append(indexOpts, c.esClient.Index.WithRouting(request.Routing))
 	}
 
+	if request.Join != nil && request.Join.Parent != "" {
+		indexOpts = append(indexOpts, c.esClient.Index.WithRouting(request.Join.Parent))
+	}
+
 	res, err := c.esClient.Index(
 		request.Index,
 		bytes.NewReader(str),
@@ -553,7 +576,22 @@ func (c *client) Update(ctx context.Context, request *UpdateRequest) (*EsIndexDo
 }
 
 func (c *client) Delete(ctx context.Context, request *DeleteRequest) error {
-	log := c.logger.Named("Delete")
+	deletedResults, err := c.DeleteByQuery(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	if deletedResults.Deleted == 0 {
+		return errors.New("elasticsearch returned zero deleted documents")
+	}
+
+	return nil
+}
+
+// DeleteByQuery deletes every document matching the search, returning the number of documents that were deleted.
+// Unlike Delete, it isn't an error for the search to match nothing.
+func (c *client) DeleteByQuery(ctx context.Context, request *DeleteRequest) (*EsDeleteResponse, error) {
+	log := c.logger.Named("DeleteByQuery")
 	encodedBody, requestJson := EncodeRequest(request.Search)
 	log = log.With(zap.String("request", requestJson))
 
@@ -575,6 +613,135 @@ func (c *client) Delete(ctx context.Context, request *DeleteRequest) error {
 		encodedBody,
 		deleteOpts...,
 	)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+	}
+
+	deletedResults := &EsDeleteResponse{}
+	if err = DecodeResponse(res.Body, deletedResults); err != nil {
+		return nil, err
+	}
+	log.Debug("deleted documents", zap.Int("deleted", deletedResults.Deleted))
+
+	return deletedResults, nil
+}
+
+// UpdateByQuery runs the script on every document matching the query, returning the number of documents that were updated.
+// It isn't an error for the query to match nothing.
+func (c *client) UpdateByQuery(ctx context.Context, request *UpdateByQueryRequest) (*EsUpdateByQueryResponse, error) {
+	log := c.logger.Named("UpdateByQuery")
+	encodedBody, requestJson := EncodeRequest(&EsUpdateByQuery{
+		Query:  request.Query,
+		Script: request.Script,
+	})
+	log = log.With(zap.String("request", requestJson))
+
+	if request.Refresh == "" {
+		request.Refresh = "true"
+	}
+
+	updateOpts := []func(*esapi.UpdateByQueryRequest){
+		c.esClient.UpdateByQuery.WithContext(ctx),
+		c.esClient.UpdateByQuery.WithBody(encodedBody),
+		c.esClient.UpdateByQuery.WithRefresh(withRefreshBool(request.Refresh)),
+	}
+
+	if request.Routing != "" {
+		updateOpts = append(updateOpts, c.esClient.UpdateByQuery.WithRouting(request.Routing))
+	}
+
+	res, err := c.esClient.UpdateByQuery(
+		[]string{request.Index},
+		updateOpts...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+	}
+
+	updatedResults := &EsUpdateByQueryResponse{}
+	if err = DecodeResponse(res.Body, updatedResults); err != nil {
+		return nil, err
+	}
+	log.Debug("updated documents", zap.Int("updated", updatedResults.Updated))
+
+	return updatedResults, nil
+}
+
+// Count returns the number of documents in the index that match the query
+func (c *client) Count(ctx context.Context, request *CountRequest) (int, error) {
+	log := c.logger.Named("Count")
+	encodedBody, requestJson := EncodeRequest(&EsSearch{Query: request.Query})
+	log = log.With(zap.String("request", requestJson))
+
+	res, err := c.esClient.Count(
+		c.esClient.Count.WithContext(ctx),
+		c.esClient.Count.WithIndex(request.Index),
+		c.esClient.Count.WithBody(encodedBody),
+	)
+	if err != nil {
+		return 0, err
+	}
+	if res.IsError() {
+		return 0, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+	}
+
+	countResponse := &EsCountResponse{}
+	if err = DecodeResponse(res.Body, countResponse); err != nil {
+		return 0, err
+	}
+	log.Debug("counted documents", zap.Int("count", countResponse.Count))
+
+	return countResponse.Count, nil
+}
+
+// CreateIndex creates the index with the given settings and aliases. It isn't an error for the index to already exist.
+func (c *client) CreateIndex(ctx context.Context, index string, request *EsCreateIndex) error {
+	log := c.logger.Named("CreateIndex").With(zap.String("index", index))
+	encodedBody, requestJson := EncodeRequest(request)
+	log = log.With(zap.String("request", requestJson))
+
+	res, err := c.esClient.Indices.Create(
+		index,
+		c.esClient.Indices.Create.WithContext(ctx),
+		c.esClient.Indices.Create.WithBody(encodedBody),
+	)
+	if err != nil {
+		return err
+	}
+	if res.IsError() {
+		if res.StatusCode == http.StatusBadRequest {
+			errResponse := &EsErrorResponse{}
+			if err := DecodeResponse(res.Body, errResponse); err == nil && errResponse.Error.Type == esResourceAlreadyExists {
+				log.Debug("index already exists")
+				return nil
+			}
+		}
+
+		return fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+	}
+
+	log.Debug("created index")
+
+	return nil
+}
+
+// PutLifecyclePolicy creates or replaces an index lifecycle management policy
+func (c *client) PutLifecyclePolicy(ctx context.Context, name string, policy *EsLifecyclePolicy) error {
+	log := c.logger.Named("PutLifecyclePolicy").With(zap.String("policy", name))
+	encodedBody, requestJson := EncodeRequest(policy)
+	log = log.With(zap.String("request", requestJson))
+
+	res, err := c.esClient.ILM.PutLifecycle(
+		name,
+		c.esClient.ILM.PutLifecycle.WithContext(ctx),
+		c.esClient.ILM.PutLifecycle.WithBody(encodedBody),
+	)
 	if err != nil {
 		return err
 	}
@@ -582,15 +749,176 @@ func (c *client) Delete(ctx context.Context, request *DeleteRequest) error {
 		return fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
 	}
 
-	deletedResults := EsDeleteResponse{}
-	if err = DecodeResponse(res.Body, &deletedResults); err != nil {
+	log.Debug("updated lifecycle policy")
+
+	return nil
+}
+
+// PutComponentTemplate creates or replaces a component template, which index templates can be composed of
+func (c *client) PutComponentTemplate(ctx context.Context, name string, template *EsIndexTemplate) error {
+	log := c.logger.Named("PutComponentTemplate").With(zap.String("template", name))
+	encodedBody, requestJson := EncodeRequest(template)
+	log = log.With(zap.String("request", requestJson))
+
+	res, err := c.esClient.Cluster.PutComponentTemplate(
+		name,
+		encodedBody,
+		c.esClient.Cluster.PutComponentTemplate.WithContext(ctx),
+	)
+	if err != nil {
 		return err
 	}
+	if res.IsError() {
+		return fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+	}
 
-	if deletedResults.Deleted == 0 {
-		return errors.New("elasticsearch returned zero deleted documents")
+	log.Debug("updated component template")
+
+	return nil
+}
+
+// PutIndexTemplate creates or replaces an index template, which is applied to new indices that match its patterns
+func (c *client) PutIndexTemplate(ctx context.Context, name string, template *EsIndexTemplate) error {
+	log := c.logger.Named("PutIndexTemplate").With(zap.String("template", name))
+	encodedBody, requestJson := EncodeRequest(template)
+	log = log.With(zap.String("request", requestJson))
+
+	res, err := c.esClient.Indices.PutIndexTemplate(
+		name,
+		encodedBody,
+		c.esClient.Indices.PutIndexTemplate.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	if res.IsError() {
+		return fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
 	}
 
+	log.Debug("updated index template")
+
+	return nil
+}
+
+// DeleteIndexTemplate deletes an index template. It isn't an error for the template to not exist.
+func (c *client) DeleteIndexTemplate(ctx context.Context, name string) error {
+	log := c.logger.Named("DeleteIndexTemplate").With(zap.String("template", name))
+
+	res, err := c.esClient.Indices.DeleteIndexTemplate(
+		name,
+		c.esClient.Indices.DeleteIndexTemplate.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+	}
+
+	log.Debug("deleted index template")
+
+	return nil
+}
+
+// UpdateAliases atomically applies the alias actions
+func (c *client) UpdateAliases(ctx context.Context, actions []*EsAliasAction) error {
+	log := c.logger.Named("UpdateAliases")
+	encodedBody, requestJson := EncodeRequest(&EsUpdateAliases{Actions: actions})
+	log = log.With(zap.String("request", requestJson))
+
+	res, err := c.esClient.Indices.UpdateAliases(
+		encodedBody,
+		c.esClient.Indices.UpdateAliases.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	if res.IsError() {
+		return fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+	}
+
+	log.Debug("updated aliases")
+
+	return nil
+}
+
+// AliasIndices returns the names of the indices that the alias points to, which are none if the alias doesn't exist
+func (c *client) AliasIndices(ctx context.Context, alias string) ([]string, error) {
+	log := c.logger.Named("AliasIndices").With(zap.String("alias", alias))
+
+	res, err := c.esClient.Indices.GetAlias(
+		c.esClient.Indices.GetAlias.WithContext(ctx),
+		c.esClient.Indices.GetAlias.WithName(alias),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		log.Debug("alias does not exist")
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+	}
+
+	aliasResponse := map[string]interface{}{}
+	if err := DecodeResponse(res.Body, &aliasResponse); err != nil {
+		return nil, err
+	}
+
+	var indices []string
+	for index := range aliasResponse {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+
+	return indices, nil
+}
+
+// OpenPointInTime opens a PIT on the index, returning its ID. The PIT is kept for keepAlive, which each search of it extends.
+func (c *client) OpenPointInTime(ctx context.Context, index, keepAlive string) (string, error) {
+	log := c.logger.Named("OpenPointInTime").With(zap.String("index", index))
+
+	res, err := c.esClient.OpenPointInTime(
+		c.esClient.OpenPointInTime.WithContext(ctx),
+		c.esClient.OpenPointInTime.WithIndex(index),
+		c.esClient.OpenPointInTime.WithKeepAlive(keepAlive),
+	)
+	if err != nil {
+		return "", err
+	}
+	if res.IsError() {
+		return "", fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+	}
+
+	var pitResponse ESPitResponse
+	if err = DecodeResponse(res.Body, &pitResponse); err != nil {
+		return "", err
+	}
+
+	log.Debug("opened point in time")
+
+	return pitResponse.Id, nil
+}
+
+// ClosePointInTime releases a PIT before its keep alive expires. It isn't an error for the PIT to have already expired.
+func (c *client) ClosePointInTime(ctx context.Context, pitId string) error {
+	log := c.logger.Named("ClosePointInTime")
+	encodedBody, _ := EncodeRequest(&EsSearchPit{Id: pitId})
+
+	res, err := c.esClient.ClosePointInTime(
+		c.esClient.ClosePointInTime.WithContext(ctx),
+		c.esClient.ClosePointInTime.WithBody(encodedBody),
+	)
+	if err != nil {
+		return err
+	}
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected response from elasticsearch: %s", res.String())
+	}
+
+	log.Debug("closed point in time")
+
 	return nil
 }
 
@@ -22,6 +22,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -226,6 +227,55 @@ var _ = Describe("elasticsearch client", func() {
 				})
 			})
 		})
+
+		When("additional fields are provided", func() {
+			var (
+				expectedField string
+				expectedValue string
+			)
+
+			BeforeEach(func() {
+				expectedField = fake.LetterN(10)
+				expectedValue = fake.LetterN(10)
+
+				expectedCreateRequest.Fields = map[string]interface{}{
+					expectedField: expectedValue,
+				}
+			})
+
+			It("should marshal the fields into the request body json", func() {
+				requestBody, err := io.ReadAll(transport.ReceivedHttpRequests[0].Body)
+				Expect(err).ToNot(HaveOccurred())
+
+				indexedMessage := &pb.Occurrence{}
+				err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(requestBody, protov1.MessageV2(indexedMessage))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(indexedMessage).To(BeEquivalentTo(expectedOccurrence))
+
+				jsonMap := map[string]interface{}{}
+				err = json.Unmarshal(requestBody, &jsonMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jsonMap[expectedField]).To(Equal(expectedValue))
+			})
+
+			When("there is no message", func() {
+				BeforeEach(func() {
+					expectedCreateRequest.Message = nil
+				})
+
+				It("should only index the fields", func() {
+					requestBody, err := io.ReadAll(transport.ReceivedHttpRequests[0].Body)
+					Expect(err).ToNot(HaveOccurred())
+
+					jsonMap := map[string]interface{}{}
+					err = json.Unmarshal(requestBody, &jsonMap)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(jsonMap).To(Equal(map[string]interface{}{
+						expectedField: expectedValue,
+					}))
+				})
+			})
+		})
 	})
 
 	Context("Bulk", func() {
@@ -371,6 +421,73 @@ var _ = Describe("elasticsearch client", func() {
 			})
 		})
 
+		When("one of the bulk items specifies an index", func() {
+			var (
+				expectedItemIndex string
+				randomItemIndex   int
+			)
+
+			BeforeEach(func() {
+				expectedItemIndex = fake.LetterN(10)
+				randomItemIndex = fake.Number(0, len(expectedBulkItems)-1)
+				expectedBulkItems[randomItemIndex].Index = expectedItemIndex
+			})
+
+			It("should write that item to the item's index, and the others to the request's index", func() {
+				var expectedPayloads []interface{}
+
+				for i := 0; i < len(expectedOccurrences); i++ {
+					expectedPayloads = append(expectedPayloads, &EsBulkQueryFragment{}, &pb.Occurrence{})
+				}
+
+				parseNDJSONRequestBodyWithProtobufs(transport.ReceivedHttpRequests[0].Body, expectedPayloads)
+
+				for i := range expectedOccurrences {
+					metadata := expectedPayloads[i*2].(*EsBulkQueryFragment)
+					if i == randomItemIndex {
+						Expect(metadata.Index.Index).To(Equal(expectedItemIndex))
+					} else {
+						Expect(metadata.Index.Index).To(Equal(expectedIndex))
+					}
+				}
+			})
+		})
+
+		When("the delete operation is specified for an item", func() {
+			var (
+				randomItemIndex    int
+				expectedDocumentId string
+				actualLines        []string
+			)
+
+			BeforeEach(func() {
+				expectedDocumentId = fake.LetterN(10)
+				randomItemIndex = fake.Number(0, len(expectedBulkItems)-1)
+				expectedBulkItems[randomItemIndex].DocumentId = expectedDocumentId
+				expectedBulkItems[randomItemIndex].Operation = BULK_DELETE
+			})
+
+			JustBeforeEach(func() {
+				buf := new(bytes.Buffer)
+				_, err := buf.ReadFrom(transport.ReceivedHttpRequests[0].Body)
+				Expect(err).ToNot(HaveOccurred())
+
+				actualLines = strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			})
+
+			It("should only send the metadata for that item", func() {
+				Expect(actualLines).To(HaveLen(len(expectedBulkItems)*2 - 1))
+
+				metadata := &EsBulkQueryFragment{}
+				Expect(json.Unmarshal([]byte(actualLines[randomItemIndex*2]), metadata)).To(Succeed())
+
+				Expect(metadata.Index).To(BeNil())
+				Expect(metadata.Delete).ToNot(BeNil())
+				Expect(metadata.Delete.Id).To(Equal(expectedDocumentId))
+				Expect(metadata.Delete.Index).To(Equal(expectedIndex))
+			})
+		})
+
 		When("one of the item specifies a join and a routing value", func() {
 			BeforeEach(func() {
 				randomItemIndex := fake.Number(0, len(expectedBulkItems)-1)
@@ -480,6 +597,37 @@ var _ = Describe("elasticsearch client", func() {
 				})
 			})
 		})
+
+		When("additional fields are provided for an item", func() {
+			var (
+				expectedField string
+				expectedValue string
+				randomIndex   int
+			)
+
+			BeforeEach(func() {
+				randomIndex = fake.Number(0, len(expectedOccurrences)-1)
+				expectedField = fake.LetterN(10)
+				expectedValue = fake.LetterN(10)
+
+				expectedBulkItems[randomIndex].Fields = map[string]interface{}{
+					expectedField: expectedValue,
+				}
+			})
+
+			It("should marshal the fields into the payload for that item", func() {
+				buf := new(bytes.Buffer)
+				_, err := buf.ReadFrom(transport.ReceivedHttpRequests[0].Body)
+				Expect(err).ToNot(HaveOccurred())
+
+				payloads := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+				jsonMap := map[string]interface{}{}
+				err = json.Unmarshal([]byte(payloads[randomIndex*2+1]), &jsonMap)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jsonMap[expectedField]).To(Equal(expectedValue))
+			})
+		})
 	})
 
 	Context("Search", func() {
@@ -594,6 +742,41 @@ var _ = Describe("elasticsearch client", func() {
 			})
 		})
 
+		When("the search is of a PIT opened by the caller", func() {
+			var expectedSearch *EsSearch
+
+			BeforeEach(func() {
+				expectedSearchResponse.PitId = fake.LetterN(10)
+				transport.PreparedHttpResponses[0].Body = structToJsonBody(expectedSearchResponse)
+
+				expectedSearch = &EsSearch{
+					Pit: &EsSearchPit{
+						Id:        fake.LetterN(10),
+						KeepAlive: "1m",
+					},
+					Sort: []EsSortField{
+						{Field: fake.LetterN(10), Order: EsSortOrderAscending},
+					},
+					SearchAfter: []interface{}{fake.LetterN(10), float64(fake.Number(1, 1000))},
+				}
+				expectedSearchRequest.Search = expectedSearch
+			})
+
+			It("should search the PIT without naming an index", func() {
+				Expect(transport.ReceivedHttpRequests).To(HaveLen(1))
+				Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal("/_search"))
+
+				searchRequest := &EsSearch{}
+				ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+				Expect(searchRequest).To(Equal(expectedSearch))
+			})
+
+			It("should return the PIT ID to use for the next search", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualSearchResponse.PitId).To(Equal(expectedSearchResponse.PitId))
+			})
+		})
+
 		When("pagination is used", func() {
 			var (
 				expectedPageSize int
@@ -1199,6 +1382,70 @@ var _ = Describe("elasticsearch client", func() {
 				Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("routing")).To(Equal(expectedRouting))
 			})
 		})
+
+		When("additional fields are provided", func() {
+			var (
+				expectedField string
+				expectedValue string
+			)
+
+			BeforeEach(func() {
+				expectedField = fake.LetterN(10)
+				expectedValue = fake.LetterN(10)
+
+				expectedUpdateRequest.Fields = map[string]interface{}{
+					expectedField: expectedValue,
+				}
+			})
+
+			It("should marshal the fields into the request body json", func() {
+				jsonMap := map[string]interface{}{}
+				ReadRequestBody(transport.ReceivedHttpRequests[0], &jsonMap)
+
+				Expect(jsonMap[expectedField]).To(Equal(expectedValue))
+			})
+		})
+
+		When("a join field is used", func() {
+			var (
+				expectedJoinField string
+				expectedParent    string
+			)
+
+			BeforeEach(func() {
+				expectedJoinField = fake.LetterN(10)
+				expectedParent = fake.LetterN(10)
+
+				expectedUpdateRequest.Join = &EsJoin{
+					Field:  expectedJoinField,
+					Name:   fake.LetterN(10),
+					Parent: expectedParent,
+				}
+			})
+
+			It("should marshal the join field into the request body json", func() {
+				jsonMap := map[string]interface{}{}
+				ReadRequestBody(transport.ReceivedHttpRequests[0], &jsonMap)
+
+				joinField := jsonMap[expectedJoinField].(map[string]interface{})
+				Expect(joinField["parent"]).To(Equal(expectedParent))
+			})
+
+			It("should set the routing to the parent ID", func() {
+				Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("routing")).To(Equal(expectedParent))
+			})
+
+			When("a routing key is also specified", func() {
+				BeforeEach(func() {
+					expectedUpdateRequest.Routing = fake.LetterN(10)
+				})
+
+				It("should return an error", func() {
+					Expect(actualErr).To(HaveOccurred())
+					Expect(transport.ReceivedHttpRequests).To(BeEmpty())
+				})
+			})
+		})
 	})
 
 	Context("Delete", func() {
@@ -1303,6 +1550,671 @@ var _ = Describe("elasticsearch client", func() {
 			})
 		})
 	})
+
+	Context("DeleteByQuery", func() {
+		var (
+			actualResponse *EsDeleteResponse
+			actualErr      error
+
+			expectedDeleteRequest *DeleteRequest
+			expectedSearch        *EsSearch
+		)
+
+		BeforeEach(func() {
+			expectedSearch = &EsSearch{
+				Query: &filtering.Query{
+					Term: &filtering.Term{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				},
+			}
+			expectedDeleteRequest = &DeleteRequest{
+				Index:  fake.LetterN(10),
+				Search: expectedSearch,
+			}
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+					Body: structToJsonBody(&EsDeleteResponse{
+						Deleted: 0,
+					}),
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualResponse, actualErr = client.DeleteByQuery(ctx, expectedDeleteRequest)
+		})
+
+		It("should delete the matching documents in ES", func() {
+			Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal(fmt.Sprintf("/%s/_delete_by_query", expectedDeleteRequest.Index)))
+
+			searchRequest := &EsSearch{}
+			ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+			Expect(searchRequest).To(BeEquivalentTo(expectedSearch))
+		})
+
+		It("should not return an error when nothing is deleted", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualResponse.Deleted).To(Equal(0))
+		})
+
+		When("deleting the documents fails", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0] = &http.Response{
+					StatusCode: http.StatusInternalServerError,
+				}
+			})
+
+			It("should return an error", func() {
+				Expect(actualResponse).To(BeNil())
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("UpdateByQuery", func() {
+		var (
+			actualResponse *EsUpdateByQueryResponse
+			actualErr      error
+
+			expectedUpdateRequest *UpdateByQueryRequest
+			expectedUpdated       int
+		)
+
+		BeforeEach(func() {
+			expectedUpdated = fake.Number(1, 1000)
+			expectedUpdateRequest = &UpdateByQueryRequest{
+				Index: fake.LetterN(10),
+				Query: &filtering.Query{
+					Term: &filtering.Term{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				},
+				Script: &EsScript{
+					Source: fake.LetterN(10),
+					Lang:   "painless",
+					Params: map[string]interface{}{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				},
+			}
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+					Body: structToJsonBody(&EsUpdateByQueryResponse{
+						Updated: expectedUpdated,
+					}),
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualResponse, actualErr = client.UpdateByQuery(ctx, expectedUpdateRequest)
+		})
+
+		It("should run the script on the matching documents in ES", func() {
+			Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal(fmt.Sprintf("/%s/_update_by_query", expectedUpdateRequest.Index)))
+			Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("refresh")).To(Equal("true"))
+
+			updateRequest := &EsUpdateByQuery{}
+			ReadRequestBody(transport.ReceivedHttpRequests[0], &updateRequest)
+
+			Expect(updateRequest.Query).To(BeEquivalentTo(expectedUpdateRequest.Query))
+			Expect(updateRequest.Script).To(BeEquivalentTo(expectedUpdateRequest.Script))
+		})
+
+		It("should return the number of updated documents", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualResponse.Updated).To(Equal(expectedUpdated))
+		})
+
+		When("a routing key is specified", func() {
+			BeforeEach(func() {
+				expectedUpdateRequest.Routing = fake.LetterN(10)
+			})
+
+			It("should only update documents with that routing", func() {
+				Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("routing")).To(Equal(expectedUpdateRequest.Routing))
+			})
+		})
+
+		When("updating the documents fails", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0] = &http.Response{
+					StatusCode: http.StatusInternalServerError,
+				}
+			})
+
+			It("should return an error", func() {
+				Expect(actualResponse).To(BeNil())
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("Count", func() {
+		var (
+			actualCount int
+			actualErr   error
+
+			expectedCountRequest *CountRequest
+			expectedCount        int
+		)
+
+		BeforeEach(func() {
+			expectedCount = fake.Number(1, 1000)
+			expectedCountRequest = &CountRequest{
+				Index: fake.LetterN(10),
+				Query: &filtering.Query{
+					Term: &filtering.Term{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				},
+			}
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+					Body: structToJsonBody(&EsCountResponse{
+						Count: expectedCount,
+					}),
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualCount, actualErr = client.Count(ctx, expectedCountRequest)
+		})
+
+		It("should count the matching documents in ES", func() {
+			Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal(fmt.Sprintf("/%s/_count", expectedCountRequest.Index)))
+
+			searchRequest := &EsSearch{}
+			ReadRequestBody(transport.ReceivedHttpRequests[0], &searchRequest)
+
+			Expect(searchRequest.Query).To(BeEquivalentTo(expectedCountRequest.Query))
+		})
+
+		It("should return the count", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualCount).To(Equal(expectedCount))
+		})
+
+		When("the count request fails", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0] = &http.Response{
+					StatusCode: http.StatusInternalServerError,
+				}
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("CreateIndex", func() {
+		var (
+			actualErr error
+
+			expectedIndex   string
+			expectedRequest *EsCreateIndex
+		)
+
+		BeforeEach(func() {
+			expectedIndex = fake.LetterN(10)
+			expectedRequest = &EsCreateIndex{
+				Aliases: map[string]*EsAlias{
+					fake.LetterN(10): {IsWriteIndex: true},
+				},
+			}
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualErr = client.CreateIndex(ctx, expectedIndex, expectedRequest)
+		})
+
+		It("should create the index", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(transport.ReceivedHttpRequests[0].Method).To(Equal(http.MethodPut))
+			Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal("/" + expectedIndex))
+
+			actualRequest := &EsCreateIndex{}
+			ReadRequestBody(transport.ReceivedHttpRequests[0], actualRequest)
+			Expect(actualRequest).To(Equal(expectedRequest))
+		})
+
+		When("the index already exists", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0] = &http.Response{
+					StatusCode: http.StatusBadRequest,
+					Body: structToJsonBody(&EsErrorResponse{
+						Error: EsError{
+							Type: "resource_already_exists_exception",
+						},
+					}),
+				}
+			})
+
+			It("should not return an error", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+			})
+		})
+
+		When("creating the index fails", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0] = &http.Response{
+					StatusCode: http.StatusBadRequest,
+					Body: structToJsonBody(&EsErrorResponse{
+						Error: EsError{
+							Type: "illegal_argument_exception",
+						},
+					}),
+				}
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("PutLifecyclePolicy", func() {
+		var (
+			actualErr error
+
+			expectedName   string
+			expectedPolicy *EsLifecyclePolicy
+		)
+
+		BeforeEach(func() {
+			expectedName = fake.LetterN(10)
+			expectedPolicy = &EsLifecyclePolicy{
+				Policy: &EsLifecyclePolicyPhases{
+					Phases: map[string]*EsLifecyclePhase{
+						"hot": {
+							Actions: &EsLifecycleActions{
+								Rollover: &EsRolloverAction{
+									MaxAge: "30d",
+								},
+							},
+						},
+					},
+				},
+			}
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualErr = client.PutLifecyclePolicy(ctx, expectedName, expectedPolicy)
+		})
+
+		It("should put the policy", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(transport.ReceivedHttpRequests[0].Method).To(Equal(http.MethodPut))
+			Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal("/_ilm/policy/" + expectedName))
+
+			actualPolicy := &EsLifecyclePolicy{}
+			ReadRequestBody(transport.ReceivedHttpRequests[0], actualPolicy)
+			Expect(actualPolicy).To(Equal(expectedPolicy))
+		})
+
+		When("the request fails", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0].StatusCode = http.StatusInternalServerError
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("templates", func() {
+		var (
+			actualErr error
+
+			expectedName     string
+			expectedTemplate *EsIndexTemplate
+		)
+
+		BeforeEach(func() {
+			expectedName = fake.LetterN(10)
+			expectedTemplate = &EsIndexTemplate{
+				IndexPatterns: []string{fake.LetterN(10) + "-*"},
+				ComposedOf:    []string{fake.LetterN(10)},
+				Template: &EsCreateIndex{
+					Settings: map[string]interface{}{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				},
+			}
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+				},
+			}
+		})
+
+		Describe("PutComponentTemplate", func() {
+			JustBeforeEach(func() {
+				actualErr = client.PutComponentTemplate(ctx, expectedName, expectedTemplate)
+			})
+
+			It("should put the component template", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal("/_component_template/" + expectedName))
+
+				actualTemplate := &EsIndexTemplate{}
+				ReadRequestBody(transport.ReceivedHttpRequests[0], actualTemplate)
+				Expect(actualTemplate).To(Equal(expectedTemplate))
+			})
+
+			When("the request fails", func() {
+				BeforeEach(func() {
+					transport.PreparedHttpResponses[0].StatusCode = http.StatusInternalServerError
+				})
+
+				It("should return an error", func() {
+					Expect(actualErr).To(HaveOccurred())
+				})
+			})
+		})
+
+		Describe("PutIndexTemplate", func() {
+			JustBeforeEach(func() {
+				actualErr = client.PutIndexTemplate(ctx, expectedName, expectedTemplate)
+			})
+
+			It("should put the index template", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal("/_index_template/" + expectedName))
+
+				actualTemplate := &EsIndexTemplate{}
+				ReadRequestBody(transport.ReceivedHttpRequests[0], actualTemplate)
+				Expect(actualTemplate).To(Equal(expectedTemplate))
+			})
+
+			When("the request fails", func() {
+				BeforeEach(func() {
+					transport.PreparedHttpResponses[0].StatusCode = http.StatusInternalServerError
+				})
+
+				It("should return an error", func() {
+					Expect(actualErr).To(HaveOccurred())
+				})
+			})
+		})
+
+		Describe("DeleteIndexTemplate", func() {
+			JustBeforeEach(func() {
+				actualErr = client.DeleteIndexTemplate(ctx, expectedName)
+			})
+
+			It("should delete the index template", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(transport.ReceivedHttpRequests[0].Method).To(Equal(http.MethodDelete))
+				Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal("/_index_template/" + expectedName))
+			})
+
+			When("the template doesn't exist", func() {
+				BeforeEach(func() {
+					transport.PreparedHttpResponses[0].StatusCode = http.StatusNotFound
+				})
+
+				It("should not return an error", func() {
+					Expect(actualErr).ToNot(HaveOccurred())
+				})
+			})
+
+			When("the request fails", func() {
+				BeforeEach(func() {
+					transport.PreparedHttpResponses[0].StatusCode = http.StatusInternalServerError
+				})
+
+				It("should return an error", func() {
+					Expect(actualErr).To(HaveOccurred())
+				})
+			})
+		})
+	})
+
+	Context("UpdateAliases", func() {
+		var (
+			actualErr error
+
+			expectedActions []*EsAliasAction
+		)
+
+		BeforeEach(func() {
+			expectedActions = []*EsAliasAction{
+				{
+					Add: &EsAliasActionDetails{
+						Index: fake.LetterN(10),
+						Alias: fake.LetterN(10),
+						Filter: &filtering.Query{
+							Term: &filtering.Term{
+								fake.LetterN(10): fake.LetterN(10),
+							},
+						},
+						Routing: fake.LetterN(10),
+					},
+				},
+				{
+					Remove: &EsAliasActionDetails{
+						Index: fake.LetterN(10),
+						Alias: fake.LetterN(10),
+					},
+				},
+			}
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualErr = client.UpdateAliases(ctx, expectedActions)
+		})
+
+		It("should apply the alias actions", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(transport.ReceivedHttpRequests[0].Method).To(Equal(http.MethodPost))
+			Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal("/_aliases"))
+
+			actualRequest := &EsUpdateAliases{}
+			ReadRequestBody(transport.ReceivedHttpRequests[0], actualRequest)
+			Expect(actualRequest.Actions).To(Equal(expectedActions))
+		})
+
+		When("the request fails", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0].StatusCode = http.StatusInternalServerError
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("AliasIndices", func() {
+		var (
+			expectedAlias   string
+			expectedIndices []string
+
+			actualIndices []string
+			actualErr     error
+		)
+
+		BeforeEach(func() {
+			expectedAlias = fake.LetterN(10)
+			expectedIndices = []string{fake.LetterN(10) + "-000001", fake.LetterN(10) + "-000002"}
+			sort.Strings(expectedIndices)
+
+			response := map[string]interface{}{}
+			for _, index := range expectedIndices {
+				response[index] = map[string]interface{}{
+					"aliases": map[string]interface{}{
+						expectedAlias: map[string]interface{}{},
+					},
+				}
+			}
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+					Body:       structToJsonBody(response),
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualIndices, actualErr = client.AliasIndices(ctx, expectedAlias)
+		})
+
+		It("should return the indices behind the alias", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(transport.ReceivedHttpRequests[0].Method).To(Equal(http.MethodGet))
+			Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal(fmt.Sprintf("/_alias/%s", expectedAlias)))
+			Expect(actualIndices).To(Equal(expectedIndices))
+		})
+
+		When("the alias doesn't exist", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0].StatusCode = http.StatusNotFound
+			})
+
+			It("should return no indices", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualIndices).To(BeEmpty())
+			})
+		})
+
+		When("the request fails", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0].StatusCode = http.StatusInternalServerError
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("OpenPointInTime", func() {
+		var (
+			expectedIndex string
+			expectedPitId string
+
+			actualPitId string
+			actualErr   error
+		)
+
+		BeforeEach(func() {
+			expectedIndex = fake.LetterN(10)
+			expectedPitId = fake.LetterN(10)
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+					Body:       structToJsonBody(&ESPitResponse{Id: expectedPitId}),
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualPitId, actualErr = client.OpenPointInTime(ctx, expectedIndex, "1m")
+		})
+
+		It("should open a PIT on the index", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualPitId).To(Equal(expectedPitId))
+			Expect(transport.ReceivedHttpRequests[0].Method).To(Equal(http.MethodPost))
+			Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal(fmt.Sprintf("/%s/_pit", expectedIndex)))
+			Expect(transport.ReceivedHttpRequests[0].URL.Query().Get("keep_alive")).To(Equal("1m"))
+		})
+
+		When("the request fails", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0].StatusCode = http.StatusInternalServerError
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(actualPitId).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("ClosePointInTime", func() {
+		var (
+			expectedPitId string
+			actualErr     error
+		)
+
+		BeforeEach(func() {
+			expectedPitId = fake.LetterN(10)
+
+			transport.PreparedHttpResponses = []*http.Response{
+				{
+					StatusCode: http.StatusOK,
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualErr = client.ClosePointInTime(ctx, expectedPitId)
+		})
+
+		It("should close the PIT", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(transport.ReceivedHttpRequests[0].Method).To(Equal(http.MethodDelete))
+			Expect(transport.ReceivedHttpRequests[0].URL.Path).To(Equal("/_pit"))
+
+			actualRequest := &EsSearchPit{}
+			ReadRequestBody(transport.ReceivedHttpRequests[0], actualRequest)
+			Expect(actualRequest).To(Equal(&EsSearchPit{Id: expectedPitId}))
+		})
+
+		When("the PIT has already expired", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0].StatusCode = http.StatusNotFound
+			})
+
+			It("should not return an error", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+			})
+		})
+
+		When("the request fails", func() {
+			BeforeEach(func() {
+				transport.PreparedHttpResponses[0].StatusCode = http.StatusInternalServerError
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+	})
 })
 
 func createRandomOccurrence() *pb.Occurrence {
@@ -1335,8 +2247,8 @@ func createRandomSearch() *EsSearch {
 				fake.LetterN(10): fake.LetterN(10),
 			},
 		},
-		Sort: map[string]EsSortOrder{
-			fake.LetterN(10): EsSortOrderDescending,
+		Sort: []EsSortField{
+			{Field: fake.LetterN(10), Order: EsSortOrderDescending},
 		},
 		Collapse: &EsSearchCollapse{
 			Field: fake.LetterN(10),
@@ -9,6 +9,20 @@ import (
 )
 
 type FakeClient struct {
+	AliasIndicesStub        func(context.Context, string) ([]string, error)
+	aliasIndicesMutex       sync.RWMutex
+	aliasIndicesArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	aliasIndicesReturns struct {
+		result1 []string
+		result2 error
+	}
+	aliasIndicesReturnsOnCall map[int]struct {
+		result1 []string
+		result2 error
+	}
 	BulkStub        func(context.Context, *esutil.BulkRequest) (*esutil.EsBulkResponse, error)
 	bulkMutex       sync.RWMutex
 	bulkArgsForCall []struct {
@@ -23,6 +37,32 @@ type FakeClient struct {
 		result1 *esutil.EsBulkResponse
 		result2 error
 	}
+	ClosePointInTimeStub        func(context.Context, string) error
+	closePointInTimeMutex       sync.RWMutex
+	closePointInTimeArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	closePointInTimeReturns struct {
+		result1 error
+	}
+	closePointInTimeReturnsOnCall map[int]struct {
+		result1 error
+	}
+	CountStub        func(context.Context, *esutil.CountRequest) (int, error)
+	countMutex       sync.RWMutex
+	countArgsForCall []struct {
+		arg1 context.Context
+		arg2 *esutil.CountRequest
+	}
+	countReturns struct {
+		result1 int
+		result2 error
+	}
+	countReturnsOnCall map[int]struct {
+		result1 int
+		result2 error
+	}
 	CreateStub        func(context.Context, *esutil.CreateRequest) (string, error)
 	createMutex       sync.RWMutex
 	createArgsForCall []struct {
@@ -37,6 +77,19 @@ type FakeClient struct {
 		result1 string
 		result2 error
 	}
+	CreateIndexStub        func(context.Context, string, *esutil.EsCreateIndex) error
+	createIndexMutex       sync.RWMutex
+	createIndexArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 *esutil.EsCreateIndex
+	}
+	createIndexReturns struct {
+		result1 error
+	}
+	createIndexReturnsOnCall map[int]struct {
+		result1 error
+	}
 	DeleteStub        func(context.Context, *esutil.DeleteRequest) error
 	deleteMutex       sync.RWMutex
 	deleteArgsForCall []struct {
@@ -49,6 +102,32 @@ type FakeClient struct {
 	deleteReturnsOnCall map[int]struct {
 		result1 error
 	}
+	DeleteByQueryStub        func(context.Context, *esutil.DeleteRequest) (*esutil.EsDeleteResponse, error)
+	deleteByQueryMutex       sync.RWMutex
+	deleteByQueryArgsForCall []struct {
+		arg1 context.Context
+		arg2 *esutil.DeleteRequest
+	}
+	deleteByQueryReturns struct {
+		result1 *esutil.EsDeleteResponse
+		result2 error
+	}
+	deleteByQueryReturnsOnCall map[int]struct {
+		result1 *esutil.EsDeleteResponse
+		result2 error
+	}
+	DeleteIndexTemplateStub        func(context.Context, string) error
+	deleteIndexTemplateMutex       sync.RWMutex
+	deleteIndexTemplateArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	deleteIndexTemplateReturns struct {
+		result1 error
+	}
+	deleteIndexTemplateReturnsOnCall map[int]struct {
+		result1 error
+	}
 	GetStub        func(context.Context, *esutil.GetRequest) (*esutil.EsGetResponse, error)
 	getMutex       sync.RWMutex
 	getArgsForCall []struct {
@@ -91,6 +170,60 @@ type FakeClient struct {
 		result1 *esutil.EsMultiSearchResponse
 		result2 error
 	}
+	OpenPointInTimeStub        func(context.Context, string, string) (string, error)
+	openPointInTimeMutex       sync.RWMutex
+	openPointInTimeArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}
+	openPointInTimeReturns struct {
+		result1 string
+		result2 error
+	}
+	openPointInTimeReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	PutComponentTemplateStub        func(context.Context, string, *esutil.EsIndexTemplate) error
+	putComponentTemplateMutex       sync.RWMutex
+	putComponentTemplateArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 *esutil.EsIndexTemplate
+	}
+	putComponentTemplateReturns struct {
+		result1 error
+	}
+	putComponentTemplateReturnsOnCall map[int]struct {
+		result1 error
+	}
+	PutIndexTemplateStub        func(context.Context, string, *esutil.EsIndexTemplate) error
+	putIndexTemplateMutex       sync.RWMutex
+	putIndexTemplateArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 *esutil.EsIndexTemplate
+	}
+	putIndexTemplateReturns struct {
+		result1 error
+	}
+	putIndexTemplateReturnsOnCall map[int]struct {
+		result1 error
+	}
+	PutLifecyclePolicyStub        func(context.Context, string, *esutil.EsLifecyclePolicy) error
+	putLifecyclePolicyMutex       sync.RWMutex
+	putLifecyclePolicyArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 *esutil.EsLifecyclePolicy
+	}
+	putLifecyclePolicyReturns struct {
+		result1 error
+	}
+	putLifecyclePolicyReturnsOnCall map[int]struct {
+		result1 error
+	}
 	SearchStub        func(context.Context, *esutil.SearchRequest) (*esutil.SearchResponse, error)
 	searchMutex       sync.RWMutex
 	searchArgsForCall []struct {
@@ -119,10 +252,101 @@ type FakeClient struct {
 		result1 *esutil.EsIndexDocResponse
 		result2 error
 	}
+	UpdateAliasesStub        func(context.Context, []*esutil.EsAliasAction) error
+	updateAliasesMutex       sync.RWMutex
+	updateAliasesArgsForCall []struct {
+		arg1 context.Context
+		arg2 []*esutil.EsAliasAction
+	}
+	updateAliasesReturns struct {
+		result1 error
+	}
+	updateAliasesReturnsOnCall map[int]struct {
+		result1 error
+	}
+	UpdateByQueryStub        func(context.Context, *esutil.UpdateByQueryRequest) (*esutil.EsUpdateByQueryResponse, error)
+	updateByQueryMutex       sync.RWMutex
+	updateByQueryArgsForCall []struct {
+		arg1 context.Context
+		arg2 *esutil.UpdateByQueryRequest
+	}
+	updateByQueryReturns struct {
+		result1 *esutil.EsUpdateByQueryResponse
+		result2 error
+	}
+	updateByQueryReturnsOnCall map[int]struct {
+		result1 *esutil.EsUpdateByQueryResponse
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
+func (fake *FakeClient) AliasIndices(arg1 context.Context, arg2 string) ([]string, error) {
+	fake.aliasIndicesMutex.Lock()
+	ret, specificReturn := fake.aliasIndicesReturnsOnCall[len(fake.aliasIndicesArgsForCall)]
+	fake.aliasIndicesArgsForCall = append(fake.aliasIndicesArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.AliasIndicesStub
+	fakeReturns := fake.aliasIndicesReturns
+	fake.recordInvocation("AliasIndices", []interface{}{arg1, arg2})
+	fake.aliasIndicesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) AliasIndicesCallCount() int {
+	fake.aliasIndicesMutex.RLock()
+	defer fake.aliasIndicesMutex.RUnlock()
+	return len(fake.aliasIndicesArgsForCall)
+}
+
+func (fake *FakeClient) AliasIndicesCalls(stub func(context.Context, string) ([]string, error)) {
+	fake.aliasIndicesMutex.Lock()
+	defer fake.aliasIndicesMutex.Unlock()
+	fake.AliasIndicesStub = stub
+}
+
+func (fake *FakeClient) AliasIndicesArgsForCall(i int) (context.Context, string) {
+	fake.aliasIndicesMutex.RLock()
+	defer fake.aliasIndicesMutex.RUnlock()
+	argsForCall := fake.aliasIndicesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) AliasIndicesReturns(result1 []string, result2 error) {
+	fake.aliasIndicesMutex.Lock()
+	defer fake.aliasIndicesMutex.Unlock()
+	fake.AliasIndicesStub = nil
+	fake.aliasIndicesReturns = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) AliasIndicesReturnsOnCall(i int, result1 []string, result2 error) {
+	fake.aliasIndicesMutex.Lock()
+	defer fake.aliasIndicesMutex.Unlock()
+	fake.AliasIndicesStub = nil
+	if fake.aliasIndicesReturnsOnCall == nil {
+		fake.aliasIndicesReturnsOnCall = make(map[int]struct {
+			result1 []string
+			result2 error
+		})
+	}
+	fake.aliasIndicesReturnsOnCall[i] = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) Bulk(arg1 context.Context, arg2 *esutil.BulkRequest) (*esutil.EsBulkResponse, error) {
 	fake.bulkMutex.Lock()
 	ret, specificReturn := fake.bulkReturnsOnCall[len(fake.bulkArgsForCall)]
@@ -188,6 +412,133 @@ func (fake *FakeClient) BulkReturnsOnCall(i int, result1 *esutil.EsBulkResponse,
 	}{result1, result2}
 }
 
+func (fake *FakeClient) ClosePointInTime(arg1 context.Context, arg2 string) error {
+	fake.closePointInTimeMutex.Lock()
+	ret, specificReturn := fake.closePointInTimeReturnsOnCall[len(fake.closePointInTimeArgsForCall)]
+	fake.closePointInTimeArgsForCall = append(fake.closePointInTimeArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.ClosePointInTimeStub
+	fakeReturns := fake.closePointInTimeReturns
+	fake.recordInvocation("ClosePointInTime", []interface{}{arg1, arg2})
+	fake.closePointInTimeMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) ClosePointInTimeCallCount() int {
+	fake.closePointInTimeMutex.RLock()
+	defer fake.closePointInTimeMutex.RUnlock()
+	return len(fake.closePointInTimeArgsForCall)
+}
+
+func (fake *FakeClient) ClosePointInTimeCalls(stub func(context.Context, string) error) {
+	fake.closePointInTimeMutex.Lock()
+	defer fake.closePointInTimeMutex.Unlock()
+	fake.ClosePointInTimeStub = stub
+}
+
+func (fake *FakeClient) ClosePointInTimeArgsForCall(i int) (context.Context, string) {
+	fake.closePointInTimeMutex.RLock()
+	defer fake.closePointInTimeMutex.RUnlock()
+	argsForCall := fake.closePointInTimeArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) ClosePointInTimeReturns(result1 error) {
+	fake.closePointInTimeMutex.Lock()
+	defer fake.closePointInTimeMutex.Unlock()
+	fake.ClosePointInTimeStub = nil
+	fake.closePointInTimeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) ClosePointInTimeReturnsOnCall(i int, result1 error) {
+	fake.closePointInTimeMutex.Lock()
+	defer fake.closePointInTimeMutex.Unlock()
+	fake.ClosePointInTimeStub = nil
+	if fake.closePointInTimeReturnsOnCall == nil {
+		fake.closePointInTimeReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.closePointInTimeReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) Count(arg1 context.Context, arg2 *esutil.CountRequest) (int, error) {
+	fake.countMutex.Lock()
+	ret, specificReturn := fake.countReturnsOnCall[len(fake.countArgsForCall)]
+	fake.countArgsForCall = append(fake.countArgsForCall, struct {
+		arg1 context.Context
+		arg2 *esutil.CountRequest
+	}{arg1, arg2})
+	stub := fake.CountStub
+	fakeReturns := fake.countReturns
+	fake.recordInvocation("Count", []interface{}{arg1, arg2})
+	fake.countMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) CountCallCount() int {
+	fake.countMutex.RLock()
+	defer fake.countMutex.RUnlock()
+	return len(fake.countArgsForCall)
+}
+
+func (fake *FakeClient) CountCalls(stub func(context.Context, *esutil.CountRequest) (int, error)) {
+	fake.countMutex.Lock()
+	defer fake.countMutex.Unlock()
+	fake.CountStub = stub
+}
+
+func (fake *FakeClient) CountArgsForCall(i int) (context.Context, *esutil.CountRequest) {
+	fake.countMutex.RLock()
+	defer fake.countMutex.RUnlock()
+	argsForCall := fake.countArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) CountReturns(result1 int, result2 error) {
+	fake.countMutex.Lock()
+	defer fake.countMutex.Unlock()
+	fake.CountStub = nil
+	fake.countReturns = struct {
+		result1 int
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) CountReturnsOnCall(i int, result1 int, result2 error) {
+	fake.countMutex.Lock()
+	defer fake.countMutex.Unlock()
+	fake.CountStub = nil
+	if fake.countReturnsOnCall == nil {
+		fake.countReturnsOnCall = make(map[int]struct {
+			result1 int
+			result2 error
+		})
+	}
+	fake.countReturnsOnCall[i] = struct {
+		result1 int
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) Create(arg1 context.Context, arg2 *esutil.CreateRequest) (string, error) {
 	fake.createMutex.Lock()
 	ret, specificReturn := fake.createReturnsOnCall[len(fake.createArgsForCall)]
@@ -253,6 +604,69 @@ func (fake *FakeClient) CreateReturnsOnCall(i int, result1 string, result2 error
 	}{result1, result2}
 }
 
+func (fake *FakeClient) CreateIndex(arg1 context.Context, arg2 string, arg3 *esutil.EsCreateIndex) error {
+	fake.createIndexMutex.Lock()
+	ret, specificReturn := fake.createIndexReturnsOnCall[len(fake.createIndexArgsForCall)]
+	fake.createIndexArgsForCall = append(fake.createIndexArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 *esutil.EsCreateIndex
+	}{arg1, arg2, arg3})
+	stub := fake.CreateIndexStub
+	fakeReturns := fake.createIndexReturns
+	fake.recordInvocation("CreateIndex", []interface{}{arg1, arg2, arg3})
+	fake.createIndexMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) CreateIndexCallCount() int {
+	fake.createIndexMutex.RLock()
+	defer fake.createIndexMutex.RUnlock()
+	return len(fake.createIndexArgsForCall)
+}
+
+func (fake *FakeClient) CreateIndexCalls(stub func(context.Context, string, *esutil.EsCreateIndex) error) {
+	fake.createIndexMutex.Lock()
+	defer fake.createIndexMutex.Unlock()
+	fake.CreateIndexStub = stub
+}
+
+func (fake *FakeClient) CreateIndexArgsForCall(i int) (context.Context, string, *esutil.EsCreateIndex) {
+	fake.createIndexMutex.RLock()
+	defer fake.createIndexMutex.RUnlock()
+	argsForCall := fake.createIndexArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) CreateIndexReturns(result1 error) {
+	fake.createIndexMutex.Lock()
+	defer fake.createIndexMutex.Unlock()
+	fake.CreateIndexStub = nil
+	fake.createIndexReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) CreateIndexReturnsOnCall(i int, result1 error) {
+	fake.createIndexMutex.Lock()
+	defer fake.createIndexMutex.Unlock()
+	fake.CreateIndexStub = nil
+	if fake.createIndexReturnsOnCall == nil {
+		fake.createIndexReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createIndexReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeClient) Delete(arg1 context.Context, arg2 *esutil.DeleteRequest) error {
 	fake.deleteMutex.Lock()
 	ret, specificReturn := fake.deleteReturnsOnCall[len(fake.deleteArgsForCall)]
@@ -315,6 +729,133 @@ func (fake *FakeClient) DeleteReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeClient) DeleteByQuery(arg1 context.Context, arg2 *esutil.DeleteRequest) (*esutil.EsDeleteResponse, error) {
+	fake.deleteByQueryMutex.Lock()
+	ret, specificReturn := fake.deleteByQueryReturnsOnCall[len(fake.deleteByQueryArgsForCall)]
+	fake.deleteByQueryArgsForCall = append(fake.deleteByQueryArgsForCall, struct {
+		arg1 context.Context
+		arg2 *esutil.DeleteRequest
+	}{arg1, arg2})
+	stub := fake.DeleteByQueryStub
+	fakeReturns := fake.deleteByQueryReturns
+	fake.recordInvocation("DeleteByQuery", []interface{}{arg1, arg2})
+	fake.deleteByQueryMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) DeleteByQueryCallCount() int {
+	fake.deleteByQueryMutex.RLock()
+	defer fake.deleteByQueryMutex.RUnlock()
+	return len(fake.deleteByQueryArgsForCall)
+}
+
+func (fake *FakeClient) DeleteByQueryCalls(stub func(context.Context, *esutil.DeleteRequest) (*esutil.EsDeleteResponse, error)) {
+	fake.deleteByQueryMutex.Lock()
+	defer fake.deleteByQueryMutex.Unlock()
+	fake.DeleteByQueryStub = stub
+}
+
+func (fake *FakeClient) DeleteByQueryArgsForCall(i int) (context.Context, *esutil.DeleteRequest) {
+	fake.deleteByQueryMutex.RLock()
+	defer fake.deleteByQueryMutex.RUnlock()
+	argsForCall := fake.deleteByQueryArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) DeleteByQueryReturns(result1 *esutil.EsDeleteResponse, result2 error) {
+	fake.deleteByQueryMutex.Lock()
+	defer fake.deleteByQueryMutex.Unlock()
+	fake.DeleteByQueryStub = nil
+	fake.deleteByQueryReturns = struct {
+		result1 *esutil.EsDeleteResponse
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) DeleteByQueryReturnsOnCall(i int, result1 *esutil.EsDeleteResponse, result2 error) {
+	fake.deleteByQueryMutex.Lock()
+	defer fake.deleteByQueryMutex.Unlock()
+	fake.DeleteByQueryStub = nil
+	if fake.deleteByQueryReturnsOnCall == nil {
+		fake.deleteByQueryReturnsOnCall = make(map[int]struct {
+			result1 *esutil.EsDeleteResponse
+			result2 error
+		})
+	}
+	fake.deleteByQueryReturnsOnCall[i] = struct {
+		result1 *esutil.EsDeleteResponse
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) DeleteIndexTemplate(arg1 context.Context, arg2 string) error {
+	fake.deleteIndexTemplateMutex.Lock()
+	ret, specificReturn := fake.deleteIndexTemplateReturnsOnCall[len(fake.deleteIndexTemplateArgsForCall)]
+	fake.deleteIndexTemplateArgsForCall = append(fake.deleteIndexTemplateArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.DeleteIndexTemplateStub
+	fakeReturns := fake.deleteIndexTemplateReturns
+	fake.recordInvocation("DeleteIndexTemplate", []interface{}{arg1, arg2})
+	fake.deleteIndexTemplateMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) DeleteIndexTemplateCallCount() int {
+	fake.deleteIndexTemplateMutex.RLock()
+	defer fake.deleteIndexTemplateMutex.RUnlock()
+	return len(fake.deleteIndexTemplateArgsForCall)
+}
+
+func (fake *FakeClient) DeleteIndexTemplateCalls(stub func(context.Context, string) error) {
+	fake.deleteIndexTemplateMutex.Lock()
+	defer fake.deleteIndexTemplateMutex.Unlock()
+	fake.DeleteIndexTemplateStub = stub
+}
+
+func (fake *FakeClient) DeleteIndexTemplateArgsForCall(i int) (context.Context, string) {
+	fake.deleteIndexTemplateMutex.RLock()
+	defer fake.deleteIndexTemplateMutex.RUnlock()
+	argsForCall := fake.deleteIndexTemplateArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) DeleteIndexTemplateReturns(result1 error) {
+	fake.deleteIndexTemplateMutex.Lock()
+	defer fake.deleteIndexTemplateMutex.Unlock()
+	fake.DeleteIndexTemplateStub = nil
+	fake.deleteIndexTemplateReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) DeleteIndexTemplateReturnsOnCall(i int, result1 error) {
+	fake.deleteIndexTemplateMutex.Lock()
+	defer fake.deleteIndexTemplateMutex.Unlock()
+	fake.DeleteIndexTemplateStub = nil
+	if fake.deleteIndexTemplateReturnsOnCall == nil {
+		fake.deleteIndexTemplateReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteIndexTemplateReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeClient) Get(arg1 context.Context, arg2 *esutil.GetRequest) (*esutil.EsGetResponse, error) {
 	fake.getMutex.Lock()
 	ret, specificReturn := fake.getReturnsOnCall[len(fake.getArgsForCall)]
@@ -510,6 +1051,261 @@ func (fake *FakeClient) MultiSearchReturnsOnCall(i int, result1 *esutil.EsMultiS
 	}{result1, result2}
 }
 
+func (fake *FakeClient) OpenPointInTime(arg1 context.Context, arg2 string, arg3 string) (string, error) {
+	fake.openPointInTimeMutex.Lock()
+	ret, specificReturn := fake.openPointInTimeReturnsOnCall[len(fake.openPointInTimeArgsForCall)]
+	fake.openPointInTimeArgsForCall = append(fake.openPointInTimeArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.OpenPointInTimeStub
+	fakeReturns := fake.openPointInTimeReturns
+	fake.recordInvocation("OpenPointInTime", []interface{}{arg1, arg2, arg3})
+	fake.openPointInTimeMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) OpenPointInTimeCallCount() int {
+	fake.openPointInTimeMutex.RLock()
+	defer fake.openPointInTimeMutex.RUnlock()
+	return len(fake.openPointInTimeArgsForCall)
+}
+
+func (fake *FakeClient) OpenPointInTimeCalls(stub func(context.Context, string, string) (string, error)) {
+	fake.openPointInTimeMutex.Lock()
+	defer fake.openPointInTimeMutex.Unlock()
+	fake.OpenPointInTimeStub = stub
+}
+
+func (fake *FakeClient) OpenPointInTimeArgsForCall(i int) (context.Context, string, string) {
+	fake.openPointInTimeMutex.RLock()
+	defer fake.openPointInTimeMutex.RUnlock()
+	argsForCall := fake.openPointInTimeArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) OpenPointInTimeReturns(result1 string, result2 error) {
+	fake.openPointInTimeMutex.Lock()
+	defer fake.openPointInTimeMutex.Unlock()
+	fake.OpenPointInTimeStub = nil
+	fake.openPointInTimeReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) OpenPointInTimeReturnsOnCall(i int, result1 string, result2 error) {
+	fake.openPointInTimeMutex.Lock()
+	defer fake.openPointInTimeMutex.Unlock()
+	fake.OpenPointInTimeStub = nil
+	if fake.openPointInTimeReturnsOnCall == nil {
+		fake.openPointInTimeReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.openPointInTimeReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) PutComponentTemplate(arg1 context.Context, arg2 string, arg3 *esutil.EsIndexTemplate) error {
+	fake.putComponentTemplateMutex.Lock()
+	ret, specificReturn := fake.putComponentTemplateReturnsOnCall[len(fake.putComponentTemplateArgsForCall)]
+	fake.putComponentTemplateArgsForCall = append(fake.putComponentTemplateArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 *esutil.EsIndexTemplate
+	}{arg1, arg2, arg3})
+	stub := fake.PutComponentTemplateStub
+	fakeReturns := fake.putComponentTemplateReturns
+	fake.recordInvocation("PutComponentTemplate", []interface{}{arg1, arg2, arg3})
+	fake.putComponentTemplateMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) PutComponentTemplateCallCount() int {
+	fake.putComponentTemplateMutex.RLock()
+	defer fake.putComponentTemplateMutex.RUnlock()
+	return len(fake.putComponentTemplateArgsForCall)
+}
+
+func (fake *FakeClient) PutComponentTemplateCalls(stub func(context.Context, string, *esutil.EsIndexTemplate) error) {
+	fake.putComponentTemplateMutex.Lock()
+	defer fake.putComponentTemplateMutex.Unlock()
+	fake.PutComponentTemplateStub = stub
+}
+
+func (fake *FakeClient) PutComponentTemplateArgsForCall(i int) (context.Context, string, *esutil.EsIndexTemplate) {
+	fake.putComponentTemplateMutex.RLock()
+	defer fake.putComponentTemplateMutex.RUnlock()
+	argsForCall := fake.putComponentTemplateArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) PutComponentTemplateReturns(result1 error) {
+	fake.putComponentTemplateMutex.Lock()
+	defer fake.putComponentTemplateMutex.Unlock()
+	fake.PutComponentTemplateStub = nil
+	fake.putComponentTemplateReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) PutComponentTemplateReturnsOnCall(i int, result1 error) {
+	fake.putComponentTemplateMutex.Lock()
+	defer fake.putComponentTemplateMutex.Unlock()
+	fake.PutComponentTemplateStub = nil
+	if fake.putComponentTemplateReturnsOnCall == nil {
+		fake.putComponentTemplateReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.putComponentTemplateReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) PutIndexTemplate(arg1 context.Context, arg2 string, arg3 *esutil.EsIndexTemplate) error {
+	fake.putIndexTemplateMutex.Lock()
+	ret, specificReturn := fake.putIndexTemplateReturnsOnCall[len(fake.putIndexTemplateArgsForCall)]
+	fake.putIndexTemplateArgsForCall = append(fake.putIndexTemplateArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 *esutil.EsIndexTemplate
+	}{arg1, arg2, arg3})
+	stub := fake.PutIndexTemplateStub
+	fakeReturns := fake.putIndexTemplateReturns
+	fake.recordInvocation("PutIndexTemplate", []interface{}{arg1, arg2, arg3})
+	fake.putIndexTemplateMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) PutIndexTemplateCallCount() int {
+	fake.putIndexTemplateMutex.RLock()
+	defer fake.putIndexTemplateMutex.RUnlock()
+	return len(fake.putIndexTemplateArgsForCall)
+}
+
+func (fake *FakeClient) PutIndexTemplateCalls(stub func(context.Context, string, *esutil.EsIndexTemplate) error) {
+	fake.putIndexTemplateMutex.Lock()
+	defer fake.putIndexTemplateMutex.Unlock()
+	fake.PutIndexTemplateStub = stub
+}
+
+func (fake *FakeClient) PutIndexTemplateArgsForCall(i int) (context.Context, string, *esutil.EsIndexTemplate) {
+	fake.putIndexTemplateMutex.RLock()
+	defer fake.putIndexTemplateMutex.RUnlock()
+	argsForCall := fake.putIndexTemplateArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) PutIndexTemplateReturns(result1 error) {
+	fake.putIndexTemplateMutex.Lock()
+	defer fake.putIndexTemplateMutex.Unlock()
+	fake.PutIndexTemplateStub = nil
+	fake.putIndexTemplateReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) PutIndexTemplateReturnsOnCall(i int, result1 error) {
+	fake.putIndexTemplateMutex.Lock()
+	defer fake.putIndexTemplateMutex.Unlock()
+	fake.PutIndexTemplateStub = nil
+	if fake.putIndexTemplateReturnsOnCall == nil {
+		fake.putIndexTemplateReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.putIndexTemplateReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) PutLifecyclePolicy(arg1 context.Context, arg2 string, arg3 *esutil.EsLifecyclePolicy) error {
+	fake.putLifecyclePolicyMutex.Lock()
+	ret, specificReturn := fake.putLifecyclePolicyReturnsOnCall[len(fake.putLifecyclePolicyArgsForCall)]
+	fake.putLifecyclePolicyArgsForCall = append(fake.putLifecyclePolicyArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 *esutil.EsLifecyclePolicy
+	}{arg1, arg2, arg3})
+	stub := fake.PutLifecyclePolicyStub
+	fakeReturns := fake.putLifecyclePolicyReturns
+	fake.recordInvocation("PutLifecyclePolicy", []interface{}{arg1, arg2, arg3})
+	fake.putLifecyclePolicyMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) PutLifecyclePolicyCallCount() int {
+	fake.putLifecyclePolicyMutex.RLock()
+	defer fake.putLifecyclePolicyMutex.RUnlock()
+	return len(fake.putLifecyclePolicyArgsForCall)
+}
+
+func (fake *FakeClient) PutLifecyclePolicyCalls(stub func(context.Context, string, *esutil.EsLifecyclePolicy) error) {
+	fake.putLifecyclePolicyMutex.Lock()
+	defer fake.putLifecyclePolicyMutex.Unlock()
+	fake.PutLifecyclePolicyStub = stub
+}
+
+func (fake *FakeClient) PutLifecyclePolicyArgsForCall(i int) (context.Context, string, *esutil.EsLifecyclePolicy) {
+	fake.putLifecyclePolicyMutex.RLock()
+	defer fake.putLifecyclePolicyMutex.RUnlock()
+	argsForCall := fake.putLifecyclePolicyArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) PutLifecyclePolicyReturns(result1 error) {
+	fake.putLifecyclePolicyMutex.Lock()
+	defer fake.putLifecyclePolicyMutex.Unlock()
+	fake.PutLifecyclePolicyStub = nil
+	fake.putLifecyclePolicyReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) PutLifecyclePolicyReturnsOnCall(i int, result1 error) {
+	fake.putLifecyclePolicyMutex.Lock()
+	defer fake.putLifecyclePolicyMutex.Unlock()
+	fake.PutLifecyclePolicyStub = nil
+	if fake.putLifecyclePolicyReturnsOnCall == nil {
+		fake.putLifecyclePolicyReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.putLifecyclePolicyReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeClient) Search(arg1 context.Context, arg2 *esutil.SearchRequest) (*esutil.SearchResponse, error) {
 	fake.searchMutex.Lock()
 	ret, specificReturn := fake.searchReturnsOnCall[len(fake.searchArgsForCall)]
@@ -640,25 +1436,181 @@ func (fake *FakeClient) UpdateReturnsOnCall(i int, result1 *esutil.EsIndexDocRes
 	}{result1, result2}
 }
 
+func (fake *FakeClient) UpdateAliases(arg1 context.Context, arg2 []*esutil.EsAliasAction) error {
+	var arg2Copy []*esutil.EsAliasAction
+	if arg2 != nil {
+		arg2Copy = make([]*esutil.EsAliasAction, len(arg2))
+		copy(arg2Copy, arg2)
+	}
+	fake.updateAliasesMutex.Lock()
+	ret, specificReturn := fake.updateAliasesReturnsOnCall[len(fake.updateAliasesArgsForCall)]
+	fake.updateAliasesArgsForCall = append(fake.updateAliasesArgsForCall, struct {
+		arg1 context.Context
+		arg2 []*esutil.EsAliasAction
+	}{arg1, arg2Copy})
+	stub := fake.UpdateAliasesStub
+	fakeReturns := fake.updateAliasesReturns
+	fake.recordInvocation("UpdateAliases", []interface{}{arg1, arg2Copy})
+	fake.updateAliasesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) UpdateAliasesCallCount() int {
+	fake.updateAliasesMutex.RLock()
+	defer fake.updateAliasesMutex.RUnlock()
+	return len(fake.updateAliasesArgsForCall)
+}
+
+func (fake *FakeClient) UpdateAliasesCalls(stub func(context.Context, []*esutil.EsAliasAction) error) {
+	fake.updateAliasesMutex.Lock()
+	defer fake.updateAliasesMutex.Unlock()
+	fake.UpdateAliasesStub = stub
+}
+
+func (fake *FakeClient) UpdateAliasesArgsForCall(i int) (context.Context, []*esutil.EsAliasAction) {
+	fake.updateAliasesMutex.RLock()
+	defer fake.updateAliasesMutex.RUnlock()
+	argsForCall := fake.updateAliasesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) UpdateAliasesReturns(result1 error) {
+	fake.updateAliasesMutex.Lock()
+	defer fake.updateAliasesMutex.Unlock()
+	fake.UpdateAliasesStub = nil
+	fake.updateAliasesReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) UpdateAliasesReturnsOnCall(i int, result1 error) {
+	fake.updateAliasesMutex.Lock()
+	defer fake.updateAliasesMutex.Unlock()
+	fake.UpdateAliasesStub = nil
+	if fake.updateAliasesReturnsOnCall == nil {
+		fake.updateAliasesReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.updateAliasesReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) UpdateByQuery(arg1 context.Context, arg2 *esutil.UpdateByQueryRequest) (*esutil.EsUpdateByQueryResponse, error) {
+	fake.updateByQueryMutex.Lock()
+	ret, specificReturn := fake.updateByQueryReturnsOnCall[len(fake.updateByQueryArgsForCall)]
+	fake.updateByQueryArgsForCall = append(fake.updateByQueryArgsForCall, struct {
+		arg1 context.Context
+		arg2 *esutil.UpdateByQueryRequest
+	}{arg1, arg2})
+	stub := fake.UpdateByQueryStub
+	fakeReturns := fake.updateByQueryReturns
+	fake.recordInvocation("UpdateByQuery", []interface{}{arg1, arg2})
+	fake.updateByQueryMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) UpdateByQueryCallCount() int {
+	fake.updateByQueryMutex.RLock()
+	defer fake.updateByQueryMutex.RUnlock()
+	return len(fake.updateByQueryArgsForCall)
+}
+
+func (fake *FakeClient) UpdateByQueryCalls(stub func(context.Context, *esutil.UpdateByQueryRequest) (*esutil.EsUpdateByQueryResponse, error)) {
+	fake.updateByQueryMutex.Lock()
+	defer fake.updateByQueryMutex.Unlock()
+	fake.UpdateByQueryStub = stub
+}
+
+func (fake *FakeClient) UpdateByQueryArgsForCall(i int) (context.Context, *esutil.UpdateByQueryRequest) {
+	fake.updateByQueryMutex.RLock()
+	defer fake.updateByQueryMutex.RUnlock()
+	argsForCall := fake.updateByQueryArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) UpdateByQueryReturns(result1 *esutil.EsUpdateByQueryResponse, result2 error) {
+	fake.updateByQueryMutex.Lock()
+	defer fake.updateByQueryMutex.Unlock()
+	fake.UpdateByQueryStub = nil
+	fake.updateByQueryReturns = struct {
+		result1 *esutil.EsUpdateByQueryResponse
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) UpdateByQueryReturnsOnCall(i int, result1 *esutil.EsUpdateByQueryResponse, result2 error) {
+	fake.updateByQueryMutex.Lock()
+	defer fake.updateByQueryMutex.Unlock()
+	fake.UpdateByQueryStub = nil
+	if fake.updateByQueryReturnsOnCall == nil {
+		fake.updateByQueryReturnsOnCall = make(map[int]struct {
+			result1 *esutil.EsUpdateByQueryResponse
+			result2 error
+		})
+	}
+	fake.updateByQueryReturnsOnCall[i] = struct {
+		result1 *esutil.EsUpdateByQueryResponse
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
+	fake.aliasIndicesMutex.RLock()
+	defer fake.aliasIndicesMutex.RUnlock()
 	fake.bulkMutex.RLock()
 	defer fake.bulkMutex.RUnlock()
+	fake.closePointInTimeMutex.RLock()
+	defer fake.closePointInTimeMutex.RUnlock()
+	fake.countMutex.RLock()
+	defer fake.countMutex.RUnlock()
 	fake.createMutex.RLock()
 	defer fake.createMutex.RUnlock()
+	fake.createIndexMutex.RLock()
+	defer fake.createIndexMutex.RUnlock()
 	fake.deleteMutex.RLock()
 	defer fake.deleteMutex.RUnlock()
+	fake.deleteByQueryMutex.RLock()
+	defer fake.deleteByQueryMutex.RUnlock()
+	fake.deleteIndexTemplateMutex.RLock()
+	defer fake.deleteIndexTemplateMutex.RUnlock()
 	fake.getMutex.RLock()
 	defer fake.getMutex.RUnlock()
 	fake.multiGetMutex.RLock()
 	defer fake.multiGetMutex.RUnlock()
 	fake.multiSearchMutex.RLock()
 	defer fake.multiSearchMutex.RUnlock()
+	fake.openPointInTimeMutex.RLock()
+	defer fake.openPointInTimeMutex.RUnlock()
+	fake.putComponentTemplateMutex.RLock()
+	defer fake.putComponentTemplateMutex.RUnlock()
+	fake.putIndexTemplateMutex.RLock()
+	defer fake.putIndexTemplateMutex.RUnlock()
+	fake.putLifecyclePolicyMutex.RLock()
+	defer fake.putLifecyclePolicyMutex.RUnlock()
 	fake.searchMutex.RLock()
 	defer fake.searchMutex.RUnlock()
 	fake.updateMutex.RLock()
 	defer fake.updateMutex.RUnlock()
+	fake.updateAliasesMutex.RLock()
+	defer fake.updateAliasesMutex.RUnlock()
+	fake.updateByQueryMutex.RLock()
+	defer fake.updateByQueryMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
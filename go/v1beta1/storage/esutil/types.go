@@ -16,6 +16,7 @@ package esutil
 
 import (
 	"encoding/json"
+	"fmt"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
@@ -42,6 +43,8 @@ type EsSearchResponseTotal struct {
 
 type EsSearchResponseHit struct {
 	ID         string          `json:"_id"`
+	Index      string          `json:"_index"`
+	Routing    string          `json:"_routing"`
 	Source     json.RawMessage `json:"_source"`
 	Highlights json.RawMessage `json:"highlight"`
 	Sort       []interface{}   `json:"sort"`
@@ -50,11 +53,14 @@ type EsSearchResponseHit struct {
 // Elasticsearch /_search query
 
 type EsSearch struct {
-	Query    *filtering.Query       `json:"query,omitempty"`
-	Sort     map[string]EsSortOrder `json:"sort,omitempty"`
-	Collapse *EsSearchCollapse      `json:"collapse,omitempty"`
-	Pit      *EsSearchPit           `json:"pit,omitempty"`
-	Routing  string                 `json:"-"`
+	Query     *filtering.Query  `json:"query,omitempty"`
+	Sort      []EsSortField     `json:"sort,omitempty"`
+	Collapse  *EsSearchCollapse `json:"collapse,omitempty"`
+	Pit       *EsSearchPit      `json:"pit,omitempty"`
+	Highlight *EsHighlight      `json:"highlight,omitempty"`
+	// SearchAfter is the sort values of the last hit of the previous page, for paging through a PIT without the limit on from and size
+	SearchAfter []interface{} `json:"search_after,omitempty"`
+	Routing     string        `json:"-"`
 }
 
 type EsSortOrder string
@@ -64,13 +70,57 @@ const (
 	EsSortOrderDescending EsSortOrder = "desc"
 )
 
+// EsSortField sorts hits by a field. Hits are sorted by each field in the order they're given, with ties broken by the next.
+type EsSortField struct {
+	Field string
+	Order EsSortOrder
+}
+
+// MarshalJSON encodes the field as {"<field>": "<order>"}
+func (s EsSortField) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]EsSortOrder{s.Field: s.Order})
+}
+
+func (s *EsSortField) UnmarshalJSON(data []byte) error {
+	var field map[string]EsSortOrder
+	if err := json.Unmarshal(data, &field); err != nil {
+		return err
+	}
+	if len(field) != 1 {
+		return fmt.Errorf("expected a single sort field, got %d", len(field))
+	}
+
+	for name, order := range field {
+		s.Field = name
+		s.Order = order
+	}
+
+	return nil
+}
+
 type EsSearchCollapse struct {
 	Field string `json:"field,omitempty"`
 }
 
 type EsSearchPit struct {
 	Id        string `json:"id"`
-	KeepAlive string `json:"keep_alive"`
+	KeepAlive string `json:"keep_alive,omitempty"`
+}
+
+// EsHighlight requests fragments of the matching text in each hit, returned in EsSearchResponseHit.Highlights
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.x/highlighting.html
+type EsHighlight struct {
+	Fields            map[string]*EsHighlightField `json:"fields"`
+	PreTags           []string                     `json:"pre_tags,omitempty"`
+	PostTags          []string                     `json:"post_tags,omitempty"`
+	FragmentSize      int                          `json:"fragment_size,omitempty"`
+	NumberOfFragments int                          `json:"number_of_fragments,omitempty"`
+}
+
+// EsHighlightField overrides the highlight settings for a single field
+type EsHighlightField struct {
+	FragmentSize      int `json:"fragment_size,omitempty"`
+	NumberOfFragments int `json:"number_of_fragments,omitempty"`
 }
 
 // Elasticsearch /_doc response
@@ -104,11 +154,43 @@ type EsDeleteResponse struct {
 	Failures             []interface{} `json:"failures"`
 }
 
+// Elasticsearch /_update_by_query request and response
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.10/docs-update-by-query.html
+
+type EsUpdateByQuery struct {
+	Query  *filtering.Query `json:"query,omitempty"`
+	Script *EsScript        `json:"script"`
+}
+
+type EsScript struct {
+	Source string                 `json:"source"`
+	Lang   string                 `json:"lang,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+type EsUpdateByQueryResponse struct {
+	Took             int           `json:"took"`
+	TimedOut         bool          `json:"timed_out"`
+	Total            int           `json:"total"`
+	Updated          int           `json:"updated"`
+	Batches          int           `json:"batches"`
+	VersionConflicts int           `json:"version_conflicts"`
+	Noops            int           `json:"noops"`
+	Failures         []interface{} `json:"failures"`
+}
+
+// Elasticsearch /_count response
+
+type EsCountResponse struct {
+	Count int `json:"count"`
+}
+
 // Elasticsearch /_bulk query fragments
 
 type EsBulkQueryFragment struct {
 	Index  *EsBulkQueryOperationFragment `json:"index,omitempty"`
 	Create *EsBulkQueryOperationFragment `json:"create,omitempty"`
+	Delete *EsBulkQueryOperationFragment `json:"delete,omitempty"`
 }
 
 type EsBulkQueryOperationFragment struct {
@@ -127,6 +209,7 @@ type EsBulkResponse struct {
 type EsBulkResponseItem struct {
 	Index  *EsIndexDocResponse `json:"index,omitempty"`
 	Create *EsIndexDocResponse `json:"create,omitempty"`
+	Delete *EsIndexDocResponse `json:"delete,omitempty"`
 }
 
 // Elasticsearch /_msearch query fragments
@@ -182,6 +265,84 @@ type EsMultiGetResponse struct {
 	Docs []*EsGetResponse `json:"docs"`
 }
 
+// Elasticsearch error response
+
+type EsErrorResponse struct {
+	Error EsError `json:"error"`
+}
+
+type EsError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// Elasticsearch index creation request
+
+type EsCreateIndex struct {
+	Settings map[string]interface{} `json:"settings,omitempty"`
+	Mappings map[string]interface{} `json:"mappings,omitempty"`
+	Aliases  map[string]*EsAlias    `json:"aliases,omitempty"`
+}
+
+type EsAlias struct {
+	IsWriteIndex bool `json:"is_write_index,omitempty"`
+}
+
+// Elasticsearch /_aliases request
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.10/indices-aliases.html
+
+type EsUpdateAliases struct {
+	Actions []*EsAliasAction `json:"actions"`
+}
+
+type EsAliasAction struct {
+	Add    *EsAliasActionDetails `json:"add,omitempty"`
+	Remove *EsAliasActionDetails `json:"remove,omitempty"`
+}
+
+type EsAliasActionDetails struct {
+	Index   string           `json:"index"`
+	Alias   string           `json:"alias"`
+	Filter  *filtering.Query `json:"filter,omitempty"`
+	Routing string           `json:"routing,omitempty"`
+}
+
+// Elasticsearch /_ilm/policy request
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.10/ilm-put-lifecycle.html
+
+type EsLifecyclePolicy struct {
+	Policy *EsLifecyclePolicyPhases `json:"policy"`
+}
+
+type EsLifecyclePolicyPhases struct {
+	Phases map[string]*EsLifecyclePhase `json:"phases"`
+}
+
+type EsLifecyclePhase struct {
+	MinAge  string              `json:"min_age,omitempty"`
+	Actions *EsLifecycleActions `json:"actions"`
+}
+
+type EsLifecycleActions struct {
+	Rollover *EsRolloverAction `json:"rollover,omitempty"`
+}
+
+type EsRolloverAction struct {
+	MaxAge  string `json:"max_age,omitempty"`
+	MaxSize string `json:"max_size,omitempty"`
+	MaxDocs int    `json:"max_docs,omitempty"`
+}
+
+// Elasticsearch /_index_template and /_component_template requests
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.10/index-templates.html
+
+type EsIndexTemplate struct {
+	IndexPatterns []string       `json:"index_patterns,omitempty"`
+	ComposedOf    []string       `json:"composed_of,omitempty"`
+	Priority      int            `json:"priority,omitempty"`
+	Template      *EsCreateIndex `json:"template"`
+}
+
 // response for index creation
 type EsIndexResponse struct {
 	Acknowledged       bool   `json:"acknowledged"`
@@ -207,23 +368,43 @@ type EsDocWithJoin struct {
 }
 
 func (e *EsDocWithJoin) MarshalJSON() ([]byte, error) {
-	joinField := map[string]string{
-		"name": e.Join.Name,
+	return EncodeDocument(e.Message, e.Join, nil)
+}
+
+// EncodeDocument marshals the protobuf message into the source JSON for a document. The join field and any additional fields,
+// such as fields used for routing or filtering that aren't part of the message, are merged into the protobuf JSON as a patch.
+// Documents without a message are made up of only the additional fields.
+func EncodeDocument(message proto.Message, join *EsJoin, fields map[string]interface{}) ([]byte, error) {
+	messageBytes := []byte("{}")
+	if message != nil {
+		var err error
+		messageBytes, err = protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if e.Join.Parent != "" {
-		joinField["parent"] = e.Join.Parent
+
+	if join == nil && len(fields) == 0 {
+		return messageBytes, nil
 	}
 
-	patch := map[string]interface{}{
-		e.Join.Field: joinField,
+	patch := map[string]interface{}{}
+	for field, value := range fields {
+		patch[field] = value
 	}
 
-	patchBytes, err := json.Marshal(patch)
-	if err != nil {
-		return nil, err
+	if join != nil {
+		joinField := map[string]string{
+			"name": join.Name,
+		}
+		if join.Parent != "" {
+			joinField["parent"] = join.Parent
+		}
+
+		patch[join.Field] = joinField
 	}
 
-	messageBytes, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(e.Message)
+	patchBytes, err := json.Marshal(patch)
 	if err != nil {
 		return nil, err
 	}
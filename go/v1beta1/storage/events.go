@@ -0,0 +1,152 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/events"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// eventMetrics are published with expvar under "events"
+var eventMetrics = expvar.NewMap("events")
+
+const (
+	// eventPublishedMetric counts the events delivered by the background publisher
+	eventPublishedMetric = "published"
+	// eventFailedMetric counts the events that the publisher returned an error for
+	eventFailedMetric = "failed"
+	// eventDroppedMetric counts the events that were dropped because the queue was full
+	eventDroppedMetric = "dropped"
+
+	// eventQueueSize is the number of events that can wait to be published before new ones are dropped
+	eventQueueSize = 1000
+)
+
+func (es *ElasticsearchStorage) eventsEnabled() bool {
+	return es.publisher != nil
+}
+
+// publish queues an event for a successful change to a single resource. Previous and current are the states of the resource
+// before and after the change, and either can be nil. The change has already been made, so a failure is logged rather than returned.
+func (es *ElasticsearchStorage) publish(ctx context.Context, log *zap.Logger, eventType events.Type, resourceKind, resource, userID string, previous, current proto.Message) {
+	// the event was written to the outbox along with the change, and is delivered from there
+	if !es.eventsEnabled() || es.outboxEnabled() {
+		return
+	}
+
+	event, err := es.newEvent(ctx, eventType, resourceKind, resource, userID, previous, current)
+	if err != nil {
+		log.Error("error creating event", zap.Error(err), zap.String("resource", resource))
+		return
+	}
+
+	es.queueEvent(log, event)
+}
+
+// queueEvent hands the event to the background publisher without waiting for it to be delivered, so that a slow publisher
+// doesn't hold up the request. The event is dropped if the queue is full.
+func (es *ElasticsearchStorage) queueEvent(log *zap.Logger, event *events.Event) {
+	select {
+	case es.eventQueue <- event:
+	default:
+		eventMetrics.Add(eventDroppedMetric, 1)
+		log.Warn("event queue is full, dropping event", zap.String("type", string(event.Type)), zap.String("resource", event.Resource))
+	}
+}
+
+// RunEvents publishes queued events until the context is done. The context is separate from the requests that made the changes,
+// so that an event isn't lost when its request is cancelled.
+func (es *ElasticsearchStorage) RunEvents(ctx context.Context) {
+	log := es.logger.Named("RunEvents")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug("stopping event publisher")
+			return
+		case event := <-es.eventQueue:
+			es.deliverEvent(ctx, log, event)
+		}
+	}
+}
+
+// deliverQueuedEvents publishes the events that are already queued, without waiting for more
+func (es *ElasticsearchStorage) deliverQueuedEvents(ctx context.Context) {
+	log := es.logger.Named("deliverQueuedEvents")
+
+	for {
+		select {
+		case event := <-es.eventQueue:
+			es.deliverEvent(ctx, log, event)
+		default:
+			return
+		}
+	}
+}
+
+func (es *ElasticsearchStorage) deliverEvent(ctx context.Context, log *zap.Logger, event *events.Event) {
+	if err := es.publisher.Publish(ctx, event); err != nil {
+		eventMetrics.Add(eventFailedMetric, 1)
+		log.Error("error publishing event", zap.Error(err), zap.String("resource", event.Resource))
+		return
+	}
+
+	eventMetrics.Add(eventPublishedMetric, 1)
+}
+
+// publishBatch sends an event for each change successfully made by a batch
+func (es *ElasticsearchStorage) publishBatch(ctx context.Context, log *zap.Logger, changes []*change) {
+	for _, c := range changes {
+		es.publish(ctx, log, c.eventType, c.resourceKind, c.resource, c.userID, c.previous, c.current)
+	}
+}
+
+func (es *ElasticsearchStorage) newEvent(ctx context.Context, eventType events.Type, resourceKind, resource, userID string, previous, current proto.Message) (*events.Event, error) {
+	newJson, err := messageJson(current)
+	if err != nil {
+		return nil, err
+	}
+
+	oldJson, err := messageJson(previous)
+	if err != nil {
+		return nil, err
+	}
+
+	return &events.Event{
+		Type:         eventType,
+		ResourceKind: resourceKind,
+		Resource:     resource,
+		Time:         time.Now().UTC(),
+		UserID:       es.actingUserID(ctx, userID),
+		New:          newJson,
+		Old:          oldJson,
+	}, nil
+}
+
+// messageJson encodes the message the same way that it's stored, or returns nil if there's no message
+func messageJson(message proto.Message) (json.RawMessage, error) {
+	if !isPresent(message) {
+		return nil, nil
+	}
+
+	return protojson.Marshal(proto.MessageV2(message))
+}
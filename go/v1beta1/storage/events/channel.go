@@ -0,0 +1,41 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+)
+
+// ChannelPublisher sends events to a channel, so that they can be consumed in the same process, such as in tests
+type ChannelPublisher struct {
+	Events chan *Event
+}
+
+// NewChannelPublisher returns a publisher with a channel that buffers up to size events
+func NewChannelPublisher(size int) *ChannelPublisher {
+	return &ChannelPublisher{
+		Events: make(chan *Event, size),
+	}
+}
+
+// Publish blocks until the event is received or buffered, or the context is done
+func (p *ChannelPublisher) Publish(ctx context.Context, event *Event) error {
+	select {
+	case p.Events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
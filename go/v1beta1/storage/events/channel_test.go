@@ -0,0 +1,40 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ChannelPublisher", func() {
+	It("should send events to the channel", func() {
+		publisher := NewChannelPublisher(1)
+		event := &Event{Type: Deleted, Resource: fake.LetterN(10)}
+
+		Expect(publisher.Publish(context.Background(), event)).To(Succeed())
+		Expect(publisher.Events).To(Receive(Equal(event)))
+	})
+
+	It("should stop waiting for a full channel when the context is done", func() {
+		publisher := NewChannelPublisher(0)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		Expect(publisher.Publish(ctx, &Event{})).To(MatchError(context.Canceled))
+	})
+})
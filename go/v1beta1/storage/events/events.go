@@ -0,0 +1,55 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+//go:generate counterfeiter -generate
+
+// Type is the kind of change that an event describes
+type Type string
+
+const (
+	Created Type = "CREATED"
+	Updated Type = "UPDATED"
+	Deleted Type = "DELETED"
+)
+
+// Event describes a change to a project, note or occurrence
+type Event struct {
+	Type Type `json:"type"`
+	// ResourceKind is projects, notes or occurrences
+	ResourceKind string `json:"resourceKind"`
+	// Resource is the name of the project, note or occurrence
+	Resource string    `json:"resource"`
+	Time     time.Time `json:"time"`
+	// UserID is the user that made the change, when it's known
+	UserID string `json:"userId,omitempty"`
+	// New is the resource after the change as JSON, which is empty for deletes
+	New json.RawMessage `json:"new,omitempty"`
+	// Old is the resource before the change as JSON, which is empty for creates
+	Old json.RawMessage `json:"old,omitempty"`
+}
+
+//counterfeiter:generate . Publisher
+
+// Publisher is called after each change is made, so an error doesn't undo the change
+type Publisher interface {
+	Publish(ctx context.Context, event *Event) error
+}
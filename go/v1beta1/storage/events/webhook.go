@@ -0,0 +1,138 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignatureHeader holds the HMAC-SHA256 of the request body, as sha256=<hex digest>
+	SignatureHeader = "X-Grafeas-Signature"
+	// EventTypeHeader holds the type of the event, so that receivers can route it without parsing the body
+	EventTypeHeader = "X-Grafeas-Event"
+
+	defaultWebhookBackoff = 500 * time.Millisecond
+)
+
+// WebhookPublisher posts each event as JSON to a URL
+type WebhookPublisher struct {
+	logger     *zap.Logger
+	client     *http.Client
+	url        string
+	secret     []byte
+	maxRetries int
+	timeout    time.Duration
+	backoff    time.Duration
+}
+
+func NewWebhookPublisher(logger *zap.Logger, client *http.Client, c *config.WebhookConfig) (*WebhookPublisher, error) {
+	timeout, err := c.TimeoutDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebhookPublisher{
+		logger:     logger,
+		client:     client,
+		url:        c.URL,
+		secret:     []byte(c.Secret),
+		maxRetries: c.MaxRetries,
+		timeout:    timeout,
+		backoff:    defaultWebhookBackoff,
+	}, nil
+}
+
+// Publish delivers the event, retrying connection errors and server errors with an exponential backoff.
+// Other client errors aren't retried, since sending the same event again won't succeed.
+func (p *WebhookPublisher) Publish(ctx context.Context, event *Event) error {
+	log := p.logger.Named("Publish").With(zap.String("resource", event.Resource), zap.String("type", string(event.Type)))
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := p.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := p.deliver(ctx, event, body)
+		if err == nil {
+			return nil
+		}
+
+		if !retry || attempt >= p.maxRetries {
+			return fmt.Errorf("error delivering event after %d attempts: %s", attempt+1, err)
+		}
+
+		log.Debug("retrying event delivery", zap.Error(err), zap.Int("attempt", attempt+1))
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// deliver makes a single attempt to post the event, returning whether a failure can be retried
+func (p *WebhookPublisher) deliver(ctx context.Context, event *Event, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, string(event.Type))
+	if len(p.secret) > 0 {
+		req.Header.Set(SignatureHeader, Signature(p.secret, body))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+
+	return retry, fmt.Errorf("unexpected response from webhook: %s", res.Status)
+}
+
+// Signature is the value of the signature header for a request body, which receivers can compare with hmac.Equal
+func Signature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
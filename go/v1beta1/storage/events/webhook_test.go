@@ -0,0 +1,173 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+)
+
+var _ = Describe("WebhookPublisher", func() {
+	var (
+		ctx        context.Context
+		server     *httptest.Server
+		publisher  *WebhookPublisher
+		webhook    *config.WebhookConfig
+		event      *Event
+		statuses   []int
+		requests   int32
+		bodies     chan []byte
+		signatures chan string
+		eventTypes chan string
+		actualErr  error
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		statuses = []int{http.StatusOK}
+		requests = 0
+		bodies = make(chan []byte, 10)
+		signatures = make(chan string, 10)
+		eventTypes = make(chan string, 10)
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempt := int(atomic.AddInt32(&requests, 1)) - 1
+
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			bodies <- body
+			signatures <- r.Header.Get(SignatureHeader)
+			eventTypes <- r.Header.Get(EventTypeHeader)
+
+			status := statuses[len(statuses)-1]
+			if attempt < len(statuses) {
+				status = statuses[attempt]
+			}
+			w.WriteHeader(status)
+		}))
+
+		webhook = &config.WebhookConfig{
+			URL:        server.URL,
+			Secret:     fake.LetterN(10),
+			MaxRetries: 2,
+		}
+		event = &Event{
+			Type:         Created,
+			ResourceKind: "occurrences",
+			Resource:     "projects/" + fake.LetterN(10) + "/occurrences/" + fake.LetterN(10),
+			Time:         time.Now().UTC().Truncate(time.Millisecond),
+			New:          json.RawMessage(`{"remediation":"upgrade"}`),
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		publisher, err = NewWebhookPublisher(logger, server.Client(), webhook)
+		Expect(err).ToNot(HaveOccurred())
+		publisher.backoff = time.Millisecond
+
+		actualErr = publisher.Publish(ctx, event)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should post the event as JSON", func() {
+		Expect(actualErr).ToNot(HaveOccurred())
+		Expect(requests).To(BeEquivalentTo(1))
+
+		actualEvent := &Event{}
+		Expect(json.Unmarshal(<-bodies, actualEvent)).To(Succeed())
+		Expect(actualEvent).To(Equal(event))
+		Expect(<-eventTypes).To(Equal(string(Created)))
+	})
+
+	It("should sign the body", func() {
+		body := <-bodies
+		signature := <-signatures
+
+		Expect(signature).To(HavePrefix("sha256="))
+		Expect(hmac.Equal([]byte(signature), []byte(Signature([]byte(webhook.Secret), body)))).To(BeTrue())
+	})
+
+	When("there isn't a secret", func() {
+		BeforeEach(func() {
+			webhook.Secret = ""
+		})
+
+		It("should not sign the body", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(<-signatures).To(BeEmpty())
+		})
+	})
+
+	When("the webhook fails and then recovers", func() {
+		BeforeEach(func() {
+			statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusAccepted}
+		})
+
+		It("should retry the delivery", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(requests).To(BeEquivalentTo(3))
+		})
+	})
+
+	When("the webhook keeps failing", func() {
+		BeforeEach(func() {
+			statuses = []int{http.StatusInternalServerError}
+		})
+
+		It("should give up after the maximum number of retries", func() {
+			Expect(actualErr).To(HaveOccurred())
+			Expect(requests).To(BeEquivalentTo(3))
+		})
+	})
+
+	When("the webhook rejects the event", func() {
+		BeforeEach(func() {
+			statuses = []int{http.StatusBadRequest}
+		})
+
+		It("should not retry the delivery", func() {
+			Expect(actualErr).To(HaveOccurred())
+			Expect(requests).To(BeEquivalentTo(1))
+		})
+	})
+
+	When("the context is cancelled while waiting to retry", func() {
+		BeforeEach(func() {
+			statuses = []int{http.StatusBadGateway}
+			cancelledCtx, cancel := context.WithCancel(context.Background())
+			cancel()
+			ctx = cancelledCtx
+		})
+
+		It("should stop retrying", func() {
+			Expect(actualErr).To(HaveOccurred())
+			Expect(requests).To(BeNumerically("<=", 1))
+		})
+	})
+})
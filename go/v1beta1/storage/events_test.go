@@ -0,0 +1,360 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/events"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/events/eventsfakes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+var _ = Describe("events", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId      string
+		expectedProjectName    string
+		expectedOccurrenceId   string
+		expectedOccurrenceName string
+		expectedNoteId         string
+		expectedNoteName       string
+		expectedUserId         string
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		publisher    *eventsfakes.FakePublisher
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	BeforeEach(func() {
+		expectedUserId = fake.LetterN(10)
+		ctx = ContextWithUserID(context.Background(), expectedUserId)
+		expectedProjectId = fake.LetterN(10)
+		expectedProjectName = fmt.Sprintf("projects/%s", expectedProjectId)
+		expectedOccurrenceId = fake.LetterN(10)
+		expectedOccurrenceName = fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, expectedOccurrenceId)
+		expectedNoteId = fake.LetterN(10)
+		expectedNoteName = fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, expectedNoteId)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		publisher = &eventsfakes.FakePublisher{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+		indexManager.IndexNameCalls(func(documentKind string, inner string) string {
+			return fmt.Sprintf("grafeas-v1-%s-%s", inner, documentKind)
+		})
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, publisher)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	searchResponse := func(messages ...proto.Message) *esutil.SearchResponse {
+		response := &esutil.SearchResponse{
+			Hits: &esutil.EsSearchResponseHits{
+				Total: &esutil.EsSearchResponseTotal{Value: len(messages)},
+			},
+		}
+		for _, message := range messages {
+			source, err := protojson.Marshal(proto.MessageV2(message))
+			Expect(err).ToNot(HaveOccurred())
+
+			response.Hits.Hits = append(response.Hits.Hits, &esutil.EsSearchResponseHit{
+				ID:     fake.LetterN(10),
+				Index:  fake.LetterN(10),
+				Source: source,
+			})
+		}
+
+		return response
+	}
+
+	// publishedEvents delivers the queued events and returns every event sent to the publisher
+	publishedEvents := func() []*events.Event {
+		elasticsearchStorage.deliverQueuedEvents(ctx)
+
+		var published []*events.Event
+		for i := 0; i < publisher.PublishCallCount(); i++ {
+			_, event := publisher.PublishArgsForCall(i)
+			published = append(published, event)
+		}
+
+		return published
+	}
+
+	decodeOccurrence := func(document []byte) *pb.Occurrence {
+		occurrence := &pb.Occurrence{}
+		Expect(protojson.Unmarshal(document, proto.MessageV2(occurrence))).To(Succeed())
+
+		return occurrence
+	}
+
+	Context("CreateOccurrence", func() {
+		var (
+			expectedOccurrence *pb.Occurrence
+			actualErr          error
+		)
+
+		BeforeEach(func() {
+			expectedOccurrence = generateTestOccurrence("")
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+		})
+
+		JustBeforeEach(func() {
+			_, actualErr = elasticsearchStorage.CreateOccurrence(ctx, expectedProjectId, "", expectedOccurrence)
+		})
+
+		It("should publish the new occurrence", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+
+			published := publishedEvents()
+			Expect(published).To(HaveLen(1))
+			Expect(published[0].Type).To(Equal(events.Created))
+			Expect(published[0].ResourceKind).To(Equal(occurrencesDocumentKind))
+			Expect(published[0].Resource).To(Equal(expectedOccurrence.Name))
+			Expect(published[0].UserID).To(Equal(expectedUserId))
+			Expect(published[0].Old).To(BeEmpty())
+			Expect(proto.Equal(decodeOccurrence(published[0].New), expectedOccurrence)).To(BeTrue())
+		})
+
+		When("the occurrence isn't created", func() {
+			BeforeEach(func() {
+				client.CreateReturns("", errors.New("create failed"))
+			})
+
+			It("should not publish an event", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(publishedEvents()).To(BeEmpty())
+			})
+		})
+
+		When("publishing fails", func() {
+			BeforeEach(func() {
+				publisher.PublishReturns(errors.New("publish failed"))
+			})
+
+			It("should still create the occurrence", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(client.CreateCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Context("BatchCreateOccurrences", func() {
+		It("should publish each occurrence that was created", func() {
+			occurrences := generateTestOccurrences(3)
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+			client.BulkReturns(&esutil.EsBulkResponse{
+				Items: []*esutil.EsBulkResponseItem{
+					{Create: &esutil.EsIndexDocResponse{}},
+					{Create: &esutil.EsIndexDocResponse{Error: &esutil.EsIndexDocError{Type: fake.LetterN(10)}}},
+					{Create: &esutil.EsIndexDocResponse{}},
+				},
+			}, nil)
+
+			created, errs := elasticsearchStorage.BatchCreateOccurrences(ctx, expectedProjectId, "", occurrences)
+			Expect(created).To(HaveLen(2))
+			Expect(errs).To(HaveLen(1))
+
+			published := publishedEvents()
+			Expect(published).To(HaveLen(2))
+			Expect(published[0].Resource).To(Equal(occurrences[0].Name))
+			Expect(published[1].Resource).To(Equal(occurrences[2].Name))
+			for _, event := range published {
+				Expect(event.Type).To(Equal(events.Created))
+			}
+		})
+	})
+
+	Context("UpdateNote", func() {
+		It("should publish the note before and after the update", func() {
+			currentNote := generateTestNote(expectedNoteName)
+			client.SearchReturns(searchResponse(currentNote), nil)
+
+			updatedNote, err := elasticsearchStorage.UpdateNote(ctx, expectedProjectId, expectedNoteId, &pb.Note{
+				ShortDescription: "updatedvalue",
+			}, &fieldmaskpb.FieldMask{Paths: []string{"shortDescription"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			published := publishedEvents()
+			Expect(published).To(HaveLen(1))
+			Expect(published[0].Type).To(Equal(events.Updated))
+			Expect(published[0].Resource).To(Equal(expectedNoteName))
+
+			oldNote := &pb.Note{}
+			Expect(protojson.Unmarshal(published[0].Old, proto.MessageV2(oldNote))).To(Succeed())
+			Expect(proto.Equal(oldNote, currentNote)).To(BeTrue())
+
+			newNote := &pb.Note{}
+			Expect(protojson.Unmarshal(published[0].New, proto.MessageV2(newNote))).To(Succeed())
+			Expect(proto.Equal(newNote, updatedNote)).To(BeTrue())
+		})
+	})
+
+	Context("DeleteOccurrence", func() {
+		var (
+			currentOccurrence *pb.Occurrence
+			actualErr         error
+		)
+
+		BeforeEach(func() {
+			currentOccurrence = generateTestOccurrence(expectedOccurrenceName)
+			client.SearchReturns(searchResponse(currentOccurrence), nil)
+		})
+
+		JustBeforeEach(func() {
+			actualErr = elasticsearchStorage.DeleteOccurrence(ctx, expectedProjectId, expectedOccurrenceId)
+		})
+
+		It("should publish the occurrence as it was before it was deleted", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+
+			published := publishedEvents()
+			Expect(published).To(HaveLen(1))
+			Expect(published[0].Type).To(Equal(events.Deleted))
+			Expect(published[0].Resource).To(Equal(expectedOccurrenceName))
+			Expect(published[0].New).To(BeEmpty())
+			Expect(proto.Equal(decodeOccurrence(published[0].Old), currentOccurrence)).To(BeTrue())
+		})
+
+		When("the occurrence does not exist", func() {
+			BeforeEach(func() {
+				client.SearchReturns(searchResponse(), nil)
+			})
+
+			It("should not delete anything", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+				Expect(client.DeleteCallCount()).To(Equal(0))
+				Expect(publishedEvents()).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("DeleteProject", func() {
+		It("should publish the project as it was before it was deleted", func() {
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+
+			Expect(elasticsearchStorage.DeleteProject(ctx, expectedProjectId)).To(Succeed())
+
+			published := publishedEvents()
+			Expect(published).To(HaveLen(1))
+			Expect(published[0].Type).To(Equal(events.Deleted))
+			Expect(published[0].ResourceKind).To(Equal(projectDocumentKind))
+			Expect(published[0].Resource).To(Equal(expectedProjectName))
+			Expect(string(published[0].Old)).To(ContainSubstring(expectedProjectName))
+		})
+	})
+
+	Context("RunEvents", func() {
+		var (
+			runCtx context.Context
+			cancel context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			runCtx, cancel = context.WithCancel(context.Background())
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("should publish queued events with its own context, after the request is done", func() {
+			requestCtx, cancelRequest := context.WithCancel(ctx)
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+
+			Expect(elasticsearchStorage.DeleteProject(requestCtx, expectedProjectId)).To(Succeed())
+			cancelRequest()
+			Expect(publisher.PublishCallCount()).To(Equal(0))
+
+			go elasticsearchStorage.RunEvents(runCtx)
+
+			Eventually(publisher.PublishCallCount).Should(Equal(1))
+			publishCtx, event := publisher.PublishArgsForCall(0)
+			Expect(publishCtx.Err()).ToNot(HaveOccurred())
+			Expect(event.Resource).To(Equal(expectedProjectName))
+		})
+
+		It("should stop when the context is done", func() {
+			done := make(chan struct{})
+			go func() {
+				elasticsearchStorage.RunEvents(runCtx)
+				close(done)
+			}()
+
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	When("the event queue is full", func() {
+		It("should drop the event without waiting", func() {
+			dropped := func() int64 {
+				if value, ok := eventMetrics.Get(eventDroppedMetric).(*expvar.Int); ok {
+					return value.Value()
+				}
+
+				return 0
+			}
+			droppedBefore := dropped()
+			for i := 0; i < eventQueueSize; i++ {
+				elasticsearchStorage.eventQueue <- &events.Event{}
+			}
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+
+			Expect(elasticsearchStorage.DeleteProject(ctx, expectedProjectId)).To(Succeed())
+
+			Expect(dropped()).To(Equal(droppedBefore + 1))
+			Expect(publishedEvents()).To(HaveLen(eventQueueSize))
+		})
+	})
+})
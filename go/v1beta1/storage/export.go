@@ -0,0 +1,157 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// exportKeepAlive is how long the PIT of an export is kept between pages
+const exportKeepAlive = "5m"
+
+// ExportSummary counts the documents that an export wrote
+type ExportSummary struct {
+	Notes       int
+	Occurrences int
+}
+
+// ExportProject writes the project, then each of its notes, then each of its occurrences to w as newline-delimited JSON,
+// one protojson message per line. Notes and occurrences are written oldest first, and a line can be told apart from the others by its name.
+// The filter only applies to occurrences, so that every note an exported occurrence refers to is exported along with it.
+// Each document kind is read from its own PIT, so documents written during the export are left out.
+// The summary counts what was written before any error.
+func (es *ElasticsearchStorage) ExportProject(ctx context.Context, projectId, filter string, w io.Writer) (*ExportSummary, error) {
+	log := es.logger.Named("ExportProject").With(zap.String("project", projectId))
+	summary := &ExportSummary{}
+
+	project, err := es.GetProject(ctx, projectId)
+	if err != nil {
+		return summary, err
+	}
+
+	var filterQuery *filtering.Query
+	if filter != "" {
+		log = log.With(zap.String("filter", filter))
+		if filterQuery, err = es.filterQuery(log, occurrencesDocumentKind, filter); err != nil {
+			return summary, err
+		}
+	}
+
+	if err := writeExportLine(w, project); err != nil {
+		return summary, err
+	}
+
+	summary.Notes, err = es.exportDocuments(ctx, log, es.notesAlias(projectId), nil, w, func() proto.Message {
+		return &pb.Note{}
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	summary.Occurrences, err = es.exportDocuments(ctx, log, es.occurrencesAlias(projectId), filterQuery, w, func() proto.Message {
+		return &pb.Occurrence{}
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	log.Info("exported project", zap.Int("notes", summary.Notes), zap.Int("occurrences", summary.Occurrences))
+
+	return summary, nil
+}
+
+// exportDocuments writes every document in the index that matches the query, paging through a PIT with search_after,
+// which isn't limited by the result window like from and size are
+func (es *ElasticsearchStorage) exportDocuments(ctx context.Context, log *zap.Logger, index string, query *filtering.Query, w io.Writer, newMessage func() proto.Message) (int, error) {
+	log = log.With(zap.String("index", index))
+
+	pitId, err := es.client.OpenPointInTime(ctx, index, exportKeepAlive)
+	if err != nil {
+		return 0, createError(log, "error opening point in time", err)
+	}
+	defer func() {
+		if err := es.client.ClosePointInTime(ctx, pitId); err != nil {
+			log.Warn("error closing point in time", zap.Error(err))
+		}
+	}()
+
+	var (
+		exported    int
+		searchAfter []interface{}
+	)
+	for {
+		// the PIT adds a tiebreaker to the sort, so the sort values of the last hit are unique
+		res, err := es.client.Search(ctx, &esutil.SearchRequest{
+			Search: &esutil.EsSearch{
+				Query: query,
+				Sort: []esutil.EsSortField{
+					{Field: sortField, Order: esutil.EsSortOrderAscending},
+				},
+				Pit: &esutil.EsSearchPit{
+					Id:        pitId,
+					KeepAlive: exportKeepAlive,
+				},
+				SearchAfter: searchAfter,
+			},
+		})
+		if err != nil {
+			return exported, createError(log, "error searching for documents to export", err)
+		}
+
+		hits := res.Hits.Hits
+		if len(hits) == 0 {
+			return exported, nil
+		}
+
+		for _, hit := range hits {
+			message := newMessage()
+			if err := decodeDocument(hit.Source, message); err != nil {
+				return exported, createError(log.With(zap.String("id", hit.ID)), "error decoding document to export", err)
+			}
+
+			if err := writeExportLine(w, message); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+
+		if res.PitId != "" {
+			pitId = res.PitId
+		}
+		searchAfter = hits[len(hits)-1].Sort
+	}
+}
+
+func writeExportLine(w io.Writer, message proto.Message) error {
+	line, err := protojson.Marshal(proto.MessageV2(message))
+	if err != nil {
+		return fmt.Errorf("error marshalling %T: %s", message, err)
+	}
+
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing export: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,351 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var _ = Describe("export", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId        string
+		expectedProject          proto.Message
+		expectedNotesAlias       string
+		expectedOccurrencesAlias string
+		expectedFilter           string
+		expectedNotes            []*pb.Note
+		expectedOccurrences      []*pb.Occurrence
+
+		// pages are the responses to the searches of each PIT, in order
+		pages         map[string][]*esutil.SearchResponse
+		notesPage     *esutil.SearchResponse
+		pitSearches   []*esutil.EsSearch
+		output        *bytes.Buffer
+		writer        io.Writer
+		actualSummary *ExportSummary
+		actualErr     error
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	// page returns the messages as a page of hits, each with a sort value after the previous page's
+	page := func(messages ...proto.Message) *esutil.SearchResponse {
+		response := &esutil.SearchResponse{
+			Hits: &esutil.EsSearchResponseHits{
+				Total: &esutil.EsSearchResponseTotal{Value: len(messages)},
+			},
+		}
+		for _, message := range messages {
+			source, err := protojson.Marshal(proto.MessageV2(message))
+			Expect(err).ToNot(HaveOccurred())
+
+			response.Hits.Hits = append(response.Hits.Hits, &esutil.EsSearchResponseHit{
+				ID:     fake.LetterN(10),
+				Source: source,
+				Sort:   []interface{}{fake.LetterN(10), float64(fake.Number(1, 1000))},
+			})
+		}
+
+		return response
+	}
+
+	pitFor := func(index string) string {
+		return "pit-" + index
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		expectedProjectId = fake.LetterN(10)
+		expectedProject = generateTestProject(expectedProjectId)
+		expectedNotesAlias = fmt.Sprintf("grafeas-%s-notes", expectedProjectId)
+		expectedOccurrencesAlias = fmt.Sprintf("grafeas-%s-occurrences", expectedProjectId)
+		expectedFilter = ""
+		expectedNotes = []*pb.Note{
+			generateTestNote(fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, fake.LetterN(10))),
+			generateTestNote(fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, fake.LetterN(10))),
+		}
+		expectedOccurrences = []*pb.Occurrence{
+			generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10))),
+			generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10))),
+			generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10))),
+		}
+		notesPage = page(expectedNotes[0], expectedNotes[1])
+		pages = map[string][]*esutil.SearchResponse{
+			pitFor(expectedNotesAlias): {
+				notesPage,
+				page(),
+			},
+			pitFor(expectedOccurrencesAlias): {
+				page(expectedOccurrences[0], expectedOccurrences[1]),
+				page(expectedOccurrences[2]),
+				page(),
+			},
+		}
+		pitSearches = nil
+		output = &bytes.Buffer{}
+		writer = output
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+
+		client.OpenPointInTimeCalls(func(_ context.Context, index string, _ string) (string, error) {
+			return pitFor(index), nil
+		})
+		client.SearchCalls(func(_ context.Context, request *esutil.SearchRequest) (*esutil.SearchResponse, error) {
+			if request.Search.Pit == nil {
+				return page(expectedProject), nil
+			}
+
+			pitId := request.Search.Pit.Id
+			pitSearches = append(pitSearches, request.Search)
+			if len(pages[pitId]) == 0 {
+				return page(), nil
+			}
+
+			response := pages[pitId][0]
+			pages[pitId] = pages[pitId][1:]
+
+			return response, nil
+		})
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+
+		actualSummary, actualErr = elasticsearchStorage.ExportProject(ctx, expectedProjectId, expectedFilter, writer)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	// exportedLines splits the output into lines, checking that each one ends with a newline
+	exportedLines := func() []string {
+		var lines []string
+		scanner := bufio.NewScanner(bytes.NewReader(output.Bytes()))
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		Expect(output.String()).To(HaveSuffix("\n"))
+
+		return lines
+	}
+
+	protojsonLine := func(message proto.Message) string {
+		line, err := protojson.Marshal(proto.MessageV2(message))
+		Expect(err).ToNot(HaveOccurred())
+
+		return string(line)
+	}
+
+	It("should write the project, then its notes, then its occurrences, as protojson", func() {
+		Expect(actualErr).ToNot(HaveOccurred())
+		Expect(actualSummary).To(Equal(&ExportSummary{Notes: 2, Occurrences: 3}))
+
+		lines := exportedLines()
+		Expect(lines).To(Equal([]string{
+			protojsonLine(expectedProject),
+			protojsonLine(expectedNotes[0]),
+			protojsonLine(expectedNotes[1]),
+			protojsonLine(expectedOccurrences[0]),
+			protojsonLine(expectedOccurrences[1]),
+			protojsonLine(expectedOccurrences[2]),
+		}))
+	})
+
+	It("should read the notes and occurrences from their own PITs, oldest first", func() {
+		Expect(client.OpenPointInTimeCallCount()).To(Equal(2))
+		_, notesIndex, keepAlive := client.OpenPointInTimeArgsForCall(0)
+		Expect(notesIndex).To(Equal(expectedNotesAlias))
+		Expect(keepAlive).To(Equal(exportKeepAlive))
+		_, occurrencesIndex, _ := client.OpenPointInTimeArgsForCall(1)
+		Expect(occurrencesIndex).To(Equal(expectedOccurrencesAlias))
+
+		for _, search := range pitSearches {
+			Expect(search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: sortField, Order: esutil.EsSortOrderAscending},
+			}))
+			Expect(search.Pit.KeepAlive).To(Equal(exportKeepAlive))
+		}
+
+		Expect(client.ClosePointInTimeCallCount()).To(Equal(2))
+		_, closedPit := client.ClosePointInTimeArgsForCall(0)
+		Expect(closedPit).To(Equal(pitFor(expectedNotesAlias)))
+		_, closedPit = client.ClosePointInTimeArgsForCall(1)
+		Expect(closedPit).To(Equal(pitFor(expectedOccurrencesAlias)))
+	})
+
+	It("should search after the last hit of the previous page", func() {
+		Expect(pitSearches).To(HaveLen(5))
+		Expect(pitSearches[0].SearchAfter).To(BeNil())
+		Expect(pitSearches[1].SearchAfter).To(Equal(notesPage.Hits.Hits[1].Sort))
+		// each PIT starts from the beginning
+		Expect(pitSearches[2].SearchAfter).To(BeNil())
+	})
+
+	When("the PIT ID changes between pages", func() {
+		var nextPitId string
+
+		BeforeEach(func() {
+			nextPitId = fake.LetterN(10)
+			notesPage.PitId = nextPitId
+			pages[nextPitId] = pages[pitFor(expectedNotesAlias)][1:]
+		})
+
+		It("should search the newest one", func() {
+			Expect(pitSearches[1].Pit.Id).To(Equal(nextPitId))
+
+			_, closedPit := client.ClosePointInTimeArgsForCall(0)
+			Expect(closedPit).To(Equal(nextPitId))
+		})
+	})
+
+	When("a filter is given", func() {
+		var expectedQuery *filtering.Query
+
+		BeforeEach(func() {
+			expectedFilter = fake.LetterN(10)
+			expectedQuery = &filtering.Query{
+				Term: &filtering.Term{
+					fake.LetterN(10): fake.LetterN(10),
+				},
+			}
+
+			filterer.EXPECT().ParseExpression(expectedFilter, gomock.Any()).Return(expectedQuery, nil)
+		})
+
+		It("should only apply it to occurrences", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(pitSearches[0].Query).To(BeNil())
+			Expect(pitSearches[2].Query).To(Equal(expectedQuery))
+		})
+	})
+
+	When("the filter is invalid", func() {
+		BeforeEach(func() {
+			expectedFilter = fake.LetterN(10)
+
+			filterer.EXPECT().ParseExpression(expectedFilter, gomock.Any()).Return(nil, &filtering.FilterError{Message: fake.LetterN(10)})
+		})
+
+		It("should return an InvalidArgument error without writing anything", func() {
+			assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
+			Expect(output.Len()).To(BeZero())
+		})
+	})
+
+	When("the project does not exist", func() {
+		BeforeEach(func() {
+			client.SearchCalls(func(context.Context, *esutil.SearchRequest) (*esutil.SearchResponse, error) {
+				return page(), nil
+			})
+		})
+
+		It("should return a NotFound error", func() {
+			assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+			Expect(output.Len()).To(BeZero())
+		})
+	})
+
+	When("opening a PIT fails", func() {
+		BeforeEach(func() {
+			client.OpenPointInTimeCalls(nil)
+			client.OpenPointInTimeReturns("", errors.New("open failed"))
+		})
+
+		It("should return an error", func() {
+			assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			Expect(client.ClosePointInTimeCallCount()).To(Equal(0))
+		})
+	})
+
+	When("a search fails partway through", func() {
+		BeforeEach(func() {
+			pages[pitFor(expectedOccurrencesAlias)] = nil
+			search := client.SearchStub
+			client.SearchCalls(func(ctx context.Context, request *esutil.SearchRequest) (*esutil.SearchResponse, error) {
+				if request.Search.Pit != nil && request.Search.Pit.Id == pitFor(expectedOccurrencesAlias) {
+					return nil, errors.New("search failed")
+				}
+
+				return search(ctx, request)
+			})
+		})
+
+		It("should return an error along with what was exported", func() {
+			assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			Expect(actualSummary).To(Equal(&ExportSummary{Notes: 2}))
+			Expect(exportedLines()).To(HaveLen(3))
+			Expect(client.ClosePointInTimeCallCount()).To(Equal(2))
+		})
+	})
+
+	When("writing fails", func() {
+		BeforeEach(func() {
+			writer = failingWriter{}
+		})
+
+		It("should return an error", func() {
+			Expect(actualErr).To(MatchError(ContainSubstring("error writing export")))
+			Expect(client.OpenPointInTimeCallCount()).To(Equal(0))
+		})
+	})
+})
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filtering
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// FilterError is returned when a filter can't be used because of a problem with the expression itself,
+// as opposed to a failure within the filterer.
+type FilterError struct {
+	Message string
+	Issues  []*FilterIssue
+}
+
+// FilterIssue describes a single problem with a filter expression.
+// Line and Column are zero if the issue couldn't be tied to a location in the expression.
+type FilterIssue struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+func (e *FilterError) Error() string {
+	var issues []string
+	for _, issue := range e.Issues {
+		issues = append(issues, issue.String())
+	}
+
+	return fmt.Sprintf("%s: %s", e.Message, strings.Join(issues, "; "))
+}
+
+func (i *FilterIssue) String() string {
+	if i.Line == 0 {
+		return i.Message
+	}
+
+	return fmt.Sprintf("%s (%d:%d)", i.Message, i.Line, i.Column)
+}
+
+// expressionError associates an error produced while visiting the expression tree with the expression that caused it,
+// so that the location can be reported back to the user.
+type expressionError struct {
+	expressionId int64
+	err          error
+}
+
+func (e *expressionError) Error() string {
+	return e.err.Error()
+}
+
+func newIssuesError(message string, issues *cel.Issues) *FilterError {
+	filterErr := &FilterError{
+		Message: message,
+	}
+	for _, e := range issues.Errors() {
+		filterErr.Issues = append(filterErr.Issues, &FilterIssue{
+			Message: e.Message,
+			Line:    e.Location.Line(),
+			Column:  e.Location.Column(),
+		})
+	}
+
+	return filterErr
+}
+
+func newExpressionError(message string, ast *cel.Ast, err error) *FilterError {
+	issue := &FilterIssue{
+		Message: err.Error(),
+	}
+
+	if exprErr, ok := err.(*expressionError); ok {
+		if offset, ok := ast.SourceInfo().GetPositions()[exprErr.expressionId]; ok {
+			if location, ok := ast.Source().OffsetLocation(offset); ok {
+				issue.Line = location.Line()
+				issue.Column = location.Column()
+			}
+		}
+	}
+
+	return &FilterError{
+		Message: message,
+		Issues:  []*FilterIssue{issue},
+	}
+}
+
+// locateError wraps err with the expression that produced it, unless the error already has a location
+func locateError(expression *expr.Expr, err error) error {
+	if _, ok := err.(*expressionError); ok {
+		return err
+	}
+
+	return &expressionError{
+		expressionId: expression.GetId(),
+		err:          err,
+	}
+}
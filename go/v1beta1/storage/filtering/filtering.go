@@ -17,39 +17,63 @@ package filtering
 import (
 	"fmt"
 	"regexp"
-
 	"strings"
+	"time"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
 	"github.com/google/cel-go/common/operators"
 	"github.com/google/cel-go/common/overloads"
-	"github.com/hashicorp/go-multierror"
+	"github.com/google/cel-go/parser"
 	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 //go:generate counterfeiter -generate
 
 //counterfeiter:generate . Filterer
 type Filterer interface {
-	ParseExpression(filter string) (*Query, error)
+	// ParseExpression translates the filter into an Elasticsearch query. Conditions on the fields of any of the parents
+	// are translated into has_parent queries, unless the parent is embedded in the document.
+	ParseExpression(filter string, parents ...Parent) (*Query, error)
+	// ParseCheckedExpression type checks the filter against the fields of message before translating it,
+	// so that references to unknown fields or comparisons between mismatched types are rejected.
+	ParseCheckedExpression(filter string, message proto.Message, parents ...Parent) (*Query, error)
 }
 
-type filterer struct{}
+type filterer struct {
+	limits     Limits
+	complexity *complexity
+}
 
+// NewFilterer returns a Filterer that enforces the DefaultLimits
 func NewFilterer() Filterer {
-	return &filterer{}
+	return NewFiltererWithLimits(DefaultLimits)
+}
+
+// NewFiltererWithLimits returns a Filterer that rejects filters exceeding the given limits.
+// Any limit that isn't set falls back to its default.
+func NewFiltererWithLimits(limits Limits) Filterer {
+	return &filterer{
+		limits: limits.withDefaults(),
+	}
 }
 
 const nestedFilter = "nestedFilter"
 
+// supportedMacros are the CEL macros that can be translated into an Elasticsearch query
+var supportedMacros = selectMacros(operators.Has, operators.All, operators.Exists, operators.ExistsOne)
+
 var elasticsearchSpecialCharacterRegex = regexp.MustCompile(`([\-=&|!(){}\[\]^"~*?:\\/])`)
 
 // ParseExpression will serve as the entrypoint to the filter
 // that is eventually passed to visit which will handle the recursive logic
-func (f *filterer) ParseExpression(filter string) (*Query, error) {
+func (f *filterer) ParseExpression(filter string, parents ...Parent) (*Query, error) {
 	env, err := cel.NewEnv(
 		cel.ClearMacros(),
+		cel.Macros(supportedMacros...),
 		cel.Declarations(decls.NewFunction(
 			nestedFilter, decls.NewOverload(nestedFilter, []*expr.Type{decls.Any}, decls.Any))),
 	)
@@ -59,28 +83,82 @@ func (f *filterer) ParseExpression(filter string) (*Query, error) {
 	}
 	parsedExpr, issues := env.Parse(filter)
 	if issues != nil && len(issues.Errors()) > 0 {
-		resultErr := fmt.Errorf("error parsing filter")
-		for _, e := range issues.Errors() {
-			resultErr = multierror.Append(resultErr, fmt.Errorf("%s (%d:%d)", e.Message, e.Location.Line(), e.Location.Column()))
-		}
-
-		return nil, resultErr
+		return nil, newIssuesError("error parsing filter", issues)
 	}
 
-	maybeQuery, err := f.visit(parsedExpr.Expr(), "")
+	return f.translate(parsedExpr, parents)
+}
+
+func (f *filterer) ParseCheckedExpression(filter string, message proto.Message, parents ...Parent) (*Query, error) {
+	provider := newSchemaProvider(message)
+	env, err := cel.NewEnv(
+		cel.ClearMacros(),
+		cel.Macros(supportedMacros...),
+		cel.CustomTypeProvider(provider),
+		cel.Declarations(provider.declarations(message)...),
+		cel.Declarations(provider.parentDeclarations(parents)...),
+		cel.Declarations(decls.NewFunction(
+			search, decls.NewOverload(search, []*expr.Type{decls.String}, decls.Bool))),
+	)
+
 	if err != nil {
 		return nil, err
 	}
+	parsedExpr, issues := env.Parse(filter)
+	if issues != nil && len(issues.Errors()) > 0 {
+		return nil, newIssuesError("error parsing filter", issues)
+	}
+
+	// the argument to nestedFilter is relative to its target, so it can't be checked against the message
+	if call := findCall(parsedExpr.Expr(), nestedFilter); call != nil {
+		err := fmt.Errorf("%s is not supported when type checking filters, use exists() instead", nestedFilter)
+		return nil, newExpressionError("error type checking filter", parsedExpr, locateError(call, err))
+	}
+
+	_, issues = env.Check(parsedExpr)
+	if issues != nil && len(issues.Errors()) > 0 {
+		return nil, newIssuesError("error type checking filter", issues)
+	}
+
+	return f.translate(parsedExpr, parents)
+}
+
+func (f *filterer) translate(ast *cel.Ast, parents []Parent) (*Query, error) {
+	// the filterer is shared between requests, so the complexity of each filter is tracked separately
+	visitor := &filterer{
+		limits:     f.limits,
+		complexity: &complexity{limits: f.limits},
+	}
+
+	maybeQuery, err := visitor.visit(ast.Expr(), "")
+	if err != nil {
+		return nil, newExpressionError("error translating filter", ast, err)
+	}
 
 	query, ok := maybeQuery.(*Query)
 	if !ok {
-		return nil, fmt.Errorf("source did not result in a valid Elasticsearch query")
+		return nil, newExpressionError("error translating filter", ast, fmt.Errorf("source did not result in a valid Elasticsearch query"))
 	}
 
-	return query, nil
+	return optimize(withParents(query, parents)), nil
 }
 
+// visit translates the expression, attaching the location of the expression to any errors that occur
 func (f *filterer) visit(expression *expr.Expr, depth string) (interface{}, error) {
+	if err := f.complexity.enter(); err != nil {
+		return nil, locateError(expression, err)
+	}
+	defer f.complexity.exit()
+
+	result, err := f.visitExpression(expression, depth)
+	if err != nil {
+		return nil, locateError(expression, err)
+	}
+
+	return result, nil
+}
+
+func (f *filterer) visitExpression(expression *expr.Expr, depth string) (interface{}, error) {
 	switch expression.ExprKind.(type) {
 	case *expr.Expr_IdentExpr:
 		return f.visitIdent(expression, depth)
@@ -90,6 +168,8 @@ func (f *filterer) visit(expression *expr.Expr, depth string) (interface{}, erro
 		return f.visitSelect(expression, depth)
 	case *expr.Expr_CallExpr:
 		return f.visitCall(expression, depth)
+	case *expr.Expr_ComprehensionExpr:
+		return f.visitComprehension(expression, depth)
 	default:
 		return nil, fmt.Errorf("unrecognized expression: %v", expression)
 	}
@@ -115,6 +195,12 @@ func (f *filterer) visitConst(expression *expr.Expr, _ string) (interface{}, err
 		value = constantExpr.GetInt64Value()
 	case *expr.Constant_Uint64Value:
 		value = constantExpr.GetUint64Value()
+	case *expr.Constant_DoubleValue:
+		value = constantExpr.GetDoubleValue()
+	case *expr.Constant_BytesValue:
+		value = string(constantExpr.GetBytesValue())
+	case *expr.Constant_NullValue:
+		value = nil
 	default:
 		return nil, fmt.Errorf("unrecognized constant kind %T", constantExpr.ConstantKind)
 	}
@@ -122,21 +208,28 @@ func (f *filterer) visitConst(expression *expr.Expr, _ string) (interface{}, err
 	return value, nil
 }
 
-func (f *filterer) visitSelect(expression *expr.Expr, depth string) (string, error) {
+func (f *filterer) visitSelect(expression *expr.Expr, depth string) (interface{}, error) {
 	selectExp := expression.GetSelectExpr()
 
 	value, err := f.visit(selectExp.Operand, depth)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	field := addPath(depth, fmt.Sprintf("%s.%s", value, selectExp.Field))
 
+	// the has() macro is expanded into a select expression that only tests for the presence of the field
+	if selectExp.TestOnly {
+		return existsQuery(field), nil
+	}
+
 	return field, nil
 }
 
 func (f *filterer) visitCall(expression *expr.Expr, depth string) (interface{}, error) {
 	function := expression.GetCallExpr().Function
 	switch function {
+	case operators.LogicalNot:
+		return f.visitNotOperator(expression, depth)
 	case operators.LogicalAnd,
 		operators.LogicalOr,
 		operators.Equals,
@@ -147,10 +240,16 @@ func (f *filterer) visitCall(expression *expr.Expr, depth string) (interface{},
 		operators.NotEquals:
 		return f.visitBinaryOperator(expression, depth)
 	case overloads.Contains,
-		overloads.StartsWith:
+		overloads.StartsWith,
+		overloads.Matches:
 		return f.visitCallFunction(expression, depth)
+	case overloads.TypeConvertTimestamp,
+		overloads.TypeConvertDuration:
+		return f.visitTypeConversionCall(expression, depth)
 	case nestedFilter:
 		return f.visitNestedFilterCall(expression, depth)
+	case search:
+		return f.visitSearchCall(expression, depth)
 	default:
 		return nil, fmt.Errorf("unrecognized function: %s", function)
 	}
@@ -166,6 +265,17 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 	leftExpr := args[0]
 	rightExpr := args[1]
 
+	if isLogicalOperator(expression) {
+		if err := f.complexity.addClauses(args); err != nil {
+			return nil, err
+		}
+	}
+
+	// durations are stored as strings like "3600s", which don't sort in the order of the durations they represent
+	if isRangeOperator(expression) && (isDurationConversion(leftExpr) || isDurationConversion(rightExpr)) {
+		return nil, fmt.Errorf("durations can only be compared with == and !=")
+	}
+
 	lhs, err := f.visit(leftExpr, depth)
 	if err != nil {
 		return nil, err
@@ -201,14 +311,24 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		if rhs == nil {
+			return &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						existsQuery(leftTerm),
+					},
+				},
+			}, nil
+		}
+
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
 
 		return &Query{
 			Term: &Term{
-				leftTerm: rightTerm,
+				keywordField(leftTerm): rightTerm,
 			},
 		}, nil
 	case operators.NotEquals:
@@ -217,7 +337,11 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		if rhs == nil {
+			return existsQuery(leftTerm), nil
+		}
+
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
@@ -225,9 +349,9 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 		return &Query{
 			Bool: &Bool{
 				MustNot: &MustNot{
-					&Bool{
+					&Query{
 						Term: &Term{
-							leftTerm: rightTerm,
+							keywordField(leftTerm): rightTerm,
 						},
 					},
 				},
@@ -239,13 +363,13 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
 		return &Query{
 			Range: &Range{
-				leftTerm: {
+				keywordField(leftTerm): {
 					Greater: rightTerm,
 				},
 			},
@@ -256,13 +380,13 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
 		return &Query{
 			Range: &Range{
-				leftTerm: {
+				keywordField(leftTerm): {
 					GreaterEquals: rightTerm,
 				},
 			},
@@ -273,14 +397,14 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
 
 		return &Query{
 			Range: &Range{
-				leftTerm: {
+				keywordField(leftTerm): {
 					Less: rightTerm,
 				},
 			},
@@ -291,14 +415,14 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 			return nil, err
 		}
 
-		rightTerm, err := assertString(rhs)
+		rightTerm, err := assertValue(rhs)
 		if err != nil {
 			return nil, err
 		}
 
 		return &Query{
 			Range: &Range{
-				leftTerm: {
+				keywordField(leftTerm): {
 					LessEquals: rightTerm,
 				},
 			},
@@ -308,9 +432,38 @@ func (f *filterer) visitBinaryOperator(expression *expr.Expr, depth string) (int
 	return nil, fmt.Errorf("unrecognized function %s", expression.GetCallExpr().Function)
 }
 
+func (f *filterer) visitNotOperator(expression *expr.Expr, depth string) (interface{}, error) {
+	args := expression.GetCallExpr().Args
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("unexpected number of arguments to unary operator")
+	}
+
+	maybeQuery, err := f.visit(args[0], depth)
+	if err != nil {
+		return nil, err
+	}
+
+	query, ok := maybeQuery.(*Query)
+	if !ok {
+		return nil, fmt.Errorf("expected %v to be a valid query", maybeQuery)
+	}
+
+	return &Query{
+		Bool: &Bool{
+			MustNot: &MustNot{
+				query,
+			},
+		},
+	}, nil
+}
+
 func (f *filterer) visitCallFunction(expression *expr.Expr, depth string) (interface{}, error) {
 	callExpr := expression.GetCallExpr()
 	targetExpr := callExpr.Target
+	if targetExpr == nil {
+		return nil, fmt.Errorf("%s must be called on a field", callExpr.Function)
+	}
 
 	parsedTarget, err := f.visit(targetExpr, depth)
 	if err != nil {
@@ -337,20 +490,40 @@ func (f *filterer) visitCallFunction(expression *expr.Expr, depth string) (inter
 		return nil, err
 	}
 
+	if callExpr.Function == overloads.Contains || callExpr.Function == overloads.Matches {
+		if err := f.complexity.addExpensiveClause(callExpr.Function); err != nil {
+			return nil, err
+		}
+	}
+
 	switch callExpr.Function {
 	case overloads.StartsWith:
 		return &Query{
 			Prefix: &Term{
-				target: arg,
+				keywordField(target): arg,
 			},
 		}, nil
 	case overloads.Contains:
 		return &Query{
 			QueryString: &QueryString{
-				DefaultField: target,
+				DefaultField: keywordField(target),
 				Query:        fmt.Sprintf("*%s*", elasticsearchSpecialCharacterRegex.ReplaceAllString(arg, `\$1`)),
 			},
 		}, nil
+	case overloads.Matches:
+		pattern, err := translateRegexp(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Query{
+			Regexp: &Regexp{
+				keywordField(target): {
+					Value: pattern,
+					Flags: "NONE",
+				},
+			},
+		}, nil
 	}
 
 	return nil, fmt.Errorf("unrecognized function: %s", callExpr.Function)
@@ -397,6 +570,230 @@ func (f *filterer) visitNestedFilterCall(expression *expr.Expr, depth string) (i
 	}, nil
 }
 
+func (f *filterer) visitTypeConversionCall(expression *expr.Expr, depth string) (interface{}, error) {
+	callExpr := expression.GetCallExpr()
+
+	if len(callExpr.Args) != 1 {
+		return nil, fmt.Errorf("invalid number of arguments")
+	}
+
+	parsedArg, err := f.visit(callExpr.Args[0], depth)
+	if err != nil {
+		return nil, err
+	}
+
+	arg, err := assertString(parsedArg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch callExpr.Function {
+	case overloads.TypeConvertTimestamp:
+		timestamp, err := time.Parse(time.RFC3339Nano, arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q, expected RFC 3339 format", arg)
+		}
+
+		return timestamp.UTC().Format(time.RFC3339Nano), nil
+	case overloads.TypeConvertDuration:
+		duration, err := time.ParseDuration(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q: %s", arg, err)
+		}
+
+		// durations are stored using their protobuf JSON representation, e.g. "3600s"
+		durationJson, err := protojson.Marshal(durationpb.New(duration))
+		if err != nil {
+			return nil, err
+		}
+
+		return strings.Trim(string(durationJson), `"`), nil
+	}
+
+	return nil, fmt.Errorf("unrecognized function: %s", callExpr.Function)
+}
+
+func isRangeOperator(expression *expr.Expr) bool {
+	switch expression.GetCallExpr().GetFunction() {
+	case operators.Greater, operators.GreaterEquals, operators.Less, operators.LessEquals:
+		return true
+	}
+
+	return false
+}
+
+func isDurationConversion(expression *expr.Expr) bool {
+	return expression.GetCallExpr().GetFunction() == overloads.TypeConvertDuration
+}
+
+// findCall returns the first call to the function within the expression, or nil if it isn't called
+func findCall(expression *expr.Expr, function string) *expr.Expr {
+	if expression == nil {
+		return nil
+	}
+
+	var children []*expr.Expr
+	switch expression.ExprKind.(type) {
+	case *expr.Expr_SelectExpr:
+		children = append(children, expression.GetSelectExpr().Operand)
+	case *expr.Expr_CallExpr:
+		callExpr := expression.GetCallExpr()
+		if callExpr.Function == function {
+			return expression
+		}
+
+		children = append(append(children, callExpr.Target), callExpr.Args...)
+	case *expr.Expr_ListExpr:
+		children = append(children, expression.GetListExpr().Elements...)
+	case *expr.Expr_ComprehensionExpr:
+		comprehension := expression.GetComprehensionExpr()
+		children = append(children, comprehension.IterRange, comprehension.LoopStep)
+	}
+
+	for _, child := range children {
+		if call := findCall(child, function); call != nil {
+			return call
+		}
+	}
+
+	return nil
+}
+
+func existsQuery(field string) *Query {
+	return &Query{
+		Exists: &Exists{
+			Field: field,
+		},
+	}
+}
+
+func selectMacros(functions ...string) []parser.Macro {
+	var macros []parser.Macro
+	for _, macro := range parser.AllMacros {
+		for _, function := range functions {
+			if macro.Function() == function {
+				macros = append(macros, macro)
+			}
+		}
+	}
+
+	return macros
+}
+
+// assertValue ensures that the right-hand side of a comparison is a value that can be sent to Elasticsearch
+func assertValue(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, fmt.Errorf("comparisons against null are only supported with == and !=")
+	}
+
+	return value, nil
+}
+
+// visitComprehension handles the expansion of the exists() and all() macros, which are translated into nested queries.
+// The iteration variable refers to each element of the nested field, so it's replaced with the path to that field
+// before visiting the predicate.
+func (f *filterer) visitComprehension(expression *expr.Expr, depth string) (interface{}, error) {
+	comprehension := expression.GetComprehensionExpr()
+
+	parsedTarget, err := f.visit(comprehension.IterRange, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := assertString(parsedTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	step := comprehension.LoopStep.GetCallExpr()
+	if step.GetFunction() == operators.Conditional {
+		return nil, fmt.Errorf("exists_one() is not supported, use exists() instead")
+	}
+
+	if step == nil || len(step.Args) != 2 {
+		return nil, fmt.Errorf("unsupported comprehension over %s", target)
+	}
+
+	predicate := step.Args[1]
+	bindIdent(predicate, comprehension.IterVar, target)
+
+	maybePredicateQuery, err := f.visit(predicate, target)
+	if err != nil {
+		return nil, err
+	}
+
+	predicateQuery, ok := maybePredicateQuery.(*Query)
+	if !ok {
+		return nil, fmt.Errorf("predicate for %s was not a valid query", target)
+	}
+
+	switch step.Function {
+	case operators.LogicalOr:
+		return &Query{
+			Nested: &Nested{
+				Path:  target,
+				Query: predicateQuery,
+			},
+		}, nil
+	case operators.LogicalAnd:
+		// every element matches if there isn't an element that doesn't match
+		return &Query{
+			Bool: &Bool{
+				MustNot: &MustNot{
+					&Query{
+						Nested: &Nested{
+							Path: target,
+							Query: &Query{
+								Bool: &Bool{
+									MustNot: &MustNot{
+										predicateQuery,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported comprehension over %s, only exists() and all() are supported", target)
+}
+
+// bindIdent replaces references to the identifier name within the expression with the given field path
+func bindIdent(expression *expr.Expr, name, path string) {
+	if expression == nil {
+		return
+	}
+
+	switch expression.ExprKind.(type) {
+	case *expr.Expr_IdentExpr:
+		ident := expression.GetIdentExpr()
+		if ident.Name == name {
+			ident.Name = path
+		}
+	case *expr.Expr_SelectExpr:
+		bindIdent(expression.GetSelectExpr().Operand, name, path)
+	case *expr.Expr_CallExpr:
+		callExpr := expression.GetCallExpr()
+		bindIdent(callExpr.Target, name, path)
+		for _, arg := range callExpr.Args {
+			bindIdent(arg, name, path)
+		}
+	case *expr.Expr_ListExpr:
+		for _, element := range expression.GetListExpr().Elements {
+			bindIdent(element, name, path)
+		}
+	case *expr.Expr_ComprehensionExpr:
+		comprehension := expression.GetComprehensionExpr()
+		bindIdent(comprehension.IterRange, name, path)
+		// an inner comprehension using the same variable name shadows the outer one
+		if comprehension.IterVar != name {
+			bindIdent(comprehension.LoopStep, name, path)
+		}
+	}
+}
+
 func assertString(value interface{}) (string, error) {
 	stringValue, ok := value.(string)
 	if !ok {
@@ -16,6 +16,11 @@ package filtering
 
 import (
 	"encoding/json"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -53,7 +58,7 @@ var _ = Describe("Filter", func() {
 			}),
 			Entry("and two terms", `(a=="b")&&(c=="d")`, &Query{
 				Bool: &Bool{
-					Must: &Must{
+					Filter: &Filter{
 						&Query{
 							Term: &Term{
 								"a": "b",
@@ -69,21 +74,15 @@ var _ = Describe("Filter", func() {
 			}),
 			Entry("and three terms", `(a=="b")&&(c=="d")&&(e=="f")`, &Query{
 				Bool: &Bool{
-					Must: &Must{
+					Filter: &Filter{
 						&Query{
-							Bool: &Bool{
-								Must: &Must{
-									&Query{
-										Term: &Term{
-											"a": "b",
-										},
-									},
-									&Query{
-										Term: &Term{
-											"c": "d",
-										},
-									},
-								},
+							Term: &Term{
+								"a": "b",
+							},
+						},
+						&Query{
+							Term: &Term{
+								"c": "d",
 							},
 						},
 						&Query{
@@ -96,7 +95,7 @@ var _ = Describe("Filter", func() {
 			}),
 			Entry("and term with or set", `(a=="b")&&((c=="d")||(e=="f"))`, &Query{
 				Bool: &Bool{
-					Must: &Must{
+					Filter: &Filter{
 						&Query{
 							Term: &Term{
 								"a": "b",
@@ -123,21 +122,15 @@ var _ = Describe("Filter", func() {
 			}),
 			Entry("and (and set) with or set", `((a=="b")&&(g=="h")) && ((c=="d")||(e=="f"))`, &Query{
 				Bool: &Bool{
-					Must: &Must{
+					Filter: &Filter{
 						&Query{
-							Bool: &Bool{
-								Must: &Must{
-									&Query{
-										Term: &Term{
-											"a": "b",
-										},
-									},
-									&Query{
-										Term: &Term{
-											"g": "h",
-										},
-									},
-								},
+							Term: &Term{
+								"a": "b",
+							},
+						},
+						&Query{
+							Term: &Term{
+								"g": "h",
 							},
 						},
 						&Query{
@@ -162,7 +155,7 @@ var _ = Describe("Filter", func() {
 			Entry("simple not equals", `"a" != "b"`, &Query{
 				Bool: &Bool{
 					MustNot: &MustNot{
-						&Bool{
+						&Query{
 							Term: &Term{
 								"a": "b",
 							},
@@ -172,21 +165,17 @@ var _ = Describe("Filter", func() {
 			}),
 			Entry("and two terms, equals and not equals", `a == b && c != d`, &Query{
 				Bool: &Bool{
-					Must: &Must{
+					Filter: &Filter{
 						&Query{
 							Term: &Term{
 								"a": "b",
 							},
 						},
+					},
+					MustNot: &MustNot{
 						&Query{
-							Bool: &Bool{
-								MustNot: &MustNot{
-									&Bool{
-										Term: &Term{
-											"c": "d",
-										},
-									},
-								},
+							Term: &Term{
+								"c": "d",
 							},
 						},
 					},
@@ -203,7 +192,7 @@ var _ = Describe("Filter", func() {
 						&Query{
 							Bool: &Bool{
 								MustNot: &MustNot{
-									&Bool{
+									&Query{
 										Term: &Term{
 											"c": "d",
 										},
@@ -276,22 +265,10 @@ var _ = Describe("Filter", func() {
 				},
 			}),
 			Entry("complex range of greater and less than", `a<b&&a>c`, &Query{
-				Bool: &Bool{
-					Must: &Must{
-						&Query{
-							Range: &Range{
-								"a": {
-									Less: "b",
-								},
-							},
-						},
-						&Query{
-							Range: &Range{
-								"a": {
-									Greater: "c",
-								},
-							},
-						},
+				Range: &Range{
+					"a": {
+						Less:    "b",
+						Greater: "c",
 					},
 				},
 			}),
@@ -335,7 +312,7 @@ var _ = Describe("Filter", func() {
 								&Query{
 									Bool: &Bool{
 										MustNot: &MustNot{
-											&Bool{
+											&Query{
 												Term: &Term{
 													"a.d": "abc",
 												},
@@ -363,6 +340,424 @@ var _ = Describe("Filter", func() {
 					},
 				},
 			}),
+			Entry("equals with int constant", `a == 1`, &Query{
+				Term: &Term{
+					"a": int64(1),
+				},
+			}),
+			Entry("equals with uint constant", `a == 1u`, &Query{
+				Term: &Term{
+					"a": uint64(1),
+				},
+			}),
+			Entry("equals with bool constant", `a == true`, &Query{
+				Term: &Term{
+					"a": true,
+				},
+			}),
+			Entry("equals with bytes constant", `a == b"c"`, &Query{
+				Term: &Term{
+					"a": "c",
+				},
+			}),
+			Entry("greater than double constant", `vulnerability.cvssScore > 7.5`, &Query{
+				Range: &Range{
+					"vulnerability.cvssScore": {
+						Greater: 7.5,
+					},
+				},
+			}),
+			Entry("less than or equals negative double constant", `a <= -1.25`, &Query{
+				Range: &Range{
+					"a": {
+						LessEquals: -1.25,
+					},
+				},
+			}),
+			Entry("greater than timestamp", `createTime > timestamp("2021-01-01T00:00:00Z")`, &Query{
+				Range: &Range{
+					"createTime": {
+						Greater: "2021-01-01T00:00:00Z",
+					},
+				},
+			}),
+			Entry("timestamp with offset is normalized to UTC", `createTime < timestamp("2021-01-01T10:30:00.5+02:00")`, &Query{
+				Range: &Range{
+					"createTime": {
+						Less: "2021-01-01T08:30:00.5Z",
+					},
+				},
+			}),
+			Entry("equals duration", `a == duration("1h")`, &Query{
+				Term: &Term{
+					"a": "3600s",
+				},
+			}),
+			Entry("fractional duration", `a == duration("1.5s")`, &Query{
+				Term: &Term{
+					"a": "1.500s",
+				},
+			}),
+			Entry("not equals duration", `a != duration("90m")`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{
+							Term: &Term{
+								"a": "5400s",
+							},
+						},
+					},
+				},
+			}),
+			Entry("has on select expression", `has(a.b.c)`, &Query{
+				Exists: &Exists{
+					Field: "a.b.c",
+				},
+			}),
+			Entry("negated has", `!has(vulnerability.packageIssue.fixedLocation)`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{
+							Exists: &Exists{
+								Field: "vulnerability.packageIssue.fixedLocation",
+							},
+						},
+					},
+				},
+			}),
+			Entry("equals null", `a.b == null`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{
+							Exists: &Exists{
+								Field: "a.b",
+							},
+						},
+					},
+				},
+			}),
+			Entry("not equals null", `a != null`, &Query{
+				Exists: &Exists{
+					Field: "a",
+				},
+			}),
+			Entry("has and term", `has(a.b) && c == "d"`, &Query{
+				Bool: &Bool{
+					Filter: &Filter{
+						&Query{
+							Exists: &Exists{
+								Field: "a.b",
+							},
+						},
+						&Query{
+							Term: &Term{
+								"c": "d",
+							},
+						},
+					},
+				},
+			}),
+			Entry("has inside nestedFilter", `a.nestedFilter(has(b.c))`, &Query{
+				Nested: &Nested{
+					Path: "a",
+					Query: &Query{
+						Exists: &Exists{
+							Field: "a.b.c",
+						},
+					},
+				},
+			}),
+			Entry("null comparison inside nestedFilter", `a.nestedFilter(b == null)`, &Query{
+				Nested: &Nested{
+					Path: "a",
+					Query: &Query{
+						Bool: &Bool{
+							MustNot: &MustNot{
+								&Query{
+									Exists: &Exists{
+										Field: "a.b",
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+			Entry("anchored matches", `"resource.uri".matches("^https://gcr.io/.*/app@sha256:.*$")`, &Query{
+				Regexp: &Regexp{
+					"resource.uri": {
+						Value: `https://gcr.io/.*/app\@sha256:.*`,
+						Flags: "NONE",
+					},
+				},
+			}),
+			Entry("unanchored matches", `a.matches("b+c")`, &Query{
+				Regexp: &Regexp{
+					"a": {
+						Value: `.*b+c.*`,
+						Flags: "NONE",
+					},
+				},
+			}),
+			Entry("matches with character classes", `a.matches("^[a-z0-9]{3,5}-\\d+[^/]?$")`, &Query{
+				Regexp: &Regexp{
+					"a": {
+						Value: `[0-9a-z]{3,5}-[0-9]+[^/]?`,
+						Flags: "NONE",
+					},
+				},
+			}),
+			Entry("matches with alternation", `a.matches("^(dev|prod)$|staging")`, &Query{
+				Regexp: &Regexp{
+					"a": {
+						Value: `(dev|prod)|.*staging.*`,
+						Flags: "NONE",
+					},
+				},
+			}),
+			Entry("matches with repeated group", `a.matches("^(?:ab)*c{2,}$")`, &Query{
+				Regexp: &Regexp{
+					"a": {
+						Value: `(ab)*c{2,}`,
+						Flags: "NONE",
+					},
+				},
+			}),
+			Entry("matches inside nestedFilter", `a.nestedFilter(b.matches("^c$"))`, &Query{
+				Nested: &Nested{
+					Path: "a",
+					Query: &Query{
+						Regexp: &Regexp{
+							"a.b": {
+								Value: "c",
+								Flags: "NONE",
+							},
+						},
+					},
+				},
+			}),
+			Entry("exists macro", `build.provenance.builtArtifacts.exists(a, a.checksum == "x")`, &Query{
+				Nested: &Nested{
+					Path: "build.provenance.builtArtifacts",
+					Query: &Query{
+						Term: &Term{
+							"build.provenance.builtArtifacts.checksum": "x",
+						},
+					},
+				},
+			}),
+			Entry("exists macro with complex predicate", `a.exists(x, x.b == "c" && x.d.startsWith("e"))`, &Query{
+				Nested: &Nested{
+					Path: "a",
+					Query: &Query{
+						Bool: &Bool{
+							Filter: &Filter{
+								&Query{
+									Term: &Term{
+										"a.b": "c",
+									},
+								},
+								&Query{
+									Prefix: &Term{
+										"a.d": "e",
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+			Entry("all macro", `a.b.all(x, x.c > 1)`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{
+							Nested: &Nested{
+								Path: "a.b",
+								Query: &Query{
+									Bool: &Bool{
+										MustNot: &MustNot{
+											&Query{
+												Range: &Range{
+													"a.b.c": {
+														Greater: int64(1),
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+			Entry("nested exists macros", `a.exists(x, x.b.exists(y, y.c == "d"))`, &Query{
+				Nested: &Nested{
+					Path: "a",
+					Query: &Query{
+						Nested: &Nested{
+							Path: "a.b",
+							Query: &Query{
+								Term: &Term{
+									"a.b.c": "d",
+								},
+							},
+						},
+					},
+				},
+			}),
+			Entry("exists macro combined with term", `a == "b" && c.exists(x, has(x.d))`, &Query{
+				Bool: &Bool{
+					Filter: &Filter{
+						&Query{
+							Term: &Term{
+								"a": "b",
+							},
+						},
+						&Query{
+							Nested: &Nested{
+								Path: "c",
+								Query: &Query{
+									Exists: &Exists{
+										Field: "c.d",
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+		)
+
+		DescribeTable("full-text search", func(filter string, expected interface{}) {
+			result, err := NewFilterer().ParseExpression(filter)
+			resultJson, _ := json.MarshalIndent(result, "", "  ")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(expected), string(resultJson))
+		},
+			Entry("search function", `search("log4j remote code")`, &Query{
+				MultiMatch: &MultiMatch{
+					Query:  "log4j remote code",
+					Fields: SearchFields,
+				},
+			}),
+			Entry("search combined with a term", `kind == "VULNERABILITY" && search("log4j")`, &Query{
+				Bool: &Bool{
+					Must: &Must{
+						&Query{
+							MultiMatch: &MultiMatch{
+								Query:  "log4j",
+								Fields: SearchFields,
+							},
+						},
+					},
+					Filter: &Filter{
+						&Query{Term: &Term{"kind": "VULNERABILITY"}},
+					},
+				},
+			}),
+			Entry("exact match on a text field", `shortDescription == "CVE-2021-44228"`, &Query{
+				Term: &Term{"shortDescription.keyword": "CVE-2021-44228"},
+			}),
+			Entry("contains on a text field", `vulnerability.longDescription.contains("jndi")`, &Query{
+				QueryString: &QueryString{
+					DefaultField: "vulnerability.longDescription.keyword",
+					Query:        "*jndi*",
+				},
+			}),
+			Entry("presence of a text field", `has(vulnerability.longDescription)`, &Query{
+				Exists: &Exists{Field: "vulnerability.longDescription"},
+			}),
+		)
+
+		DescribeTable("scoring", func(filter string, expected bool) {
+			result, err := NewFilterer().ParseExpression(filter)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(IsScored(result)).To(Equal(expected))
+		},
+			Entry("term", `a == "b"`, false),
+			Entry("search", `search("b")`, true),
+			Entry("search or term", `search("b") || a == "b"`, true),
+			Entry("search and term", `search("b") && a == "b"`, true),
+			Entry("negated search", `!search("b")`, false),
+		)
+
+		DescribeTable("query optimization", func(filter string, expected interface{}) {
+			result, err := NewFilterer().ParseExpression(filter)
+			resultJson, _ := json.MarshalIndent(result, "", "  ")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(expected), string(resultJson))
+		},
+			Entry("flattens a chain of or", `a == "b" || (c == "d" || e == "f")`, &Query{
+				Bool: &Bool{
+					Should: &Should{
+						&Query{Term: &Term{"a": "b"}},
+						&Query{Term: &Term{"c": "d"}},
+						&Query{Term: &Term{"e": "f"}},
+					},
+				},
+			}),
+			Entry("removes a double negation", `!(a != "b")`, &Query{
+				Term: &Term{"a": "b"},
+			}),
+			Entry("negates each clause of a negated or", `!(a == "b" || c == "d")`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{Term: &Term{"a": "b"}},
+						&Query{Term: &Term{"c": "d"}},
+					},
+				},
+			}),
+			Entry("turns a negated and of negations into an or", `a == "b" || !(c != "d" && e != "f")`, &Query{
+				Bool: &Bool{
+					Should: &Should{
+						&Query{Term: &Term{"a": "b"}},
+						&Query{Term: &Term{"c": "d"}},
+						&Query{Term: &Term{"e": "f"}},
+					},
+				},
+			}),
+			Entry("merges ranges on the same field", `a >= 1 && b == "c" && a <= 5`, &Query{
+				Bool: &Bool{
+					Filter: &Filter{
+						&Query{Range: &Range{"a": {GreaterEquals: int64(1), LessEquals: int64(5)}}},
+						&Query{Term: &Term{"b": "c"}},
+					},
+				},
+			}),
+			Entry("doesn't merge ranges with the same bound", `a > 1 && a >= 2`, &Query{
+				Bool: &Bool{
+					Filter: &Filter{
+						&Query{Range: &Range{"a": {Greater: int64(1)}}},
+						&Query{Range: &Range{"a": {GreaterEquals: int64(2)}}},
+					},
+				},
+			}),
+			Entry("doesn't merge ranges across an or", `a > 1 || a < 0`, &Query{
+				Bool: &Bool{
+					Should: &Should{
+						&Query{Range: &Range{"a": {Greater: int64(1)}}},
+						&Query{Range: &Range{"a": {Less: int64(0)}}},
+					},
+				},
+			}),
+			Entry("optimizes nested queries", `a.nestedFilter(b == "c" && (d == "e" && f == "g"))`, &Query{
+				Nested: &Nested{
+					Path: "a",
+					Query: &Query{
+						Bool: &Bool{
+							Filter: &Filter{
+								&Query{Term: &Term{"a.b": "c"}},
+								&Query{Term: &Term{"a.d": "e"}},
+								&Query{Term: &Term{"a.f": "g"}},
+							},
+						},
+					},
+				},
+			}),
 		)
 
 		DescribeTable("error handling", func(filter string) {
@@ -383,6 +778,357 @@ var _ = Describe("Filter", func() {
 			Entry("and comparison with lhs value containing unknown operator without quotes", `a/b&&c==d`),
 			Entry("and comparison with rhs value containing unknown operator without quotes", `a==b&&c/d`),
 			Entry("nestedFilter with no expression arg", `a.nestedFilter()`),
+			Entry("range comparison with null", `a > null`),
+			Entry("invalid timestamp", `createTime > timestamp("yesterday")`),
+			Entry("invalid duration", `a == duration("forever")`),
+			// "10s" < "9s" as strings, so a range over stored durations wouldn't match the order of the durations
+			Entry("greater than duration", `a > duration("9s")`),
+			Entry("less than or equals duration", `a <= duration("1h")`),
+			Entry("duration on the left of a range", `duration("1h") < a`),
+			Entry("timestamp with no arguments", `createTime > timestamp()`),
+			Entry("startsWith with a non-string argument", `a.startsWith(1)`),
+			Entry("has on an identifier", `has(a)`),
+			Entry("negation of a field", `!a`),
+			Entry("unsupported macro", `a.map(x, x == "b")`),
+			Entry("invalid regular expression", `a.matches("(")`),
+			Entry("regular expression with word boundary", `a.matches("\\bfoo")`),
+			Entry("regular expression with anchor in the middle", `a.matches("a^b")`),
+			Entry("case-insensitive regular expression", `a.matches("(?i)foo")`),
+			Entry("matches without a target", `matches(a, "b")`),
+			Entry("exists_one macro", `a.exists_one(x, x.b == "c")`),
+			Entry("exists macro with a non-query predicate", `a.exists(x, x.b)`),
+			Entry("exists macro with a complex iteration variable", `a.exists(x.y, x.y == "c")`),
+			Entry("search called on a field", `a.search("b")`),
+			Entry("search with a non-constant argument", `search(a)`),
+			Entry("search inside nestedFilter", `a.nestedFilter(search("b"))`),
+		)
+
+		It("should return a filter error with the location of an unsupported regular expression", func() {
+			_, err := NewFilterer().ParseExpression(`a == "b" && c.matches("\\bfoo")`)
+
+			Expect(err).To(BeAssignableToTypeOf(&FilterError{}))
+			filterErr := err.(*FilterError)
+			Expect(filterErr.Issues).To(HaveLen(1))
+			Expect(filterErr.Issues[0].Message).To(ContainSubstring("word boundaries are not supported"))
+			Expect(filterErr.Issues[0].Line).To(Equal(1))
+			Expect(filterErr.Issues[0].Column).To(Equal(21))
+		})
+
+		It("should return a filter error for each parse issue", func() {
+			_, err := NewFilterer().ParseExpression(`a == && b ==`)
+
+			Expect(err).To(BeAssignableToTypeOf(&FilterError{}))
+			filterErr := err.(*FilterError)
+			Expect(filterErr.Issues).ToNot(BeEmpty())
+			for _, issue := range filterErr.Issues {
+				Expect(issue.Line).To(Equal(1))
+			}
+		})
+	})
+	Describe("ParseCheckedExpression", func() {
+		DescribeTable("valid occurrence filters", func(filter string, expected interface{}) {
+			result, err := NewFilterer().ParseCheckedExpression(filter, proto.MessageV2(&pb.Occurrence{}))
+			resultJson, _ := json.MarshalIndent(result, "", "  ")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(expected), string(resultJson))
+		},
+			Entry("string field", `resource.uri == "x"`, &Query{
+				Term: &Term{
+					"resource.uri": "x",
+				},
+			}),
+			Entry("enum field compared to a string", `kind == "VULNERABILITY"`, &Query{
+				Term: &Term{
+					"kind": "VULNERABILITY",
+				},
+			}),
+			Entry("multi-word field name", `noteName.startsWith("projects/")`, &Query{
+				Prefix: &Term{
+					"noteName": "projects/",
+				},
+			}),
+			Entry("timestamp field", `createTime > timestamp("2021-01-01T00:00:00Z")`, &Query{
+				Range: &Range{
+					"createTime": {
+						Greater: "2021-01-01T00:00:00Z",
+					},
+				},
+			}),
+			Entry("double field", `vulnerability.cvssScore >= 7.5`, &Query{
+				Range: &Range{
+					"vulnerability.cvssScore": {
+						GreaterEquals: 7.5,
+					},
+				},
+			}),
+			Entry("exists on a repeated field", `build.provenance.builtArtifacts.exists(a, a.checksum == "x")`, &Query{
+				Nested: &Nested{
+					Path: "build.provenance.builtArtifacts",
+					Query: &Query{
+						Term: &Term{
+							"build.provenance.builtArtifacts.checksum": "x",
+						},
+					},
+				},
+			}),
+			Entry("presence test", `!has(resource.contentHash)`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{
+							Exists: &Exists{
+								Field: "resource.contentHash",
+							},
+						},
+					},
+				},
+			}),
+		)
+
+		DescribeTable("invalid occurrence filters", func(filter string) {
+			result, err := NewFilterer().ParseCheckedExpression(filter, proto.MessageV2(&pb.Occurrence{}))
+
+			Expect(result).To(BeNil())
+			Expect(err).To(BeAssignableToTypeOf(&FilterError{}))
+		},
+			Entry("unknown top-level field", `resouce.uri == "x"`),
+			Entry("unknown nested field", `resource.url == "x"`),
+			Entry("type mismatch", `resource.uri == 1`),
+			Entry("string function on a message", `resource.startsWith("x")`),
+			Entry("field that belongs to a note", `shortDescription == "x"`),
+			Entry("nestedFilter", `build.provenance.builtArtifacts.nestedFilter(checksum == "x")`),
 		)
+
+		It("should include the location of the error", func() {
+			_, err := NewFilterer().ParseCheckedExpression(`resource.uri == "x" && resouce.uri == "y"`, proto.MessageV2(&pb.Occurrence{}))
+
+			Expect(err).To(MatchError(ContainSubstring("resouce")))
+			Expect(err).To(MatchError(ContainSubstring("(1:23)")))
+			Expect(err.(*FilterError).Issues[0].Column).To(Equal(23))
+		})
+
+		It("should allow searching", func() {
+			_, err := NewFilterer().ParseCheckedExpression(`kind == "VULNERABILITY" && search("log4j")`, proto.MessageV2(&pb.Note{}))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = NewFilterer().ParseCheckedExpression(`search(1)`, proto.MessageV2(&pb.Note{}))
+			Expect(err).To(BeAssignableToTypeOf(&FilterError{}))
+		})
+
+		It("should check against the given message", func() {
+			_, err := NewFilterer().ParseCheckedExpression(`name == "projects/foo"`, proto.MessageV2(&prpb.Project{}))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = NewFilterer().ParseCheckedExpression(`resource.uri == "x"`, proto.MessageV2(&prpb.Project{}))
+			Expect(err).To(BeAssignableToTypeOf(&FilterError{}))
+		})
+
+		It("should return parse errors", func() {
+			_, err := NewFilterer().ParseCheckedExpression(`a==`, proto.MessageV2(&pb.Occurrence{}))
+
+			Expect(err).To(BeAssignableToTypeOf(&FilterError{}))
+		})
+	})
+
+	Describe("parents", func() {
+		var parents []Parent
+
+		BeforeEach(func() {
+			parents = []Parent{
+				{
+					Name:    "note",
+					Message: proto.MessageV2(&pb.Note{}),
+				},
+			}
+		})
+
+		DescribeTable("filters on parent fields", func(filter string, expected interface{}) {
+			result, err := NewFilterer().ParseExpression(filter, parents...)
+			resultJson, _ := json.MarshalIndent(result, "", "  ")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(expected), string(resultJson))
+		},
+			Entry("term on a parent field", `note.vulnerability.severity == "CRITICAL"`, &Query{
+				HasParent: &HasParent{
+					ParentType: "note",
+					Query: &Query{
+						Term: &Term{
+							"vulnerability.severity": "CRITICAL",
+						},
+					},
+				},
+			}),
+			Entry("contains on a parent text field", `note.shortDescription.contains("openssl")`, &Query{
+				HasParent: &HasParent{
+					ParentType: "note",
+					Query: &Query{
+						QueryString: &QueryString{
+							DefaultField: "shortDescription.keyword",
+							Query:        "*openssl*",
+						},
+					},
+				},
+			}),
+			Entry("parent and child fields", `note.kind == "VULNERABILITY" && resource.uri == "x"`, &Query{
+				Bool: &Bool{
+					Filter: &Filter{
+						&Query{
+							HasParent: &HasParent{
+								ParentType: "note",
+								Query: &Query{
+									Term: &Term{
+										"kind": "VULNERABILITY",
+									},
+								},
+							},
+						},
+						&Query{
+							Term: &Term{
+								"resource.uri": "x",
+							},
+						},
+					},
+				},
+			}),
+			Entry("negated parent field", `!has(note.vulnerability.cvssV3)`, &Query{
+				Bool: &Bool{
+					MustNot: &MustNot{
+						&Query{
+							HasParent: &HasParent{
+								ParentType: "note",
+								Query: &Query{
+									Exists: &Exists{
+										Field: "vulnerability.cvssV3",
+									},
+								},
+							},
+						},
+					},
+				},
+			}),
+			Entry("nested parent field", `note.relatedUrl.exists(u, u.url == "x")`, &Query{
+				HasParent: &HasParent{
+					ParentType: "note",
+					Query: &Query{
+						Nested: &Nested{
+							Path: "relatedUrl",
+							Query: &Query{
+								Term: &Term{
+									"relatedUrl.url": "x",
+								},
+							},
+						},
+					},
+				},
+			}),
+		)
+
+		It("should leave fields with the prefix unchanged when there are no parents", func() {
+			result, err := NewFilterer().ParseExpression(`note.kind == "VULNERABILITY"`)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(&Query{
+				Term: &Term{
+					"note.kind": "VULNERABILITY",
+				},
+			}))
+		})
+
+		It("should type check parent fields against the parent message", func() {
+			result, err := NewFilterer().ParseCheckedExpression(`note.shortDescription == "x" && resource.uri == "y"`, proto.MessageV2(&pb.Occurrence{}), parents...)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*result.Bool.Filter).To(HaveLen(2))
+
+			_, err = NewFilterer().ParseCheckedExpression(`note.resource.uri == "x"`, proto.MessageV2(&pb.Occurrence{}), parents...)
+			Expect(err).To(BeAssignableToTypeOf(&FilterError{}))
+		})
+
+		When("the parent is embedded", func() {
+			BeforeEach(func() {
+				parents = []Parent{
+					{
+						Name:     "_note",
+						Message:  proto.MessageV2(&pb.Note{}),
+						Embedded: true,
+					},
+				}
+			})
+
+			It("should match the parent's fields in the document itself", func() {
+				result, err := NewFilterer().ParseExpression(`_note.vulnerability.severity == "CRITICAL"`, parents...)
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).To(Equal(&Query{
+					Term: &Term{
+						"_note.vulnerability.severity": "CRITICAL",
+					},
+				}))
+			})
+
+			It("should type check the parent's fields against the parent message", func() {
+				result, err := NewFilterer().ParseCheckedExpression(`_note.vulnerability.cvssScore > 7.5`, proto.MessageV2(&pb.Occurrence{}), parents...)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.Range).ToNot(BeNil())
+
+				_, err = NewFilterer().ParseCheckedExpression(`_note.resource.uri == "x"`, proto.MessageV2(&pb.Occurrence{}), parents...)
+				Expect(err).To(BeAssignableToTypeOf(&FilterError{}))
+			})
+		})
+	})
+
+	Describe("limits", func() {
+		var filterer Filterer
+
+		BeforeEach(func() {
+			filterer = NewFiltererWithLimits(Limits{
+				MaxDepth:            8,
+				MaxClauses:          4,
+				MaxExpensiveClauses: 2,
+			})
+		})
+
+		DescribeTable("filters within the limits", func(filter string) {
+			_, err := filterer.ParseExpression(filter)
+
+			Expect(err).ToNot(HaveOccurred())
+		},
+			Entry("maximum number of clauses", `a == "b" || c == "d" || e == "f" || g == "h"`),
+			Entry("maximum number of expensive clauses", `a.contains("b") && c.matches("d")`),
+			Entry("maximum depth", `a.nestedFilter(b.nestedFilter(c.nestedFilter(d.e.f.g == "h")))`),
+		)
+
+		DescribeTable("filters exceeding the limits", func(filter, message string) {
+			result, err := filterer.ParseExpression(filter)
+
+			Expect(result).To(BeNil())
+			Expect(err).To(BeAssignableToTypeOf(&FilterError{}))
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+			Entry("too many clauses", `a == "b" || c == "d" || e == "f" || g == "h" || i == "j"`, "maximum of 4 boolean clauses"),
+			Entry("too many clauses across nested operators", `(a == "b" || c == "d") && (e == "f" || (g == "h" && !(i == "j")))`, "maximum of 4 boolean clauses"),
+			Entry("too many contains calls", `a.contains("b") || c.contains("d") || e.contains("f")`, "maximum of 2 wildcard or regular expression clauses"),
+			Entry("too many matches calls", `a.matches("b") && c.matches("d") && e.matches("f")`, "maximum of 2 wildcard or regular expression clauses"),
+			Entry("nested too deeply", `a.nestedFilter(b.nestedFilter(c.nestedFilter(d.e.f.g.h == "i")))`, "maximum depth of 8"),
+			Entry("field selected too deeply", `a.b.c.d.e.f.g.h == "i"`, "maximum depth of 8"),
+		)
+
+		It("should report the location of the clause exceeding the limit", func() {
+			_, err := filterer.ParseExpression(`a.contains("b") || c.contains("d") || e.contains("f")`)
+
+			Expect(err.(*FilterError).Issues[0].Column).To(Equal(48))
+		})
+
+		It("should use the default for limits that aren't set", func() {
+			filterer = NewFiltererWithLimits(Limits{MaxClauses: 1})
+			var clauses []string
+			for i := 0; i <= DefaultLimits.MaxExpensiveClauses; i++ {
+				clauses = append(clauses, `a.contains("b")`)
+			}
+
+			_, err := filterer.ParseExpression(strings.Join(clauses, " && "))
+
+			Expect(err).To(MatchError(ContainSubstring("maximum of 1 boolean clauses")))
+		})
 	})
 })
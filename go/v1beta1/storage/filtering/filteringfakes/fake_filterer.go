@@ -5,13 +5,30 @@ import (
 	"sync"
 
 	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 type FakeFilterer struct {
-	ParseExpressionStub        func(string) (*filtering.Query, error)
+	ParseCheckedExpressionStub        func(string, protoreflect.ProtoMessage, ...filtering.Parent) (*filtering.Query, error)
+	parseCheckedExpressionMutex       sync.RWMutex
+	parseCheckedExpressionArgsForCall []struct {
+		arg1 string
+		arg2 protoreflect.ProtoMessage
+		arg3 []filtering.Parent
+	}
+	parseCheckedExpressionReturns struct {
+		result1 *filtering.Query
+		result2 error
+	}
+	parseCheckedExpressionReturnsOnCall map[int]struct {
+		result1 *filtering.Query
+		result2 error
+	}
+	ParseExpressionStub        func(string, ...filtering.Parent) (*filtering.Query, error)
 	parseExpressionMutex       sync.RWMutex
 	parseExpressionArgsForCall []struct {
 		arg1 string
+		arg2 []filtering.Parent
 	}
 	parseExpressionReturns struct {
 		result1 *filtering.Query
@@ -25,18 +42,85 @@ type FakeFilterer struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeFilterer) ParseExpression(arg1 string) (*filtering.Query, error) {
+func (fake *FakeFilterer) ParseCheckedExpression(arg1 string, arg2 protoreflect.ProtoMessage, arg3 ...filtering.Parent) (*filtering.Query, error) {
+	fake.parseCheckedExpressionMutex.Lock()
+	ret, specificReturn := fake.parseCheckedExpressionReturnsOnCall[len(fake.parseCheckedExpressionArgsForCall)]
+	fake.parseCheckedExpressionArgsForCall = append(fake.parseCheckedExpressionArgsForCall, struct {
+		arg1 string
+		arg2 protoreflect.ProtoMessage
+		arg3 []filtering.Parent
+	}{arg1, arg2, arg3})
+	stub := fake.ParseCheckedExpressionStub
+	fakeReturns := fake.parseCheckedExpressionReturns
+	fake.recordInvocation("ParseCheckedExpression", []interface{}{arg1, arg2, arg3})
+	fake.parseCheckedExpressionMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeFilterer) ParseCheckedExpressionCallCount() int {
+	fake.parseCheckedExpressionMutex.RLock()
+	defer fake.parseCheckedExpressionMutex.RUnlock()
+	return len(fake.parseCheckedExpressionArgsForCall)
+}
+
+func (fake *FakeFilterer) ParseCheckedExpressionCalls(stub func(string, protoreflect.ProtoMessage, ...filtering.Parent) (*filtering.Query, error)) {
+	fake.parseCheckedExpressionMutex.Lock()
+	defer fake.parseCheckedExpressionMutex.Unlock()
+	fake.ParseCheckedExpressionStub = stub
+}
+
+func (fake *FakeFilterer) ParseCheckedExpressionArgsForCall(i int) (string, protoreflect.ProtoMessage, []filtering.Parent) {
+	fake.parseCheckedExpressionMutex.RLock()
+	defer fake.parseCheckedExpressionMutex.RUnlock()
+	argsForCall := fake.parseCheckedExpressionArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeFilterer) ParseCheckedExpressionReturns(result1 *filtering.Query, result2 error) {
+	fake.parseCheckedExpressionMutex.Lock()
+	defer fake.parseCheckedExpressionMutex.Unlock()
+	fake.ParseCheckedExpressionStub = nil
+	fake.parseCheckedExpressionReturns = struct {
+		result1 *filtering.Query
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeFilterer) ParseCheckedExpressionReturnsOnCall(i int, result1 *filtering.Query, result2 error) {
+	fake.parseCheckedExpressionMutex.Lock()
+	defer fake.parseCheckedExpressionMutex.Unlock()
+	fake.ParseCheckedExpressionStub = nil
+	if fake.parseCheckedExpressionReturnsOnCall == nil {
+		fake.parseCheckedExpressionReturnsOnCall = make(map[int]struct {
+			result1 *filtering.Query
+			result2 error
+		})
+	}
+	fake.parseCheckedExpressionReturnsOnCall[i] = struct {
+		result1 *filtering.Query
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeFilterer) ParseExpression(arg1 string, arg2 ...filtering.Parent) (*filtering.Query, error) {
 	fake.parseExpressionMutex.Lock()
 	ret, specificReturn := fake.parseExpressionReturnsOnCall[len(fake.parseExpressionArgsForCall)]
 	fake.parseExpressionArgsForCall = append(fake.parseExpressionArgsForCall, struct {
 		arg1 string
-	}{arg1})
+		arg2 []filtering.Parent
+	}{arg1, arg2})
 	stub := fake.ParseExpressionStub
 	fakeReturns := fake.parseExpressionReturns
-	fake.recordInvocation("ParseExpression", []interface{}{arg1})
+	fake.recordInvocation("ParseExpression", []interface{}{arg1, arg2})
 	fake.parseExpressionMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2...)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -50,17 +134,17 @@ func (fake *FakeFilterer) ParseExpressionCallCount() int {
 	return len(fake.parseExpressionArgsForCall)
 }
 
-func (fake *FakeFilterer) ParseExpressionCalls(stub func(string) (*filtering.Query, error)) {
+func (fake *FakeFilterer) ParseExpressionCalls(stub func(string, ...filtering.Parent) (*filtering.Query, error)) {
 	fake.parseExpressionMutex.Lock()
 	defer fake.parseExpressionMutex.Unlock()
 	fake.ParseExpressionStub = stub
 }
 
-func (fake *FakeFilterer) ParseExpressionArgsForCall(i int) string {
+func (fake *FakeFilterer) ParseExpressionArgsForCall(i int) (string, []filtering.Parent) {
 	fake.parseExpressionMutex.RLock()
 	defer fake.parseExpressionMutex.RUnlock()
 	argsForCall := fake.parseExpressionArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeFilterer) ParseExpressionReturns(result1 *filtering.Query, result2 error) {
@@ -92,6 +176,8 @@ func (fake *FakeFilterer) ParseExpressionReturnsOnCall(i int, result1 *filtering
 func (fake *FakeFilterer) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
+	fake.parseCheckedExpressionMutex.RLock()
+	defer fake.parseCheckedExpressionMutex.RUnlock()
 	fake.parseExpressionMutex.RLock()
 	defer fake.parseExpressionMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
@@ -0,0 +1,212 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filtering
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Parent lets filters refer to the fields of a document's parent in a join field, through a prefix such as note.shortDescription.
+// Conditions on the parent's fields are translated into has_parent queries.
+type Parent struct {
+	// Name is both the prefix used in filters and the name of the parent relation in the join field
+	Name string
+	// Message is the type of the parent document, which is used to type check conditions on its fields
+	Message proto.Message
+	// Embedded parents have their fields copied into the document under Name instead of being joined,
+	// so conditions on their fields are matched against the document itself
+	Embedded bool
+}
+
+// withParents replaces each condition on a parent's fields with a has_parent query, removing the prefix from the fields.
+// Every child has at most one parent, so conditions that are combined with && or || can be wrapped separately.
+func withParents(query *Query, parents []Parent) *Query {
+	for _, parent := range parents {
+		if !parent.Embedded {
+			query = withParent(query, parent.Name)
+		}
+	}
+
+	return query
+}
+
+func withParent(query *Query, parent string) *Query {
+	if query == nil {
+		return nil
+	}
+
+	prefix := parent + "."
+	if field, ok := queryField(query); ok {
+		if !strings.HasPrefix(field, prefix) {
+			return query
+		}
+
+		return &Query{
+			HasParent: &HasParent{
+				ParentType: parent,
+				Query:      trimFieldPrefix(query, prefix),
+			},
+		}
+	}
+
+	if query.Bool != nil {
+		for _, clauses := range boolClauses(query.Bool) {
+			for i, clause := range clauses {
+				if clauseQuery, ok := clause.(*Query); ok {
+					clauses[i] = withParent(clauseQuery, parent)
+				}
+			}
+		}
+	}
+
+	return query
+}
+
+// queryField returns the field that a leaf query matches against. Compound queries have no field.
+func queryField(query *Query) (string, bool) {
+	switch {
+	case query.Term != nil:
+		return singleKey(*query.Term)
+	case query.Prefix != nil:
+		return singleKey(*query.Prefix)
+	case query.Range != nil:
+		for field := range *query.Range {
+			return field, true
+		}
+	case query.Regexp != nil:
+		for field := range *query.Regexp {
+			return field, true
+		}
+	case query.QueryString != nil:
+		return query.QueryString.DefaultField, true
+	case query.Exists != nil:
+		return query.Exists.Field, true
+	case query.Nested != nil:
+		return query.Nested.Path, true
+	}
+
+	return "", false
+}
+
+// trimFieldPrefix removes the prefix from the fields of the query, so that they refer to the fields of the parent document.
+// Exact matches on the parent's text fields use their keyword subfield, the same as when filtering the parent directly.
+func trimFieldPrefix(query *Query, prefix string) *Query {
+	if query == nil {
+		return nil
+	}
+
+	field := func(name string) string {
+		return strings.TrimPrefix(name, prefix)
+	}
+
+	result := *query
+	switch {
+	case query.Term != nil:
+		result.Term = &Term{}
+		for name, value := range *query.Term {
+			(*result.Term)[keywordField(field(name))] = value
+		}
+	case query.Prefix != nil:
+		result.Prefix = &Term{}
+		for name, value := range *query.Prefix {
+			(*result.Prefix)[keywordField(field(name))] = value
+		}
+	case query.Range != nil:
+		result.Range = &Range{}
+		for name, value := range *query.Range {
+			(*result.Range)[keywordField(field(name))] = value
+		}
+	case query.Regexp != nil:
+		result.Regexp = &Regexp{}
+		for name, value := range *query.Regexp {
+			(*result.Regexp)[keywordField(field(name))] = value
+		}
+	case query.QueryString != nil:
+		result.QueryString = &QueryString{
+			DefaultField: keywordField(field(query.QueryString.DefaultField)),
+			Query:        query.QueryString.Query,
+		}
+	case query.Exists != nil:
+		result.Exists = &Exists{
+			Field: field(query.Exists.Field),
+		}
+	case query.Nested != nil:
+		result.Nested = &Nested{
+			Path:  field(query.Nested.Path),
+			Query: trimFieldPrefix(query.Nested.Query, prefix),
+		}
+	case query.Bool != nil:
+		result.Bool = &Bool{}
+		if query.Bool.Must != nil {
+			result.Bool.Must = &Must{}
+			*result.Bool.Must = trimClausePrefix(*query.Bool.Must, prefix)
+		}
+		if query.Bool.Filter != nil {
+			result.Bool.Filter = &Filter{}
+			*result.Bool.Filter = trimClausePrefix(*query.Bool.Filter, prefix)
+		}
+		if query.Bool.MustNot != nil {
+			result.Bool.MustNot = &MustNot{}
+			*result.Bool.MustNot = trimClausePrefix(*query.Bool.MustNot, prefix)
+		}
+		if query.Bool.Should != nil {
+			result.Bool.Should = &Should{}
+			*result.Bool.Should = trimClausePrefix(*query.Bool.Should, prefix)
+		}
+	}
+
+	return &result
+}
+
+func trimClausePrefix(clauses []interface{}, prefix string) []interface{} {
+	var result []interface{}
+	for _, clause := range clauses {
+		if clauseQuery, ok := clause.(*Query); ok {
+			clause = trimFieldPrefix(clauseQuery, prefix)
+		}
+
+		result = append(result, clause)
+	}
+
+	return result
+}
+
+func boolClauses(b *Bool) [][]interface{} {
+	var clauses [][]interface{}
+	if b.Must != nil {
+		clauses = append(clauses, *b.Must)
+	}
+	if b.Filter != nil {
+		clauses = append(clauses, *b.Filter)
+	}
+	if b.MustNot != nil {
+		clauses = append(clauses, *b.MustNot)
+	}
+	if b.Should != nil {
+		clauses = append(clauses, *b.Should)
+	}
+
+	return clauses
+}
+
+func singleKey(term Term) (string, bool) {
+	for field := range term {
+		return field, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filtering
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/common/operators"
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// Limits bound the complexity of the queries that a filter can be translated into,
+// so that a single request can't produce a query that's expensive for the whole cluster to run.
+type Limits struct {
+	// MaxDepth is the deepest that the expression tree can be nested, e.g. through field selections or nestedFilter calls
+	MaxDepth int
+	// MaxClauses is the number of conditions that can be combined with && and ||
+	MaxClauses int
+	// MaxExpensiveClauses is the number of contains() and matches() calls, which become wildcard and regexp queries
+	MaxExpensiveClauses int
+}
+
+// DefaultLimits are used for any limit that isn't set
+var DefaultLimits = Limits{
+	MaxDepth:            32,
+	MaxClauses:          256,
+	MaxExpensiveClauses: 8,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultLimits.MaxDepth
+	}
+	if l.MaxClauses <= 0 {
+		l.MaxClauses = DefaultLimits.MaxClauses
+	}
+	if l.MaxExpensiveClauses <= 0 {
+		l.MaxExpensiveClauses = DefaultLimits.MaxExpensiveClauses
+	}
+
+	return l
+}
+
+// complexity tracks the size of a single filter as it's visited
+type complexity struct {
+	limits           Limits
+	depth            int
+	clauses          int
+	expensiveClauses int
+}
+
+func (c *complexity) enter() error {
+	c.depth++
+	if c.depth > c.limits.MaxDepth {
+		return fmt.Errorf("filter exceeds the maximum depth of %d", c.limits.MaxDepth)
+	}
+
+	return nil
+}
+
+func (c *complexity) exit() {
+	c.depth--
+}
+
+// addClauses counts the operands of a logical operator that aren't themselves logical operators,
+// so that each condition is counted once regardless of how the operators are nested
+func (c *complexity) addClauses(args []*expr.Expr) error {
+	for _, arg := range args {
+		if !isLogicalOperator(arg) {
+			c.clauses++
+		}
+	}
+
+	if c.clauses > c.limits.MaxClauses {
+		return fmt.Errorf("filter exceeds the maximum of %d boolean clauses", c.limits.MaxClauses)
+	}
+
+	return nil
+}
+
+func (c *complexity) addExpensiveClause(function string) error {
+	c.expensiveClauses++
+	if c.expensiveClauses > c.limits.MaxExpensiveClauses {
+		return fmt.Errorf("%s exceeds the maximum of %d wildcard or regular expression clauses in a filter", function, c.limits.MaxExpensiveClauses)
+	}
+
+	return nil
+}
+
+func isLogicalOperator(expression *expr.Expr) bool {
+	function := expression.GetCallExpr().GetFunction()
+
+	return function == operators.LogicalAnd || function == operators.LogicalOr
+}
@@ -0,0 +1,275 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filtering
+
+// optimize rewrites the query produced by visiting a filter into an equivalent one that's smaller and cheaper to run:
+// chains of && and || are flattened into a single bool query, clauses that don't rank results are moved into
+// filter context so that Elasticsearch can skip scoring and cache them, and range conditions on the same field are merged.
+func optimize(query *Query) *Query {
+	if query == nil {
+		return nil
+	}
+
+	if query.Nested != nil {
+		query.Nested.Query = optimize(query.Nested.Query)
+	}
+
+	if query.HasParent != nil {
+		query.HasParent.Query = optimize(query.HasParent.Query)
+	}
+
+	if query.Bool != nil && query.Bool.Term == nil {
+		return optimizeBool(query)
+	}
+
+	return query
+}
+
+func optimizeBool(query *Query) *Query {
+	original := query.Bool
+	var must, filter, mustNot, should []interface{}
+
+	// should clauses become optional once a bool has filter clauses, so avoid adding
+	// filter clauses to a bool that relies on at least one should clause matching
+	canAddFilters := original.Should == nil || original.Must != nil || original.Filter != nil
+
+	for _, clause := range concatClauses(original.Must, original.Filter) {
+		optimized := optimizeClause(clause)
+		if conjunction, ok := asConjunction(optimized); ok {
+			if conjunction.Must != nil {
+				must = append(must, *conjunction.Must...)
+			}
+			if conjunction.Filter != nil {
+				filter = append(filter, *conjunction.Filter...)
+			}
+			if conjunction.MustNot != nil {
+				mustNot = append(mustNot, *conjunction.MustNot...)
+			}
+		} else if isScoring(optimized) {
+			// full-text queries stay in query context so that they're used to rank results
+			must = append(must, optimized)
+		} else {
+			filter = append(filter, optimized)
+		}
+	}
+
+	if original.MustNot != nil {
+		for _, clause := range *original.MustNot {
+			optimized := optimizeClause(clause)
+			if disjunction, ok := asDisjunction(optimized); ok {
+				// !(a || b) is equivalent to !a && !b
+				mustNot = append(mustNot, disjunction...)
+			} else if negated, ok := asNegation(optimized); ok && canAddFilters {
+				// !(!a && !b) is equivalent to a || b
+				filter = append(filter, newDisjunction(negated))
+			} else {
+				mustNot = append(mustNot, optimized)
+			}
+		}
+	}
+
+	if original.Should != nil {
+		for _, clause := range *original.Should {
+			optimized := optimizeClause(clause)
+			if disjunction, ok := asDisjunction(optimized); ok {
+				should = append(should, disjunction...)
+			} else {
+				should = append(should, optimized)
+			}
+		}
+	}
+
+	filter = mergeRanges(filter)
+
+	// a bool with a single clause is equivalent to the clause itself
+	if len(must) == 1 && len(filter) == 0 && len(mustNot) == 0 && len(should) == 0 {
+		if q, ok := must[0].(*Query); ok {
+			return q
+		}
+	}
+
+	if len(must) == 0 && len(mustNot) == 0 && len(should) == 0 && len(filter) == 1 {
+		if q, ok := filter[0].(*Query); ok {
+			return q
+		}
+	}
+
+	if len(must) == 0 && len(mustNot) == 0 && len(filter) == 0 && len(should) == 1 {
+		if q, ok := should[0].(*Query); ok {
+			return q
+		}
+	}
+
+	optimized := &Bool{}
+	if len(must) > 0 {
+		optimized.Must = (*Must)(&must)
+	}
+	if len(filter) > 0 {
+		optimized.Filter = (*Filter)(&filter)
+	}
+	if len(mustNot) > 0 {
+		optimized.MustNot = (*MustNot)(&mustNot)
+	}
+	if len(should) > 0 {
+		optimized.Should = (*Should)(&should)
+	}
+	query.Bool = optimized
+
+	return query
+}
+
+func optimizeClause(clause interface{}) interface{} {
+	if query, ok := clause.(*Query); ok {
+		return optimize(query)
+	}
+
+	return clause
+}
+
+func concatClauses(must *Must, filter *Filter) []interface{} {
+	var clauses []interface{}
+	if must != nil {
+		clauses = append(clauses, *must...)
+	}
+	if filter != nil {
+		clauses = append(clauses, *filter...)
+	}
+
+	return clauses
+}
+
+// asConjunction returns the bool query if it only has clauses that must or must not match
+func asConjunction(clause interface{}) (*Bool, bool) {
+	query, ok := clause.(*Query)
+	if !ok || query.Bool == nil || *query != (Query{Bool: query.Bool}) {
+		return nil, false
+	}
+
+	b := query.Bool
+	if b.Should != nil || b.Term != nil {
+		return nil, false
+	}
+
+	return b, true
+}
+
+// asDisjunction returns the clauses of a bool query that only has should clauses
+func asDisjunction(clause interface{}) ([]interface{}, bool) {
+	query, ok := clause.(*Query)
+	if !ok || query.Bool == nil || *query != (Query{Bool: query.Bool}) {
+		return nil, false
+	}
+
+	if *query.Bool != (Bool{Should: query.Bool.Should}) || query.Bool.Should == nil {
+		return nil, false
+	}
+
+	return *query.Bool.Should, true
+}
+
+// asNegation returns the clauses of a bool query that only has must_not clauses
+func asNegation(clause interface{}) ([]interface{}, bool) {
+	query, ok := clause.(*Query)
+	if !ok || query.Bool == nil || *query != (Query{Bool: query.Bool}) {
+		return nil, false
+	}
+
+	if *query.Bool != (Bool{MustNot: query.Bool.MustNot}) || query.Bool.MustNot == nil {
+		return nil, false
+	}
+
+	return *query.Bool.MustNot, true
+}
+
+func newDisjunction(clauses []interface{}) interface{} {
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+
+	should := Should(clauses)
+
+	return &Query{
+		Bool: &Bool{
+			Should: &should,
+		},
+	}
+}
+
+// mergeRanges combines range conditions on the same field into a single range query,
+// as long as they don't both set a lower or upper bound
+func mergeRanges(clauses []interface{}) []interface{} {
+	var merged []interface{}
+	ranges := map[string]*RangeOperator{}
+
+	for _, clause := range clauses {
+		field, operator, ok := asSingleRange(clause)
+		if !ok {
+			merged = append(merged, clause)
+			continue
+		}
+
+		existing, ok := ranges[field]
+		if ok && canMergeRange(existing, operator) {
+			mergeRange(existing, operator)
+			continue
+		}
+
+		// copy the operator so that merging doesn't modify the original query
+		combined := *operator
+		ranges[field] = &combined
+		merged = append(merged, &Query{
+			Range: &Range{
+				field: &combined,
+			},
+		})
+	}
+
+	return merged
+}
+
+func asSingleRange(clause interface{}) (string, *RangeOperator, bool) {
+	query, ok := clause.(*Query)
+	if !ok || query.Range == nil || *query != (Query{Range: query.Range}) || len(*query.Range) != 1 {
+		return "", nil, false
+	}
+
+	for field, operator := range *query.Range {
+		return field, operator, operator != nil
+	}
+
+	return "", nil, false
+}
+
+func canMergeRange(existing, operator *RangeOperator) bool {
+	hasLowerBound := func(r *RangeOperator) bool { return r.Greater != nil || r.GreaterEquals != nil }
+	hasUpperBound := func(r *RangeOperator) bool { return r.Less != nil || r.LessEquals != nil }
+
+	return !(hasLowerBound(existing) && hasLowerBound(operator)) && !(hasUpperBound(existing) && hasUpperBound(operator))
+}
+
+func mergeRange(existing, operator *RangeOperator) {
+	if operator.Greater != nil {
+		existing.Greater = operator.Greater
+	}
+	if operator.GreaterEquals != nil {
+		existing.GreaterEquals = operator.GreaterEquals
+	}
+	if operator.Less != nil {
+		existing.Less = operator.Less
+	}
+	if operator.LessEquals != nil {
+		existing.LessEquals = operator.LessEquals
+	}
+}
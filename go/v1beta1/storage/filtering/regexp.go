@@ -0,0 +1,207 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filtering
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// luceneReservedCharacters must be escaped in order to be treated as literals in an Elasticsearch regexp query
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.12/regexp-syntax.html#regexp-reserved-characters
+const luceneReservedCharacters = `.?+*|{}[]()"\#@&<>~`
+
+// translateRegexp converts an RE2 pattern, as used by the CEL matches() function, into the Lucene regular expression
+// syntax supported by Elasticsearch. Lucene expressions always match the entire term, so unanchored patterns
+// are surrounded by wildcards to keep the substring matching semantics of RE2.
+// An error is returned if the pattern uses a construct that has no Lucene equivalent.
+func translateRegexp(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("invalid regular expression %q: %s", pattern, err)
+	}
+
+	var branches []*syntax.Regexp
+	if re.Op == syntax.OpAlternate {
+		branches = re.Sub
+	} else {
+		branches = []*syntax.Regexp{re}
+	}
+
+	var translatedBranches []string
+	for _, branch := range branches {
+		translated, err := translateAnchoredBranch(branch)
+		if err != nil {
+			return "", fmt.Errorf("unsupported regular expression %q: %s", pattern, err)
+		}
+
+		translatedBranches = append(translatedBranches, translated)
+	}
+
+	return strings.Join(translatedBranches, "|"), nil
+}
+
+// translateAnchoredBranch strips leading and trailing anchors from a top-level branch of the expression,
+// padding the result with wildcards when the branch isn't anchored.
+func translateAnchoredBranch(re *syntax.Regexp) (string, error) {
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+
+	prefix, suffix := ".*", ".*"
+	if len(subs) > 0 && isBeginAnchor(subs[0]) {
+		prefix = ""
+		subs = subs[1:]
+	}
+	if len(subs) > 0 && isEndAnchor(subs[len(subs)-1]) {
+		suffix = ""
+		subs = subs[:len(subs)-1]
+	}
+
+	var builder strings.Builder
+	builder.WriteString(prefix)
+	for _, sub := range subs {
+		if err := writeRegexp(&builder, sub, sub.Op != syntax.OpAlternate); err != nil {
+			return "", err
+		}
+	}
+	builder.WriteString(suffix)
+
+	return builder.String(), nil
+}
+
+// writeRegexp appends the Lucene form of re to the builder.
+// grouped indicates that re doesn't need to be wrapped in parentheses to preserve precedence.
+func writeRegexp(builder *strings.Builder, re *syntax.Regexp, grouped bool) error {
+	if re.Flags&syntax.FoldCase != 0 {
+		return fmt.Errorf("case-insensitive matching is not supported")
+	}
+
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		builder.WriteString("()")
+	case syntax.OpLiteral:
+		if !grouped && len(re.Rune) > 1 {
+			builder.WriteString("(")
+			defer builder.WriteString(")")
+		}
+
+		for _, r := range re.Rune {
+			writeLiteral(builder, r, luceneReservedCharacters)
+		}
+	case syntax.OpCharClass:
+		writeCharClass(builder, re.Rune)
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		builder.WriteString(".")
+	case syntax.OpCapture:
+		builder.WriteString("(")
+		if err := writeRegexp(builder, re.Sub[0], true); err != nil {
+			return err
+		}
+		builder.WriteString(")")
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		if err := writeRegexp(builder, re.Sub[0], false); err != nil {
+			return err
+		}
+
+		switch re.Op {
+		case syntax.OpStar:
+			builder.WriteString("*")
+		case syntax.OpPlus:
+			builder.WriteString("+")
+		case syntax.OpQuest:
+			builder.WriteString("?")
+		case syntax.OpRepeat:
+			builder.WriteString("{" + strconv.Itoa(re.Min) + ",")
+			if re.Max != -1 {
+				builder.WriteString(strconv.Itoa(re.Max))
+			}
+			builder.WriteString("}")
+		}
+	case syntax.OpConcat, syntax.OpAlternate:
+		if !grouped {
+			builder.WriteString("(")
+			defer builder.WriteString(")")
+		}
+
+		for i, sub := range re.Sub {
+			if i > 0 && re.Op == syntax.OpAlternate {
+				builder.WriteString("|")
+			}
+
+			if err := writeRegexp(builder, sub, re.Op == syntax.OpAlternate || sub.Op != syntax.OpAlternate); err != nil {
+				return err
+			}
+		}
+	case syntax.OpBeginLine, syntax.OpBeginText, syntax.OpEndLine, syntax.OpEndText:
+		return fmt.Errorf("anchors are only supported at the beginning or end of the pattern")
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return fmt.Errorf("word boundaries are not supported")
+	default:
+		return fmt.Errorf("%s is not supported", re)
+	}
+
+	return nil
+}
+
+func writeCharClass(builder *strings.Builder, ranges []rune) {
+	// RE2 represents negated classes as the ranges of every other character, so write those using Lucene's negation
+	negated := len(ranges) > 0 && ranges[0] == 0 && ranges[len(ranges)-1] == unicode.MaxRune
+	if negated {
+		var complement []rune
+		for i := 1; i+2 < len(ranges); i += 2 {
+			complement = append(complement, ranges[i]+1, ranges[i+1]-1)
+		}
+
+		if len(complement) == 0 {
+			builder.WriteString(".")
+			return
+		}
+
+		ranges = complement
+		builder.WriteString("[^")
+	} else {
+		builder.WriteString("[")
+	}
+
+	for i := 0; i+1 < len(ranges); i += 2 {
+		writeLiteral(builder, ranges[i], `[]\-^"`)
+		if ranges[i] != ranges[i+1] {
+			builder.WriteString("-")
+			writeLiteral(builder, ranges[i+1], `[]\-^"`)
+		}
+	}
+	builder.WriteString("]")
+}
+
+func writeLiteral(builder *strings.Builder, r rune, reserved string) {
+	if strings.ContainsRune(reserved, r) {
+		builder.WriteString(`\`)
+	}
+
+	builder.WriteRune(r)
+}
+
+func isBeginAnchor(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpBeginText || re.Op == syntax.OpBeginLine
+}
+
+func isEndAnchor(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpEndText || re.Op == syntax.OpEndLine
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filtering
+
+import (
+	"strings"
+
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// schemaProvider describes the fields of a protobuf message to the CEL type checker
+// the same way that they're stored in Elasticsearch: field names use their JSON form, and enums are strings.
+type schemaProvider struct {
+	ref.TypeProvider
+	messages map[string]protoreflect.MessageDescriptor
+}
+
+func newSchemaProvider(message proto.Message) *schemaProvider {
+	provider := &schemaProvider{
+		TypeProvider: types.NewRegistry(),
+		messages:     map[string]protoreflect.MessageDescriptor{},
+	}
+	provider.addMessage(message.ProtoReflect().Descriptor())
+
+	return provider
+}
+
+// declarations returns a variable for each top-level field of the message, since filters refer to them without a prefix
+func (p *schemaProvider) declarations(message proto.Message) []*expr.Decl {
+	var declarations []*expr.Decl
+
+	fields := message.ProtoReflect().Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		declarations = append(declarations, decls.NewVar(field.JSONName(), fieldType(field)))
+	}
+
+	return declarations
+}
+
+// parentDeclarations returns a variable for each parent, named after its prefix, so that filters can refer to the parent's fields
+func (p *schemaProvider) parentDeclarations(parents []Parent) []*expr.Decl {
+	var declarations []*expr.Decl
+	for _, parent := range parents {
+		descriptor := parent.Message.ProtoReflect().Descriptor()
+		p.addMessage(descriptor)
+		declarations = append(declarations, decls.NewVar(parent.Name, decls.NewObjectType(string(descriptor.FullName()))))
+	}
+
+	return declarations
+}
+
+func (p *schemaProvider) addMessage(descriptor protoreflect.MessageDescriptor) {
+	name := string(descriptor.FullName())
+	if _, ok := p.messages[name]; ok || isWellKnownType(name) {
+		return
+	}
+
+	p.messages[name] = descriptor
+
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if field.IsMap() {
+			field = field.MapValue()
+		}
+
+		if field.Message() != nil {
+			p.addMessage(field.Message())
+		}
+	}
+}
+
+func (p *schemaProvider) FindType(typeName string) (*expr.Type, bool) {
+	if _, ok := p.messages[typeName]; ok {
+		return decls.NewTypeType(decls.NewObjectType(typeName)), true
+	}
+
+	return p.TypeProvider.FindType(typeName)
+}
+
+func (p *schemaProvider) FindFieldType(messageType string, fieldName string) (*ref.FieldType, bool) {
+	descriptor, ok := p.messages[messageType]
+	if !ok {
+		return p.TypeProvider.FindFieldType(messageType, fieldName)
+	}
+
+	field := descriptor.Fields().ByJSONName(fieldName)
+	if field == nil {
+		field = descriptor.Fields().ByName(protoreflect.Name(fieldName))
+	}
+	if field == nil {
+		return nil, false
+	}
+
+	return &ref.FieldType{
+		Type: fieldType(field),
+	}, true
+}
+
+func fieldType(field protoreflect.FieldDescriptor) *expr.Type {
+	if field.IsMap() {
+		return decls.NewMapType(singularFieldType(field.MapKey()), singularFieldType(field.MapValue()))
+	}
+
+	if field.IsList() {
+		return decls.NewListType(singularFieldType(field))
+	}
+
+	return singularFieldType(field)
+}
+
+func singularFieldType(field protoreflect.FieldDescriptor) *expr.Type {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return decls.Bool
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return decls.Int
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return decls.Uint
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return decls.Double
+	case protoreflect.StringKind, protoreflect.EnumKind:
+		return decls.String
+	case protoreflect.BytesKind:
+		return decls.Bytes
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		switch name := string(field.Message().FullName()); name {
+		case "google.protobuf.Timestamp":
+			return decls.Timestamp
+		case "google.protobuf.Duration":
+			return decls.Duration
+		default:
+			if isWellKnownType(name) {
+				return decls.Dyn
+			}
+
+			return decls.NewObjectType(name)
+		}
+	}
+
+	return decls.Dyn
+}
+
+func isWellKnownType(name string) bool {
+	return strings.HasPrefix(name, "google.protobuf.")
+}
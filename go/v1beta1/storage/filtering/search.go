@@ -0,0 +1,112 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filtering
+
+import (
+	"fmt"
+
+	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+const search = "search"
+
+// SearchFields are the prose fields of notes and occurrences that are mapped as text, and searched by search().
+// Each one has a keyword subfield that's used for exact matches, so that other filters work the same way as on any other field.
+var SearchFields = []string{
+	"shortDescription",
+	"longDescription",
+	"vulnerability.shortDescription",
+	"vulnerability.longDescription",
+	"vulnerability.details.description",
+	"vulnerability.windowsDetails.description",
+}
+
+// keywordField returns the name of the field to use for exact matches on field
+func keywordField(field string) string {
+	for _, searchField := range SearchFields {
+		if field == searchField {
+			return field + ".keyword"
+		}
+	}
+
+	return field
+}
+
+func (f *filterer) visitSearchCall(expression *expr.Expr, depth string) (interface{}, error) {
+	callExpr := expression.GetCallExpr()
+	if callExpr.Target != nil {
+		return nil, fmt.Errorf(`%s must be called as a function, e.g. %s("text")`, search, search)
+	}
+
+	if depth != "" {
+		return nil, fmt.Errorf("%s can't be used inside %s", search, nestedFilter)
+	}
+
+	if len(callExpr.Args) != 1 {
+		return nil, fmt.Errorf("invalid number of arguments")
+	}
+
+	text, ok := callExpr.Args[0].GetConstExpr().GetConstantKind().(*expr.Constant_StringValue)
+	if !ok {
+		return nil, fmt.Errorf("the argument to %s must be a string", search)
+	}
+
+	return &Query{
+		MultiMatch: &MultiMatch{
+			Query:  text.StringValue,
+			Fields: SearchFields,
+		},
+	}, nil
+}
+
+// IsScored reports whether the query ranks results by relevance, in which case they should be sorted by score
+func IsScored(query *Query) bool {
+	return isScoring(query)
+}
+
+// isScoring reports whether the clause contributes to the score of the documents it matches.
+// Clauses in filter or must_not context never do.
+func isScoring(clause interface{}) bool {
+	query, ok := clause.(*Query)
+	if !ok || query == nil {
+		return false
+	}
+
+	if query.MultiMatch != nil {
+		return true
+	}
+
+	if query.Nested != nil && isScoring(query.Nested.Query) {
+		return true
+	}
+
+	if query.Bool != nil {
+		var clauses []interface{}
+		if query.Bool.Must != nil {
+			clauses = append(clauses, *query.Bool.Must...)
+		}
+		if query.Bool.Should != nil {
+			clauses = append(clauses, *query.Bool.Should...)
+		}
+
+		for _, c := range clauses {
+			if isScoring(c) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -18,17 +18,22 @@ package filtering
 type Query struct {
 	Bool        *Bool        `json:"bool,omitempty"`
 	Term        *Term        `json:"term,omitempty"`
+	Terms       *Terms       `json:"terms,omitempty"`
 	Prefix      *Term        `json:"prefix,omitempty"`
 	QueryString *QueryString `json:"query_string,omitempty"`
 	Nested      *Nested      `json:"nested,omitempty"`
 	Range       *Range       `json:"range,omitempty"`
 	HasParent   *HasParent   `json:"has_parent,omitempty"`
+	Exists      *Exists      `json:"exists,omitempty"`
+	Regexp      *Regexp      `json:"regexp,omitempty"`
+	MultiMatch  *MultiMatch  `json:"multi_match,omitempty"`
 }
 
 // Bool holds a general query that carries any number of
-// Must, MustNot, and Should operations
+// Must, Filter, MustNot, and Should operations
 type Bool struct {
 	Must    *Must    `json:"must,omitempty"`
+	Filter  *Filter  `json:"filter,omitempty"`
 	MustNot *MustNot `json:"must_not,omitempty"`
 	Should  *Should  `json:"should,omitempty"`
 	Term    *Term    `json:"term,omitempty"`
@@ -37,14 +42,22 @@ type Bool struct {
 // Must holds a must operator which each equates to an AND operation
 type Must []interface{}
 
+// Filter holds clauses that must match like Must, but that don't contribute to the score,
+// which allows Elasticsearch to cache them
+type Filter []interface{}
+
 // MustNot holds a must_not operator which each equates to a != operation
 type MustNot []interface{}
 
 // Should holds a should operator which equates to an OR operation
 type Should []interface{}
 
-// Term holds a comparison for equating two strings
-type Term map[string]string
+// Term holds a comparison for equating a field to a value.
+// Values keep their original type so that numeric, boolean and date fields are matched correctly.
+type Term map[string]interface{}
+
+// Terms matches documents where the field is equal to any of the values
+type Terms map[string][]string
 
 type QueryString struct {
 	DefaultField string `json:"default_field"`
@@ -65,9 +78,28 @@ type HasParent struct {
 	Query      *Query `json:"query"`
 }
 
+// Exists matches documents that contain an indexed value for a field
+type Exists struct {
+	Field string `json:"field"`
+}
+
+// Regexp matches a field against a regular expression, using the Lucene syntax supported by Elasticsearch
+type Regexp map[string]*RegexpOptions
+
+type RegexpOptions struct {
+	Value string `json:"value"`
+	Flags string `json:"flags,omitempty"`
+}
+
 type RangeOperator struct {
-	Greater       string `json:"gt,omitempty"`
-	GreaterEquals string `json:"gte,omitempty"`
-	Less          string `json:"lt,omitempty"`
-	LessEquals    string `json:"lte,omitempty"`
+	Greater       interface{} `json:"gt,omitempty"`
+	GreaterEquals interface{} `json:"gte,omitempty"`
+	Less          interface{} `json:"lt,omitempty"`
+	LessEquals    interface{} `json:"lte,omitempty"`
+}
+
+// MultiMatch runs a full-text query against several text fields, scoring documents by relevance
+type MultiMatch struct {
+	Query  string   `json:"query"`
+	Fields []string `json:"fields,omitempty"`
 }
@@ -25,6 +25,10 @@ import (
 
 type newElasticsearchStorageFunc func(*config.ElasticsearchConfig) (*ElasticsearchStorage, error)
 
+// InitializedFunc is called once the storage provider has initialized its indices, to start anything that serves
+// from the storage alongside Grafeas
+type InitializedFunc func(*config.ElasticsearchConfig, *ElasticsearchStorage) error
+
 type registerStorageTypeProviderFunc func(string, *grafeasConfig.StorageConfiguration) (*storage.Storage, error)
 
 // ElasticsearchStorageTypeProviderCreator takes a function that returns a new instance of ElasticsearchStorage,
@@ -33,7 +37,8 @@ type registerStorageTypeProviderFunc func(string, *grafeasConfig.StorageConfigur
 // This allows for the ability to still inject different ElasticsearchStorage configurations, e.g. testing.
 // This is done this way because we do not get access to the parsed config until after Grafeas server is started
 // and registers the storage type provider.
-func ElasticsearchStorageTypeProviderCreator(newES newElasticsearchStorageFunc, logger *zap.Logger) registerStorageTypeProviderFunc {
+// When onInitialized isn't nil, it's called after the storage is initialized, and its error fails the registration.
+func ElasticsearchStorageTypeProviderCreator(newES newElasticsearchStorageFunc, onInitialized InitializedFunc, logger *zap.Logger) registerStorageTypeProviderFunc {
 	return func(storageType string, sc *grafeasConfig.StorageConfiguration) (*storage.Storage, error) {
 		var c *config.ElasticsearchConfig
 
@@ -63,6 +68,35 @@ func ElasticsearchStorageTypeProviderCreator(newES newElasticsearchStorageFunc,
 			return nil, err
 		}
 
+		if onInitialized != nil {
+			if err := onInitialized(c, es); err != nil {
+				return nil, err
+			}
+		}
+
+		retentionInterval, err := c.Retention.IntervalDuration()
+		if err != nil {
+			return nil, err
+		}
+		if retentionInterval > 0 {
+			log.Info("starting retention", zap.Duration("interval", retentionInterval), zap.Bool("dryRun", c.Retention.DryRun))
+			go es.RunRetention(context.Background(), retentionInterval)
+		}
+
+		if es.eventsEnabled() {
+			go es.RunEvents(context.Background())
+		}
+
+		if es.outboxEnabled() {
+			outboxInterval, err := c.Events.Outbox.IntervalDuration()
+			if err != nil {
+				return nil, err
+			}
+
+			log.Info("starting event outbox", zap.Duration("interval", outboxInterval))
+			go es.RunOutbox(context.Background(), outboxInterval)
+		}
+
 		return &storage.Storage{
 			Ps: es,
 			Gs: es,
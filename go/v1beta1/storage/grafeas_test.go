@@ -51,7 +51,7 @@ var _ = Describe("Grafeas integration", func() {
 	})
 
 	JustBeforeEach(func() {
-		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager)
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
 	})
 
 	AfterEach(func() {
@@ -63,6 +63,8 @@ var _ = Describe("Grafeas integration", func() {
 			err                 error
 			expectedStorageType string
 			storageConfig       grafeasConfig.StorageConfiguration
+			onInitialized       InitializedFunc
+			initializedStorage  *ElasticsearchStorage
 		)
 
 		// BeforeEach configures the happy path for this context
@@ -74,11 +76,17 @@ var _ = Describe("Grafeas integration", func() {
 			newElasticsearchStorage = func(ec *config.ElasticsearchConfig) (*ElasticsearchStorage, error) {
 				return elasticsearchStorage, nil
 			}
+
+			initializedStorage = nil
+			onInitialized = func(ec *config.ElasticsearchConfig, es *ElasticsearchStorage) error {
+				initializedStorage = es
+				return nil
+			}
 		})
 
 		// JustBeforeEach actually invokes the system under test
 		JustBeforeEach(func() {
-			registerStorageTypeProvider := ElasticsearchStorageTypeProviderCreator(newElasticsearchStorage, logger)
+			registerStorageTypeProvider := ElasticsearchStorageTypeProviderCreator(newElasticsearchStorage, onInitialized, logger)
 			_, err = registerStorageTypeProvider(expectedStorageType, &storageConfig)
 		})
 
@@ -124,5 +132,44 @@ var _ = Describe("Grafeas integration", func() {
 				Expect(err).To(HaveOccurred())
 			})
 		})
+
+		It("should call onInitialized after initializing the storage", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(indexManager.InitializeCallCount()).To(Equal(1))
+			Expect(initializedStorage).To(Equal(elasticsearchStorage))
+		})
+
+		When("initializing the storage fails", func() {
+			BeforeEach(func() {
+				indexManager.InitializeReturns(fmt.Errorf("fail"))
+			})
+
+			It("should return an error without calling onInitialized", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(initializedStorage).To(BeNil())
+			})
+		})
+
+		When("onInitialized fails", func() {
+			BeforeEach(func() {
+				onInitialized = func(ec *config.ElasticsearchConfig, es *ElasticsearchStorage) error {
+					return fmt.Errorf("fail")
+				}
+			})
+
+			It("should return an error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		When("onInitialized is nil", func() {
+			BeforeEach(func() {
+				onInitialized = nil
+			})
+
+			It("should not return an error", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
 	})
 })
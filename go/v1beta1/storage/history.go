@@ -0,0 +1,384 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	revisionsDocumentKind = "revisions"
+	// revisionField holds the details of the change alongside the fields of the previous message
+	revisionField     = "revision"
+	revisionTimeField = revisionField + ".time"
+)
+
+// RevisionOperation is the kind of change that replaced the previous state of an occurrence or note
+type RevisionOperation string
+
+const (
+	RevisionOperationUpdate RevisionOperation = "UPDATE"
+	RevisionOperationDelete RevisionOperation = "DELETE"
+)
+
+// Revision describes a change to an occurrence or note
+type Revision struct {
+	Operation RevisionOperation `json:"operation"`
+	// Time is when the change was made, which is when the previous state stopped being current
+	Time time.Time `json:"time"`
+	// UserID is the user that made the change, when it's set on the request context with ContextWithUserID
+	UserID string `json:"userId,omitempty"`
+	// UpdateMask holds the paths of the fields that were changed by an update
+	UpdateMask []string `json:"updateMask,omitempty"`
+}
+
+// OccurrenceRevision is the state of an occurrence before it was changed
+type OccurrenceRevision struct {
+	Revision
+	Occurrence *pb.Occurrence
+}
+
+// NoteRevision is the state of a note before it was changed
+type NoteRevision struct {
+	Revision
+	Note *pb.Note
+}
+
+type userIDKey struct{}
+
+// ContextWithUserID records the user making a request, so that revisions written by the request identify who made the change.
+// The Grafeas API doesn't pass the user to updates and deletes, so this is for services that embed this backend.
+func ContextWithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// userIDFromContext is the user set with ContextWithUserID, falling back to the user in the request's gRPC metadata
+// when a metadata key is configured
+func (es *ElasticsearchStorage) userIDFromContext(ctx context.Context) string {
+	if userID, ok := ctx.Value(userIDKey{}).(string); ok {
+		return userID
+	}
+
+	if es.config.UserIDMetadata == "" {
+		return ""
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if values := md.Get(es.config.UserIDMetadata); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+func (es *ElasticsearchStorage) historyEnabled() bool {
+	return es.config.History.Enabled
+}
+
+// newRevision describes a change to an occurrence or note that's about to be made, or returns nil when history is disabled
+func (es *ElasticsearchStorage) newRevision(ctx context.Context, operation RevisionOperation, mask *fieldmaskpb.FieldMask) *Revision {
+	if !es.historyEnabled() {
+		return nil
+	}
+
+	revision := &Revision{
+		Operation: operation,
+		Time:      time.Now().UTC(),
+		UserID:    es.userIDFromContext(ctx),
+	}
+	if mask != nil {
+		revision.UpdateMask = mask.Paths
+	}
+
+	return revision
+}
+
+// revisionItem keeps the previous state of an occurrence or note, so that it can be written in the same bulk request as the change.
+// It's given an ID so that it can be removed if the change fails.
+func (es *ElasticsearchStorage) revisionItem(previous proto.Message, revision *Revision) *esutil.BulkRequestItem {
+	return &esutil.BulkRequestItem{
+		Operation:  esutil.BULK_CREATE,
+		Index:      es.revisionsAlias(),
+		DocumentId: uuid.New().String(),
+		Message:    proto.MessageV2(previous),
+		Fields: map[string]interface{}{
+			revisionField: revision,
+		},
+	}
+}
+
+// settleRevision handles a revision written in the same bulk request as its change, which may have failed independently of it.
+// The revision of a failed change is removed, since the previous state is still current. A revision that couldn't be written
+// is only logged, since the change has already been made.
+func (es *ElasticsearchStorage) settleRevision(ctx context.Context, log *zap.Logger, item *esutil.BulkRequestItem, succeeded bool, response *esutil.EsBulkResponseItem) {
+	revisionErr := bulkItemError(response)
+	if succeeded && revisionErr != nil {
+		log.Error("error writing revision, the previous state wasn't kept", zap.Error(revisionErr))
+		return
+	}
+	if succeeded || revisionErr != nil {
+		return
+	}
+
+	res, err := es.client.Bulk(ctx, &esutil.BulkRequest{
+		Index:   item.Index,
+		Refresh: es.config.Refresh.String(),
+		Items: []*esutil.BulkRequestItem{
+			{
+				Operation:  esutil.BULK_DELETE,
+				DocumentId: item.DocumentId,
+			},
+		},
+	})
+	if err == nil {
+		err = bulkItemError(res.Items[0])
+	}
+	if err != nil {
+		log.Error("error removing the revision of a failed change", zap.Error(err))
+	}
+}
+
+// ListOccurrenceRevisions returns up to pageSize number of the previous states of the occurrence, beginning with the most recent change.
+func (es *ElasticsearchStorage) ListOccurrenceRevisions(ctx context.Context, projectId, occurrenceId, pageToken string, pageSize int32) ([]*OccurrenceRevision, string, error) {
+	occurrenceName := fmt.Sprintf("projects/%s/occurrences/%s", projectId, occurrenceId)
+	log := es.logger.Named("ListOccurrenceRevisions").With(zap.String("occurrence", occurrenceName))
+
+	hits, nextPageToken, err := es.listRevisions(ctx, log, occurrenceName, pageToken, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var revisions []*OccurrenceRevision
+	for _, hit := range hits {
+		occurrence := &pb.Occurrence{}
+		revision, err := decodeRevision(hit.Source, occurrence)
+		if err != nil {
+			return nil, "", createError(log, "error converting _doc to occurrence revision", err)
+		}
+
+		revisions = append(revisions, &OccurrenceRevision{
+			Revision:   *revision,
+			Occurrence: occurrence,
+		})
+	}
+
+	return revisions, nextPageToken, nil
+}
+
+// ListNoteRevisions returns up to pageSize number of the previous states of the note, beginning with the most recent change.
+func (es *ElasticsearchStorage) ListNoteRevisions(ctx context.Context, projectId, noteId, pageToken string, pageSize int32) ([]*NoteRevision, string, error) {
+	noteName := fmt.Sprintf("projects/%s/notes/%s", projectId, noteId)
+	log := es.logger.Named("ListNoteRevisions").With(zap.String("note", noteName))
+
+	hits, nextPageToken, err := es.listRevisions(ctx, log, noteName, pageToken, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var revisions []*NoteRevision
+	for _, hit := range hits {
+		note := &pb.Note{}
+		revision, err := decodeRevision(hit.Source, note)
+		if err != nil {
+			return nil, "", createError(log, "error converting _doc to note revision", err)
+		}
+
+		revisions = append(revisions, &NoteRevision{
+			Revision: *revision,
+			Note:     note,
+		})
+	}
+
+	return revisions, nextPageToken, nil
+}
+
+// GetOccurrenceAsOf returns the occurrence as it was at the given time.
+// A NotFound error is returned if the occurrence didn't exist at that time.
+func (es *ElasticsearchStorage) GetOccurrenceAsOf(ctx context.Context, projectId, occurrenceId string, asOf time.Time) (*pb.Occurrence, error) {
+	occurrenceName := fmt.Sprintf("projects/%s/occurrences/%s", projectId, occurrenceId)
+	log := es.logger.Named("GetOccurrenceAsOf").With(zap.String("occurrence", occurrenceName), zap.Time("asOf", asOf))
+
+	occurrence := &pb.Occurrence{}
+	if err := es.getAsOf(ctx, log, occurrenceName, es.occurrencesAlias(projectId), asOf, occurrence); err != nil {
+		return nil, err
+	}
+
+	return occurrence, nil
+}
+
+// GetNoteAsOf returns the note as it was at the given time.
+// A NotFound error is returned if the note didn't exist at that time.
+func (es *ElasticsearchStorage) GetNoteAsOf(ctx context.Context, projectId, noteId string, asOf time.Time) (*pb.Note, error) {
+	noteName := fmt.Sprintf("projects/%s/notes/%s", projectId, noteId)
+	log := es.logger.Named("GetNoteAsOf").With(zap.String("note", noteName), zap.Time("asOf", asOf))
+
+	note := &pb.Note{}
+	if err := es.getAsOf(ctx, log, noteName, es.notesAlias(projectId), asOf, note); err != nil {
+		return nil, err
+	}
+
+	return note, nil
+}
+
+func (es *ElasticsearchStorage) listRevisions(ctx context.Context, log *zap.Logger, name, pageToken string, pageSize int32) ([]*esutil.EsSearchResponseHit, string, error) {
+	if !es.historyEnabled() {
+		return nil, "", historyDisabledError()
+	}
+
+	search := &esutil.EsSearch{
+		Query: &filtering.Query{
+			Term: &filtering.Term{
+				"name": name,
+			},
+		},
+		Sort: []esutil.EsSortField{
+			{Field: revisionTimeField, Order: esutil.EsSortOrderDescending},
+		},
+	}
+
+	res, nextPageToken, err := es.genericList(ctx, log, revisionsDocumentKind, es.revisionsAlias(), "", false, search, pageToken, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return res.Hits, nextPageToken, nil
+}
+
+// getAsOf unmarshals the state of the resource at the given time into message. That's the state held by the first revision made
+// after that time, or the current document if the resource hasn't changed since. Resources created after that time didn't exist yet.
+func (es *ElasticsearchStorage) getAsOf(ctx context.Context, log *zap.Logger, name, index string, asOf time.Time, message createTimeMessage) error {
+	if !es.historyEnabled() {
+		return historyDisabledError()
+	}
+
+	search := &esutil.EsSearch{
+		Query: &filtering.Query{
+			Bool: &filtering.Bool{
+				Filter: &filtering.Filter{
+					&filtering.Query{
+						Term: &filtering.Term{
+							"name": name,
+						},
+					},
+					&filtering.Query{
+						Range: &filtering.Range{
+							revisionTimeField: &filtering.RangeOperator{
+								Greater: asOf.UTC().Format(time.RFC3339Nano),
+							},
+						},
+					},
+				},
+			},
+		},
+		Sort: []esutil.EsSortField{
+			{Field: revisionTimeField, Order: esutil.EsSortOrderAscending},
+		},
+	}
+
+	_, err := es.genericGet(ctx, log, search, es.revisionsAlias(), message)
+	if status.Code(err) == codes.NotFound {
+		log.Debug("no revisions since, using the current document")
+		_, err = es.genericGet(ctx, log, &esutil.EsSearch{
+			Query: &filtering.Query{
+				Term: &filtering.Term{
+					"name": name,
+				},
+			},
+		}, index, message)
+	}
+	if err != nil {
+		return err
+	}
+
+	if createTime := message.GetCreateTime(); createTime != nil && createTime.AsTime().After(asOf) {
+		log.Debug("created after the requested time")
+		return status.Errorf(codes.NotFound, "%s did not exist at %s", name, asOf.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// createTimeMessage is an occurrence or note
+type createTimeMessage interface {
+	proto.Message
+	GetCreateTime() *timestamppb.Timestamp
+}
+
+// decodeRevision unmarshals the previous state of the resource into message, returning the details of the change
+func decodeRevision(source []byte, message proto.Message) (*Revision, error) {
+	if err := decodeDocument(source, message); err != nil {
+		return nil, err
+	}
+
+	var document struct {
+		Revision *Revision `json:"revision"`
+	}
+	if err := json.Unmarshal(source, &document); err != nil {
+		return nil, err
+	}
+	if document.Revision == nil {
+		return nil, fmt.Errorf("revision details are missing")
+	}
+
+	return document.Revision, nil
+}
+
+// deleteProjectRevisions deletes the revisions of the project's occurrences and notes
+func (es *ElasticsearchStorage) deleteProjectRevisions(ctx context.Context, projectId string) error {
+	_, err := es.client.DeleteByQuery(ctx, &esutil.DeleteRequest{
+		Index: es.revisionsAlias(),
+		Search: &esutil.EsSearch{
+			Query: &filtering.Query{
+				Prefix: &filtering.Term{
+					"name": fmt.Sprintf("projects/%s/", projectId),
+				},
+			},
+		},
+		Refresh: es.config.Refresh.String(),
+	})
+
+	return err
+}
+
+func historyDisabledError() error {
+	return status.Error(codes.FailedPrecondition, "history is not enabled")
+}
+
+func (es *ElasticsearchStorage) revisionsIndex() string {
+	return es.indexManager.IndexName(revisionsDocumentKind, "")
+}
+
+func (es *ElasticsearchStorage) revisionsAlias() string {
+	return es.indexManager.AliasName(revisionsDocumentKind, "")
+}
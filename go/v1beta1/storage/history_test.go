@@ -0,0 +1,569 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ = Describe("history", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId      string
+		expectedOccurrenceId   string
+		expectedOccurrenceName string
+		expectedNoteId         string
+		expectedNoteName       string
+		expectedRevisionsAlias string
+		expectedUserId         string
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	BeforeEach(func() {
+		expectedUserId = fake.LetterN(10)
+		ctx = ContextWithUserID(context.Background(), expectedUserId)
+		expectedProjectId = fake.LetterN(10)
+		expectedOccurrenceId = fake.LetterN(10)
+		expectedOccurrenceName = fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, expectedOccurrenceId)
+		expectedNoteId = fake.LetterN(10)
+		expectedNoteName = fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, expectedNoteId)
+		expectedRevisionsAlias = "grafeas-revisions"
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+			History: config.HistoryConfig{
+				Enabled: true,
+			},
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+		indexManager.IndexNameCalls(func(documentKind string, inner string) string {
+			return fmt.Sprintf("grafeas-v1-%s-%s", inner, documentKind)
+		})
+		client.DeleteByQueryReturns(&esutil.EsDeleteResponse{}, nil)
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	searchResponse := func(messages ...proto.Message) *esutil.SearchResponse {
+		response := &esutil.SearchResponse{
+			Hits: &esutil.EsSearchResponseHits{
+				Total: &esutil.EsSearchResponseTotal{Value: len(messages)},
+			},
+		}
+		for _, message := range messages {
+			source, err := protojson.Marshal(proto.MessageV2(message))
+			Expect(err).ToNot(HaveOccurred())
+
+			response.Hits.Hits = append(response.Hits.Hits, &esutil.EsSearchResponseHit{
+				ID:     fake.LetterN(10),
+				Index:  fake.LetterN(10),
+				Source: source,
+			})
+		}
+
+		return response
+	}
+
+	revisionResponse := func(message proto.Message, revision *Revision) *esutil.SearchResponse {
+		source, err := esutil.EncodeDocument(proto.MessageV2(message), nil, map[string]interface{}{
+			revisionField: revision,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		return &esutil.SearchResponse{
+			Hits: &esutil.EsSearchResponseHits{
+				Total: &esutil.EsSearchResponseTotal{Value: 1},
+				Hits: []*esutil.EsSearchResponseHit{
+					{Source: source},
+				},
+			},
+		}
+	}
+
+	Context("Initialize", func() {
+		It("should create the revisions index", func() {
+			Expect(elasticsearchStorage.Initialize(ctx)).To(Succeed())
+			Expect(indexManager.CreateIndexCallCount()).To(Equal(2))
+
+			_, index, alias, documentKind := indexManager.CreateIndexArgsForCall(1)
+			Expect(index).To(Equal("grafeas-v1--revisions"))
+			Expect(alias).To(Equal(expectedRevisionsAlias))
+			Expect(documentKind).To(Equal(revisionsDocumentKind))
+		})
+	})
+
+	// revisionItem is the revision written in the first bulk request, after the change itself
+	revisionItem := func() *esutil.BulkRequestItem {
+		_, bulkRequest := client.BulkArgsForCall(0)
+		Expect(bulkRequest.Items).To(HaveLen(2))
+
+		return bulkRequest.Items[1]
+	}
+
+	Context("UpdateOccurrence", func() {
+		var (
+			currentOccurrence *pb.Occurrence
+			actualErr         error
+		)
+
+		BeforeEach(func() {
+			currentOccurrence = generateTestOccurrence(expectedOccurrenceName)
+			client.SearchReturns(searchResponse(currentOccurrence), nil)
+			client.BulkReturns(bulkResponse(2), nil)
+		})
+
+		JustBeforeEach(func() {
+			_, actualErr = elasticsearchStorage.UpdateOccurrence(ctx, expectedProjectId, expectedOccurrenceId, &pb.Occurrence{
+				Resource: &pb.Resource{Uri: "updatedvalue"},
+			}, &fieldmaskpb.FieldMask{Paths: []string{"resource.uri"}})
+		})
+
+		It("should keep the previous state of the occurrence in the same bulk request as the update", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(client.BulkCallCount()).To(Equal(1))
+			Expect(client.CreateCallCount()).To(Equal(0))
+
+			item := revisionItem()
+			Expect(item.Operation).To(Equal(esutil.BULK_CREATE))
+			Expect(item.Index).To(Equal(expectedRevisionsAlias))
+			Expect(item.DocumentId).ToNot(BeEmpty())
+			Expect(proto.Equal(proto.MessageV1(item.Message), currentOccurrence)).To(BeTrue())
+
+			revision := item.Fields[revisionField].(*Revision)
+			Expect(revision.Operation).To(Equal(RevisionOperationUpdate))
+			Expect(revision.UserID).To(Equal(expectedUserId))
+			Expect(revision.UpdateMask).To(ContainElement("resource.uri"))
+			Expect(revision.Time).To(BeTemporally("~", time.Now(), time.Minute))
+		})
+
+		It("should update the occurrence", func() {
+			Expect(client.UpdateCallCount()).To(Equal(0))
+
+			_, bulkRequest := client.BulkArgsForCall(0)
+			Expect(bulkRequest.Items[0].Operation).To(Equal(esutil.BULK_INDEX))
+			Expect(proto.MessageV1(bulkRequest.Items[0].Message).(*pb.Occurrence).Resource.Uri).To(Equal("updatedvalue"))
+		})
+
+		When("the user is in the request metadata", func() {
+			BeforeEach(func() {
+				esConfig.UserIDMetadata = "x-user-id"
+				ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("X-User-Id", expectedUserId))
+			})
+
+			It("should record the user from the metadata", func() {
+				revision := revisionItem().Fields[revisionField].(*Revision)
+				Expect(revision.UserID).To(Equal(expectedUserId))
+			})
+
+			When("the metadata key isn't configured", func() {
+				BeforeEach(func() {
+					esConfig.UserIDMetadata = ""
+				})
+
+				It("should not trust the metadata", func() {
+					revision := revisionItem().Fields[revisionField].(*Revision)
+					Expect(revision.UserID).To(BeEmpty())
+				})
+			})
+		})
+
+		When("the update fails", func() {
+			BeforeEach(func() {
+				client.BulkReturnsOnCall(0, &esutil.EsBulkResponse{
+					Items: []*esutil.EsBulkResponseItem{
+						{Index: &esutil.EsIndexDocResponse{Status: 409, Error: &esutil.EsIndexDocError{Type: fake.LetterN(10)}}},
+						{Create: &esutil.EsIndexDocResponse{}},
+					},
+				}, nil)
+				client.BulkReturnsOnCall(1, bulkResponse(1), nil)
+			})
+
+			It("should remove the revision", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(client.BulkCallCount()).To(Equal(2))
+
+				revisionId := revisionItem().DocumentId
+				_, discard := client.BulkArgsForCall(1)
+				Expect(discard.Index).To(Equal(expectedRevisionsAlias))
+				Expect(discard.Items).To(Equal([]*esutil.BulkRequestItem{
+					{
+						Operation:  esutil.BULK_DELETE,
+						DocumentId: revisionId,
+					},
+				}))
+			})
+		})
+
+		When("writing the revision fails", func() {
+			BeforeEach(func() {
+				client.BulkReturns(&esutil.EsBulkResponse{
+					Items: []*esutil.EsBulkResponseItem{
+						{Index: &esutil.EsIndexDocResponse{}},
+						{Create: &esutil.EsIndexDocResponse{Status: 500, Error: &esutil.EsIndexDocError{Type: fake.LetterN(10)}}},
+					},
+				}, nil)
+			})
+
+			It("should still update the occurrence", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(client.BulkCallCount()).To(Equal(1))
+			})
+		})
+
+		When("history is disabled", func() {
+			BeforeEach(func() {
+				esConfig.History.Enabled = false
+			})
+
+			It("should not keep the previous state", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(client.BulkCallCount()).To(Equal(0))
+				Expect(client.UpdateCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Context("UpdateNote", func() {
+		It("should keep the previous state of the note", func() {
+			currentNote := generateTestNote(expectedNoteName)
+			client.SearchReturns(searchResponse(currentNote), nil)
+			client.BulkReturns(bulkResponse(2), nil)
+
+			_, err := elasticsearchStorage.UpdateNote(ctx, expectedProjectId, expectedNoteId, &pb.Note{
+				ShortDescription: "updatedvalue",
+			}, &fieldmaskpb.FieldMask{Paths: []string{"shortDescription"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			item := revisionItem()
+			Expect(item.Index).To(Equal(expectedRevisionsAlias))
+			Expect(proto.Equal(proto.MessageV1(item.Message), currentNote)).To(BeTrue())
+			Expect(item.Fields[revisionField].(*Revision).Operation).To(Equal(RevisionOperationUpdate))
+		})
+	})
+
+	Context("DeleteOccurrence", func() {
+		var (
+			currentOccurrence *pb.Occurrence
+			actualErr         error
+		)
+
+		BeforeEach(func() {
+			currentOccurrence = generateTestOccurrence(expectedOccurrenceName)
+			client.SearchReturns(searchResponse(currentOccurrence), nil)
+			client.BulkReturns(bulkResponse(2), nil)
+		})
+
+		JustBeforeEach(func() {
+			actualErr = elasticsearchStorage.DeleteOccurrence(ctx, expectedProjectId, expectedOccurrenceId)
+		})
+
+		It("should keep the state of the occurrence in the same bulk request that deletes it", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(client.DeleteCallCount()).To(Equal(0))
+
+			_, bulkRequest := client.BulkArgsForCall(0)
+			Expect(bulkRequest.Items[0].Operation).To(Equal(esutil.BULK_DELETE))
+
+			item := revisionItem()
+			Expect(proto.Equal(proto.MessageV1(item.Message), currentOccurrence)).To(BeTrue())
+
+			revision := item.Fields[revisionField].(*Revision)
+			Expect(revision.Operation).To(Equal(RevisionOperationDelete))
+			Expect(revision.UpdateMask).To(BeEmpty())
+		})
+
+		When("the occurrence does not exist", func() {
+			BeforeEach(func() {
+				client.SearchReturns(searchResponse(), nil)
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+				Expect(client.BulkCallCount()).To(Equal(0))
+				Expect(client.DeleteCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("DeleteNote", func() {
+		It("should keep the state of the note before deleting it", func() {
+			currentNote := generateTestNote(expectedNoteName)
+			client.SearchReturns(searchResponse(currentNote), nil)
+			client.BulkReturns(bulkResponse(2), nil)
+
+			Expect(elasticsearchStorage.DeleteNote(ctx, expectedProjectId, expectedNoteId)).To(Succeed())
+
+			item := revisionItem()
+			Expect(proto.Equal(proto.MessageV1(item.Message), currentNote)).To(BeTrue())
+			Expect(item.Fields[revisionField].(*Revision).Operation).To(Equal(RevisionOperationDelete))
+		})
+	})
+
+	Context("DeleteProject", func() {
+		It("should delete the revisions of the project's occurrences and notes", func() {
+			Expect(elasticsearchStorage.DeleteProject(ctx, expectedProjectId)).To(Succeed())
+			Expect(client.DeleteByQueryCallCount()).To(Equal(1))
+
+			_, deleteRequest := client.DeleteByQueryArgsForCall(0)
+			Expect(deleteRequest.Index).To(Equal(expectedRevisionsAlias))
+			Expect(deleteRequest.Search.Query).To(Equal(&filtering.Query{
+				Prefix: &filtering.Term{
+					"name": fmt.Sprintf("projects/%s/", expectedProjectId),
+				},
+			}))
+		})
+	})
+
+	Context("ListOccurrenceRevisions", func() {
+		var (
+			expectedOccurrence *pb.Occurrence
+			expectedRevision   *Revision
+			expectedPageToken  string
+
+			actualRevisions []*OccurrenceRevision
+			actualPageToken string
+			actualErr       error
+		)
+
+		BeforeEach(func() {
+			expectedOccurrence = generateTestOccurrence(expectedOccurrenceName)
+			expectedRevision = &Revision{
+				Operation:  RevisionOperationUpdate,
+				Time:       time.Now().UTC().Truncate(time.Millisecond),
+				UserID:     expectedUserId,
+				UpdateMask: []string{"resource.uri"},
+			}
+			expectedPageToken = fake.LetterN(10)
+
+			response := revisionResponse(expectedOccurrence, expectedRevision)
+			response.NextPageToken = expectedPageToken
+			client.SearchReturns(response, nil)
+		})
+
+		JustBeforeEach(func() {
+			actualRevisions, actualPageToken, actualErr = elasticsearchStorage.ListOccurrenceRevisions(ctx, expectedProjectId, expectedOccurrenceId, "", 10)
+		})
+
+		It("should search for the occurrence's revisions, most recent first", func() {
+			_, searchRequest := client.SearchArgsForCall(0)
+			Expect(searchRequest.Index).To(Equal(expectedRevisionsAlias))
+			Expect(searchRequest.Search.Query).To(Equal(&filtering.Query{
+				Term: &filtering.Term{
+					"name": expectedOccurrenceName,
+				},
+			}))
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: "revision.time", Order: esutil.EsSortOrderDescending},
+			}))
+			Expect(searchRequest.Pagination.Size).To(Equal(10))
+		})
+
+		It("should return the previous states along with the details of each change", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualPageToken).To(Equal(expectedPageToken))
+			Expect(actualRevisions).To(HaveLen(1))
+			Expect(actualRevisions[0].Occurrence).To(Equal(expectedOccurrence))
+			Expect(actualRevisions[0].Revision).To(Equal(*expectedRevision))
+		})
+
+		When("history is disabled", func() {
+			BeforeEach(func() {
+				esConfig.History.Enabled = false
+			})
+
+			It("should return a failed precondition error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.FailedPrecondition)
+				Expect(client.SearchCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("ListNoteRevisions", func() {
+		It("should return the previous states of the note", func() {
+			expectedNote := generateTestNote(expectedNoteName)
+			client.SearchReturns(revisionResponse(expectedNote, &Revision{
+				Operation: RevisionOperationDelete,
+			}), nil)
+
+			revisions, _, err := elasticsearchStorage.ListNoteRevisions(ctx, expectedProjectId, expectedNoteId, "", 10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revisions).To(HaveLen(1))
+			Expect(revisions[0].Note).To(Equal(expectedNote))
+			Expect(revisions[0].Operation).To(Equal(RevisionOperationDelete))
+		})
+	})
+
+	Context("GetOccurrenceAsOf", func() {
+		var (
+			asOf               time.Time
+			previousOccurrence *pb.Occurrence
+			currentOccurrence  *pb.Occurrence
+
+			actualOccurrence *pb.Occurrence
+			actualErr        error
+		)
+
+		BeforeEach(func() {
+			asOf = time.Now().Add(-time.Hour)
+			previousOccurrence = generateTestOccurrence(expectedOccurrenceName)
+			previousOccurrence.CreateTime = timestamppb.New(asOf.Add(-time.Hour))
+			currentOccurrence = generateTestOccurrence(expectedOccurrenceName)
+			currentOccurrence.CreateTime = previousOccurrence.CreateTime
+
+			client.SearchReturnsOnCall(0, revisionResponse(previousOccurrence, &Revision{Operation: RevisionOperationUpdate}), nil)
+			client.SearchReturnsOnCall(1, searchResponse(currentOccurrence), nil)
+		})
+
+		JustBeforeEach(func() {
+			actualOccurrence, actualErr = elasticsearchStorage.GetOccurrenceAsOf(ctx, expectedProjectId, expectedOccurrenceId, asOf)
+		})
+
+		It("should search for the first revision after that time", func() {
+			_, searchRequest := client.SearchArgsForCall(0)
+			Expect(searchRequest.Index).To(Equal(expectedRevisionsAlias))
+			Expect(searchRequest.Search.Query).To(Equal(&filtering.Query{
+				Bool: &filtering.Bool{
+					Filter: &filtering.Filter{
+						&filtering.Query{
+							Term: &filtering.Term{
+								"name": expectedOccurrenceName,
+							},
+						},
+						&filtering.Query{
+							Range: &filtering.Range{
+								"revision.time": &filtering.RangeOperator{
+									Greater: asOf.UTC().Format(time.RFC3339Nano),
+								},
+							},
+						},
+					},
+				},
+			}))
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: "revision.time", Order: esutil.EsSortOrderAscending},
+			}))
+		})
+
+		It("should return the state held by the revision", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualOccurrence).To(Equal(previousOccurrence))
+			Expect(client.SearchCallCount()).To(Equal(1))
+		})
+
+		When("the occurrence hasn't changed since", func() {
+			BeforeEach(func() {
+				client.SearchReturnsOnCall(0, searchResponse(), nil)
+			})
+
+			It("should return the current occurrence", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualOccurrence).To(Equal(currentOccurrence))
+
+				_, searchRequest := client.SearchArgsForCall(1)
+				Expect(searchRequest.Index).To(Equal(fmt.Sprintf("grafeas-%s-occurrences", expectedProjectId)))
+			})
+		})
+
+		When("the occurrence was created after that time", func() {
+			BeforeEach(func() {
+				client.SearchReturnsOnCall(0, searchResponse(), nil)
+				currentOccurrence.CreateTime = timestamppb.New(asOf.Add(time.Minute))
+				client.SearchReturnsOnCall(1, searchResponse(currentOccurrence), nil)
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+			})
+		})
+
+		When("the occurrence doesn't exist", func() {
+			BeforeEach(func() {
+				client.SearchReturnsOnCall(0, searchResponse(), nil)
+				client.SearchReturnsOnCall(1, searchResponse(), nil)
+			})
+
+			It("should return a not found error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.NotFound)
+			})
+		})
+
+		When("searching the revisions fails", func() {
+			BeforeEach(func() {
+				client.SearchReturnsOnCall(0, nil, errors.New("search failed"))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			})
+		})
+	})
+
+	Context("GetNoteAsOf", func() {
+		It("should return the state of the note at that time", func() {
+			previousNote := generateTestNote(expectedNoteName)
+			previousNote.CreateTime = timestamppb.New(time.Now().Add(-time.Hour))
+			client.SearchReturns(revisionResponse(previousNote, &Revision{Operation: RevisionOperationUpdate}), nil)
+
+			note, err := elasticsearchStorage.GetNoteAsOf(ctx, expectedProjectId, expectedNoteId, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(note).To(Equal(previousNote))
+		})
+	})
+})
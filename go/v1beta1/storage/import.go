@@ -0,0 +1,471 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const defaultImportChunkSize = 500
+
+// ImportOptions control how an import is written and where it starts
+type ImportOptions struct {
+	// ChunkSize is the number of notes or occurrences written in each bulk request. It defaults to 500.
+	ChunkSize int
+	// StartLine is the first line to import, which resumes an import from a checkpoint. Lines are numbered from 1.
+	StartLine int
+	// Checkpoint is called after each chunk is written, with the line to resume from if the import is interrupted
+	Checkpoint func(line int) error
+}
+
+// ImportSummary counts the documents that an import wrote, and reports the lines that couldn't be imported
+type ImportSummary struct {
+	Projects    int
+	Notes       int
+	Occurrences int
+	// Skipped counts the documents that already existed, such as those written before an import was interrupted
+	Skipped int
+	Errors  []*ImportError
+}
+
+// ImportError is why a line wasn't imported
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// importRecord is a note or occurrence waiting to be written
+type importRecord struct {
+	line       int
+	note       *pb.Note
+	occurrence *pb.Occurrence
+}
+
+// importer holds the state of an import between chunks
+type importer struct {
+	es        *ElasticsearchStorage
+	log       *zap.Logger
+	options   *ImportOptions
+	summary   *ImportSummary
+	userID    string
+	projectId string
+	// projects records whether each project that lines referred to exists
+	projects map[string]bool
+	chunk    []*importRecord
+}
+
+// ImportProjects reads newline-delimited JSON of projects, notes and occurrences, in the format written by ExportProject, and writes them to Elasticsearch.
+// Names and timestamps are kept, and each line is validated as protojson of the message its name refers to.
+// Lines that can't be imported are reported in the summary, and don't stop the import.
+// Projects are created as they're read, so a project's line must come before its notes and occurrences, unless the project already exists.
+// Notes and occurrences are written in chunks through bulk requests. Notes that already exist are found by name, and occurrences are given IDs
+// derived from their names, so that importing a document again skips it rather than duplicating it.
+// When deduplication is enabled, occurrences are renamed to their dedupe key and use it as their ID, as they would be when created
+// through the Grafeas API, so that a later create of the same occurrence finds and keeps the imported one.
+// Unlike creates through the Grafeas API, notes and occurrences that are imported don't get events, audit records or revisions.
+func (es *ElasticsearchStorage) ImportProjects(ctx context.Context, r io.Reader, options *ImportOptions) (*ImportSummary, error) {
+	if options == nil {
+		options = &ImportOptions{}
+	}
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = defaultImportChunkSize
+	}
+
+	im := &importer{
+		es:       es,
+		log:      es.logger.Named("ImportProjects"),
+		options:  options,
+		summary:  &ImportSummary{},
+		userID:   es.actingUserID(ctx, ""),
+		projects: map[string]bool{},
+	}
+
+	reader := bufio.NewReader(r)
+	lineNumber := 0
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return im.summary, fmt.Errorf("error reading line %d: %s", lineNumber+1, readErr)
+		}
+
+		// only the read at the end of the input can be empty, since every other line ends with a newline
+		if len(line) > 0 {
+			lineNumber++
+			line = bytes.TrimSpace(line)
+			if lineNumber >= options.StartLine && len(line) > 0 {
+				if err := im.importLine(ctx, lineNumber, line); err != nil {
+					return im.summary, err
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if err := im.flush(ctx, lineNumber+1); err != nil {
+		return im.summary, err
+	}
+
+	im.log.Info("imported projects",
+		zap.Int("projects", im.summary.Projects),
+		zap.Int("notes", im.summary.Notes),
+		zap.Int("occurrences", im.summary.Occurrences),
+		zap.Int("skipped", im.summary.Skipped),
+		zap.Int("errors", len(im.summary.Errors)))
+
+	return im.summary, nil
+}
+
+// importLine validates a line and adds it to the chunk, writing the chunk when it's full.
+// An error is only returned when the import can't continue.
+func (im *importer) importLine(ctx context.Context, lineNumber int, line []byte) error {
+	var header struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		im.lineError(lineNumber, fmt.Errorf("invalid JSON: %s", err))
+		return nil
+	}
+
+	parts := strings.Split(header.Name, "/")
+	if parts[0] != "projects" || (len(parts) != 2 && len(parts) != 4) || hasEmptyPart(parts) {
+		im.lineError(lineNumber, fmt.Errorf("name must be a project, note or occurrence, got %q", header.Name))
+		return nil
+	}
+	projectId := parts[1]
+
+	if len(parts) == 2 {
+		project := &prpb.Project{}
+		if err := protojson.Unmarshal(line, proto.MessageV2(project)); err != nil {
+			im.lineError(lineNumber, fmt.Errorf("invalid project: %s", err))
+			return nil
+		}
+
+		return im.importProject(ctx, lineNumber, projectId, project)
+	}
+
+	record := &importRecord{line: lineNumber}
+	switch parts[2] {
+	case notesDocumentKind:
+		record.note = &pb.Note{}
+		if err := protojson.Unmarshal(line, proto.MessageV2(record.note)); err != nil {
+			im.lineError(lineNumber, fmt.Errorf("invalid note: %s", err))
+			return nil
+		}
+		if record.note.CreateTime == nil {
+			record.note.CreateTime = ptypes.TimestampNow()
+		}
+	case occurrencesDocumentKind:
+		record.occurrence = &pb.Occurrence{}
+		if err := protojson.Unmarshal(line, proto.MessageV2(record.occurrence)); err != nil {
+			im.lineError(lineNumber, fmt.Errorf("invalid occurrence: %s", err))
+			return nil
+		}
+		if record.occurrence.NoteName == "" || record.occurrence.Resource.GetUri() == "" {
+			im.lineError(lineNumber, errors.New("occurrence must have a note name and a resource uri"))
+			return nil
+		}
+		if record.occurrence.CreateTime == nil {
+			record.occurrence.CreateTime = ptypes.TimestampNow()
+		}
+	default:
+		im.lineError(lineNumber, fmt.Errorf("name must be a project, note or occurrence, got %q", header.Name))
+		return nil
+	}
+
+	exists, err := im.projectExists(ctx, projectId)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		im.lineError(lineNumber, status.Errorf(codes.FailedPrecondition, "project with ID %s does not exist", projectId))
+		return nil
+	}
+
+	// a chunk holds one kind of document from one project. notes are written before the occurrences that follow them,
+	// so that the occurrences can be enriched with their fields
+	if len(im.chunk) > 0 && (im.projectId != projectId || (im.chunk[0].note == nil) != (record.note == nil)) {
+		if err := im.flush(ctx, lineNumber); err != nil {
+			return err
+		}
+	}
+
+	im.projectId = projectId
+	im.chunk = append(im.chunk, record)
+	if len(im.chunk) >= im.options.ChunkSize {
+		return im.flush(ctx, lineNumber+1)
+	}
+
+	return nil
+}
+
+func (im *importer) importProject(ctx context.Context, lineNumber int, projectId string, project *prpb.Project) error {
+	exists, err := im.projectExists(ctx, projectId)
+	if err != nil {
+		return err
+	}
+	if exists {
+		im.summary.Skipped++
+		return nil
+	}
+
+	_, err = im.es.CreateProject(ctx, projectId, project)
+	switch {
+	case err == nil:
+		im.summary.Projects++
+	case status.Code(err) == codes.AlreadyExists:
+		im.summary.Skipped++
+	default:
+		im.lineError(lineNumber, err)
+		return nil
+	}
+	im.projects[projectId] = true
+
+	return nil
+}
+
+func (im *importer) projectExists(ctx context.Context, projectId string) (bool, error) {
+	if exists, ok := im.projects[projectId]; ok {
+		return exists, nil
+	}
+
+	exists, err := im.es.doesProjectExist(ctx, im.log, projectId)
+	if err != nil {
+		return false, err
+	}
+	im.projects[projectId] = exists
+
+	return exists, nil
+}
+
+// flush writes the chunk in a single bulk request, then checkpoints the import at nextLine, since every line before it has been handled
+func (im *importer) flush(ctx context.Context, nextLine int) error {
+	if len(im.chunk) == 0 {
+		return im.checkpoint(nextLine)
+	}
+
+	log := im.log.With(zap.String("project", im.projectId), zap.Int("firstLine", im.chunk[0].line))
+	items, records, err := im.bulkItems(ctx, log)
+	if err != nil {
+		return err
+	}
+
+	if len(items) > 0 {
+		res, err := im.es.client.Bulk(ctx, &esutil.BulkRequest{
+			Items:   items,
+			Refresh: string(im.es.config.Refresh),
+		})
+		if err != nil {
+			return createError(log, "error writing imported documents", err)
+		}
+
+		for i, item := range res.Items {
+			record := records[i]
+			if itemErr := bulkItemError(item); itemErr == nil {
+				if record.note != nil {
+					im.summary.Notes++
+				} else {
+					im.summary.Occurrences++
+				}
+			} else if item.Create != nil && item.Create.Status == http.StatusConflict {
+				im.summary.Skipped++
+			} else {
+				im.lineError(record.line, itemErr)
+			}
+		}
+	}
+
+	im.chunk = nil
+
+	return im.checkpoint(nextLine)
+}
+
+// bulkItems creates the bulk request items for the chunk, returning the records that each one is for
+func (im *importer) bulkItems(ctx context.Context, log *zap.Logger) ([]*esutil.BulkRequestItem, []*importRecord, error) {
+	if im.chunk[0].note != nil {
+		return im.noteItems(ctx, log)
+	}
+
+	return im.occurrenceItems(ctx, log)
+}
+
+// noteItems creates the bulk request items for a chunk of notes. Notes are given the same document IDs as those created through
+// the Grafeas API, which are generated by Elasticsearch unless notes are parents in the joined layout, so notes that already exist
+// are found by name and skipped, along with repeats of a note within the chunk.
+func (im *importer) noteItems(ctx context.Context, log *zap.Logger) ([]*esutil.BulkRequestItem, []*importRecord, error) {
+	es := im.es
+	metadata := &DocumentMetadata{CreatedBy: im.userID}
+
+	var searches []*esutil.EsSearch
+	for _, record := range im.chunk {
+		searches = append(searches, &esutil.EsSearch{
+			Query: &filtering.Query{
+				Term: &filtering.Term{
+					"name": record.note.Name,
+				},
+			},
+		})
+	}
+
+	res, err := es.client.MultiSearch(ctx, &esutil.MultiSearchRequest{
+		Index:    es.notesAlias(im.projectId),
+		Searches: searches,
+	})
+	if err != nil {
+		return nil, nil, createError(log, "error checking for existing notes", err)
+	}
+
+	var (
+		items   []*esutil.BulkRequestItem
+		records []*importRecord
+		names   = map[string]bool{}
+	)
+	for i, record := range im.chunk {
+		if res.Responses[i].Hits.Total.Value != 0 || names[record.note.Name] {
+			im.summary.Skipped++
+			continue
+		}
+		names[record.note.Name] = true
+
+		// a document without an ID is given one by Elasticsearch, which the create operation doesn't allow
+		operation := esutil.BULK_CREATE
+		documentId := es.noteDocumentId(record.note)
+		if documentId == "" {
+			operation = esutil.BULK_INDEX
+		}
+
+		items = append(items, &esutil.BulkRequestItem{
+			Operation:  operation,
+			Index:      es.notesAlias(im.projectId),
+			DocumentId: documentId,
+			Message:    proto.MessageV2(record.note),
+			Join:       es.noteJoin(),
+			Fields:     withMetadata(es.documentFields(im.projectId), metadata),
+		})
+		records = append(records, record)
+	}
+
+	return items, records, nil
+}
+
+// occurrenceItems creates the bulk request items for a chunk of occurrences
+func (im *importer) occurrenceItems(ctx context.Context, log *zap.Logger) ([]*esutil.BulkRequestItem, []*importRecord, error) {
+	es := im.es
+	metadata := &DocumentMetadata{CreatedBy: im.userID}
+
+	var (
+		occurrences []*pb.Occurrence
+		records     []*importRecord
+		documentIds []string
+	)
+	for _, record := range im.chunk {
+		documentId := record.occurrence.Name
+		if es.dedupeEnabled() {
+			key, err := occurrenceKey(im.projectId, record.occurrence, es.config.Dedupe.Fields)
+			if err != nil {
+				im.lineError(record.line, fmt.Errorf("error computing occurrence key: %s", err))
+				continue
+			}
+			documentId = key
+			record.occurrence.Name = fmt.Sprintf("projects/%s/occurrences/%s", im.projectId, key)
+		}
+
+		occurrences = append(occurrences, record.occurrence)
+		records = append(records, record)
+		documentIds = append(documentIds, documentId)
+	}
+	if len(occurrences) == 0 {
+		return nil, nil, nil
+	}
+
+	metadataPerOccurrence := make([]*DocumentMetadata, len(occurrences))
+	for i := range occurrences {
+		metadataPerOccurrence[i] = metadata
+	}
+	fields, err := es.occurrenceFields(ctx, log, im.projectId, occurrences, metadataPerOccurrence)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var items []*esutil.BulkRequestItem
+	for i, occurrence := range occurrences {
+		items = append(items, &esutil.BulkRequestItem{
+			Operation:  esutil.BULK_CREATE,
+			Index:      es.occurrencesWriteAlias(im.projectId),
+			DocumentId: documentIds[i],
+			Message:    proto.MessageV2(occurrence),
+			Join:       es.occurrenceJoin(occurrence),
+			Fields:     fields[i],
+		})
+	}
+
+	return items, records, nil
+}
+
+func (im *importer) checkpoint(nextLine int) error {
+	if im.options.Checkpoint == nil {
+		return nil
+	}
+
+	if err := im.options.Checkpoint(nextLine); err != nil {
+		return fmt.Errorf("error saving checkpoint: %s", err)
+	}
+
+	return nil
+}
+
+func (im *importer) lineError(line int, err error) {
+	im.log.Debug("line not imported", zap.Int("line", line), zap.Error(err))
+	im.summary.Errors = append(im.summary.Errors, &ImportError{Line: line, Err: err})
+}
+
+func hasEmptyPart(parts []string) bool {
+	for _, part := range parts {
+		if part == "" {
+			return true
+		}
+	}
+
+	return false
+}
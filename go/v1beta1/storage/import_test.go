@@ -0,0 +1,455 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var _ = Describe("import", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId   string
+		expectedNotes       []*pb.Note
+		expectedOccurrences []*pb.Occurrence
+
+		// existingProjects are the projects that doesProjectExist finds
+		existingProjects map[string]bool
+		// existingNotes are the names of the notes that are found before writing a chunk
+		existingNotes map[string]bool
+		// itemStatuses overrides the status of the bulk items for the documents with these names
+		itemStatuses  map[string]int
+		bulkRequests  []*esutil.BulkRequest
+		checkpoints   []int
+		lines         []string
+		options       *ImportOptions
+		actualSummary *ImportSummary
+		actualErr     error
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	line := func(message proto.Message) string {
+		encoded, err := protojson.Marshal(proto.MessageV2(message))
+		Expect(err).ToNot(HaveOccurred())
+
+		return string(encoded)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		expectedProjectId = fake.LetterN(10)
+		expectedNotes = generateTestNotes(2, expectedProjectId)
+		expectedOccurrences = []*pb.Occurrence{
+			generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10))),
+			generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10))),
+			generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10))),
+		}
+		existingProjects = map[string]bool{expectedProjectId: true}
+		existingNotes = map[string]bool{}
+		itemStatuses = map[string]int{}
+		bulkRequests = nil
+		checkpoints = nil
+		lines = []string{
+			line(generateTestProject(expectedProjectId)),
+			line(expectedNotes[0]),
+			line(expectedNotes[1]),
+			line(expectedOccurrences[0]),
+			line(expectedOccurrences[1]),
+			line(expectedOccurrences[2]),
+		}
+		options = &ImportOptions{
+			Checkpoint: func(line int) error {
+				checkpoints = append(checkpoints, line)
+				return nil
+			},
+		}
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+		indexManager.IndexNameCalls(func(documentKind string, inner string) string {
+			return fmt.Sprintf("grafeas-v1-%s-%s", inner, documentKind)
+		})
+
+		client.SearchCalls(func(_ context.Context, request *esutil.SearchRequest) (*esutil.SearchResponse, error) {
+			response := &esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{},
+				},
+			}
+
+			projectName := (*request.Search.Query.Term)["name"].(string)
+			if existingProjects[strings.TrimPrefix(projectName, "projects/")] {
+				response.Hits.Total.Value = 1
+				response.Hits.Hits = []*esutil.EsSearchResponseHit{
+					{Source: []byte(fmt.Sprintf(`{"name": %q}`, projectName))},
+				}
+			}
+
+			return response, nil
+		})
+		client.BulkCalls(func(_ context.Context, request *esutil.BulkRequest) (*esutil.EsBulkResponse, error) {
+			bulkRequests = append(bulkRequests, request)
+
+			response := &esutil.EsBulkResponse{}
+			for _, item := range request.Items {
+				result := &esutil.EsIndexDocResponse{Status: http.StatusCreated}
+				if status, ok := itemStatuses[resourceName(proto.MessageV1(item.Message))]; ok {
+					result.Status = status
+					result.Error = &esutil.EsIndexDocError{Type: fake.LetterN(10), Reason: fake.LetterN(10)}
+				}
+
+				response.Items = append(response.Items, &esutil.EsBulkResponseItem{Create: result})
+			}
+
+			return response, nil
+		})
+		client.MultiSearchCalls(func(_ context.Context, request *esutil.MultiSearchRequest) (*esutil.EsMultiSearchResponse, error) {
+			response := &esutil.EsMultiSearchResponse{}
+			for _, search := range request.Searches {
+				total := 0
+				if existingNotes[(*search.Query.Term)["name"].(string)] {
+					total = 1
+				}
+
+				response.Responses = append(response.Responses, &esutil.EsMultiSearchResponseHitsSummary{
+					Hits: &esutil.EsMultiSearchResponseHits{
+						Total: &esutil.EsSearchResponseTotal{Value: total},
+					},
+				})
+			}
+
+			return response, nil
+		})
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+
+		input := strings.NewReader(strings.Join(lines, "\n") + "\n")
+		actualSummary, actualErr = elasticsearchStorage.ImportProjects(ctx, input, options)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	// writtenItems returns the items of every bulk request, in order
+	writtenItems := func() []*esutil.BulkRequestItem {
+		var items []*esutil.BulkRequestItem
+		for _, request := range bulkRequests {
+			items = append(items, request.Items...)
+		}
+
+		return items
+	}
+
+	lineErrors := func() map[int]error {
+		errs := map[int]error{}
+		for _, importErr := range actualSummary.Errors {
+			errs[importErr.Line] = importErr.Err
+		}
+
+		return errs
+	}
+
+	It("should write the notes, then the occurrences, keeping their names and timestamps", func() {
+		Expect(actualErr).ToNot(HaveOccurred())
+		Expect(actualSummary).To(Equal(&ImportSummary{Notes: 2, Occurrences: 3, Skipped: 1}))
+
+		Expect(bulkRequests).To(HaveLen(2))
+		for i, item := range bulkRequests[0].Items {
+			Expect(item.Operation).To(Equal(esutil.BULK_INDEX))
+			Expect(item.Index).To(Equal(fmt.Sprintf("grafeas-%s-notes", expectedProjectId)))
+			Expect(item.DocumentId).To(BeEmpty())
+			Expect(proto.Equal(proto.MessageV1(item.Message), expectedNotes[i])).To(BeTrue())
+		}
+		for i, item := range bulkRequests[1].Items {
+			Expect(item.Operation).To(Equal(esutil.BULK_CREATE))
+			Expect(item.Index).To(Equal(fmt.Sprintf("grafeas-%s-occurrences", expectedProjectId)))
+			Expect(item.DocumentId).To(Equal(expectedOccurrences[i].Name))
+			Expect(proto.Equal(proto.MessageV1(item.Message), expectedOccurrences[i])).To(BeTrue())
+		}
+	})
+
+	It("should look for existing notes by name", func() {
+		Expect(client.MultiSearchCallCount()).To(Equal(1))
+
+		_, multiSearchRequest := client.MultiSearchArgsForCall(0)
+		Expect(multiSearchRequest.Index).To(Equal(fmt.Sprintf("grafeas-%s-notes", expectedProjectId)))
+		Expect(multiSearchRequest.Searches).To(HaveLen(2))
+		for i, search := range multiSearchRequest.Searches {
+			Expect((*search.Query.Term)["name"]).To(Equal(expectedNotes[i].Name))
+		}
+	})
+
+	It("should only check that the project exists once", func() {
+		Expect(client.SearchCallCount()).To(Equal(1))
+		Expect(client.CreateCallCount()).To(Equal(0))
+	})
+
+	It("should checkpoint after each chunk is written", func() {
+		// the notes are written when the first occurrence is read, and the occurrences at the end of the input
+		Expect(checkpoints).To(Equal([]int{4, 7}))
+	})
+
+	When("the project does not exist", func() {
+		BeforeEach(func() {
+			existingProjects = map[string]bool{}
+		})
+
+		It("should create it before its notes and occurrences", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualSummary.Projects).To(Equal(1))
+			Expect(actualSummary.Errors).To(BeEmpty())
+
+			Expect(client.CreateCallCount()).To(Equal(1))
+			_, createRequest := client.CreateArgsForCall(0)
+			Expect(createRequest.Index).To(Equal("grafeas-projects"))
+			Expect(writtenItems()).To(HaveLen(5))
+		})
+
+		When("its line is missing", func() {
+			BeforeEach(func() {
+				lines = lines[1:]
+			})
+
+			It("should report each of its notes and occurrences", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualSummary.Errors).To(HaveLen(5))
+				for _, importErr := range actualSummary.Errors {
+					assertErrorHasGrpcStatusCode(importErr.Err, codes.FailedPrecondition)
+				}
+				Expect(bulkRequests).To(BeEmpty())
+			})
+		})
+	})
+
+	When("the chunk size is reached", func() {
+		BeforeEach(func() {
+			options.ChunkSize = 2
+		})
+
+		It("should write the chunk", func() {
+			Expect(bulkRequests).To(HaveLen(3))
+			Expect(bulkRequests[0].Items).To(HaveLen(2))
+			Expect(bulkRequests[1].Items).To(HaveLen(2))
+			Expect(bulkRequests[2].Items).To(HaveLen(1))
+			Expect(checkpoints).To(Equal([]int{4, 6, 7}))
+		})
+	})
+
+	When("lines are invalid", func() {
+		BeforeEach(func() {
+			noNote := generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10)))
+			noNote.NoteName = ""
+
+			lines = append(lines,
+				"{",
+				fmt.Sprintf(`{"name": "projects/%s/notes/%s", "unknownField": true}`, expectedProjectId, fake.LetterN(10)),
+				fmt.Sprintf(`{"name": "projects/%s/attestations/%s"}`, expectedProjectId, fake.LetterN(10)),
+				`{"name": "projects//notes/x"}`,
+				line(noNote),
+				line(generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10)))),
+			)
+		})
+
+		It("should report each of them and import the rest", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualSummary.Occurrences).To(Equal(4))
+
+			errs := lineErrors()
+			Expect(errs).To(HaveLen(5))
+			Expect(errs[7]).To(MatchError(ContainSubstring("invalid JSON")))
+			Expect(errs[8]).To(MatchError(ContainSubstring("invalid note")))
+			Expect(errs[9]).To(MatchError(ContainSubstring("must be a project, note or occurrence")))
+			Expect(errs[10]).To(MatchError(ContainSubstring("must be a project, note or occurrence")))
+			Expect(errs[11]).To(MatchError(ContainSubstring("note name")))
+			Expect(actualSummary.Errors[0].Error()).To(HavePrefix("line 7: "))
+		})
+	})
+
+	When("documents were already imported", func() {
+		BeforeEach(func() {
+			existingNotes[expectedNotes[1].Name] = true
+			itemStatuses[expectedOccurrences[0].Name] = http.StatusConflict
+		})
+
+		It("should skip them", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualSummary).To(Equal(&ImportSummary{Notes: 1, Occurrences: 2, Skipped: 3}))
+			Expect(bulkRequests[0].Items).To(HaveLen(1))
+		})
+	})
+
+	When("a note is repeated", func() {
+		BeforeEach(func() {
+			lines = append(lines[:3], append([]string{line(expectedNotes[0])}, lines[3:]...)...)
+		})
+
+		It("should only write it once", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualSummary).To(Equal(&ImportSummary{Notes: 2, Occurrences: 3, Skipped: 2}))
+			Expect(bulkRequests[0].Items).To(HaveLen(2))
+		})
+	})
+
+	When("notes are parents in the joined layout", func() {
+		BeforeEach(func() {
+			esConfig.Layout = config.IndexLayoutJoined
+		})
+
+		It("should write notes with their names as their IDs", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+
+			for i, item := range bulkRequests[0].Items {
+				Expect(item.Operation).To(Equal(esutil.BULK_CREATE))
+				Expect(item.DocumentId).To(Equal(expectedNotes[i].Name))
+			}
+		})
+	})
+
+	When("checking for existing notes fails", func() {
+		BeforeEach(func() {
+			client.MultiSearchCalls(nil)
+			client.MultiSearchReturns(nil, errors.New("multi search failed"))
+		})
+
+		It("should stop without writing the notes", func() {
+			assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			Expect(bulkRequests).To(BeEmpty())
+			Expect(checkpoints).To(BeEmpty())
+		})
+	})
+
+	When("a document can't be written", func() {
+		BeforeEach(func() {
+			itemStatuses[expectedOccurrences[1].Name] = http.StatusBadRequest
+		})
+
+		It("should report its line", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualSummary.Occurrences).To(Equal(2))
+
+			errs := lineErrors()
+			Expect(errs).To(HaveLen(1))
+			Expect(errs[5]).To(MatchError(ContainSubstring("[400]")))
+		})
+	})
+
+	When("resuming from a checkpoint", func() {
+		BeforeEach(func() {
+			options.StartLine = 5
+		})
+
+		It("should skip the lines before it", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualSummary).To(Equal(&ImportSummary{Occurrences: 2}))
+
+			items := writtenItems()
+			Expect(items).To(HaveLen(2))
+			Expect(items[0].DocumentId).To(Equal(expectedOccurrences[1].Name))
+		})
+	})
+
+	When("deduplication is enabled", func() {
+		BeforeEach(func() {
+			esConfig.Dedupe = config.DedupeConfig{
+				Fields: []string{"resource.uri", "noteName"},
+				Mode:   config.DedupeModeReject,
+			}
+		})
+
+		It("should write occurrences with their dedupe key as their ID and name", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+
+			for i, item := range bulkRequests[1].Items {
+				key, err := occurrenceKey(expectedProjectId, expectedOccurrences[i], esConfig.Dedupe.Fields)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(item.DocumentId).To(Equal(key))
+				Expect(proto.MessageV1(item.Message).(*pb.Occurrence).Name).To(Equal(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, key)))
+			}
+		})
+	})
+
+	When("a bulk request fails", func() {
+		BeforeEach(func() {
+			client.BulkCalls(nil)
+			client.BulkReturnsOnCall(0, &esutil.EsBulkResponse{
+				Items: []*esutil.EsBulkResponseItem{
+					{Create: &esutil.EsIndexDocResponse{}},
+					{Create: &esutil.EsIndexDocResponse{}},
+				},
+			}, nil)
+			client.BulkReturnsOnCall(1, nil, errors.New("bulk failed"))
+		})
+
+		It("should stop without checkpointing the chunk", func() {
+			assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			Expect(actualSummary.Notes).To(Equal(2))
+			Expect(checkpoints).To(Equal([]int{4}))
+		})
+	})
+
+	When("saving a checkpoint fails", func() {
+		BeforeEach(func() {
+			options.Checkpoint = func(int) error {
+				return errors.New("checkpoint failed")
+			}
+		})
+
+		It("should stop", func() {
+			Expect(actualErr).To(MatchError(ContainSubstring("error saving checkpoint")))
+			Expect(client.BulkCallCount()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,283 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+)
+
+const (
+	// projectField is added to every note and occurrence in the shared layouts, to identify the project the document belongs to
+	projectField         = "project"
+	sharedLayoutPageSize = 1000
+
+	// documentsDocumentKind holds both notes and occurrences in the joined layout
+	documentsDocumentKind = "documents"
+	// joinField relates each occurrence to its note in the joined layout. The relation names are also the prefix used
+	// to filter occurrences by the fields of their note.
+	joinField          = "join"
+	noteJoinName       = "note"
+	occurrenceJoinName = "occurrence"
+)
+
+// initializeSharedLayout creates the indices shared by every project, then points each project's aliases at them.
+// The aliases are added again on every start, because the index manager replaces an index when migrating it to a new mapping,
+// and only moves the shared alias to the new index.
+func (es *ElasticsearchStorage) initializeSharedLayout(ctx context.Context) error {
+	for _, documentKind := range es.sharedDocumentKinds() {
+		indexName := es.indexManager.IndexName(documentKind, "")
+		aliasName := es.indexManager.AliasName(documentKind, "")
+		if err := es.indexManager.CreateIndex(ctx, indexName, aliasName, documentKind); err != nil {
+			return fmt.Errorf("error creating shared %s index: %s", documentKind, err)
+		}
+	}
+
+	pageToken := ""
+	for {
+		projects, nextPageToken, err := es.ListProjects(ctx, "", sharedLayoutPageSize, pageToken)
+		if err != nil {
+			return err
+		}
+
+		var actions []*esutil.EsAliasAction
+		for _, project := range projects {
+			projectId := strings.TrimPrefix(project.Name, "projects/")
+			actions = append(actions, es.projectAliasActions(projectId)...)
+		}
+
+		if len(actions) > 0 {
+			if err := es.client.UpdateAliases(ctx, actions); err != nil {
+				return fmt.Errorf("error adding project aliases: %s", err)
+			}
+		}
+
+		if nextPageToken == "" {
+			return nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+// createProjectAliases adds the project's note and occurrence aliases to the shared indices
+func (es *ElasticsearchStorage) createProjectAliases(ctx context.Context, projectId string) error {
+	return es.client.UpdateAliases(ctx, es.projectAliasActions(projectId))
+}
+
+// deleteProjectDocuments deletes the project's notes and occurrences from the shared indices, then removes its aliases
+func (es *ElasticsearchStorage) deleteProjectDocuments(ctx context.Context, projectId string) error {
+	for _, documentKind := range es.sharedDocumentKinds() {
+		_, err := es.client.DeleteByQuery(ctx, &esutil.DeleteRequest{
+			Index: es.indexManager.AliasName(documentKind, ""),
+			Search: &esutil.EsSearch{
+				Query: &filtering.Query{
+					Term: &filtering.Term{
+						projectField: projectId,
+					},
+				},
+			},
+			Refresh: es.config.Refresh.String(),
+			Routing: es.documentRouting(projectId),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var actions []*esutil.EsAliasAction
+	for _, documentKind := range []string{occurrencesDocumentKind, notesDocumentKind} {
+		actions = append(actions, &esutil.EsAliasAction{
+			Remove: &esutil.EsAliasActionDetails{
+				Index: es.indexManager.IndexName(es.sharedDocumentKind(documentKind), ""),
+				Alias: es.indexManager.AliasName(documentKind, projectId),
+			},
+		})
+	}
+
+	return es.client.UpdateAliases(ctx, actions)
+}
+
+// projectAliasActions adds a filtered alias for each of the project's document kinds, so the project can be used the same way as in the project layout.
+// The alias limits searches to the project's documents of that kind. In the shared layout, it also routes reads and writes to the shard that holds them.
+func (es *ElasticsearchStorage) projectAliasActions(projectId string) []*esutil.EsAliasAction {
+	var actions []*esutil.EsAliasAction
+	for _, documentKind := range []string{occurrencesDocumentKind, notesDocumentKind} {
+		actions = append(actions, &esutil.EsAliasAction{
+			Add: &esutil.EsAliasActionDetails{
+				Index:   es.indexManager.IndexName(es.sharedDocumentKind(documentKind), ""),
+				Alias:   es.indexManager.AliasName(documentKind, projectId),
+				Filter:  es.projectQuery(projectId, documentKind),
+				Routing: es.documentRouting(projectId),
+			},
+		})
+	}
+
+	return actions
+}
+
+func (es *ElasticsearchStorage) projectQuery(projectId, documentKind string) *filtering.Query {
+	projectTerm := &filtering.Query{
+		Term: &filtering.Term{
+			projectField: projectId,
+		},
+	}
+	if !es.joinedLayoutEnabled() {
+		return projectTerm
+	}
+
+	joinName := occurrenceJoinName
+	if documentKind == notesDocumentKind {
+		joinName = noteJoinName
+	}
+
+	return &filtering.Query{
+		Bool: &filtering.Bool{
+			Filter: &filtering.Filter{
+				projectTerm,
+				&filtering.Query{
+					Term: &filtering.Term{
+						joinField: joinName,
+					},
+				},
+			},
+		},
+	}
+}
+
+// sharedLayoutEnabled is true for both layouts that store every project's documents in the same indices
+func (es *ElasticsearchStorage) sharedLayoutEnabled() bool {
+	return es.config.Layout == config.IndexLayoutShared || es.joinedLayoutEnabled()
+}
+
+func (es *ElasticsearchStorage) joinedLayoutEnabled() bool {
+	return es.config.Layout == config.IndexLayoutJoined
+}
+
+// sharedDocumentKinds are the document kinds of the indices shared by every project
+func (es *ElasticsearchStorage) sharedDocumentKinds() []string {
+	if es.joinedLayoutEnabled() {
+		return []string{documentsDocumentKind}
+	}
+
+	return []string{occurrencesDocumentKind, notesDocumentKind}
+}
+
+// sharedDocumentKind is the document kind of the shared index that holds documents of the given kind
+func (es *ElasticsearchStorage) sharedDocumentKind(documentKind string) string {
+	if es.joinedLayoutEnabled() {
+		return documentsDocumentKind
+	}
+
+	return documentKind
+}
+
+// documentFields are the fields that are added to a project's notes and occurrences, alongside the fields of the message
+func (es *ElasticsearchStorage) documentFields(projectId string) map[string]interface{} {
+	if !es.sharedLayoutEnabled() {
+		return nil
+	}
+
+	return map[string]interface{}{
+		projectField: projectId,
+	}
+}
+
+// documentRouting is the routing for the project's documents in the shared layout. In the joined layout, occurrences are routed by their note instead.
+func (es *ElasticsearchStorage) documentRouting(projectId string) string {
+	if es.config.Layout != config.IndexLayoutShared {
+		return ""
+	}
+
+	return projectId
+}
+
+// noteJoin makes the note a parent in the joined layout. Notes are stored with their name as the document ID,
+// so that occurrences can refer to them by noteName.
+func (es *ElasticsearchStorage) noteJoin() *esutil.EsJoin {
+	if !es.joinedLayoutEnabled() {
+		return nil
+	}
+
+	return &esutil.EsJoin{
+		Field: joinField,
+		Name:  noteJoinName,
+	}
+}
+
+// occurrenceJoin makes the occurrence a child of its note in the joined layout, which also routes it to the note's shard
+func (es *ElasticsearchStorage) occurrenceJoin(occurrence *pb.Occurrence) *esutil.EsJoin {
+	if !es.joinedLayoutEnabled() {
+		return nil
+	}
+
+	return &esutil.EsJoin{
+		Field:  joinField,
+		Name:   occurrenceJoinName,
+		Parent: occurrence.NoteName,
+	}
+}
+
+// noteDocumentId is the ID of the note's document, which is generated by Elasticsearch unless notes are parents in the joined layout
+func (es *ElasticsearchStorage) noteDocumentId(note *pb.Note) string {
+	if !es.joinedLayoutEnabled() {
+		return ""
+	}
+
+	return note.Name
+}
+
+// filterParents allows notes and occurrences to be filtered by their metadata, e.g. _meta.createdBy == "alice".
+// Occurrences can also be filtered by the fields of their note: through the join in the joined layout,
+// e.g. note.vulnerability.severity == "CRITICAL", and through the copied fields when enrichment is enabled, e.g. _note.kind == "VULNERABILITY"
+func (es *ElasticsearchStorage) filterParents(documentKind string) []filtering.Parent {
+	if documentKind != occurrencesDocumentKind && documentKind != notesDocumentKind {
+		return nil
+	}
+
+	parents := []filtering.Parent{
+		{
+			Name:     metadataField,
+			Message:  metadataMessage,
+			Embedded: true,
+		},
+	}
+	if documentKind == notesDocumentKind {
+		return parents
+	}
+
+	if es.joinedLayoutEnabled() {
+		parents = append(parents, filtering.Parent{
+			Name:    noteJoinName,
+			Message: proto.MessageV2(&pb.Note{}),
+		})
+	}
+
+	if es.enrichmentEnabled() {
+		parents = append(parents, filtering.Parent{
+			Name:     enrichmentField,
+			Message:  proto.MessageV2(&pb.Note{}),
+			Embedded: true,
+		})
+	}
+
+	return parents
+}
@@ -0,0 +1,783 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+var _ = Describe("shared layout", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId        string
+		expectedOccurrencesAlias string
+		expectedNotesAlias       string
+		expectedOccurrencesIndex string
+		expectedNotesIndex       string
+		expectedProjectQuery     *filtering.Query
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		expectedProjectId = fake.LetterN(10)
+		expectedOccurrencesAlias = fmt.Sprintf("grafeas-%s-occurrences", expectedProjectId)
+		expectedNotesAlias = fmt.Sprintf("grafeas-%s-notes", expectedProjectId)
+		expectedOccurrencesIndex = "grafeas-v1--occurrences"
+		expectedNotesIndex = "grafeas-v1--notes"
+		expectedProjectQuery = &filtering.Query{
+			Term: &filtering.Term{
+				"project": expectedProjectId,
+			},
+		}
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+			Layout:  config.IndexLayoutShared,
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+		indexManager.IndexNameCalls(func(documentKind string, inner string) string {
+			return fmt.Sprintf("grafeas-v1-%s-%s", inner, documentKind)
+		})
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Initialize", func() {
+		var (
+			expectedProjects []*prpb.Project
+			actualErr        error
+		)
+
+		BeforeEach(func() {
+			expectedProjects = []*prpb.Project{
+				generateTestProject(expectedProjectId),
+				generateTestProject(fake.LetterN(10)),
+			}
+
+			var hits []*esutil.EsSearchResponseHit
+			for _, project := range expectedProjects {
+				projectJson, err := protojson.Marshal(proto.MessageV2(project))
+				Expect(err).ToNot(HaveOccurred())
+
+				hits = append(hits, &esutil.EsSearchResponseHit{Source: projectJson})
+			}
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: len(hits)},
+					Hits:  hits,
+				},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			actualErr = elasticsearchStorage.Initialize(ctx)
+		})
+
+		It("should create the shared indices", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(indexManager.CreateIndexCallCount()).To(Equal(3))
+
+			_, occurrencesIndex, occurrencesAlias, occurrencesKind := indexManager.CreateIndexArgsForCall(1)
+			Expect(occurrencesIndex).To(Equal(expectedOccurrencesIndex))
+			Expect(occurrencesAlias).To(Equal("grafeas-occurrences"))
+			Expect(occurrencesKind).To(Equal(occurrencesDocumentKind))
+
+			_, notesIndex, notesAlias, notesKind := indexManager.CreateIndexArgsForCall(2)
+			Expect(notesIndex).To(Equal(expectedNotesIndex))
+			Expect(notesAlias).To(Equal("grafeas-notes"))
+			Expect(notesKind).To(Equal(notesDocumentKind))
+		})
+
+		It("should add the aliases for every existing project", func() {
+			Expect(client.UpdateAliasesCallCount()).To(Equal(1))
+
+			_, actions := client.UpdateAliasesArgsForCall(0)
+			Expect(actions).To(HaveLen(len(expectedProjects) * 2))
+			Expect(actions).To(ContainElements(
+				&esutil.EsAliasAction{
+					Add: &esutil.EsAliasActionDetails{
+						Index:   expectedOccurrencesIndex,
+						Alias:   expectedOccurrencesAlias,
+						Filter:  expectedProjectQuery,
+						Routing: expectedProjectId,
+					},
+				},
+				&esutil.EsAliasAction{
+					Add: &esutil.EsAliasActionDetails{
+						Index:   expectedNotesIndex,
+						Alias:   expectedNotesAlias,
+						Filter:  expectedProjectQuery,
+						Routing: expectedProjectId,
+					},
+				},
+			))
+		})
+
+		When("there are no projects", func() {
+			BeforeEach(func() {
+				client.SearchReturns(&esutil.SearchResponse{
+					Hits: &esutil.EsSearchResponseHits{
+						Total: &esutil.EsSearchResponseTotal{},
+					},
+				}, nil)
+			})
+
+			It("should not update any aliases", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(client.UpdateAliasesCallCount()).To(Equal(0))
+			})
+		})
+
+		When("creating a shared index fails", func() {
+			BeforeEach(func() {
+				indexManager.CreateIndexReturnsOnCall(1, errors.New("create index failed"))
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(client.UpdateAliasesCallCount()).To(Equal(0))
+			})
+		})
+
+		When("updating the aliases fails", func() {
+			BeforeEach(func() {
+				client.UpdateAliasesReturns(errors.New("update aliases failed"))
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+
+		When("the project layout is used", func() {
+			BeforeEach(func() {
+				esConfig.Layout = config.IndexLayoutProject
+			})
+
+			It("should only create the projects index", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(indexManager.CreateIndexCallCount()).To(Equal(1))
+				Expect(client.UpdateAliasesCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("CreateProject", func() {
+		var actualErr error
+
+		BeforeEach(func() {
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{},
+				},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			_, actualErr = elasticsearchStorage.CreateProject(ctx, expectedProjectId, &prpb.Project{})
+		})
+
+		It("should add the project's aliases instead of creating indices", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(indexManager.CreateIndexCallCount()).To(Equal(0))
+			Expect(client.UpdateAliasesCallCount()).To(Equal(1))
+
+			_, actions := client.UpdateAliasesArgsForCall(0)
+			Expect(actions).To(HaveLen(2))
+			Expect(actions[0].Add.Alias).To(Equal(expectedOccurrencesAlias))
+			Expect(actions[1].Add.Alias).To(Equal(expectedNotesAlias))
+		})
+
+		When("adding the aliases fails", func() {
+			BeforeEach(func() {
+				client.UpdateAliasesReturns(errors.New("update aliases failed"))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			})
+		})
+	})
+
+	Context("DeleteProject", func() {
+		var actualErr error
+
+		BeforeEach(func() {
+			client.DeleteByQueryReturns(&esutil.EsDeleteResponse{}, nil)
+		})
+
+		JustBeforeEach(func() {
+			actualErr = elasticsearchStorage.DeleteProject(ctx, expectedProjectId)
+		})
+
+		It("should delete the project's documents from the shared indices", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(indexManager.DeleteIndexCallCount()).To(Equal(0))
+			Expect(client.DeleteByQueryCallCount()).To(Equal(2))
+
+			for i, alias := range []string{"grafeas-occurrences", "grafeas-notes"} {
+				_, deleteRequest := client.DeleteByQueryArgsForCall(i)
+				Expect(deleteRequest.Index).To(Equal(alias))
+				Expect(deleteRequest.Search.Query).To(Equal(expectedProjectQuery))
+				Expect(deleteRequest.Routing).To(Equal(expectedProjectId))
+			}
+		})
+
+		It("should remove the project's aliases", func() {
+			Expect(client.UpdateAliasesCallCount()).To(Equal(1))
+
+			_, actions := client.UpdateAliasesArgsForCall(0)
+			Expect(actions).To(ConsistOf(
+				&esutil.EsAliasAction{
+					Remove: &esutil.EsAliasActionDetails{
+						Index: expectedOccurrencesIndex,
+						Alias: expectedOccurrencesAlias,
+					},
+				},
+				&esutil.EsAliasAction{
+					Remove: &esutil.EsAliasActionDetails{
+						Index: expectedNotesIndex,
+						Alias: expectedNotesAlias,
+					},
+				},
+			))
+		})
+
+		When("deleting the documents fails", func() {
+			BeforeEach(func() {
+				client.DeleteByQueryReturns(nil, errors.New("delete by query failed"))
+			})
+
+			It("should return an error without removing the aliases", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(client.UpdateAliasesCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("writing documents", func() {
+		BeforeEach(func() {
+			projectJson, err := protojson.Marshal(proto.MessageV2(generateTestProject(expectedProjectId)))
+			Expect(err).ToNot(HaveOccurred())
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: 1},
+					Hits: []*esutil.EsSearchResponseHit{
+						{Source: projectJson},
+					},
+				},
+			}, nil)
+			client.BulkReturns(&esutil.EsBulkResponse{
+				Items: []*esutil.EsBulkResponseItem{
+					{Create: &esutil.EsIndexDocResponse{}},
+				},
+			}, nil)
+		})
+
+		It("should add the project to created occurrences", func() {
+			_, err := elasticsearchStorage.CreateOccurrence(ctx, expectedProjectId, "", generateTestOccurrence(""))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, createRequest := client.CreateArgsForCall(0)
+			Expect(createRequest.Index).To(Equal(expectedOccurrencesAlias))
+			Expect(createRequest.Fields).To(Equal(map[string]interface{}{"project": expectedProjectId}))
+		})
+
+		It("should add the project to batch created occurrences", func() {
+			_, errs := elasticsearchStorage.BatchCreateOccurrences(ctx, expectedProjectId, "", []*pb.Occurrence{generateTestOccurrence("")})
+			Expect(errs).To(BeEmpty())
+
+			_, bulkRequest := client.BulkArgsForCall(0)
+			Expect(bulkRequest.Items[0].Fields).To(Equal(map[string]interface{}{"project": expectedProjectId}))
+		})
+
+		It("should add the project to batch created notes", func() {
+			client.MultiSearchReturns(&esutil.EsMultiSearchResponse{
+				Responses: []*esutil.EsMultiSearchResponseHitsSummary{
+					{
+						Hits: &esutil.EsMultiSearchResponseHits{
+							Total: &esutil.EsSearchResponseTotal{},
+						},
+					},
+				},
+			}, nil)
+
+			_, errs := elasticsearchStorage.BatchCreateNotes(ctx, expectedProjectId, "", map[string]*pb.Note{
+				fake.LetterN(10): generateTestNote(""),
+			})
+			Expect(errs).To(BeEmpty())
+
+			_, bulkRequest := client.BulkArgsForCall(0)
+			Expect(bulkRequest.Index).To(Equal(expectedNotesAlias))
+			Expect(bulkRequest.Items[0].Fields).To(Equal(map[string]interface{}{"project": expectedProjectId}))
+		})
+
+		It("should route occurrence updates to the project's shard", func() {
+			occurrenceJson, err := protojson.Marshal(proto.MessageV2(generateTestOccurrence("")))
+			Expect(err).ToNot(HaveOccurred())
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: 1},
+					Hits: []*esutil.EsSearchResponseHit{
+						{
+							ID:     fake.LetterN(10),
+							Index:  expectedOccurrencesIndex,
+							Source: occurrenceJson,
+						},
+					},
+				},
+			}, nil)
+
+			_, err = elasticsearchStorage.UpdateOccurrence(ctx, expectedProjectId, fake.LetterN(10), generateTestOccurrence(""), &fieldmaskpb.FieldMask{})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, updateRequest := client.UpdateArgsForCall(0)
+			Expect(updateRequest.Index).To(Equal(expectedOccurrencesIndex))
+			Expect(updateRequest.Routing).To(Equal(expectedProjectId))
+			Expect(updateRequest.Fields).To(Equal(map[string]interface{}{"project": expectedProjectId}))
+		})
+	})
+
+	Context("reading documents", func() {
+		It("should ignore the project field", func() {
+			expectedOccurrence := generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10)))
+			occurrenceJson, err := protojson.Marshal(proto.MessageV2(expectedOccurrence))
+			Expect(err).ToNot(HaveOccurred())
+
+			source := map[string]interface{}{}
+			Expect(json.Unmarshal(occurrenceJson, &source)).To(Succeed())
+			source["project"] = expectedProjectId
+			sourceJson, err := json.Marshal(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: 1},
+					Hits: []*esutil.EsSearchResponseHit{
+						{Source: sourceJson},
+					},
+				},
+			}, nil)
+
+			actualOccurrence, err := elasticsearchStorage.GetOccurrence(ctx, expectedProjectId, fake.LetterN(10))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(proto.Equal(actualOccurrence, expectedOccurrence)).To(BeTrue())
+
+			_, searchRequest := client.SearchArgsForCall(0)
+			Expect(searchRequest.Index).To(Equal(expectedOccurrencesAlias))
+		})
+	})
+})
+
+var _ = Describe("joined layout", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId        string
+		expectedOccurrencesAlias string
+		expectedNotesAlias       string
+		expectedDocumentsIndex   string
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		expectedProjectId = fake.LetterN(10)
+		expectedOccurrencesAlias = fmt.Sprintf("grafeas-%s-occurrences", expectedProjectId)
+		expectedNotesAlias = fmt.Sprintf("grafeas-%s-notes", expectedProjectId)
+		expectedDocumentsIndex = "grafeas-v1--documents"
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+			Layout:  config.IndexLayoutJoined,
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+		indexManager.IndexNameCalls(func(documentKind string, inner string) string {
+			return fmt.Sprintf("grafeas-v1-%s-%s", inner, documentKind)
+		})
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Initialize", func() {
+		var actualErr error
+
+		BeforeEach(func() {
+			projectJson, err := protojson.Marshal(proto.MessageV2(generateTestProject(expectedProjectId)))
+			Expect(err).ToNot(HaveOccurred())
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: 1},
+					Hits: []*esutil.EsSearchResponseHit{
+						{Source: projectJson},
+					},
+				},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			actualErr = elasticsearchStorage.Initialize(ctx)
+		})
+
+		It("should create a single index for notes and occurrences", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(indexManager.CreateIndexCallCount()).To(Equal(2))
+
+			_, index, alias, documentKind := indexManager.CreateIndexArgsForCall(1)
+			Expect(index).To(Equal(expectedDocumentsIndex))
+			Expect(alias).To(Equal("grafeas-documents"))
+			Expect(documentKind).To(Equal(documentsDocumentKind))
+		})
+
+		It("should filter each project's aliases by project and relation", func() {
+			Expect(client.UpdateAliasesCallCount()).To(Equal(1))
+
+			_, actions := client.UpdateAliasesArgsForCall(0)
+			Expect(actions).To(ConsistOf(
+				&esutil.EsAliasAction{
+					Add: &esutil.EsAliasActionDetails{
+						Index:  expectedDocumentsIndex,
+						Alias:  expectedOccurrencesAlias,
+						Filter: expectedJoinedProjectQuery(expectedProjectId, "occurrence"),
+					},
+				},
+				&esutil.EsAliasAction{
+					Add: &esutil.EsAliasActionDetails{
+						Index:  expectedDocumentsIndex,
+						Alias:  expectedNotesAlias,
+						Filter: expectedJoinedProjectQuery(expectedProjectId, "note"),
+					},
+				},
+			))
+		})
+	})
+
+	Context("DeleteProject", func() {
+		var actualErr error
+
+		BeforeEach(func() {
+			client.DeleteByQueryReturns(&esutil.EsDeleteResponse{}, nil)
+		})
+
+		JustBeforeEach(func() {
+			actualErr = elasticsearchStorage.DeleteProject(ctx, expectedProjectId)
+		})
+
+		It("should delete the project's notes and occurrences together", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(client.DeleteByQueryCallCount()).To(Equal(1))
+
+			_, deleteRequest := client.DeleteByQueryArgsForCall(0)
+			Expect(deleteRequest.Index).To(Equal("grafeas-documents"))
+			Expect(deleteRequest.Search.Query).To(Equal(&filtering.Query{
+				Term: &filtering.Term{
+					"project": expectedProjectId,
+				},
+			}))
+			Expect(deleteRequest.Routing).To(BeEmpty())
+		})
+
+		It("should remove the project's aliases", func() {
+			_, actions := client.UpdateAliasesArgsForCall(0)
+			Expect(actions).To(HaveLen(2))
+			for _, action := range actions {
+				Expect(action.Remove.Index).To(Equal(expectedDocumentsIndex))
+			}
+		})
+	})
+
+	Context("writing documents", func() {
+		var expectedOccurrence *pb.Occurrence
+
+		BeforeEach(func() {
+			expectedOccurrence = generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.LetterN(10)))
+			projectJson, err := protojson.Marshal(proto.MessageV2(generateTestProject(expectedProjectId)))
+			Expect(err).ToNot(HaveOccurred())
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: 1},
+					Hits: []*esutil.EsSearchResponseHit{
+						{Source: projectJson},
+					},
+				},
+			}, nil)
+			client.BulkReturns(&esutil.EsBulkResponse{
+				Items: []*esutil.EsBulkResponseItem{
+					{Create: &esutil.EsIndexDocResponse{}},
+				},
+			}, nil)
+		})
+
+		It("should create occurrences as children of their note", func() {
+			_, err := elasticsearchStorage.CreateOccurrence(ctx, expectedProjectId, "", expectedOccurrence)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, createRequest := client.CreateArgsForCall(0)
+			Expect(createRequest.Index).To(Equal(expectedOccurrencesAlias))
+			Expect(createRequest.Join).To(Equal(&esutil.EsJoin{
+				Field:  "join",
+				Name:   "occurrence",
+				Parent: expectedOccurrence.NoteName,
+			}))
+			Expect(createRequest.Fields).To(Equal(map[string]interface{}{"project": expectedProjectId}))
+		})
+
+		It("should batch create occurrences as children of their note", func() {
+			_, errs := elasticsearchStorage.BatchCreateOccurrences(ctx, expectedProjectId, "", []*pb.Occurrence{expectedOccurrence})
+			Expect(errs).To(BeEmpty())
+
+			_, bulkRequest := client.BulkArgsForCall(0)
+			Expect(bulkRequest.Items[0].Join.Parent).To(Equal(expectedOccurrence.NoteName))
+			Expect(bulkRequest.Items[0].Routing).To(BeEmpty())
+		})
+
+		It("should create notes as parents, using their name as the document ID", func() {
+			client.SearchReturnsOnCall(1, &esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{},
+				},
+			}, nil)
+			noteId := fake.LetterN(10)
+
+			note, err := elasticsearchStorage.CreateNote(ctx, expectedProjectId, noteId, "", generateTestNote(""))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, createRequest := client.CreateArgsForCall(0)
+			Expect(createRequest.Index).To(Equal(expectedNotesAlias))
+			Expect(createRequest.DocumentId).To(Equal(note.Name))
+			Expect(createRequest.Join).To(Equal(&esutil.EsJoin{
+				Field: "join",
+				Name:  "note",
+			}))
+		})
+
+		It("should batch create notes as parents", func() {
+			client.MultiSearchReturns(&esutil.EsMultiSearchResponse{
+				Responses: []*esutil.EsMultiSearchResponseHitsSummary{
+					{
+						Hits: &esutil.EsMultiSearchResponseHits{
+							Total: &esutil.EsSearchResponseTotal{},
+						},
+					},
+				},
+			}, nil)
+
+			notes, errs := elasticsearchStorage.BatchCreateNotes(ctx, expectedProjectId, "", map[string]*pb.Note{
+				fake.LetterN(10): generateTestNote(""),
+			})
+			Expect(errs).To(BeEmpty())
+
+			_, bulkRequest := client.BulkArgsForCall(0)
+			Expect(bulkRequest.Items[0].DocumentId).To(Equal(notes[0].Name))
+			Expect(bulkRequest.Items[0].Join.Name).To(Equal("note"))
+		})
+
+		Describe("UpdateOccurrence", func() {
+			var (
+				update *pb.Occurrence
+				mask   *fieldmaskpb.FieldMask
+			)
+
+			BeforeEach(func() {
+				occurrenceJson, err := protojson.Marshal(proto.MessageV2(expectedOccurrence))
+				Expect(err).ToNot(HaveOccurred())
+
+				client.SearchReturns(&esutil.SearchResponse{
+					Hits: &esutil.EsSearchResponseHits{
+						Total: &esutil.EsSearchResponseTotal{Value: 1},
+						Hits: []*esutil.EsSearchResponseHit{
+							{
+								ID:     fake.LetterN(10),
+								Index:  expectedDocumentsIndex,
+								Source: occurrenceJson,
+							},
+						},
+					},
+				}, nil)
+
+				update = generateTestOccurrence("")
+				mask = &fieldmaskpb.FieldMask{Paths: []string{"Remediation"}}
+			})
+
+			It("should keep the occurrence a child of its note", func() {
+				_, err := elasticsearchStorage.UpdateOccurrence(ctx, expectedProjectId, fake.LetterN(10), update, mask)
+				Expect(err).ToNot(HaveOccurred())
+
+				_, updateRequest := client.UpdateArgsForCall(0)
+				Expect(updateRequest.Join.Parent).To(Equal(expectedOccurrence.NoteName))
+				Expect(updateRequest.Routing).To(BeEmpty())
+			})
+
+			It("should not allow the note to be changed", func() {
+				mask.Paths = append(mask.Paths, "NoteName")
+
+				_, err := elasticsearchStorage.UpdateOccurrence(ctx, expectedProjectId, fake.LetterN(10), update, mask)
+
+				assertErrorHasGrpcStatusCode(err, codes.InvalidArgument)
+				Expect(client.UpdateCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("filtering occurrences", func() {
+		var (
+			expectedFilter string
+			expectedQuery  *filtering.Query
+		)
+
+		BeforeEach(func() {
+			expectedFilter = `note.vulnerability.severity == "CRITICAL"`
+			expectedQuery = &filtering.Query{
+				HasParent: &filtering.HasParent{
+					ParentType: "note",
+				},
+			}
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{},
+				},
+			}, nil)
+		})
+
+		It("should allow filtering by the fields of the note", func() {
+			var actualParents []filtering.Parent
+			filterer.EXPECT().ParseExpression(expectedFilter, gomock.Any()).
+				DoAndReturn(func(_ string, parents ...filtering.Parent) (*filtering.Query, error) {
+					actualParents = parents
+					return expectedQuery, nil
+				})
+
+			_, _, err := elasticsearchStorage.ListOccurrences(ctx, expectedProjectId, expectedFilter, "", 0)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(actualParents).To(HaveLen(2))
+			Expect(actualParents[1].Name).To(Equal("note"))
+			Expect(actualParents[1].Message.ProtoReflect().Descriptor().FullName()).To(BeEquivalentTo("grafeas.v1beta1.Note"))
+
+			_, searchRequest := client.SearchArgsForCall(0)
+			Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
+		})
+
+		It("should not allow filtering notes by a parent", func() {
+			var actualParents []filtering.Parent
+			filterer.EXPECT().ParseExpression(expectedFilter, gomock.Any()).
+				DoAndReturn(func(_ string, parents ...filtering.Parent) (*filtering.Query, error) {
+					actualParents = parents
+					return expectedQuery, nil
+				})
+
+			_, _, err := elasticsearchStorage.ListNotes(ctx, expectedProjectId, expectedFilter, "", 0)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, parent := range actualParents {
+				Expect(parent.Embedded).To(BeTrue())
+			}
+		})
+	})
+})
+
+func expectedJoinedProjectQuery(projectId, joinName string) *filtering.Query {
+	return &filtering.Query{
+		Bool: &filtering.Bool{
+			Filter: &filtering.Filter{
+				&filtering.Query{
+					Term: &filtering.Term{
+						"project": projectId,
+					},
+				},
+				&filtering.Query{
+					Term: &filtering.Term{
+						"join": joinName,
+					},
+				},
+			},
+		},
+	}
+}
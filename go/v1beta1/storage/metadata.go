@@ -0,0 +1,125 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// metadataField holds the DocumentMetadata of notes and occurrences, alongside the fields of the message.
+// It's left out when decoding documents, since it isn't part of the Grafeas protos.
+const metadataField = "_meta"
+
+// DocumentMetadata is stored with each note and occurrence, and can be filtered on with the _meta prefix, e.g. _meta.createdBy == "alice"
+type DocumentMetadata struct {
+	// CreatedBy is the user that created the resource
+	CreatedBy string `json:"createdBy,omitempty"`
+	// UpdatedBy is the user that last updated the resource, including occurrences that were replaced by dedupe in upsert mode
+	UpdatedBy string `json:"updatedBy,omitempty"`
+}
+
+// metadataMessage describes DocumentMetadata to the filter type checker, which only understands protobuf messages
+var metadataMessage = newMetadataMessage()
+
+// actingUserID is the user passed to a method, or the user set on the request context with ContextWithUserID
+func (es *ElasticsearchStorage) actingUserID(ctx context.Context, userID string) string {
+	if userID != "" {
+		return userID
+	}
+
+	return es.userIDFromContext(ctx)
+}
+
+// withMetadata adds the metadata to the additional fields of a document, unless it's empty
+func withMetadata(fields map[string]interface{}, metadata *DocumentMetadata) map[string]interface{} {
+	if metadata == nil || *metadata == (DocumentMetadata{}) {
+		return fields
+	}
+
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+	fields[metadataField] = metadata
+
+	return fields
+}
+
+// decodeMetadata returns the metadata stored in a document, which is empty for documents written without a user
+func decodeMetadata(source []byte) (*DocumentMetadata, error) {
+	document := struct {
+		Metadata *DocumentMetadata `json:"_meta"`
+	}{}
+	if err := json.Unmarshal(source, &document); err != nil {
+		return nil, err
+	}
+
+	if document.Metadata == nil {
+		return &DocumentMetadata{}, nil
+	}
+
+	return document.Metadata, nil
+}
+
+// updatedMetadata keeps the user that created a resource, and marks it as updated by the user on the request context
+func (es *ElasticsearchStorage) updatedMetadata(ctx context.Context, source []byte) (*DocumentMetadata, error) {
+	metadata, err := decodeMetadata(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DocumentMetadata{
+		CreatedBy: metadata.CreatedBy,
+		UpdatedBy: es.userIDFromContext(ctx),
+	}, nil
+}
+
+func newMetadataMessage() protoreflect.ProtoMessage {
+	stringField := func(name, jsonName string, number int32) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(jsonName),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}
+	}
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("grafeas_elasticsearch/metadata.proto"),
+		Package: proto.String("grafeas_elasticsearch"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("DocumentMetadata"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					stringField("created_by", "createdBy", 1),
+					stringField("updated_by", "updatedBy", 2),
+				},
+			},
+		},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	return dynamicpb.NewMessage(file.Messages().Get(0))
+}
@@ -0,0 +1,270 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+var _ = Describe("metadata", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId    string
+		expectedOccurrenceId string
+		expectedNoteId       string
+		expectedUserId       string
+		expectedCreatorId    string
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	BeforeEach(func() {
+		expectedUserId = fake.LetterN(10)
+		expectedCreatorId = fake.LetterN(10)
+		ctx = ContextWithUserID(context.Background(), expectedUserId)
+		expectedProjectId = fake.LetterN(10)
+		expectedOccurrenceId = fake.LetterN(10)
+		expectedNoteId = fake.LetterN(10)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+		indexManager.IndexNameCalls(func(documentKind string, inner string) string {
+			return fmt.Sprintf("grafeas-v1-%s-%s", inner, documentKind)
+		})
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	// documentResponse returns a search hit for the message, with the metadata stored alongside it
+	documentResponse := func(message proto.Message, metadata *DocumentMetadata) *esutil.SearchResponse {
+		source, err := esutil.EncodeDocument(proto.MessageV2(message), nil, withMetadata(nil, metadata))
+		Expect(err).ToNot(HaveOccurred())
+
+		return &esutil.SearchResponse{
+			Hits: &esutil.EsSearchResponseHits{
+				Total: &esutil.EsSearchResponseTotal{Value: 1},
+				Hits: []*esutil.EsSearchResponseHit{
+					{
+						ID:     fake.LetterN(10),
+						Index:  fake.LetterN(10),
+						Source: source,
+					},
+				},
+			},
+		}
+	}
+
+	Context("CreateOccurrence", func() {
+		BeforeEach(func() {
+			client.SearchReturns(documentResponse(generateTestProject(expectedProjectId), nil), nil)
+		})
+
+		It("should store the user that created the occurrence", func() {
+			_, err := elasticsearchStorage.CreateOccurrence(ctx, expectedProjectId, expectedCreatorId, generateTestOccurrence(""))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, createRequest := client.CreateArgsForCall(0)
+			Expect(createRequest.Fields).To(HaveKeyWithValue(metadataField, &DocumentMetadata{CreatedBy: expectedCreatorId}))
+		})
+
+		It("should fall back to the user on the request context", func() {
+			_, err := elasticsearchStorage.CreateOccurrence(ctx, expectedProjectId, "", generateTestOccurrence(""))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, createRequest := client.CreateArgsForCall(0)
+			Expect(createRequest.Fields).To(HaveKeyWithValue(metadataField, &DocumentMetadata{CreatedBy: expectedUserId}))
+		})
+
+		It("should not store metadata without a user", func() {
+			_, err := elasticsearchStorage.CreateOccurrence(context.Background(), expectedProjectId, "", generateTestOccurrence(""))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, createRequest := client.CreateArgsForCall(0)
+			Expect(createRequest.Fields).ToNot(HaveKey(metadataField))
+		})
+
+		When("an existing occurrence is replaced by dedupe", func() {
+			BeforeEach(func() {
+				esConfig.Dedupe = config.DedupeConfig{
+					Fields: []string{"resource.uri"},
+					Mode:   config.DedupeModeUpsert,
+				}
+
+				existing, err := esutil.EncodeDocument(proto.MessageV2(generateTestOccurrence("")), nil, withMetadata(nil, &DocumentMetadata{CreatedBy: expectedCreatorId}))
+				Expect(err).ToNot(HaveOccurred())
+
+				client.MultiGetReturns(&esutil.EsMultiGetResponse{
+					Docs: []*esutil.EsGetResponse{
+						{Found: true, Source: existing},
+					},
+				}, nil)
+			})
+
+			It("should keep the user that created it", func() {
+				_, err := elasticsearchStorage.CreateOccurrence(ctx, expectedProjectId, "", generateTestOccurrence(""))
+				Expect(err).ToNot(HaveOccurred())
+
+				_, createRequest := client.CreateArgsForCall(0)
+				Expect(createRequest.Fields).To(HaveKeyWithValue(metadataField, &DocumentMetadata{
+					CreatedBy: expectedCreatorId,
+					UpdatedBy: expectedUserId,
+				}))
+			})
+		})
+	})
+
+	Context("UpdateOccurrence", func() {
+		It("should keep the user that created the occurrence and store the user that updated it", func() {
+			occurrenceName := fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, expectedOccurrenceId)
+			client.SearchReturns(documentResponse(generateTestOccurrence(occurrenceName), &DocumentMetadata{
+				CreatedBy: expectedCreatorId,
+				UpdatedBy: fake.LetterN(10),
+			}), nil)
+
+			_, err := elasticsearchStorage.UpdateOccurrence(ctx, expectedProjectId, expectedOccurrenceId, &pb.Occurrence{
+				Remediation: fake.LetterN(10),
+			}, &fieldmaskpb.FieldMask{Paths: []string{"remediation"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, updateRequest := client.UpdateArgsForCall(0)
+			Expect(updateRequest.Fields).To(HaveKeyWithValue(metadataField, &DocumentMetadata{
+				CreatedBy: expectedCreatorId,
+				UpdatedBy: expectedUserId,
+			}))
+		})
+	})
+
+	Context("CreateNote", func() {
+		It("should store the user that created the note", func() {
+			client.SearchReturnsOnCall(0, documentResponse(generateTestProject(expectedProjectId), nil), nil)
+			client.SearchReturnsOnCall(1, &esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{},
+				},
+			}, nil)
+
+			_, err := elasticsearchStorage.CreateNote(ctx, expectedProjectId, expectedNoteId, expectedCreatorId, generateTestNote(""))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, createRequest := client.CreateArgsForCall(0)
+			Expect(createRequest.Fields).To(HaveKeyWithValue(metadataField, &DocumentMetadata{CreatedBy: expectedCreatorId}))
+		})
+	})
+
+	Context("UpdateNote", func() {
+		It("should keep the user that created the note and store the user that updated it", func() {
+			noteName := fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, expectedNoteId)
+			client.SearchReturns(documentResponse(generateTestNote(noteName), &DocumentMetadata{CreatedBy: expectedCreatorId}), nil)
+
+			_, err := elasticsearchStorage.UpdateNote(ctx, expectedProjectId, expectedNoteId, &pb.Note{
+				ShortDescription: fake.LetterN(10),
+			}, &fieldmaskpb.FieldMask{Paths: []string{"shortDescription"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, updateRequest := client.UpdateArgsForCall(0)
+			Expect(updateRequest.Fields).To(HaveKeyWithValue(metadataField, &DocumentMetadata{
+				CreatedBy: expectedCreatorId,
+				UpdatedBy: expectedUserId,
+			}))
+		})
+	})
+
+	Context("GetOccurrence", func() {
+		It("should leave out the metadata", func() {
+			occurrenceName := fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, expectedOccurrenceId)
+			expectedOccurrence := generateTestOccurrence(occurrenceName)
+			client.SearchReturns(documentResponse(expectedOccurrence, &DocumentMetadata{CreatedBy: expectedCreatorId}), nil)
+
+			actualOccurrence, err := elasticsearchStorage.GetOccurrence(ctx, expectedProjectId, expectedOccurrenceId)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(proto.Equal(actualOccurrence, expectedOccurrence)).To(BeTrue())
+		})
+	})
+
+	Context("filtering", func() {
+		It("should allow type checked filters on the metadata", func() {
+			for _, documentKind := range []string{occurrencesDocumentKind, notesDocumentKind} {
+				query, err := filtering.NewFilterer().ParseCheckedExpression(
+					fmt.Sprintf(`_meta.createdBy == "%s"`, expectedCreatorId),
+					proto.MessageV2(&pb.Occurrence{}),
+					elasticsearchStorage.filterParents(documentKind)...,
+				)
+				Expect(err).ToNot(HaveOccurred())
+
+				queryJson, err := json.Marshal(query)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(queryJson)).To(ContainSubstring(`"_meta.createdBy":"%s"`, expectedCreatorId))
+			}
+		})
+
+		It("should reject filters on unknown metadata", func() {
+			_, err := filtering.NewFilterer().ParseCheckedExpression(
+				`_meta.deletedBy == "alice"`,
+				proto.MessageV2(&pb.Occurrence{}),
+				elasticsearchStorage.filterParents(occurrencesDocumentKind)...,
+			)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("decodeMetadata", func() {
+		It("should be empty for documents without metadata", func() {
+			metadata, err := decodeMetadata([]byte(`{"name":"projects/rode/notes/foo"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metadata).To(Equal(&DocumentMetadata{}))
+		})
+	})
+})
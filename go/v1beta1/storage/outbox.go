@@ -0,0 +1,559 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/events"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"go.uber.org/zap"
+)
+
+// outboxMetrics are published with expvar under "outbox"
+var outboxMetrics = expvar.NewMap("outbox")
+
+const (
+	outboxDocumentKind = "outbox"
+
+	// outboxDeliveredMetric counts the events delivered from the outbox
+	outboxDeliveredMetric = "delivered"
+	// outboxFailedMetric counts the delivery attempts that failed
+	outboxFailedMetric = "failed"
+	// outboxStalledMetric is the number of events waiting to be retried after a failed delivery, as of the last time the outbox was dispatched
+	outboxStalledMetric = "stalled"
+
+	outboxPageSize = 100
+	// outboxMaxBackoff limits the time between attempts to deliver an event
+	outboxMaxBackoff = time.Hour
+	// outboxPurgeInterval is how often delivered events are removed from the outbox
+	outboxPurgeInterval = time.Hour
+	// outboxRetention is how long delivered events are kept, as Elasticsearch date math
+	outboxRetention = "now-1d"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxStatusPending OutboxStatus = "PENDING"
+	OutboxStatusDone    OutboxStatus = "DONE"
+)
+
+// OutboxEntry is an event in the outbox, along with the state of its delivery
+type OutboxEntry struct {
+	ID     string        `json:"-"`
+	Event  *events.Event `json:"event"`
+	Status OutboxStatus  `json:"status"`
+	// Attempts is the number of times that delivery was attempted
+	Attempts        int        `json:"attempts"`
+	CreateTime      time.Time  `json:"createTime"`
+	NextAttemptTime time.Time  `json:"nextAttemptTime"`
+	DoneTime        *time.Time `json:"doneTime,omitempty"`
+	LastError       string     `json:"lastError,omitempty"`
+}
+
+// change describes the event for a change to a single resource, so that it can be written to the outbox along with the change
+type change struct {
+	eventType    events.Type
+	resourceKind string
+	resource     string
+	userID       string
+	previous     proto.Message
+	current      proto.Message
+	// revision is kept along with the previous state when history is enabled, and is nil otherwise
+	revision *Revision
+	// auditOperation is the operation recorded for the change when audit is enabled
+	auditOperation AuditOperation
+	// auditRecord is set once the change has been written along with its audit record
+	auditRecord *AuditRecord
+}
+
+// audited reports whether the outcome of the change was recorded when it was written. A successful record only covers a request
+// that succeeded, since a later step may still fail, while the record of a failed change has already been replaced with its failure.
+func (c *change) audited(err error) bool {
+	return c != nil && c.auditRecord != nil && (err == nil || c.auditRecord.Outcome == AuditOutcomeFailure)
+}
+
+func (es *ElasticsearchStorage) outboxEnabled() bool {
+	return es.eventsEnabled() && es.config.Events.Outbox.Enabled
+}
+
+// writesWithChange reports whether other documents are written in the same bulk request as the change
+func (es *ElasticsearchStorage) writesWithChange(c *change) bool {
+	return es.outboxEnabled() || es.auditEnabled() || c.revision != nil
+}
+
+// createDocument creates a single document. When the outbox or audit is enabled, the document is written with the given bulk operation
+// in the same bulk request that creates the outbox entry and the audit record for the change.
+func (es *ElasticsearchStorage) createDocument(ctx context.Context, log *zap.Logger, request *esutil.CreateRequest, operation esutil.EsBulkOperation, c *change) error {
+	if !es.writesWithChange(c) {
+		_, err := es.client.Create(ctx, request)
+		return err
+	}
+
+	// a document without an ID is given one by Elasticsearch, which the create operation doesn't allow
+	if request.DocumentId == "" {
+		operation = esutil.BULK_INDEX
+	}
+
+	return es.writeChange(ctx, log, &esutil.BulkRequest{
+		Index:   request.Index,
+		Refresh: request.Refresh,
+		Items: []*esutil.BulkRequestItem{
+			{
+				Operation:  operation,
+				DocumentId: request.DocumentId,
+				Message:    request.Message,
+				Join:       request.Join,
+				Fields:     request.Fields,
+			},
+		},
+	}, c)
+}
+
+// updateDocument replaces a single document. When the outbox, history or audit is enabled, the document is replaced in the same bulk request
+// that creates the outbox entry, the revision and the audit record for the change.
+func (es *ElasticsearchStorage) updateDocument(ctx context.Context, log *zap.Logger, request *esutil.UpdateRequest, c *change) error {
+	if !es.writesWithChange(c) {
+		_, err := es.client.Update(ctx, request)
+		return err
+	}
+
+	return es.writeChange(ctx, log, &esutil.BulkRequest{
+		Index:   request.Index,
+		Refresh: request.Refresh,
+		Items: []*esutil.BulkRequestItem{
+			{
+				Operation:  esutil.BULK_INDEX,
+				DocumentId: request.DocumentId,
+				Message:    request.Message,
+				Routing:    request.Routing,
+				Join:       request.Join,
+				Fields:     request.Fields,
+			},
+		},
+	}, c)
+}
+
+// deleteDocument deletes the documents matching the request's search. When the outbox, history or audit is enabled, the target document,
+// which is the one that was found by the search, is deleted in the same bulk request that creates the outbox entry, the revision
+// and the audit record for the change.
+func (es *ElasticsearchStorage) deleteDocument(ctx context.Context, log *zap.Logger, request *esutil.DeleteRequest, target *esutil.EsSearchResponseHit, c *change) error {
+	if !es.writesWithChange(c) {
+		return es.client.Delete(ctx, request)
+	}
+
+	return es.writeChange(ctx, log, &esutil.BulkRequest{
+		Index:   target.Index,
+		Refresh: request.Refresh,
+		Items: []*esutil.BulkRequestItem{
+			{
+				Operation:  esutil.BULK_DELETE,
+				DocumentId: target.ID,
+				Routing:    target.Routing,
+			},
+		},
+	}, c)
+}
+
+// writeChange makes the single change in the bulk request, along with the revision of the previous state, the audit record of the change
+// and the outbox entry for its event
+func (es *ElasticsearchStorage) writeChange(ctx context.Context, log *zap.Logger, request *esutil.BulkRequest, c *change) error {
+	var revisionItem *esutil.BulkRequestItem
+	if c.revision != nil {
+		revisionItem = es.revisionItem(c.previous, c.revision)
+		request.Items = append(request.Items, revisionItem)
+	}
+
+	var auditRecords []*AuditRecord
+	request.Items, auditRecords = es.withAudit(ctx, log, request.Items, []*change{c})
+
+	items, entries, err := es.withOutbox(ctx, request.Items, []*change{c})
+	if err != nil {
+		return err
+	}
+	request.Items = items
+
+	response, err := es.client.Bulk(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	err = bulkItemError(response.Items[0])
+	responses := response.Items[1:]
+	if revisionItem != nil {
+		es.settleRevision(ctx, log, revisionItem, err == nil, responses[0])
+		responses = responses[1:]
+	}
+	responses = es.settleAudit(ctx, log, auditRecords, []error{err}, responses)
+	if auditRecords != nil {
+		c.auditRecord = auditRecords[0]
+	}
+	es.settleOutbox(ctx, log, entries, []bool{err == nil}, responses)
+
+	return err
+}
+
+// withOutbox adds an outbox entry for each change to the end of the items of a bulk request,
+// so that the entries are written in the same request as the changes. Nothing is added when the outbox is disabled.
+// The entries are written to the outbox index, regardless of the request's index.
+func (es *ElasticsearchStorage) withOutbox(ctx context.Context, items []*esutil.BulkRequestItem, changes []*change) ([]*esutil.BulkRequestItem, []*OutboxEntry, error) {
+	if !es.outboxEnabled() {
+		return items, nil, nil
+	}
+
+	now := time.Now().UTC()
+	var entries []*OutboxEntry
+	for _, c := range changes {
+		event, err := es.newEvent(ctx, c.eventType, c.resourceKind, c.resource, c.userID, c.previous, c.current)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		entry := &OutboxEntry{
+			ID:              uuid.New().String(),
+			Event:           event,
+			Status:          OutboxStatusPending,
+			CreateTime:      now,
+			NextAttemptTime: now,
+		}
+
+		item, err := es.outboxItem(entry, esutil.BULK_CREATE)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		items = append(items, item)
+		entries = append(entries, entry)
+	}
+
+	return items, entries, nil
+}
+
+// settleOutbox handles the outbox entries written in a bulk request, whose changes may have failed independently of them.
+// The entries of failed changes are removed, so that their events aren't delivered, and the events of successful changes whose entries
+// couldn't be written are queued to be published directly instead.
+func (es *ElasticsearchStorage) settleOutbox(ctx context.Context, log *zap.Logger, entries []*OutboxEntry, succeeded []bool, responses []*esutil.EsBulkResponseItem) {
+	var discarded []*esutil.BulkRequestItem
+	for i, entry := range entries {
+		entryErr := bulkItemError(responses[i])
+		if succeeded[i] && entryErr != nil {
+			log.Error("error writing event to the outbox, publishing it directly", zap.Error(entryErr), zap.String("resource", entry.Event.Resource))
+			es.queueEvent(log, entry.Event)
+		} else if !succeeded[i] && entryErr == nil {
+			discarded = append(discarded, &esutil.BulkRequestItem{
+				Operation:  esutil.BULK_DELETE,
+				DocumentId: entry.ID,
+			})
+		}
+	}
+
+	if len(discarded) == 0 {
+		return
+	}
+
+	if err := es.writeOutbox(ctx, discarded); err != nil {
+		log.Error("error removing outbox entries for failed changes, their events may be delivered", zap.Error(err))
+	}
+}
+
+// RunOutbox delivers the events in the outbox every interval until the context is cancelled.
+// Delivered events are removed from the outbox after a day.
+func (es *ElasticsearchStorage) RunOutbox(ctx context.Context, interval time.Duration) {
+	log := es.logger.Named("RunOutbox")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	purgeTicker := time.NewTicker(outboxPurgeInterval)
+	defer purgeTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug("stopping outbox")
+			return
+		case <-ticker.C:
+			if _, err := es.DispatchOutbox(ctx); err != nil {
+				log.Error("error dispatching outbox", zap.Error(err))
+			}
+		case <-purgeTicker.C:
+			if err := es.purgeOutbox(ctx); err != nil {
+				log.Error("error purging outbox", zap.Error(err))
+			}
+		}
+	}
+}
+
+// DispatchOutbox publishes the events in the outbox that are due, oldest first, and marks each one done once it's delivered.
+// Failed deliveries are retried with an exponential backoff. An event may be delivered more than once if it can't be marked done,
+// or if more than one instance dispatches the outbox at the same time. It returns the number of events delivered.
+func (es *ElasticsearchStorage) DispatchOutbox(ctx context.Context) (int, error) {
+	if !es.outboxEnabled() {
+		return 0, nil
+	}
+
+	log := es.logger.Named("DispatchOutbox")
+	search := &esutil.EsSearch{
+		Query: &filtering.Query{
+			Bool: &filtering.Bool{
+				Filter: &filtering.Filter{
+					&filtering.Query{
+						Term: &filtering.Term{
+							"status": OutboxStatusPending,
+						},
+					},
+					&filtering.Query{
+						Range: &filtering.Range{
+							"nextAttemptTime": {
+								LessEquals: "now",
+							},
+						},
+					},
+				},
+			},
+		},
+		Sort: []esutil.EsSortField{
+			{Field: "createTime", Order: esutil.EsSortOrderAscending},
+		},
+	}
+
+	var (
+		delivered int
+		pageToken string
+		pitId     string
+	)
+	// the first search opens a PIT to page through, which is closed once the pending entries are dispatched rather than left to expire
+	defer func() {
+		if pitId == "" {
+			return
+		}
+
+		if err := es.client.ClosePointInTime(ctx, pitId); err != nil {
+			log.Warn("error closing point in time", zap.Error(err))
+		}
+	}()
+
+	for {
+		res, err := es.client.Search(ctx, &esutil.SearchRequest{
+			Index:  es.outboxAlias(),
+			Search: search,
+			Pagination: &esutil.SearchPaginationOptions{
+				Size:  outboxPageSize,
+				Token: pageToken,
+			},
+		})
+		if err != nil {
+			return delivered, err
+		}
+		if res.PitId != "" {
+			pitId = res.PitId
+		}
+
+		var items []*esutil.BulkRequestItem
+		for _, hit := range res.Hits.Hits {
+			entry := &OutboxEntry{}
+			if err := json.Unmarshal(hit.Source, entry); err != nil {
+				return delivered, err
+			}
+			entry.ID = hit.ID
+
+			if es.deliver(ctx, log, entry) {
+				delivered++
+			}
+
+			item, err := es.outboxItem(entry, esutil.BULK_INDEX)
+			if err != nil {
+				return delivered, err
+			}
+			items = append(items, item)
+		}
+
+		if len(items) > 0 {
+			if err := es.writeOutbox(ctx, items); err != nil {
+				return delivered, fmt.Errorf("error updating outbox entries: %s", err)
+			}
+		}
+
+		if res.NextPageToken == "" {
+			break
+		}
+		pageToken = res.NextPageToken
+	}
+
+	stalled, err := es.client.Count(ctx, &esutil.CountRequest{
+		Index: es.outboxAlias(),
+		Query: &filtering.Query{
+			Bool: &filtering.Bool{
+				Filter: &filtering.Filter{
+					&filtering.Query{
+						Term: &filtering.Term{
+							"status": OutboxStatusPending,
+						},
+					},
+					&filtering.Query{
+						Range: &filtering.Range{
+							"attempts": {
+								GreaterEquals: 1,
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return delivered, err
+	}
+
+	stalledMetric := new(expvar.Int)
+	stalledMetric.Set(int64(stalled))
+	outboxMetrics.Set(outboxStalledMetric, stalledMetric)
+
+	return delivered, nil
+}
+
+// deliver publishes the entry's event, updating the entry with the outcome. It returns true if the event was delivered.
+func (es *ElasticsearchStorage) deliver(ctx context.Context, log *zap.Logger, entry *OutboxEntry) bool {
+	entry.Attempts++
+	err := es.publisher.Publish(ctx, entry.Event)
+	now := time.Now().UTC()
+	if err == nil {
+		entry.Status = OutboxStatusDone
+		entry.DoneTime = &now
+		entry.LastError = ""
+		outboxMetrics.Add(outboxDeliveredMetric, 1)
+
+		return true
+	}
+
+	entry.LastError = err.Error()
+	entry.NextAttemptTime = now.Add(es.outboxBackoff(entry.Attempts))
+	outboxMetrics.Add(outboxFailedMetric, 1)
+	log.Warn("error delivering event, it will be retried", zap.Error(err), zap.String("resource", entry.Event.Resource), zap.Int("attempts", entry.Attempts), zap.Time("nextAttemptTime", entry.NextAttemptTime))
+
+	return false
+}
+
+// outboxBackoff is the time to wait after the given number of failed attempts, which doubles from the outbox interval with each attempt
+func (es *ElasticsearchStorage) outboxBackoff(attempts int) time.Duration {
+	backoff, err := es.config.Events.Outbox.IntervalDuration()
+	if err != nil || backoff <= 0 {
+		backoff = time.Second
+	}
+
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+
+	return backoff
+}
+
+// purgeOutbox removes the events that were delivered before the outbox retention
+func (es *ElasticsearchStorage) purgeOutbox(ctx context.Context) error {
+	_, err := es.client.DeleteByQuery(ctx, &esutil.DeleteRequest{
+		Index: es.outboxAlias(),
+		Search: &esutil.EsSearch{
+			Query: &filtering.Query{
+				Bool: &filtering.Bool{
+					Filter: &filtering.Filter{
+						&filtering.Query{
+							Term: &filtering.Term{
+								"status": OutboxStatusDone,
+							},
+						},
+						&filtering.Query{
+							Range: &filtering.Range{
+								"doneTime": {
+									Less: outboxRetention,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		Refresh: es.config.Refresh.String(),
+	})
+
+	return err
+}
+
+// writeOutbox writes the items to the outbox index, returning an error if any of them fail
+func (es *ElasticsearchStorage) writeOutbox(ctx context.Context, items []*esutil.BulkRequestItem) error {
+	response, err := es.client.Bulk(ctx, &esutil.BulkRequest{
+		Index:   es.outboxAlias(),
+		Refresh: es.config.Refresh.String(),
+		Items:   items,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, item := range response.Items {
+		if err := bulkItemError(item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (es *ElasticsearchStorage) outboxItem(entry *OutboxEntry, operation esutil.EsBulkOperation) (*esutil.BulkRequestItem, error) {
+	fields, err := structFields(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &esutil.BulkRequestItem{
+		Operation:  operation,
+		Index:      es.outboxAlias(),
+		DocumentId: entry.ID,
+		Fields:     fields,
+	}, nil
+}
+
+// bulkItemError returns the error for a failed item in a bulk response, or nil if it succeeded
+func bulkItemError(item *esutil.EsBulkResponseItem) error {
+	result := item.Create
+	if result == nil {
+		result = item.Index
+	}
+	if result == nil {
+		result = item.Delete
+	}
+	if result == nil || result.Error == nil {
+		return nil
+	}
+
+	return fmt.Errorf("[%d] %s: %s", result.Status, result.Error.Type, result.Error.Reason)
+}
+
+func (es *ElasticsearchStorage) outboxIndex() string {
+	return es.indexManager.IndexName(outboxDocumentKind, "")
+}
+
+func (es *ElasticsearchStorage) outboxAlias() string {
+	return es.indexManager.AliasName(outboxDocumentKind, "")
+}
@@ -0,0 +1,635 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/events"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/events/eventsfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+var _ = Describe("outbox", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId      string
+		expectedOccurrenceId   string
+		expectedOccurrenceName string
+		expectedNoteId         string
+		expectedNoteName       string
+		expectedOutboxAlias    string
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		publisher    *eventsfakes.FakePublisher
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		expectedProjectId = fake.LetterN(10)
+		expectedOccurrenceId = fake.LetterN(10)
+		expectedOccurrenceName = fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, expectedOccurrenceId)
+		expectedNoteId = fake.LetterN(10)
+		expectedNoteName = fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, expectedNoteId)
+		expectedOutboxAlias = "grafeas-outbox"
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		publisher = &eventsfakes.FakePublisher{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+			Events: config.EventsConfig{
+				Outbox: config.OutboxConfig{
+					Enabled:  true,
+					Interval: "10s",
+				},
+			},
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+		indexManager.IndexNameCalls(func(documentKind string, inner string) string {
+			return fmt.Sprintf("grafeas-v1-%s-%s", inner, documentKind)
+		})
+
+		client.BulkReturns(bulkResponse(2), nil)
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, publisher)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	searchResponse := func(messages ...proto.Message) *esutil.SearchResponse {
+		response := &esutil.SearchResponse{
+			Hits: &esutil.EsSearchResponseHits{
+				Total: &esutil.EsSearchResponseTotal{Value: len(messages)},
+			},
+		}
+		for _, message := range messages {
+			source, err := protojson.Marshal(proto.MessageV2(message))
+			Expect(err).ToNot(HaveOccurred())
+
+			response.Hits.Hits = append(response.Hits.Hits, &esutil.EsSearchResponseHit{
+				ID:      fake.LetterN(10),
+				Index:   fake.LetterN(10),
+				Routing: fake.LetterN(10),
+				Source:  source,
+			})
+		}
+
+		return response
+	}
+
+	bulkRequest := func(call int) *esutil.BulkRequest {
+		_, request := client.BulkArgsForCall(call)
+
+		return request
+	}
+
+	decodeEntry := func(item *esutil.BulkRequestItem) *OutboxEntry {
+		source, err := json.Marshal(item.Fields)
+		Expect(err).ToNot(HaveOccurred())
+
+		entry := &OutboxEntry{}
+		Expect(json.Unmarshal(source, entry)).To(Succeed())
+		entry.ID = item.DocumentId
+
+		return entry
+	}
+
+	expectEntry := func(item *esutil.BulkRequestItem) *OutboxEntry {
+		Expect(item.Operation).To(Equal(esutil.BULK_CREATE))
+		Expect(item.Index).To(Equal(expectedOutboxAlias))
+		Expect(item.DocumentId).ToNot(BeEmpty())
+
+		entry := decodeEntry(item)
+		Expect(entry.Status).To(Equal(OutboxStatusPending))
+		Expect(entry.Attempts).To(BeZero())
+
+		return entry
+	}
+
+	metricValue := func(name string) int64 {
+		if value, ok := outboxMetrics.Get(name).(*expvar.Int); ok {
+			return value.Value()
+		}
+
+		return 0
+	}
+
+	Context("Initialize", func() {
+		It("should create the outbox index", func() {
+			Expect(elasticsearchStorage.Initialize(ctx)).To(Succeed())
+			Expect(indexManager.CreateIndexCallCount()).To(Equal(2))
+
+			_, index, alias, documentKind := indexManager.CreateIndexArgsForCall(1)
+			Expect(index).To(Equal("grafeas-v1--outbox"))
+			Expect(alias).To(Equal(expectedOutboxAlias))
+			Expect(documentKind).To(Equal(outboxDocumentKind))
+		})
+	})
+
+	Context("CreateOccurrence", func() {
+		var (
+			expectedOccurrence *pb.Occurrence
+			actualErr          error
+		)
+
+		BeforeEach(func() {
+			expectedOccurrence = generateTestOccurrence("")
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+		})
+
+		JustBeforeEach(func() {
+			_, actualErr = elasticsearchStorage.CreateOccurrence(ctx, expectedProjectId, "", expectedOccurrence)
+		})
+
+		It("should create the occurrence and the outbox entry in the same request", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(client.CreateCallCount()).To(Equal(0))
+			Expect(client.BulkCallCount()).To(Equal(1))
+
+			request := bulkRequest(0)
+			Expect(request.Index).To(Equal(fmt.Sprintf("grafeas-%s-occurrences", expectedProjectId)))
+			Expect(request.Refresh).To(Equal(config.RefreshTrue))
+			Expect(request.Items).To(HaveLen(2))
+			// occurrences are given an ID by Elasticsearch when deduplication is disabled
+			Expect(request.Items[0].Operation).To(Equal(esutil.BULK_INDEX))
+			Expect(request.Items[0].Index).To(BeEmpty())
+			Expect(request.Items[0].Message).To(Equal(proto.MessageV2(expectedOccurrence)))
+
+			entry := expectEntry(request.Items[1])
+			Expect(entry.Event.Type).To(Equal(events.Created))
+			Expect(entry.Event.ResourceKind).To(Equal(occurrencesDocumentKind))
+			Expect(entry.Event.Resource).To(Equal(expectedOccurrence.Name))
+		})
+
+		It("should leave the event to be delivered from the outbox", func() {
+			elasticsearchStorage.deliverQueuedEvents(ctx)
+			Expect(publisher.PublishCallCount()).To(Equal(0))
+		})
+
+		When("the occurrence isn't created", func() {
+			BeforeEach(func() {
+				client.BulkReturnsOnCall(0, &esutil.EsBulkResponse{
+					Items: []*esutil.EsBulkResponseItem{
+						{Create: &esutil.EsIndexDocResponse{Status: 409, Error: &esutil.EsIndexDocError{Type: fake.LetterN(10)}}},
+						{Create: &esutil.EsIndexDocResponse{}},
+					},
+				}, nil)
+				client.BulkReturnsOnCall(1, bulkResponse(1), nil)
+			})
+
+			It("should remove the outbox entry", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(client.BulkCallCount()).To(Equal(2))
+
+				entryId := bulkRequest(0).Items[1].DocumentId
+				discard := bulkRequest(1)
+				Expect(discard.Index).To(Equal(expectedOutboxAlias))
+				Expect(discard.Items).To(HaveLen(1))
+				Expect(discard.Items[0].Operation).To(Equal(esutil.BULK_DELETE))
+				Expect(discard.Items[0].DocumentId).To(Equal(entryId))
+				Expect(publisher.PublishCallCount()).To(Equal(0))
+			})
+		})
+
+		When("the outbox entry isn't created", func() {
+			BeforeEach(func() {
+				client.BulkReturnsOnCall(0, &esutil.EsBulkResponse{
+					Items: []*esutil.EsBulkResponseItem{
+						{Create: &esutil.EsIndexDocResponse{}},
+						{Create: &esutil.EsIndexDocResponse{Status: 500, Error: &esutil.EsIndexDocError{Type: fake.LetterN(10)}}},
+					},
+				}, nil)
+			})
+
+			It("should publish the event directly", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+
+				elasticsearchStorage.deliverQueuedEvents(ctx)
+				Expect(publisher.PublishCallCount()).To(Equal(1))
+
+				_, event := publisher.PublishArgsForCall(0)
+				Expect(event.Resource).To(Equal(expectedOccurrence.Name))
+			})
+		})
+
+		When("the bulk request fails", func() {
+			BeforeEach(func() {
+				client.BulkReturnsOnCall(0, nil, errors.New("bulk failed"))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(publisher.PublishCallCount()).To(Equal(0))
+			})
+		})
+
+		When("deduplication is enabled", func() {
+			var (
+				expectedKey              string
+				expectedMultiGetResponse *esutil.EsMultiGetResponse
+			)
+
+			BeforeEach(func() {
+				esConfig.Dedupe = config.DedupeConfig{
+					Fields: []string{"resource.uri", "noteName"},
+					Mode:   config.DedupeModeUpsert,
+				}
+
+				key, err := occurrenceKey(expectedProjectId, expectedOccurrence, esConfig.Dedupe.Fields)
+				Expect(err).ToNot(HaveOccurred())
+				expectedKey = key
+
+				expectedMultiGetResponse = &esutil.EsMultiGetResponse{
+					Docs: []*esutil.EsGetResponse{{Id: expectedKey}},
+				}
+				client.MultiGetReturns(expectedMultiGetResponse, nil)
+			})
+
+			It("should create the occurrence as a new event", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+
+				request := bulkRequest(0)
+				Expect(request.Items[0].Operation).To(Equal(esutil.BULK_INDEX))
+				Expect(request.Items[0].DocumentId).To(Equal(expectedKey))
+
+				entry := expectEntry(request.Items[1])
+				Expect(entry.Event.Type).To(Equal(events.Created))
+				Expect(entry.Event.Old).To(BeNil())
+			})
+
+			When("an occurrence with the same key exists", func() {
+				var existingOccurrence *pb.Occurrence
+
+				BeforeEach(func() {
+					existingOccurrence = deepCopyOccurrence(expectedOccurrence)
+					existingOccurrence.Name = fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, expectedKey)
+					existingOccurrence.Remediation = fake.LetterN(10)
+					existingJson, err := protojson.Marshal(proto.MessageV2(existingOccurrence))
+					Expect(err).ToNot(HaveOccurred())
+
+					expectedMultiGetResponse.Docs[0].Found = true
+					expectedMultiGetResponse.Docs[0].Source = existingJson
+				})
+
+				It("should replace the existing occurrence in the same request as an update event", func() {
+					Expect(actualErr).ToNot(HaveOccurred())
+
+					request := bulkRequest(0)
+					Expect(request.Items).To(HaveLen(2))
+					Expect(request.Items[0].Operation).To(Equal(esutil.BULK_INDEX))
+					Expect(request.Items[0].DocumentId).To(Equal(expectedKey))
+
+					entry := expectEntry(request.Items[1])
+					Expect(entry.Event.Type).To(Equal(events.Updated))
+					Expect(entry.Event.Resource).To(Equal(existingOccurrence.Name))
+
+					previous := &pb.Occurrence{}
+					Expect(protojson.Unmarshal(entry.Event.Old, proto.MessageV2(previous))).To(Succeed())
+					Expect(previous.Remediation).To(Equal(existingOccurrence.Remediation))
+				})
+			})
+
+			When("the dedupe mode is reject", func() {
+				BeforeEach(func() {
+					esConfig.Dedupe.Mode = config.DedupeModeReject
+				})
+
+				It("should create the occurrence so that a concurrent duplicate conflicts", func() {
+					Expect(actualErr).ToNot(HaveOccurred())
+					Expect(bulkRequest(0).Items[0].Operation).To(Equal(esutil.BULK_CREATE))
+				})
+			})
+		})
+	})
+
+	Context("BatchCreateOccurrences", func() {
+		It("should add an outbox entry for each occurrence, and remove the entries of occurrences that weren't created", func() {
+			occurrences := generateTestOccurrences(3)
+			client.SearchReturns(searchResponse(generateTestProject(expectedProjectId)), nil)
+			client.BulkReturnsOnCall(0, &esutil.EsBulkResponse{
+				Items: []*esutil.EsBulkResponseItem{
+					{Create: &esutil.EsIndexDocResponse{}},
+					{Create: &esutil.EsIndexDocResponse{Error: &esutil.EsIndexDocError{Type: fake.LetterN(10)}}},
+					{Create: &esutil.EsIndexDocResponse{}},
+					{Create: &esutil.EsIndexDocResponse{}},
+					{Create: &esutil.EsIndexDocResponse{}},
+					{Create: &esutil.EsIndexDocResponse{}},
+				},
+			}, nil)
+			client.BulkReturnsOnCall(1, bulkResponse(1), nil)
+
+			created, errs := elasticsearchStorage.BatchCreateOccurrences(ctx, expectedProjectId, "", occurrences)
+			Expect(created).To(HaveLen(2))
+			Expect(errs).To(HaveLen(1))
+
+			request := bulkRequest(0)
+			Expect(request.Items).To(HaveLen(6))
+			for i, occurrence := range occurrences {
+				entry := expectEntry(request.Items[i+3])
+				Expect(entry.Event.Resource).To(Equal(occurrence.Name))
+			}
+
+			Expect(client.BulkCallCount()).To(Equal(2))
+			discard := bulkRequest(1)
+			Expect(discard.Items).To(HaveLen(1))
+			Expect(discard.Items[0].DocumentId).To(Equal(request.Items[4].DocumentId))
+			Expect(publisher.PublishCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("UpdateNote", func() {
+		It("should replace the note in the same request as the outbox entry", func() {
+			currentNote := generateTestNote(expectedNoteName)
+			response := searchResponse(currentNote)
+			client.SearchReturns(response, nil)
+
+			_, err := elasticsearchStorage.UpdateNote(ctx, expectedProjectId, expectedNoteId, &pb.Note{
+				ShortDescription: "updatedvalue",
+			}, &fieldmaskpb.FieldMask{Paths: []string{"shortDescription"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(client.UpdateCallCount()).To(Equal(0))
+
+			request := bulkRequest(0)
+			Expect(request.Index).To(Equal(response.Hits.Hits[0].Index))
+			Expect(request.Items[0].Operation).To(Equal(esutil.BULK_INDEX))
+			Expect(request.Items[0].DocumentId).To(Equal(response.Hits.Hits[0].ID))
+
+			entry := expectEntry(request.Items[1])
+			Expect(entry.Event.Type).To(Equal(events.Updated))
+			Expect(string(entry.Event.Old)).ToNot(ContainSubstring("updatedvalue"))
+			Expect(string(entry.Event.New)).To(ContainSubstring("updatedvalue"))
+		})
+	})
+
+	Context("DeleteOccurrence", func() {
+		It("should delete the occurrence that was found in the same request as the outbox entry", func() {
+			response := searchResponse(generateTestOccurrence(expectedOccurrenceName))
+			client.SearchReturns(response, nil)
+
+			Expect(elasticsearchStorage.DeleteOccurrence(ctx, expectedProjectId, expectedOccurrenceId)).To(Succeed())
+			Expect(client.DeleteCallCount()).To(Equal(0))
+
+			request := bulkRequest(0)
+			Expect(request.Index).To(Equal(response.Hits.Hits[0].Index))
+			Expect(request.Items[0].Operation).To(Equal(esutil.BULK_DELETE))
+			Expect(request.Items[0].DocumentId).To(Equal(response.Hits.Hits[0].ID))
+			Expect(request.Items[0].Routing).To(Equal(response.Hits.Hits[0].Routing))
+
+			entry := expectEntry(request.Items[1])
+			Expect(entry.Event.Type).To(Equal(events.Deleted))
+			Expect(entry.Event.Resource).To(Equal(expectedOccurrenceName))
+		})
+
+		When("history is enabled", func() {
+			BeforeEach(func() {
+				esConfig.History.Enabled = true
+				client.SearchReturns(searchResponse(generateTestOccurrence(expectedOccurrenceName)), nil)
+				client.BulkReturnsOnCall(0, &esutil.EsBulkResponse{
+					Items: []*esutil.EsBulkResponseItem{
+						{Delete: &esutil.EsIndexDocResponse{}},
+						{Create: &esutil.EsIndexDocResponse{}},
+						{Create: &esutil.EsIndexDocResponse{Status: 500, Error: &esutil.EsIndexDocError{Type: fake.LetterN(10)}}},
+					},
+				}, nil)
+			})
+
+			It("should write the revision and the outbox entry in the same request, settling the entry separately", func() {
+				Expect(elasticsearchStorage.DeleteOccurrence(ctx, expectedProjectId, expectedOccurrenceId)).To(Succeed())
+				Expect(client.BulkCallCount()).To(Equal(1))
+
+				request := bulkRequest(0)
+				Expect(request.Items).To(HaveLen(3))
+				Expect(request.Items[1].Index).To(Equal("grafeas-revisions"))
+				expectEntry(request.Items[2])
+
+				elasticsearchStorage.deliverQueuedEvents(ctx)
+				Expect(publisher.PublishCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Context("DispatchOutbox", func() {
+		var (
+			expectedEntries  []*OutboxEntry
+			expectedPitId    string
+			expectedStalled  int
+			publishErr       error
+			actualDelivered  int
+			actualErr        error
+			deliveredMetric  int64
+			failedMetric     int64
+			dispatchedBefore time.Time
+		)
+
+		BeforeEach(func() {
+			expectedEntries = nil
+			expectedPitId = fake.LetterN(10)
+			expectedStalled = fake.Number(1, 10)
+			publishErr = nil
+
+			response := &esutil.SearchResponse{
+				Hits:  &esutil.EsSearchResponseHits{},
+				PitId: expectedPitId,
+			}
+			for i := 0; i < 2; i++ {
+				entry := &OutboxEntry{
+					ID: fake.UUID(),
+					Event: &events.Event{
+						Type:     events.Created,
+						Resource: fake.LetterN(10),
+					},
+					Status:   OutboxStatusPending,
+					Attempts: i,
+				}
+				source, err := json.Marshal(entry)
+				Expect(err).ToNot(HaveOccurred())
+
+				expectedEntries = append(expectedEntries, entry)
+				response.Hits.Hits = append(response.Hits.Hits, &esutil.EsSearchResponseHit{
+					ID:     entry.ID,
+					Source: source,
+				})
+			}
+
+			client.SearchReturns(response, nil)
+			client.CountReturns(expectedStalled, nil)
+		})
+
+		JustBeforeEach(func() {
+			publisher.PublishReturns(publishErr)
+			deliveredMetric = metricValue(outboxDeliveredMetric)
+			failedMetric = metricValue(outboxFailedMetric)
+			dispatchedBefore = time.Now()
+
+			actualDelivered, actualErr = elasticsearchStorage.DispatchOutbox(ctx)
+		})
+
+		It("should search for the pending entries that are due, oldest first", func() {
+			Expect(client.SearchCallCount()).To(Equal(1))
+
+			_, request := client.SearchArgsForCall(0)
+			Expect(request.Index).To(Equal(expectedOutboxAlias))
+			Expect(request.Pagination.Size).To(Equal(outboxPageSize))
+			Expect(request.Search.Sort).To(Equal([]esutil.EsSortField{{Field: "createTime", Order: esutil.EsSortOrderAscending}}))
+
+			filter := *request.Search.Query.Bool.Filter
+			Expect(filter[0]).To(Equal(&filtering.Query{Term: &filtering.Term{"status": OutboxStatusPending}}))
+			Expect(filter[1]).To(Equal(&filtering.Query{Range: &filtering.Range{"nextAttemptTime": {LessEquals: "now"}}}))
+		})
+
+		It("should publish each event and mark it done", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualDelivered).To(Equal(2))
+			Expect(publisher.PublishCallCount()).To(Equal(2))
+
+			request := bulkRequest(0)
+			Expect(request.Index).To(Equal(expectedOutboxAlias))
+			Expect(request.Items).To(HaveLen(2))
+			for i, item := range request.Items {
+				_, event := publisher.PublishArgsForCall(i)
+				Expect(event).To(Equal(expectedEntries[i].Event))
+
+				Expect(item.Operation).To(Equal(esutil.BULK_INDEX))
+				Expect(item.DocumentId).To(Equal(expectedEntries[i].ID))
+
+				entry := decodeEntry(item)
+				Expect(entry.Status).To(Equal(OutboxStatusDone))
+				Expect(entry.Attempts).To(Equal(expectedEntries[i].Attempts + 1))
+				Expect(entry.DoneTime).ToNot(BeNil())
+			}
+
+			Expect(metricValue(outboxDeliveredMetric)).To(Equal(deliveredMetric + 2))
+		})
+
+		It("should close the point in time used for the search", func() {
+			Expect(client.ClosePointInTimeCallCount()).To(Equal(1))
+
+			_, pitId := client.ClosePointInTimeArgsForCall(0)
+			Expect(pitId).To(Equal(expectedPitId))
+		})
+
+		It("should record the number of stalled entries", func() {
+			Expect(metricValue(outboxStalledMetric)).To(BeEquivalentTo(expectedStalled))
+		})
+
+		When("publishing fails", func() {
+			BeforeEach(func() {
+				publishErr = errors.New("publish failed")
+			})
+
+			It("should schedule another attempt with a backoff", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualDelivered).To(BeZero())
+
+				request := bulkRequest(0)
+				for i, item := range request.Items {
+					entry := decodeEntry(item)
+					Expect(entry.Status).To(Equal(OutboxStatusPending))
+					Expect(entry.Attempts).To(Equal(expectedEntries[i].Attempts + 1))
+					Expect(entry.LastError).To(Equal("publish failed"))
+					Expect(entry.NextAttemptTime).To(BeTemporally(">=", dispatchedBefore.Add(elasticsearchStorage.outboxBackoff(entry.Attempts))))
+				}
+
+				Expect(metricValue(outboxFailedMetric)).To(Equal(failedMetric + 2))
+			})
+		})
+
+		When("the entries can't be marked", func() {
+			BeforeEach(func() {
+				client.BulkReturns(&esutil.EsBulkResponse{
+					Items: []*esutil.EsBulkResponseItem{
+						{Index: &esutil.EsIndexDocResponse{Error: &esutil.EsIndexDocError{Type: fake.LetterN(10)}}},
+						{Index: &esutil.EsIndexDocResponse{}},
+					},
+				}, nil)
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+
+		When("the outbox is disabled", func() {
+			BeforeEach(func() {
+				esConfig.Events.Outbox.Enabled = false
+			})
+
+			It("should not deliver anything", func() {
+				Expect(actualDelivered).To(BeZero())
+				Expect(client.SearchCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("outboxBackoff", func() {
+		It("should double from the interval with each attempt, up to the maximum", func() {
+			Expect(elasticsearchStorage.outboxBackoff(1)).To(Equal(10 * time.Second))
+			Expect(elasticsearchStorage.outboxBackoff(2)).To(Equal(20 * time.Second))
+			Expect(elasticsearchStorage.outboxBackoff(4)).To(Equal(80 * time.Second))
+			Expect(elasticsearchStorage.outboxBackoff(100)).To(Equal(outboxMaxBackoff))
+		})
+	})
+})
+
+// bulkResponse is a successful response to a bulk request with the given number of items
+func bulkResponse(items int) *esutil.EsBulkResponse {
+	response := &esutil.EsBulkResponse{}
+	for i := 0; i < items; i++ {
+		response.Items = append(response.Items, &esutil.EsBulkResponseItem{
+			Index: &esutil.EsIndexDocResponse{},
+		})
+	}
+
+	return response
+}
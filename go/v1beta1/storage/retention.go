@@ -0,0 +1,323 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strings"
+	"time"
+
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/hashicorp/go-multierror"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"go.uber.org/zap"
+)
+
+// retentionMetrics are published with expvar under "retention"
+var retentionMetrics = expvar.NewMap("retention")
+
+const (
+	// retentionRunsMetric counts the number of times that retention policies were applied
+	retentionRunsMetric = "runs"
+	// retentionErrorsMetric counts the projects where applying a retention policy failed
+	retentionErrorsMetric = "errors"
+	// retentionMatchedMetric counts the occurrences selected for deletion, including during a dry run
+	retentionMatchedMetric = "matched"
+	// retentionDeletedMetric counts the occurrences that were deleted
+	retentionDeletedMetric = "deleted"
+
+	retentionPageSize = 1000
+	// retentionKeepAlive is how long the PIT of a project's scan for superseded occurrences is kept between pages
+	retentionKeepAlive = "5m"
+)
+
+// RetentionReport summarizes the occurrences deleted from a project by its retention policy.
+// During a dry run, the counts are the occurrences that would have been deleted.
+type RetentionReport struct {
+	ProjectId string
+	DryRun    bool
+	// Expired is the number of occurrences older than the maximum age
+	Expired int
+	// Superseded is the number of occurrences that weren't among the latest to keep for their resource and note
+	Superseded int
+}
+
+// RunRetention applies the retention policies every interval until the context is cancelled
+func (es *ElasticsearchStorage) RunRetention(ctx context.Context, interval time.Duration) {
+	log := es.logger.Named("RunRetention")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug("stopping retention")
+			return
+		case <-ticker.C:
+			if _, err := es.ApplyRetention(ctx); err != nil {
+				log.Error("error applying retention policies", zap.Error(err))
+			}
+		}
+	}
+}
+
+// ApplyRetention deletes the occurrences in each project that fall outside of the project's retention policy,
+// or only reports them when configured for a dry run.
+// Projects are processed independently, so a failure in one project doesn't stop the others.
+func (es *ElasticsearchStorage) ApplyRetention(ctx context.Context) ([]*RetentionReport, error) {
+	log := es.logger.Named("ApplyRetention").With(zap.Bool("dryRun", es.config.Retention.DryRun))
+	retentionMetrics.Add(retentionRunsMetric, 1)
+
+	var (
+		reports   []*RetentionReport
+		errs      error
+		pageToken string
+	)
+	for {
+		projects, nextPageToken, err := es.ListProjects(ctx, "", retentionPageSize, pageToken)
+		if err != nil {
+			retentionMetrics.Add(retentionErrorsMetric, 1)
+			return reports, err
+		}
+
+		for _, project := range projects {
+			projectId := strings.TrimPrefix(project.Name, "projects/")
+			report, err := es.applyProjectRetention(ctx, log.With(zap.String("project", project.Name)), projectId, es.config.Retention.Policy(projectId))
+			if err != nil {
+				retentionMetrics.Add(retentionErrorsMetric, 1)
+				errs = multierror.Append(errs, fmt.Errorf("error applying retention policy to project %s: %s", projectId, err))
+				continue
+			}
+
+			if report != nil {
+				reports = append(reports, report)
+			}
+		}
+
+		if nextPageToken == "" {
+			break
+		}
+		pageToken = nextPageToken
+	}
+
+	return reports, errs
+}
+
+func (es *ElasticsearchStorage) applyProjectRetention(ctx context.Context, log *zap.Logger, projectId string, policy config.RetentionPolicy) (*RetentionReport, error) {
+	if policy.MaxAgeDays == 0 && policy.KeepLatest == 0 {
+		return nil, nil
+	}
+
+	report := &RetentionReport{
+		ProjectId: projectId,
+		DryRun:    es.config.Retention.DryRun,
+	}
+
+	var maxAge *filtering.RangeOperator
+	if policy.MaxAgeDays > 0 {
+		maxAge = &filtering.RangeOperator{
+			Less: fmt.Sprintf("now-%dd", policy.MaxAgeDays),
+		}
+
+		expired, err := es.deleteOccurrences(ctx, projectId, retentionQuery(policy, &filtering.Query{
+			Range: &filtering.Range{
+				sortField: maxAge,
+			},
+		}))
+		if err != nil {
+			return nil, err
+		}
+
+		report.Expired = expired
+	}
+
+	if policy.KeepLatest > 0 {
+		// occurrences older than the maximum age were counted as expired, so they're left out here. otherwise a dry run would count them twice
+		var scanQuery *filtering.Query
+		if maxAge != nil {
+			scanQuery = &filtering.Query{
+				Range: &filtering.Range{
+					sortField: {
+						GreaterEquals: maxAge.Less,
+					},
+				},
+			}
+		}
+
+		superseded, err := es.deleteSupersededOccurrences(ctx, log, projectId, policy, retentionQuery(policy, scanQuery))
+		if err != nil {
+			return nil, err
+		}
+
+		report.Superseded = superseded
+	}
+
+	log.Info("applied retention policy", zap.Int("expired", report.Expired), zap.Int("superseded", report.Superseded))
+
+	if deleted := report.Expired + report.Superseded; !report.DryRun && deleted > 0 {
+		es.auditRetention(ctx, log, projectId, deleted)
+	}
+
+	return report, nil
+}
+
+// deleteSupersededOccurrences deletes the occurrences that aren't among the latest policy.KeepLatest occurrences for their resource and note,
+// returning the number deleted. The occurrences are paged through a PIT with search_after, which isn't limited by the result window like from and size are.
+// They're sorted by resource and note, so only the current resource and note need to be counted, and each page's superseded occurrences are deleted
+// before the next page is read.
+func (es *ElasticsearchStorage) deleteSupersededOccurrences(ctx context.Context, log *zap.Logger, projectId string, policy config.RetentionPolicy, query *filtering.Query) (int, error) {
+	pitId, err := es.client.OpenPointInTime(ctx, es.occurrencesAlias(projectId), retentionKeepAlive)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := es.client.ClosePointInTime(ctx, pitId); err != nil {
+			log.Warn("error closing point in time", zap.Error(err))
+		}
+	}()
+
+	var (
+		deleted     int
+		searchAfter []interface{}
+		currentKey  string
+		keyCount    int
+	)
+	for {
+		// the PIT adds a tiebreaker to the sort, so the sort values of the last hit are unique
+		res, err := es.client.Search(ctx, &esutil.SearchRequest{
+			Search: &esutil.EsSearch{
+				Query: query,
+				Sort: []esutil.EsSortField{
+					{Field: "resource.uri", Order: esutil.EsSortOrderAscending},
+					{Field: "noteName", Order: esutil.EsSortOrderAscending},
+					{Field: sortField, Order: esutil.EsSortOrderDescending},
+				},
+				Pit: &esutil.EsSearchPit{
+					Id:        pitId,
+					KeepAlive: retentionKeepAlive,
+				},
+				SearchAfter: searchAfter,
+			},
+		})
+		if err != nil {
+			return deleted, err
+		}
+
+		hits := res.Hits.Hits
+		if len(hits) == 0 {
+			return deleted, nil
+		}
+
+		var superseded []string
+		for _, hit := range hits {
+			occurrence := &pb.Occurrence{}
+			if err := decodeDocument(hit.Source, occurrence); err != nil {
+				return deleted, err
+			}
+
+			key := occurrence.Resource.GetUri() + "\n" + occurrence.NoteName
+			if key != currentKey {
+				currentKey = key
+				keyCount = 0
+			}
+
+			keyCount++
+			if keyCount > policy.KeepLatest {
+				superseded = append(superseded, occurrence.Name)
+			}
+		}
+
+		// the PIT still returns deleted occurrences, so deleting them doesn't shift the pages that follow
+		if len(superseded) > 0 {
+			pageDeleted, err := es.deleteOccurrences(ctx, projectId, &filtering.Query{
+				Terms: &filtering.Terms{
+					"name": superseded,
+				},
+			})
+			if err != nil {
+				return deleted, err
+			}
+
+			deleted += pageDeleted
+		}
+
+		if res.PitId != "" {
+			pitId = res.PitId
+		}
+		searchAfter = hits[len(hits)-1].Sort
+	}
+}
+
+// deleteOccurrences deletes the occurrences in the project that match the query, returning the number deleted.
+// During a dry run the matching occurrences are only counted.
+func (es *ElasticsearchStorage) deleteOccurrences(ctx context.Context, projectId string, query *filtering.Query) (int, error) {
+	if es.config.Retention.DryRun {
+		count, err := es.client.Count(ctx, &esutil.CountRequest{
+			Index: es.occurrencesAlias(projectId),
+			Query: query,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		retentionMetrics.Add(retentionMatchedMetric, int64(count))
+
+		return count, nil
+	}
+
+	res, err := es.client.DeleteByQuery(ctx, &esutil.DeleteRequest{
+		Index: es.occurrencesAlias(projectId),
+		Search: &esutil.EsSearch{
+			Query: query,
+		},
+		Refresh: es.config.Refresh.String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	retentionMetrics.Add(retentionMatchedMetric, int64(res.Deleted))
+	retentionMetrics.Add(retentionDeletedMetric, int64(res.Deleted))
+
+	return res.Deleted, nil
+}
+
+// retentionQuery excludes the policy's exempt kinds from the query. A nil query matches every occurrence.
+func retentionQuery(policy config.RetentionPolicy, query *filtering.Query) *filtering.Query {
+	if len(policy.ExemptKinds) == 0 {
+		return query
+	}
+
+	exempt := &filtering.Bool{
+		MustNot: &filtering.MustNot{
+			&filtering.Query{
+				Terms: &filtering.Terms{
+					"kind": policy.ExemptKinds,
+				},
+			},
+		},
+	}
+	if query != nil {
+		exempt.Filter = &filtering.Filter{query}
+	}
+
+	return &filtering.Query{
+		Bool: exempt,
+	}
+}
@@ -0,0 +1,474 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var _ = Describe("retention", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectAlias string
+		expectedProjectIds   []string
+		expectedOccurrences  []*pb.Occurrence
+		expectedPitId        string
+
+		actualReports []*RetentionReport
+		actualErr     error
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	occurrencesAlias := func(projectId string) string {
+		return "occurrences-" + projectId
+	}
+
+	searchHits := func(messages ...proto.Message) *esutil.SearchResponse {
+		response := &esutil.SearchResponse{
+			Hits: &esutil.EsSearchResponseHits{
+				Total: &esutil.EsSearchResponseTotal{
+					Value: len(messages),
+				},
+			},
+		}
+		for _, message := range messages {
+			source, err := protojson.Marshal(proto.MessageV2(message))
+			Expect(err).ToNot(HaveOccurred())
+
+			response.Hits.Hits = append(response.Hits.Hits, &esutil.EsSearchResponseHit{
+				Source: source,
+			})
+		}
+
+		return response
+	}
+
+	metricValue := func(name string) int64 {
+		if value, ok := retentionMetrics.Get(name).(*expvar.Int); ok {
+			return value.Value()
+		}
+
+		return 0
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		expectedProjectAlias = fake.LetterN(10)
+		expectedProjectIds = []string{fake.LetterN(10)}
+		expectedOccurrences = nil
+		expectedPitId = fake.LetterN(10)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+			Retention: config.RetentionConfig{
+				Interval: "1h",
+			},
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if documentKind == projectDocumentKind {
+				return expectedProjectAlias
+			}
+
+			return occurrencesAlias(inner)
+		})
+
+		client.SearchCalls(func(_ context.Context, request *esutil.SearchRequest) (*esutil.SearchResponse, error) {
+			if request.Index == expectedProjectAlias {
+				var projects []proto.Message
+				for _, projectId := range expectedProjectIds {
+					projects = append(projects, &prpb.Project{Name: "projects/" + projectId})
+				}
+
+				return searchHits(projects...), nil
+			}
+
+			// the occurrences are returned as a single page, followed by an empty one
+			if request.Search.SearchAfter != nil {
+				return searchHits(), nil
+			}
+
+			var occurrences []proto.Message
+			for _, occurrence := range expectedOccurrences {
+				occurrences = append(occurrences, occurrence)
+			}
+
+			response := searchHits(occurrences...)
+			for i, hit := range response.Hits.Hits {
+				hit.Sort = []interface{}{i}
+			}
+
+			return response, nil
+		})
+		client.OpenPointInTimeReturns(expectedPitId, nil)
+		client.DeleteByQueryReturns(&esutil.EsDeleteResponse{Deleted: 1}, nil)
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+		actualReports, actualErr = elasticsearchStorage.ApplyRetention(ctx)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	When("projects don't have a retention policy", func() {
+		It("should not delete anything", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualReports).To(BeEmpty())
+			Expect(client.DeleteByQueryCallCount()).To(Equal(0))
+			Expect(client.CountCallCount()).To(Equal(0))
+		})
+	})
+
+	When("the policy has a maximum age", func() {
+		var (
+			expectedDeleted int
+			deletedBefore   int64
+		)
+
+		BeforeEach(func() {
+			expectedDeleted = fake.Number(1, 100)
+			client.DeleteByQueryReturns(&esutil.EsDeleteResponse{Deleted: expectedDeleted}, nil)
+			esConfig.Retention.Default = config.RetentionPolicy{
+				MaxAgeDays:  90,
+				ExemptKinds: []string{"ATTESTATION"},
+			}
+
+			deletedBefore = metricValue(retentionDeletedMetric)
+		})
+
+		It("should delete expired occurrences that aren't exempt", func() {
+			Expect(client.DeleteByQueryCallCount()).To(Equal(1))
+
+			_, deleteRequest := client.DeleteByQueryArgsForCall(0)
+			Expect(deleteRequest.Index).To(Equal(occurrencesAlias(expectedProjectIds[0])))
+			Expect(deleteRequest.Search.Query).To(Equal(&filtering.Query{
+				Bool: &filtering.Bool{
+					Filter: &filtering.Filter{
+						&filtering.Query{
+							Range: &filtering.Range{
+								"createTime": {
+									Less: "now-90d",
+								},
+							},
+						},
+					},
+					MustNot: &filtering.MustNot{
+						&filtering.Query{
+							Terms: &filtering.Terms{
+								"kind": {"ATTESTATION"},
+							},
+						},
+					},
+				},
+			}))
+		})
+
+		It("should report the number of occurrences deleted", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualReports).To(ConsistOf(&RetentionReport{
+				ProjectId: expectedProjectIds[0],
+				Expired:   expectedDeleted,
+			}))
+		})
+
+		It("should record the deleted occurrences in the metrics", func() {
+			Expect(metricValue(retentionDeletedMetric)).To(Equal(deletedBefore + int64(expectedDeleted)))
+		})
+
+		When("retention is a dry run", func() {
+			var expectedCount int
+
+			BeforeEach(func() {
+				esConfig.Retention.DryRun = true
+				expectedCount = fake.Number(1, 100)
+				client.CountReturns(expectedCount, nil)
+			})
+
+			It("should count the occurrences instead of deleting them", func() {
+				Expect(client.DeleteByQueryCallCount()).To(Equal(0))
+				Expect(client.CountCallCount()).To(Equal(1))
+
+				_, countRequest := client.CountArgsForCall(0)
+				Expect(countRequest.Index).To(Equal(occurrencesAlias(expectedProjectIds[0])))
+				Expect(actualReports).To(ConsistOf(&RetentionReport{
+					ProjectId: expectedProjectIds[0],
+					DryRun:    true,
+					Expired:   expectedCount,
+				}))
+			})
+		})
+
+		When("a project has its own policy", func() {
+			BeforeEach(func() {
+				expectedProjectIds = append(expectedProjectIds, fake.LetterN(10))
+				esConfig.Retention.Projects = map[string]config.RetentionPolicy{
+					expectedProjectIds[1]: {},
+				}
+			})
+
+			It("should use the project's policy instead of the default", func() {
+				Expect(client.DeleteByQueryCallCount()).To(Equal(1))
+
+				_, deleteRequest := client.DeleteByQueryArgsForCall(0)
+				Expect(deleteRequest.Index).To(Equal(occurrencesAlias(expectedProjectIds[0])))
+				Expect(actualReports).To(HaveLen(1))
+			})
+		})
+
+		When("deleting occurrences fails for a project", func() {
+			BeforeEach(func() {
+				expectedProjectIds = append(expectedProjectIds, fake.LetterN(10))
+				client.DeleteByQueryCalls(func(_ context.Context, request *esutil.DeleteRequest) (*esutil.EsDeleteResponse, error) {
+					if request.Index == occurrencesAlias(expectedProjectIds[0]) {
+						return nil, errors.New("delete failed")
+					}
+
+					return &esutil.EsDeleteResponse{Deleted: expectedDeleted}, nil
+				})
+			})
+
+			It("should continue with the remaining projects", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(actualErr.Error()).To(ContainSubstring(expectedProjectIds[0]))
+				Expect(actualReports).To(ConsistOf(&RetentionReport{
+					ProjectId: expectedProjectIds[1],
+					Expired:   expectedDeleted,
+				}))
+			})
+		})
+	})
+
+	When("the policy keeps the latest occurrences", func() {
+		var (
+			resourceUri string
+			noteName    string
+		)
+
+		BeforeEach(func() {
+			esConfig.Retention.Default = config.RetentionPolicy{
+				KeepLatest: 1,
+			}
+
+			resourceUri = fake.URL()
+			noteName = fmt.Sprintf("projects/%s/notes/%s", fake.LetterN(10), fake.LetterN(10))
+			for i := 0; i < 3; i++ {
+				occurrence := generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectIds[0], fake.LetterN(10)))
+				occurrence.Resource.Uri = resourceUri
+				occurrence.NoteName = noteName
+				expectedOccurrences = append(expectedOccurrences, occurrence)
+			}
+			expectedOccurrences = append(expectedOccurrences, generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectIds[0], fake.LetterN(10))))
+
+			client.DeleteByQueryReturns(&esutil.EsDeleteResponse{Deleted: 2}, nil)
+		})
+
+		It("should search the project's occurrences by resource and note, from newest to oldest", func() {
+			Expect(client.OpenPointInTimeCallCount()).To(Equal(1))
+			_, index, keepAlive := client.OpenPointInTimeArgsForCall(0)
+			Expect(index).To(Equal(occurrencesAlias(expectedProjectIds[0])))
+			Expect(keepAlive).To(Equal(retentionKeepAlive))
+
+			_, searchRequest := client.SearchArgsForCall(1)
+			Expect(searchRequest.Index).To(BeEmpty())
+			Expect(searchRequest.Search.Query).To(BeNil())
+			Expect(searchRequest.Search.Sort).To(Equal([]esutil.EsSortField{
+				{Field: "resource.uri", Order: esutil.EsSortOrderAscending},
+				{Field: "noteName", Order: esutil.EsSortOrderAscending},
+				{Field: sortField, Order: esutil.EsSortOrderDescending},
+			}))
+			Expect(searchRequest.Search.Pit).To(Equal(&esutil.EsSearchPit{
+				Id:        expectedPitId,
+				KeepAlive: retentionKeepAlive,
+			}))
+			Expect(searchRequest.Search.SearchAfter).To(BeNil())
+		})
+
+		It("should page through the occurrences after the last hit", func() {
+			Expect(client.SearchCallCount()).To(Equal(3))
+
+			_, searchRequest := client.SearchArgsForCall(2)
+			Expect(searchRequest.Search.SearchAfter).To(Equal([]interface{}{len(expectedOccurrences) - 1}))
+		})
+
+		It("should close the point in time used for the search", func() {
+			Expect(client.ClosePointInTimeCallCount()).To(Equal(1))
+
+			_, pitId := client.ClosePointInTimeArgsForCall(0)
+			Expect(pitId).To(Equal(expectedPitId))
+		})
+
+		When("opening the point in time fails", func() {
+			BeforeEach(func() {
+				client.OpenPointInTimeReturns("", errors.New("open failed"))
+			})
+
+			It("should report an error for the project without deleting anything", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(client.DeleteByQueryCallCount()).To(Equal(0))
+				Expect(client.ClosePointInTimeCallCount()).To(Equal(0))
+			})
+		})
+
+		It("should delete all but the latest occurrence for each resource and note", func() {
+			Expect(client.DeleteByQueryCallCount()).To(Equal(1))
+
+			_, deleteRequest := client.DeleteByQueryArgsForCall(0)
+			Expect(deleteRequest.Search.Query.Terms).To(Equal(&filtering.Terms{
+				"name": {expectedOccurrences[1].Name, expectedOccurrences[2].Name},
+			}))
+			Expect(actualReports).To(ConsistOf(&RetentionReport{
+				ProjectId:  expectedProjectIds[0],
+				Superseded: 2,
+			}))
+		})
+
+		When("the occurrences span several pages", func() {
+			BeforeEach(func() {
+				pages := [][]*pb.Occurrence{expectedOccurrences[:2], expectedOccurrences[2:]}
+				client.SearchCalls(func(_ context.Context, request *esutil.SearchRequest) (*esutil.SearchResponse, error) {
+					if request.Index == expectedProjectAlias {
+						return searchHits(&prpb.Project{Name: "projects/" + expectedProjectIds[0]}), nil
+					}
+
+					page := 0
+					if request.Search.SearchAfter != nil {
+						page = request.Search.SearchAfter[0].(int) + 1
+					}
+					if page == len(pages) {
+						return searchHits(), nil
+					}
+
+					var occurrences []proto.Message
+					for _, occurrence := range pages[page] {
+						occurrences = append(occurrences, occurrence)
+					}
+
+					response := searchHits(occurrences...)
+					for _, hit := range response.Hits.Hits {
+						hit.Sort = []interface{}{page}
+					}
+
+					return response, nil
+				})
+				client.DeleteByQueryReturns(&esutil.EsDeleteResponse{Deleted: 1}, nil)
+			})
+
+			It("should delete each page's superseded occurrences before reading the next page", func() {
+				Expect(client.DeleteByQueryCallCount()).To(Equal(2))
+
+				_, firstDelete := client.DeleteByQueryArgsForCall(0)
+				Expect(firstDelete.Search.Query.Terms).To(Equal(&filtering.Terms{
+					"name": {expectedOccurrences[1].Name},
+				}))
+				_, secondDelete := client.DeleteByQueryArgsForCall(1)
+				Expect(secondDelete.Search.Query.Terms).To(Equal(&filtering.Terms{
+					"name": {expectedOccurrences[2].Name},
+				}))
+
+				Expect(actualReports).To(ConsistOf(&RetentionReport{
+					ProjectId:  expectedProjectIds[0],
+					Superseded: 2,
+				}))
+			})
+		})
+
+		When("deleting a page of superseded occurrences fails", func() {
+			BeforeEach(func() {
+				client.DeleteByQueryReturns(nil, errors.New("delete failed"))
+			})
+
+			It("should stop the scan and close the point in time", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(client.SearchCallCount()).To(Equal(2))
+				Expect(client.ClosePointInTimeCallCount()).To(Equal(1))
+			})
+		})
+
+		When("the policy also has a maximum age", func() {
+			BeforeEach(func() {
+				esConfig.Retention.Default.MaxAgeDays = 30
+			})
+
+			It("should leave expired occurrences out of the search", func() {
+				_, searchRequest := client.SearchArgsForCall(1)
+				Expect(searchRequest.Search.Query).To(Equal(&filtering.Query{
+					Range: &filtering.Range{
+						"createTime": {
+							GreaterEquals: "now-30d",
+						},
+					},
+				}))
+			})
+		})
+
+		When("no occurrences are superseded", func() {
+			BeforeEach(func() {
+				esConfig.Retention.Default.KeepLatest = 3
+			})
+
+			It("should not delete anything", func() {
+				Expect(client.DeleteByQueryCallCount()).To(Equal(0))
+				Expect(actualReports).To(ConsistOf(&RetentionReport{
+					ProjectId: expectedProjectIds[0],
+				}))
+			})
+		})
+	})
+
+	When("listing projects fails", func() {
+		BeforeEach(func() {
+			esConfig.Retention.Default.MaxAgeDays = 1
+			client.SearchCalls(nil)
+			client.SearchReturns(nil, errors.New("search failed"))
+		})
+
+		It("should return an error", func() {
+			Expect(actualErr).To(HaveOccurred())
+			Expect(client.DeleteByQueryCallCount()).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,201 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"go.uber.org/zap"
+)
+
+const (
+	rolloverInner = "rollover"
+	// rolloverIndexType replaces the mapping's _meta.type on rollover indices, so that the index manager doesn't try to migrate them.
+	// Mapping changes are picked up by the next index in the series instead.
+	rolloverIndexType      = "grafeas-rollover"
+	rolloverFirstIndex     = "000001"
+	rolloverWriteAliasName = "write"
+	// rolloverIndexPattern matches the numbered suffix of a project's rollover indices, which is zero-padded to six digits.
+	// Project IDs can contain "-", so matching any suffix would also match the indices of other projects whose IDs start with this one's.
+	rolloverIndexPattern = "-0*"
+	// rolloverMigrationPageSize is how many projects are checked at a time when migrating existing projects to rollover
+	rolloverMigrationPageSize = 1000
+)
+
+// initializeRollover installs the lifecycle policy that rolls over occurrence indices, and the component template that
+// gives each new index the current occurrence mappings and that policy
+func (es *ElasticsearchStorage) initializeRollover(ctx context.Context) error {
+	mapping := es.indexManager.Mapping(occurrencesDocumentKind)
+	if mapping == nil {
+		return fmt.Errorf("unable to find a mapping for document kind %s", occurrencesDocumentKind)
+	}
+
+	policyName := es.occurrencesRolloverName()
+	err := es.client.PutLifecyclePolicy(ctx, policyName, &esutil.EsLifecyclePolicy{
+		Policy: &esutil.EsLifecyclePolicyPhases{
+			Phases: map[string]*esutil.EsLifecyclePhase{
+				"hot": {
+					Actions: &esutil.EsLifecycleActions{
+						Rollover: &esutil.EsRolloverAction{
+							MaxAge:  es.config.Rollover.MaxAge,
+							MaxSize: es.config.Rollover.MaxSize,
+							MaxDocs: es.config.Rollover.MaxDocs,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating occurrence lifecycle policy: %s", err)
+	}
+
+	mappings := map[string]interface{}{}
+	for key, value := range mapping.Mappings {
+		mappings[key] = value
+	}
+	mappings["_meta"] = map[string]interface{}{
+		"type": rolloverIndexType,
+	}
+
+	err = es.client.PutComponentTemplate(ctx, policyName, &esutil.EsIndexTemplate{
+		Template: &esutil.EsCreateIndex{
+			Settings: map[string]interface{}{
+				"index.lifecycle.name": policyName,
+			},
+			Mappings: mappings,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating occurrence component template: %s", err)
+	}
+
+	return nil
+}
+
+// migrateRolloverProjects starts the series of occurrence indices for projects that were created before rollover was enabled,
+// which don't have a write alias. The project's existing occurrence index keeps its read alias, so the occurrences in it are still
+// found and updated in place, while new occurrences are written to the series. The existing index is deleted along with the project.
+func (es *ElasticsearchStorage) migrateRolloverProjects(ctx context.Context) error {
+	log := es.logger.Named("migrateRolloverProjects")
+
+	pageToken := ""
+	for {
+		projects, nextPageToken, err := es.ListProjects(ctx, "", rolloverMigrationPageSize, pageToken)
+		if err != nil {
+			return fmt.Errorf("error listing projects to migrate to rollover: %s", err)
+		}
+
+		for _, project := range projects {
+			projectId := strings.TrimPrefix(project.Name, "projects/")
+			writeIndices, err := es.client.AliasIndices(ctx, es.occurrencesWriteAlias(projectId))
+			if err != nil {
+				return fmt.Errorf("error finding the occurrence write index for project %s: %s", projectId, err)
+			}
+			if len(writeIndices) > 0 {
+				continue
+			}
+
+			log.Info("migrating project to rollover", zap.String("project", project.Name))
+			if err := es.createRolloverIndex(ctx, projectId); err != nil {
+				return fmt.Errorf("error migrating project %s to rollover: %s", projectId, err)
+			}
+		}
+
+		if nextPageToken == "" {
+			return nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+// createRolloverIndex starts the series of occurrence indices for a project. The project's index template adds the read alias
+// to every index in the series, and tells the lifecycle policy which alias to roll over. The write alias only ever points to the newest index.
+func (es *ElasticsearchStorage) createRolloverIndex(ctx context.Context, projectId string) error {
+	templateName := es.occurrencesRolloverIndex(projectId)
+	err := es.client.PutIndexTemplate(ctx, templateName, &esutil.EsIndexTemplate{
+		IndexPatterns: []string{templateName + rolloverIndexPattern},
+		ComposedOf:    []string{es.occurrencesRolloverName()},
+		Template: &esutil.EsCreateIndex{
+			Settings: map[string]interface{}{
+				"index.lifecycle.rollover_alias": es.occurrencesWriteAlias(projectId),
+			},
+			Aliases: map[string]*esutil.EsAlias{
+				es.occurrencesAlias(projectId): {},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating occurrence index template: %s", err)
+	}
+
+	err = es.client.CreateIndex(ctx, fmt.Sprintf("%s-%s", templateName, rolloverFirstIndex), &esutil.EsCreateIndex{
+		Aliases: map[string]*esutil.EsAlias{
+			es.occurrencesWriteAlias(projectId): {
+				IsWriteIndex: true,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating occurrence index: %s", err)
+	}
+
+	return nil
+}
+
+// deleteRolloverIndices deletes every index in the project's series, along with the template used to create them.
+// The indices are found through the project's read alias rather than a pattern, which could match the indices of other projects.
+func (es *ElasticsearchStorage) deleteRolloverIndices(ctx context.Context, projectId string) error {
+	templateName := es.occurrencesRolloverIndex(projectId)
+	if err := es.client.DeleteIndexTemplate(ctx, templateName); err != nil {
+		return err
+	}
+
+	indices, err := es.client.AliasIndices(ctx, es.occurrencesAlias(projectId))
+	if err != nil {
+		return fmt.Errorf("error finding occurrence indices: %s", err)
+	}
+
+	for _, index := range indices {
+		if err := es.indexManager.DeleteIndex(ctx, index); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// occurrencesWriteAlias is the alias that new occurrences are written to. Reads use occurrencesAlias, which covers every index
+// when rollover is enabled.
+func (es *ElasticsearchStorage) occurrencesWriteAlias(projectId string) string {
+	if !es.config.Rollover.Enabled {
+		return es.occurrencesAlias(projectId)
+	}
+
+	return fmt.Sprintf("%s-%s", es.occurrencesAlias(projectId), rolloverWriteAliasName)
+}
+
+// occurrencesRolloverName names the lifecycle policy and component template shared by every project
+func (es *ElasticsearchStorage) occurrencesRolloverName() string {
+	return es.indexManager.AliasName(occurrencesDocumentKind, rolloverInner)
+}
+
+// occurrencesRolloverIndex is the prefix of the project's rollover indices, which are numbered from 000001
+func (es *ElasticsearchStorage) occurrencesRolloverIndex(projectId string) string {
+	return es.indexManager.AliasName(occurrencesDocumentKind, fmt.Sprintf("%s-%s", rolloverInner, projectId))
+}
@@ -0,0 +1,385 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rode/es-index-manager/indexmanager"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var _ = Describe("rollover", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId        string
+		expectedOccurrencesAlias string
+		expectedWriteAlias       string
+		expectedRolloverName     string
+		expectedRolloverIndex    string
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		expectedProjectId = fake.LetterN(10)
+		expectedOccurrencesAlias = fmt.Sprintf("grafeas-%s-occurrences", expectedProjectId)
+		expectedWriteAlias = expectedOccurrencesAlias + "-write"
+		expectedRolloverName = "grafeas-rollover-occurrences"
+		expectedRolloverIndex = fmt.Sprintf("grafeas-rollover-%s-occurrences", expectedProjectId)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+			Rollover: config.RolloverConfig{
+				Enabled: true,
+				MaxAge:  "30d",
+				MaxDocs: fake.Number(1000, 10000),
+			},
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+		indexManager.IndexNameCalls(func(documentKind string, inner string) string {
+			return fmt.Sprintf("grafeas-v1-%s-%s", inner, documentKind)
+		})
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Initialize", func() {
+		var (
+			expectedMapping *indexmanager.VersionedMapping
+			actualErr       error
+		)
+
+		BeforeEach(func() {
+			expectedMapping = &indexmanager.VersionedMapping{
+				Version: "v1",
+				Mappings: map[string]interface{}{
+					"_meta": map[string]interface{}{
+						"type": "grafeas",
+					},
+					"properties": map[string]interface{}{
+						fake.LetterN(10): fake.LetterN(10),
+					},
+				},
+			}
+			indexManager.MappingReturns(expectedMapping)
+
+			migratedProjectId := fake.LetterN(10)
+			var hits []*esutil.EsSearchResponseHit
+			for _, projectId := range []string{expectedProjectId, migratedProjectId} {
+				projectJson, err := protojson.Marshal(proto.MessageV2(generateTestProject(projectId)))
+				Expect(err).ToNot(HaveOccurred())
+
+				hits = append(hits, &esutil.EsSearchResponseHit{Source: projectJson})
+			}
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: len(hits)},
+					Hits:  hits,
+				},
+			}, nil)
+
+			client.AliasIndicesCalls(func(_ context.Context, alias string) ([]string, error) {
+				if alias == fmt.Sprintf("grafeas-%s-occurrences-write", migratedProjectId) {
+					return []string{fmt.Sprintf("grafeas-rollover-%s-occurrences-000001", migratedProjectId)}, nil
+				}
+
+				return nil, nil
+			})
+		})
+
+		JustBeforeEach(func() {
+			actualErr = elasticsearchStorage.Initialize(ctx)
+		})
+
+		It("should install the lifecycle policy", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(client.PutLifecyclePolicyCallCount()).To(Equal(1))
+
+			_, name, policy := client.PutLifecyclePolicyArgsForCall(0)
+			Expect(name).To(Equal(expectedRolloverName))
+			Expect(policy.Policy.Phases["hot"].Actions.Rollover).To(Equal(&esutil.EsRolloverAction{
+				MaxAge:  esConfig.Rollover.MaxAge,
+				MaxDocs: esConfig.Rollover.MaxDocs,
+			}))
+		})
+
+		It("should install a component template with the occurrence mappings", func() {
+			Expect(client.PutComponentTemplateCallCount()).To(Equal(1))
+
+			_, name, template := client.PutComponentTemplateArgsForCall(0)
+			Expect(name).To(Equal(expectedRolloverName))
+			Expect(template.Template.Settings).To(HaveKeyWithValue("index.lifecycle.name", expectedRolloverName))
+			Expect(template.Template.Mappings["properties"]).To(Equal(expectedMapping.Mappings["properties"]))
+		})
+
+		It("should keep the index manager from migrating rollover indices", func() {
+			_, _, template := client.PutComponentTemplateArgsForCall(0)
+
+			Expect(template.Template.Mappings["_meta"]).To(Equal(map[string]interface{}{
+				"type": rolloverIndexType,
+			}))
+			Expect(expectedMapping.Mappings["_meta"]).To(Equal(map[string]interface{}{
+				"type": "grafeas",
+			}))
+		})
+
+		It("should start the series of occurrence indices for existing projects without a write alias", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(client.AliasIndicesCallCount()).To(Equal(2))
+
+			Expect(client.PutIndexTemplateCallCount()).To(Equal(1))
+			_, templateName, _ := client.PutIndexTemplateArgsForCall(0)
+			Expect(templateName).To(Equal(expectedRolloverIndex))
+
+			Expect(client.CreateIndexCallCount()).To(Equal(1))
+			_, indexName, index := client.CreateIndexArgsForCall(0)
+			Expect(indexName).To(Equal(expectedRolloverIndex + "-000001"))
+			Expect(index.Aliases).To(HaveKeyWithValue(expectedWriteAlias, &esutil.EsAlias{IsWriteIndex: true}))
+		})
+
+		When("listing the existing projects fails", func() {
+			BeforeEach(func() {
+				client.SearchReturns(nil, errors.New("search failed"))
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(client.CreateIndexCallCount()).To(Equal(0))
+			})
+		})
+
+		When("finding a project's write alias fails", func() {
+			BeforeEach(func() {
+				client.AliasIndicesReturns(nil, errors.New("get alias failed"))
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(client.CreateIndexCallCount()).To(Equal(0))
+			})
+		})
+
+		When("starting a project's series fails", func() {
+			BeforeEach(func() {
+				client.CreateIndexReturns(errors.New("create index failed"))
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+			})
+		})
+
+		When("installing the lifecycle policy fails", func() {
+			BeforeEach(func() {
+				client.PutLifecyclePolicyReturns(errors.New("put policy failed"))
+			})
+
+			It("should return an error", func() {
+				Expect(actualErr).To(HaveOccurred())
+				Expect(client.PutComponentTemplateCallCount()).To(Equal(0))
+			})
+		})
+
+		When("rollover is disabled", func() {
+			BeforeEach(func() {
+				esConfig.Rollover.Enabled = false
+			})
+
+			It("should not install the lifecycle policy", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(client.PutLifecyclePolicyCallCount()).To(Equal(0))
+				Expect(client.PutComponentTemplateCallCount()).To(Equal(0))
+				Expect(client.AliasIndicesCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("CreateProject", func() {
+		var actualErr error
+
+		BeforeEach(func() {
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{},
+				},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			_, actualErr = elasticsearchStorage.CreateProject(ctx, expectedProjectId, &prpb.Project{})
+		})
+
+		It("should create a template for the project's occurrence indices", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(client.PutIndexTemplateCallCount()).To(Equal(1))
+
+			_, name, template := client.PutIndexTemplateArgsForCall(0)
+			Expect(name).To(Equal(expectedRolloverIndex))
+			Expect(template.IndexPatterns).To(ConsistOf(expectedRolloverIndex + "-0*"))
+			Expect(template.ComposedOf).To(ConsistOf(expectedRolloverName))
+			Expect(template.Template.Settings).To(HaveKeyWithValue("index.lifecycle.rollover_alias", expectedWriteAlias))
+			Expect(template.Template.Aliases).To(HaveKey(expectedOccurrencesAlias))
+		})
+
+		It("should create the first occurrence index with the write alias", func() {
+			Expect(client.CreateIndexCallCount()).To(Equal(1))
+
+			_, index, request := client.CreateIndexArgsForCall(0)
+			Expect(index).To(Equal(expectedRolloverIndex + "-000001"))
+			Expect(request.Aliases).To(Equal(map[string]*esutil.EsAlias{
+				expectedWriteAlias: {IsWriteIndex: true},
+			}))
+		})
+
+		It("should only use the index manager for the notes index", func() {
+			Expect(indexManager.CreateIndexCallCount()).To(Equal(1))
+
+			_, _, _, documentKind := indexManager.CreateIndexArgsForCall(0)
+			Expect(documentKind).To(Equal(notesDocumentKind))
+		})
+
+		When("creating the occurrence index fails", func() {
+			BeforeEach(func() {
+				client.CreateIndexReturns(errors.New("create index failed"))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			})
+		})
+	})
+
+	Context("DeleteProject", func() {
+		var (
+			actualErr       error
+			expectedIndices []string
+		)
+
+		BeforeEach(func() {
+			expectedIndices = []string{expectedRolloverIndex + "-000001", expectedRolloverIndex + "-000002"}
+			client.AliasIndicesReturns(expectedIndices, nil)
+		})
+
+		JustBeforeEach(func() {
+			actualErr = elasticsearchStorage.DeleteProject(ctx, expectedProjectId)
+		})
+
+		It("should delete the project's template and every occurrence index", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(client.DeleteIndexTemplateCallCount()).To(Equal(1))
+
+			_, name := client.DeleteIndexTemplateArgsForCall(0)
+			Expect(name).To(Equal(expectedRolloverIndex))
+
+			Expect(client.AliasIndicesCallCount()).To(Equal(1))
+			_, alias := client.AliasIndicesArgsForCall(0)
+			Expect(alias).To(Equal(expectedOccurrencesAlias))
+
+			var deletedIndices []string
+			for i := 0; i < indexManager.DeleteIndexCallCount(); i++ {
+				_, index := indexManager.DeleteIndexArgsForCall(i)
+				deletedIndices = append(deletedIndices, index)
+			}
+			Expect(deletedIndices).To(ConsistOf(expectedIndices[0], expectedIndices[1], fmt.Sprintf("grafeas-v1-%s-notes", expectedProjectId)))
+		})
+
+		When("finding the occurrence indices fails", func() {
+			BeforeEach(func() {
+				client.AliasIndicesReturns(nil, errors.New("get alias failed"))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+			})
+		})
+	})
+
+	Context("writing occurrences", func() {
+		BeforeEach(func() {
+			projectJson, err := protojson.Marshal(proto.MessageV2(generateTestProject(expectedProjectId)))
+			Expect(err).ToNot(HaveOccurred())
+
+			client.SearchReturns(&esutil.SearchResponse{
+				Hits: &esutil.EsSearchResponseHits{
+					Total: &esutil.EsSearchResponseTotal{Value: 1},
+					Hits: []*esutil.EsSearchResponseHit{
+						{Source: projectJson},
+					},
+				},
+			}, nil)
+			client.BulkReturns(&esutil.EsBulkResponse{
+				Items: []*esutil.EsBulkResponseItem{
+					{Create: &esutil.EsIndexDocResponse{}},
+				},
+			}, nil)
+		})
+
+		It("should create occurrences through the write alias", func() {
+			_, err := elasticsearchStorage.CreateOccurrence(ctx, expectedProjectId, "", generateTestOccurrence(""))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, createRequest := client.CreateArgsForCall(0)
+			Expect(createRequest.Index).To(Equal(expectedWriteAlias))
+		})
+
+		It("should batch create occurrences through the write alias", func() {
+			_, errs := elasticsearchStorage.BatchCreateOccurrences(ctx, expectedProjectId, "", []*pb.Occurrence{generateTestOccurrence("")})
+			Expect(errs).To(BeEmpty())
+
+			_, bulkRequest := client.BulkArgsForCall(0)
+			Expect(bulkRequest.Index).To(Equal(expectedWriteAlias))
+		})
+	})
+})
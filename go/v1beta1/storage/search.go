@@ -0,0 +1,184 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"go.uber.org/zap"
+)
+
+// NoteSearchResult is a note that matched a search, along with the highlighted fragments of each text field that matched
+type NoteSearchResult struct {
+	Note       *pb.Note
+	Highlights map[string][]string
+}
+
+// OccurrenceSearchResult is an occurrence that matched a search, along with the highlighted fragments of each text field that matched
+type OccurrenceSearchResult struct {
+	Occurrence *pb.Occurrence
+	Highlights map[string][]string
+}
+
+// allProjects matches the alias of every project when used in place of a project ID
+const allProjects = "*"
+
+// searchHighlight requests highlights for every field that can be matched with the search() filter function
+var searchHighlight = newSearchHighlight()
+
+func newSearchHighlight() *esutil.EsHighlight {
+	highlight := &esutil.EsHighlight{
+		Fields: map[string]*esutil.EsHighlightField{},
+	}
+	for _, field := range filtering.SearchFields {
+		highlight.Fields[field] = &esutil.EsHighlightField{}
+	}
+
+	return highlight
+}
+
+// SearchNotes returns up to pageSize number of notes in the project that match the filter, beginning at pageToken.
+// Each result includes fragments of the text that matched a search() in the filter, so that callers can show why a note matched.
+func (es *ElasticsearchStorage) SearchNotes(ctx context.Context, projectId, filter, pageToken string, pageSize int32) ([]*NoteSearchResult, string, error) {
+	projectName := fmt.Sprintf("projects/%s", projectId)
+	log := es.logger.Named("SearchNotes").With(zap.String("project", projectName))
+
+	res, nextPageToken, err := es.genericList(ctx, log, notesDocumentKind, es.notesAlias(projectId), filter, true, &esutil.EsSearch{Highlight: searchHighlight}, pageToken, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var results []*NoteSearchResult
+	for _, hit := range res.Hits {
+		hitLogger := log.With(zap.String("note raw", string(hit.Source)))
+
+		note := &pb.Note{}
+		if err := decodeDocument(hit.Source, note); err != nil {
+			return nil, "", createError(hitLogger, "error converting _doc to note", err)
+		}
+
+		highlights, err := decodeHighlights(hit.Highlights)
+		if err != nil {
+			return nil, "", createError(hitLogger, "error decoding highlights", err)
+		}
+
+		results = append(results, &NoteSearchResult{
+			Note:       note,
+			Highlights: highlights,
+		})
+	}
+
+	return results, nextPageToken, nil
+}
+
+// SearchOccurrences returns up to pageSize number of occurrences in the project that match the filter, beginning at pageToken.
+// Each result includes fragments of the text that matched a search() in the filter.
+func (es *ElasticsearchStorage) SearchOccurrences(ctx context.Context, projectId, filter, pageToken string, pageSize int32) ([]*OccurrenceSearchResult, string, error) {
+	projectName := fmt.Sprintf("projects/%s", projectId)
+	log := es.logger.Named("SearchOccurrences").With(zap.String("project", projectName))
+
+	res, nextPageToken, err := es.genericList(ctx, log, occurrencesDocumentKind, es.occurrencesAlias(projectId), filter, true, &esutil.EsSearch{Highlight: searchHighlight}, pageToken, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	results, err := occurrenceSearchResults(log, res)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return results, nextPageToken, nil
+}
+
+// SearchAllOccurrences returns up to pageSize number of occurrences across every project that match the filter,
+// beginning at pageToken. If projectIds isn't empty, only occurrences in those projects are returned.
+func (es *ElasticsearchStorage) SearchAllOccurrences(ctx context.Context, projectIds []string, filter, pageToken string, pageSize int32) ([]*OccurrenceSearchResult, string, error) {
+	log := es.logger.Named("SearchAllOccurrences").With(zap.Strings("projects", projectIds))
+
+	search := &esutil.EsSearch{
+		Highlight: searchHighlight,
+	}
+	if len(projectIds) > 0 {
+		projects := filtering.Should{}
+		for _, projectId := range projectIds {
+			projects = append(projects, &filtering.Query{
+				Prefix: &filtering.Term{
+					"name": fmt.Sprintf("projects/%s/", projectId),
+				},
+			})
+		}
+
+		search.Query = &filtering.Query{
+			Bool: &filtering.Bool{
+				Should: &projects,
+			},
+		}
+	}
+
+	res, nextPageToken, err := es.genericList(ctx, log, occurrencesDocumentKind, es.occurrencesAlias(allProjects), filter, true, search, pageToken, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	results, err := occurrenceSearchResults(log, res)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return results, nextPageToken, nil
+}
+
+func occurrenceSearchResults(log *zap.Logger, res *esutil.EsSearchResponseHits) ([]*OccurrenceSearchResult, error) {
+	var results []*OccurrenceSearchResult
+	for _, hit := range res.Hits {
+		hitLogger := log.With(zap.String("occurrence raw", string(hit.Source)))
+
+		occurrence := &pb.Occurrence{}
+		if err := decodeDocument(hit.Source, occurrence); err != nil {
+			return nil, createError(hitLogger, "error converting _doc to occurrence", err)
+		}
+
+		highlights, err := decodeHighlights(hit.Highlights)
+		if err != nil {
+			return nil, createError(hitLogger, "error decoding highlights", err)
+		}
+
+		results = append(results, &OccurrenceSearchResult{
+			Occurrence: occurrence,
+			Highlights: highlights,
+		})
+	}
+
+	return results, nil
+}
+
+// decodeHighlights converts the highlights of a hit into a map of field names to fragments, or nil if nothing was highlighted
+func decodeHighlights(raw json.RawMessage) (map[string][]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var highlights map[string][]string
+	if err := json.Unmarshal(raw, &highlights); err != nil {
+		return nil, err
+	}
+
+	return highlights, nil
+}
@@ -0,0 +1,400 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var _ = Describe("search", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+
+		expectedProjectId           string
+		expectedOccurrencesAlias    string
+		expectedAllOccurrencesAlias string
+		expectedNotesAlias          string
+
+		expectedFilter        string
+		expectedQuery         *filtering.Query
+		expectedPageSize      int
+		expectedPageToken     string
+		expectedNextPageToken string
+		expectedHighlights    map[string][]string
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		expectedProjectId = fake.LetterN(10)
+		expectedOccurrencesAlias = fake.LetterN(10)
+		expectedNotesAlias = fake.LetterN(10)
+		expectedAllOccurrencesAlias = fake.LetterN(10)
+
+		expectedFilter = fmt.Sprintf(`search("%s")`, fake.LetterN(10))
+		expectedQuery = &filtering.Query{
+			MultiMatch: &filtering.MultiMatch{
+				Query: fake.LetterN(10),
+			},
+		}
+		expectedPageSize = fake.Number(10, 20)
+		expectedPageToken = fake.LetterN(10)
+		expectedNextPageToken = fake.LetterN(10)
+		expectedHighlights = map[string][]string{
+			"shortDescription": {fake.LetterN(10), fake.LetterN(10)},
+		}
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if documentKind == occurrencesDocumentKind && inner == "*" {
+				return expectedAllOccurrencesAlias
+			}
+
+			return map[string]string{
+				occurrencesDocumentKind: expectedOccurrencesAlias,
+				notesDocumentKind:       expectedNotesAlias,
+			}[documentKind]
+		})
+
+		filterer.
+			EXPECT().
+			ParseExpression(expectedFilter, gomock.Any()).
+			Return(expectedQuery, nil).
+			AnyTimes()
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	searchResponse := func(documents []proto.Message, highlights []byte) *esutil.SearchResponse {
+		hits := &esutil.EsSearchResponseHits{}
+		for _, document := range documents {
+			source, err := protojson.Marshal(proto.MessageV2(document))
+			Expect(err).ToNot(HaveOccurred())
+
+			hits.Hits = append(hits.Hits, &esutil.EsSearchResponseHit{
+				Source:     source,
+				Highlights: highlights,
+			})
+		}
+
+		return &esutil.SearchResponse{
+			Hits:          hits,
+			NextPageToken: expectedNextPageToken,
+		}
+	}
+
+	Context("SearchNotes", func() {
+		var (
+			expectedNotes []*pb.Note
+
+			actualResults       []*NoteSearchResult
+			actualNextPageToken string
+			actualErr           error
+		)
+
+		BeforeEach(func() {
+			expectedNotes = generateTestNotes(fake.Number(2, 5), expectedProjectId)
+
+			var documents []proto.Message
+			for _, note := range expectedNotes {
+				documents = append(documents, note)
+			}
+			client.SearchReturns(searchResponse(documents, []byte(fmt.Sprintf(`{"shortDescription":["%s","%s"]}`, expectedHighlights["shortDescription"][0], expectedHighlights["shortDescription"][1]))), nil)
+		})
+
+		JustBeforeEach(func() {
+			actualResults, actualNextPageToken, actualErr = elasticsearchStorage.SearchNotes(ctx, expectedProjectId, expectedFilter, expectedPageToken, int32(expectedPageSize))
+		})
+
+		It("should search the notes alias with highlighting", func() {
+			Expect(client.SearchCallCount()).To(Equal(1))
+
+			_, searchRequest := client.SearchArgsForCall(0)
+			Expect(searchRequest.Index).To(Equal(expectedNotesAlias))
+			Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
+			Expect(searchRequest.Pagination.Size).To(Equal(expectedPageSize))
+			Expect(searchRequest.Pagination.Token).To(Equal(expectedPageToken))
+
+			Expect(searchRequest.Search.Highlight).ToNot(BeNil())
+			for _, field := range filtering.SearchFields {
+				Expect(searchRequest.Search.Highlight.Fields).To(HaveKey(field))
+			}
+		})
+
+		It("should sort by relevance", func() {
+			_, searchRequest := client.SearchArgsForCall(0)
+
+			Expect(searchRequest.Search.Sort[0]).To(Equal(esutil.EsSortField{Field: scoreSortField, Order: esutil.EsSortOrderDescending}))
+		})
+
+		It("should return the notes with their highlights", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualNextPageToken).To(Equal(expectedNextPageToken))
+			Expect(actualResults).To(HaveLen(len(expectedNotes)))
+
+			for i, result := range actualResults {
+				Expect(result.Note).To(Equal(expectedNotes[i]))
+				Expect(result.Highlights).To(Equal(expectedHighlights))
+			}
+		})
+
+		When("a note has no highlights", func() {
+			BeforeEach(func() {
+				client.SearchReturns(searchResponse([]proto.Message{expectedNotes[0]}, nil), nil)
+			})
+
+			It("should return the note without highlights", func() {
+				Expect(actualErr).ToNot(HaveOccurred())
+				Expect(actualResults).To(HaveLen(1))
+				Expect(actualResults[0].Highlights).To(BeNil())
+			})
+		})
+
+		When("the highlights can't be decoded", func() {
+			BeforeEach(func() {
+				client.SearchReturns(searchResponse([]proto.Message{expectedNotes[0]}, []byte(`{"shortDescription":"a"}`)), nil)
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(actualResults).To(BeNil())
+			})
+		})
+
+		When("the search fails", func() {
+			BeforeEach(func() {
+				client.SearchReturns(nil, errors.New(fake.Word()))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(actualResults).To(BeNil())
+				Expect(actualNextPageToken).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("SearchOccurrences", func() {
+		var (
+			expectedOccurrences []*pb.Occurrence
+
+			actualResults       []*OccurrenceSearchResult
+			actualNextPageToken string
+			actualErr           error
+		)
+
+		BeforeEach(func() {
+			expectedOccurrences = generateTestOccurrences(fake.Number(2, 5))
+
+			var documents []proto.Message
+			for _, occurrence := range expectedOccurrences {
+				documents = append(documents, occurrence)
+			}
+			client.SearchReturns(searchResponse(documents, []byte(fmt.Sprintf(`{"shortDescription":["%s","%s"]}`, expectedHighlights["shortDescription"][0], expectedHighlights["shortDescription"][1]))), nil)
+		})
+
+		JustBeforeEach(func() {
+			actualResults, actualNextPageToken, actualErr = elasticsearchStorage.SearchOccurrences(ctx, expectedProjectId, expectedFilter, expectedPageToken, int32(expectedPageSize))
+		})
+
+		It("should search the occurrences alias with highlighting", func() {
+			Expect(client.SearchCallCount()).To(Equal(1))
+
+			_, searchRequest := client.SearchArgsForCall(0)
+			Expect(searchRequest.Index).To(Equal(expectedOccurrencesAlias))
+			Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
+			Expect(searchRequest.Search.Highlight).ToNot(BeNil())
+		})
+
+		It("should return the occurrences with their highlights", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualNextPageToken).To(Equal(expectedNextPageToken))
+			Expect(actualResults).To(HaveLen(len(expectedOccurrences)))
+
+			for i, result := range actualResults {
+				Expect(result.Occurrence).To(Equal(expectedOccurrences[i]))
+				Expect(result.Highlights).To(Equal(expectedHighlights))
+			}
+		})
+
+		When("the search fails", func() {
+			BeforeEach(func() {
+				client.SearchReturns(nil, errors.New(fake.Word()))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(actualResults).To(BeNil())
+				Expect(actualNextPageToken).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("SearchAllOccurrences", func() {
+		var (
+			expectedOccurrences []*pb.Occurrence
+			expectedProjectIds  []string
+
+			actualResults       []*OccurrenceSearchResult
+			actualNextPageToken string
+			actualErr           error
+		)
+
+		BeforeEach(func() {
+			expectedOccurrences = generateTestOccurrences(fake.Number(2, 5))
+			expectedProjectIds = nil
+
+			var documents []proto.Message
+			for _, occurrence := range expectedOccurrences {
+				documents = append(documents, occurrence)
+			}
+			client.SearchReturns(searchResponse(documents, nil), nil)
+		})
+
+		JustBeforeEach(func() {
+			actualResults, actualNextPageToken, actualErr = elasticsearchStorage.SearchAllOccurrences(ctx, expectedProjectIds, expectedFilter, expectedPageToken, int32(expectedPageSize))
+		})
+
+		It("should search the occurrences of every project", func() {
+			Expect(client.SearchCallCount()).To(Equal(1))
+
+			_, searchRequest := client.SearchArgsForCall(0)
+			Expect(searchRequest.Index).To(Equal(expectedAllOccurrencesAlias))
+			Expect(searchRequest.Search.Query).To(Equal(expectedQuery))
+			Expect(searchRequest.Pagination.Size).To(Equal(expectedPageSize))
+			Expect(searchRequest.Pagination.Token).To(Equal(expectedPageToken))
+		})
+
+		It("should return the occurrences", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(actualNextPageToken).To(Equal(expectedNextPageToken))
+			Expect(actualResults).To(HaveLen(len(expectedOccurrences)))
+
+			for i, result := range actualResults {
+				Expect(result.Occurrence).To(Equal(expectedOccurrences[i]))
+			}
+		})
+
+		When("the search is restricted to a list of projects", func() {
+			BeforeEach(func() {
+				expectedProjectIds = []string{fake.LetterN(10), fake.LetterN(10)}
+			})
+
+			It("should only match occurrences in those projects", func() {
+				_, searchRequest := client.SearchArgsForCall(0)
+
+				Expect(searchRequest.Search.Query).To(Equal(&filtering.Query{
+					Bool: &filtering.Bool{
+						Must: &filtering.Must{expectedQuery},
+						Filter: &filtering.Filter{
+							&filtering.Query{
+								Bool: &filtering.Bool{
+									Should: &filtering.Should{
+										&filtering.Query{
+											Prefix: &filtering.Term{
+												"name": fmt.Sprintf("projects/%s/", expectedProjectIds[0]),
+											},
+										},
+										&filtering.Query{
+											Prefix: &filtering.Term{
+												"name": fmt.Sprintf("projects/%s/", expectedProjectIds[1]),
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				}))
+			})
+
+			When("no filter is specified", func() {
+				BeforeEach(func() {
+					expectedFilter = ""
+				})
+
+				It("should only query by project", func() {
+					_, searchRequest := client.SearchArgsForCall(0)
+
+					Expect(*searchRequest.Search.Query.Bool.Should).To(HaveLen(len(expectedProjectIds)))
+					Expect(searchRequest.Search.Query.Bool.Must).To(BeNil())
+				})
+			})
+		})
+
+		When("the filter is invalid", func() {
+			BeforeEach(func() {
+				expectedFilter = fake.LetterN(10)
+				filterer.
+					EXPECT().
+					ParseExpression(expectedFilter, gomock.Any()).
+					Return(nil, &filtering.FilterError{Message: fake.LetterN(10)})
+			})
+
+			It("should return an invalid argument error without searching", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
+				Expect(client.SearchCallCount()).To(Equal(0))
+			})
+		})
+
+		When("the search fails", func() {
+			BeforeEach(func() {
+				client.SearchReturns(nil, errors.New(fake.Word()))
+			})
+
+			It("should return an error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+				Expect(actualResults).To(BeNil())
+				Expect(actualNextPageToken).To(BeEmpty())
+			})
+		})
+	})
+})
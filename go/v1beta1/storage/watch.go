@@ -0,0 +1,233 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	createTimeField = "createTime"
+	updateTimeField = "updateTime"
+
+	// watchSettleTime leaves out the most recent changes, which may not be searchable yet. Elasticsearch refreshes indices every second by default.
+	watchSettleTime = time.Second
+	// watchTimePrecision is the precision of dates in Elasticsearch
+	watchTimePrecision = time.Millisecond
+)
+
+// watchCursor is how far a watch has read through the occurrences that were created and updated.
+// It's given to clients as an opaque string.
+type watchCursor struct {
+	Created *watchPosition `json:"created"`
+	Updated *watchPosition `json:"updated"`
+}
+
+// watchPosition is the time of the last occurrence that was sent, along with the names of the occurrences sent with that time,
+// so that the next search can start at the same time without sending them again
+type watchPosition struct {
+	Time  time.Time `json:"time"`
+	Names []string  `json:"names,omitempty"`
+}
+
+// WatchOccurrences sends the occurrences in the project that match the filter as they're created or updated, checking for them every interval,
+// until the context is cancelled or send returns an error. Each batch is sent with a cursor that can be passed back in to resume from after the batch.
+// Without a cursor, the watch starts from the current time.
+// Occurrences are found by their create and update times, so an occurrence written with a time earlier than the cursor isn't sent,
+// and an occurrence is sent again each time it's updated.
+func (es *ElasticsearchStorage) WatchOccurrences(ctx context.Context, projectId, filter, cursor string, interval time.Duration, send func(occurrences []*pb.Occurrence, cursor string) error) error {
+	log := es.logger.Named("WatchOccurrences").With(zap.String("project", projectId))
+
+	exists, err := es.doesProjectExist(ctx, log, projectId)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		log.Debug("project does not exist")
+		return status.Error(codes.FailedPrecondition, fmt.Sprintf("project with ID %s does not exist", projectId))
+	}
+
+	position, err := parseWatchCursor(cursor)
+	if err != nil {
+		log.Debug("invalid cursor", zap.Error(err))
+		return status.Errorf(codes.InvalidArgument, "invalid cursor: %s", err)
+	}
+
+	var filterQuery *filtering.Query
+	if filter != "" {
+		log = log.With(zap.String("filter", filter))
+		if filterQuery, err = es.filterQuery(log, occurrencesDocumentKind, filter); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		occurrences, next, err := es.pollOccurrences(ctx, projectId, filterQuery, position)
+		if err != nil {
+			// the search was interrupted because the client went away
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return createError(log, "error searching for occurrences", err)
+		}
+
+		if len(occurrences) > 0 {
+			if err := send(occurrences, next.encode()); err != nil {
+				return err
+			}
+			position = next
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Debug("stopping watch")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollOccurrences returns the occurrences that were created or updated after the cursor, along with the cursor after them
+func (es *ElasticsearchStorage) pollOccurrences(ctx context.Context, projectId string, filterQuery *filtering.Query, cursor *watchCursor) ([]*pb.Occurrence, *watchCursor, error) {
+	until := time.Now().Add(-watchSettleTime)
+
+	created, createdPosition, err := es.tailOccurrences(ctx, projectId, createTimeField, filterQuery, cursor.Created, until)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	updated, updatedPosition, err := es.tailOccurrences(ctx, projectId, updateTimeField, filterQuery, cursor.Updated, until)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append(created, updated...), &watchCursor{Created: createdPosition, Updated: updatedPosition}, nil
+}
+
+// tailOccurrences returns the occurrences whose time field is at or after the position and no later than until, oldest first,
+// along with the position after them
+func (es *ElasticsearchStorage) tailOccurrences(ctx context.Context, projectId, timeField string, filterQuery *filtering.Query, position *watchPosition, until time.Time) ([]*pb.Occurrence, *watchPosition, error) {
+	query := &filtering.Query{
+		Bool: &filtering.Bool{
+			Filter: &filtering.Filter{
+				&filtering.Query{
+					Range: &filtering.Range{
+						timeField: {
+							GreaterEquals: position.Time.Format(time.RFC3339Nano),
+							LessEquals:    until.Format(time.RFC3339Nano),
+						},
+					},
+				},
+			},
+		},
+	}
+	if filterQuery != nil {
+		*query.Bool.Filter = append(*query.Bool.Filter, filterQuery)
+	}
+	if len(position.Names) > 0 {
+		query.Bool.MustNot = &filtering.MustNot{
+			&filtering.Query{
+				Terms: &filtering.Terms{
+					"name": position.Names,
+				},
+			},
+		}
+	}
+
+	res, err := es.client.Search(ctx, &esutil.SearchRequest{
+		Index: es.occurrencesAlias(projectId),
+		Search: &esutil.EsSearch{
+			Query: query,
+			Sort: []esutil.EsSortField{
+				{Field: timeField, Order: esutil.EsSortOrderAscending},
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	next := &watchPosition{
+		Time:  position.Time,
+		Names: position.Names,
+	}
+	var occurrences []*pb.Occurrence
+	for _, hit := range res.Hits.Hits {
+		occurrence := &pb.Occurrence{}
+		if err := decodeDocument(hit.Source, occurrence); err != nil {
+			return nil, nil, err
+		}
+
+		occurrenceTime := occurrence.CreateTime
+		if timeField == updateTimeField {
+			occurrenceTime = occurrence.UpdateTime
+		}
+
+		// dates are compared with the precision that they're stored with, so that occurrences with the same stored time are tracked together
+		t := occurrenceTime.AsTime().Truncate(watchTimePrecision)
+		if t.After(next.Time) {
+			next = &watchPosition{Time: t}
+		}
+		next.Names = append(next.Names, occurrence.Name)
+
+		occurrences = append(occurrences, occurrence)
+	}
+
+	return occurrences, next, nil
+}
+
+func parseWatchCursor(cursor string) (*watchCursor, error) {
+	if cursor == "" {
+		now := &watchPosition{Time: time.Now().UTC().Truncate(watchTimePrecision)}
+
+		return &watchCursor{Created: now, Updated: now}, nil
+	}
+
+	cursorJson, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	position := &watchCursor{}
+	if err := json.Unmarshal(cursorJson, position); err != nil {
+		return nil, err
+	}
+	if position.Created == nil || position.Updated == nil {
+		return nil, fmt.Errorf("missing position")
+	}
+
+	return position, nil
+}
+
+func (c *watchCursor) encode() string {
+	cursorJson, _ := json.Marshal(c)
+
+	return base64.URLEncoding.EncodeToString(cursorJson)
+}
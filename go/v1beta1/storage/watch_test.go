@@ -0,0 +1,359 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	immocks "github.com/rode/es-index-manager/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/config"
+	"github.com/rode/grafeas-elasticsearch/go/mocks"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/esutil/esutilfakes"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/storage/filtering"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var _ = Describe("watch", func() {
+	var (
+		elasticsearchStorage *ElasticsearchStorage
+		ctx                  context.Context
+		cancel               context.CancelFunc
+
+		expectedProjectId        string
+		expectedOccurrencesAlias string
+		expectedFilter           string
+		expectedCursor           string
+
+		// occurrenceResponses are returned for the searches of created and updated occurrences, in order
+		occurrenceResponses []*esutil.SearchResponse
+		occurrenceSearches  []*esutil.EsSearch
+		sentBatches         [][]*pb.Occurrence
+		sentCursors         []string
+		sendErr             error
+		batchesBeforeCancel int
+		actualErr           error
+
+		mockCtrl     *gomock.Controller
+		filterer     *mocks.MockFilterer
+		client       *esutilfakes.FakeClient
+		indexManager *immocks.FakeIndexManager
+		esConfig     *config.ElasticsearchConfig
+	)
+
+	searchResponse := func(messages ...proto.Message) *esutil.SearchResponse {
+		response := &esutil.SearchResponse{
+			Hits: &esutil.EsSearchResponseHits{
+				Total: &esutil.EsSearchResponseTotal{Value: len(messages)},
+			},
+		}
+		for _, message := range messages {
+			source, err := protojson.Marshal(proto.MessageV2(message))
+			Expect(err).ToNot(HaveOccurred())
+
+			response.Hits.Hits = append(response.Hits.Hits, &esutil.EsSearchResponseHit{
+				ID:     fake.LetterN(10),
+				Source: source,
+			})
+		}
+
+		return response
+	}
+
+	occurrenceAt := func(createTime time.Time) *pb.Occurrence {
+		occurrence := generateTestOccurrence(fmt.Sprintf("projects/%s/occurrences/%s", expectedProjectId, fake.UUID()))
+		occurrence.CreateTime, _ = ptypes.TimestampProto(createTime)
+
+		return occurrence
+	}
+
+	BeforeEach(func() {
+		// every watch is expected to be cancelled or fail long before the timeout
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		expectedProjectId = fake.LetterN(10)
+		expectedOccurrencesAlias = fmt.Sprintf("grafeas-%s-occurrences", expectedProjectId)
+		expectedFilter = ""
+		expectedCursor = ""
+		occurrenceResponses = nil
+		occurrenceSearches = nil
+		sentBatches = nil
+		sentCursors = nil
+		sendErr = nil
+		batchesBeforeCancel = 1
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		filterer = mocks.NewMockFilterer(mockCtrl)
+		indexManager = &immocks.FakeIndexManager{}
+		client = &esutilfakes.FakeClient{}
+		esConfig = &config.ElasticsearchConfig{
+			URL:     fake.URL(),
+			Refresh: config.RefreshTrue,
+		}
+
+		indexManager.AliasNameCalls(func(documentKind string, inner string) string {
+			if inner == "" {
+				return fmt.Sprintf("grafeas-%s", documentKind)
+			}
+
+			return fmt.Sprintf("grafeas-%s-%s", inner, documentKind)
+		})
+
+		client.SearchCalls(func(_ context.Context, request *esutil.SearchRequest) (*esutil.SearchResponse, error) {
+			if request.Index != expectedOccurrencesAlias {
+				return searchResponse(generateTestProject(expectedProjectId)), nil
+			}
+
+			occurrenceSearches = append(occurrenceSearches, request.Search)
+			if len(occurrenceResponses) == 0 {
+				return searchResponse(), nil
+			}
+
+			response := occurrenceResponses[0]
+			occurrenceResponses = occurrenceResponses[1:]
+
+			return response, nil
+		})
+	})
+
+	JustBeforeEach(func() {
+		elasticsearchStorage = NewElasticsearchStorage(logger, client, filterer, esConfig, indexManager, nil)
+
+		actualErr = elasticsearchStorage.WatchOccurrences(ctx, expectedProjectId, expectedFilter, expectedCursor, time.Millisecond, func(occurrences []*pb.Occurrence, cursor string) error {
+			sentBatches = append(sentBatches, occurrences)
+			sentCursors = append(sentCursors, cursor)
+			if len(sentBatches) >= batchesBeforeCancel {
+				cancel()
+			}
+
+			return sendErr
+		})
+	})
+
+	AfterEach(func() {
+		cancel()
+		mockCtrl.Finish()
+	})
+
+	timeRange := func(search *esutil.EsSearch) (string, *filtering.RangeOperator) {
+		for field, operator := range *(*search.Query.Bool.Filter)[0].(*filtering.Query).Range {
+			return field, operator
+		}
+
+		return "", nil
+	}
+
+	When("occurrences are created", func() {
+		var (
+			startTime           time.Time
+			expectedOccurrences []*pb.Occurrence
+		)
+
+		BeforeEach(func() {
+			startTime = time.Now().UTC()
+			expectedOccurrences = []*pb.Occurrence{
+				occurrenceAt(startTime.Add(time.Second)),
+				occurrenceAt(startTime.Add(2 * time.Second)),
+			}
+			occurrenceResponses = []*esutil.SearchResponse{
+				searchResponse(expectedOccurrences[0], expectedOccurrences[1]),
+				searchResponse(),
+			}
+		})
+
+		It("should send them along with a cursor", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(sentBatches).To(HaveLen(1))
+			Expect(sentBatches[0]).To(HaveLen(2))
+			for i, occurrence := range sentBatches[0] {
+				Expect(proto.Equal(occurrence, expectedOccurrences[i])).To(BeTrue())
+			}
+			Expect(sentCursors[0]).ToNot(BeEmpty())
+		})
+
+		It("should search for occurrences created and updated since the watch started, oldest first", func() {
+			Expect(occurrenceSearches).To(HaveLen(2))
+
+			for i, expectedField := range []string{createTimeField, updateTimeField} {
+				search := occurrenceSearches[i]
+				Expect(search.Sort).To(Equal([]esutil.EsSortField{{Field: expectedField, Order: esutil.EsSortOrderAscending}}))
+
+				field, operator := timeRange(search)
+				Expect(field).To(Equal(expectedField))
+
+				from, err := time.Parse(time.RFC3339Nano, operator.GreaterEquals.(string))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(from).To(BeTemporally("~", startTime, time.Second))
+				Expect(search.Query.Bool.MustNot).To(BeNil())
+			}
+		})
+
+		It("should continue from after the last occurrence that was sent", func() {
+			cursor, err := parseWatchCursor(sentCursors[0])
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(cursor.Created.Time).To(Equal(expectedOccurrences[1].CreateTime.AsTime().Truncate(time.Millisecond)))
+			Expect(cursor.Created.Names).To(ConsistOf(expectedOccurrences[1].Name))
+		})
+
+		When("the watch is resumed from the cursor", func() {
+			BeforeEach(func() {
+				expectedCursor = (&watchCursor{
+					Created: &watchPosition{Time: startTime, Names: []string{expectedOccurrences[0].Name}},
+					Updated: &watchPosition{Time: startTime},
+				}).encode()
+			})
+
+			It("should leave out the occurrences that were already sent", func() {
+				field, operator := timeRange(occurrenceSearches[0])
+				Expect(field).To(Equal(createTimeField))
+				Expect(operator.GreaterEquals).To(Equal(startTime.Format(time.RFC3339Nano)))
+
+				Expect(*occurrenceSearches[0].Query.Bool.MustNot).To(ConsistOf(&filtering.Query{
+					Terms: &filtering.Terms{
+						"name": {expectedOccurrences[0].Name},
+					},
+				}))
+			})
+		})
+
+		When("sending fails", func() {
+			BeforeEach(func() {
+				sendErr = errors.New("send failed")
+			})
+
+			It("should return the error", func() {
+				Expect(actualErr).To(MatchError(sendErr))
+			})
+		})
+	})
+
+	When("more occurrences are created with the same time", func() {
+		var first, second *pb.Occurrence
+
+		BeforeEach(func() {
+			createTime := time.Now().UTC()
+			first = occurrenceAt(createTime)
+			second = occurrenceAt(createTime)
+			occurrenceResponses = []*esutil.SearchResponse{
+				searchResponse(first),
+				searchResponse(),
+				searchResponse(second),
+				searchResponse(),
+			}
+			batchesBeforeCancel = 2
+		})
+
+		It("should send each of them once", func() {
+			Expect(actualErr).ToNot(HaveOccurred())
+			Expect(sentBatches).To(HaveLen(2))
+			Expect(sentBatches[0][0].Name).To(Equal(first.Name))
+			Expect(sentBatches[1][0].Name).To(Equal(second.Name))
+
+			cursor, err := parseWatchCursor(sentCursors[1])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cursor.Created.Names).To(ConsistOf(first.Name, second.Name))
+		})
+	})
+
+	When("a filter is given", func() {
+		var (
+			expectedQuery      *filtering.Query
+			expectedParseError error
+		)
+
+		BeforeEach(func() {
+			expectedFilter = `kind == "VULNERABILITY"`
+			expectedQuery = &filtering.Query{
+				Term: &filtering.Term{
+					"kind": "VULNERABILITY",
+				},
+			}
+			expectedParseError = nil
+			occurrenceResponses = []*esutil.SearchResponse{
+				searchResponse(occurrenceAt(time.Now())),
+			}
+
+			filterer.EXPECT().ParseExpression(expectedFilter, gomock.Any()).DoAndReturn(func(string, ...filtering.Parent) (*filtering.Query, error) {
+				if expectedParseError != nil {
+					return nil, expectedParseError
+				}
+
+				return expectedQuery, nil
+			})
+		})
+
+		It("should only search for matching occurrences", func() {
+			Expect(*occurrenceSearches[0].Query.Bool.Filter).To(ContainElement(expectedQuery))
+		})
+
+		When("the filter is invalid", func() {
+			BeforeEach(func() {
+				expectedParseError = &filtering.FilterError{Message: fake.LetterN(10)}
+			})
+
+			It("should return an InvalidArgument error", func() {
+				assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
+				Expect(occurrenceSearches).To(BeEmpty())
+			})
+		})
+	})
+
+	When("the cursor is invalid", func() {
+		BeforeEach(func() {
+			expectedCursor = base64.URLEncoding.EncodeToString([]byte(fake.LetterN(10)))
+		})
+
+		It("should return an InvalidArgument error", func() {
+			assertErrorHasGrpcStatusCode(actualErr, codes.InvalidArgument)
+		})
+	})
+
+	When("the project does not exist", func() {
+		BeforeEach(func() {
+			client.SearchReturns(searchResponse(), nil)
+		})
+
+		It("should return a FailedPrecondition error", func() {
+			assertErrorHasGrpcStatusCode(actualErr, codes.FailedPrecondition)
+		})
+	})
+
+	When("searching fails", func() {
+		BeforeEach(func() {
+			client.SearchCalls(func(_ context.Context, request *esutil.SearchRequest) (*esutil.SearchResponse, error) {
+				if request.Index != expectedOccurrencesAlias {
+					return searchResponse(generateTestProject(expectedProjectId)), nil
+				}
+
+				return nil, errors.New("search failed")
+			})
+		})
+
+		It("should return an Internal error", func() {
+			assertErrorHasGrpcStatusCode(actualErr, codes.Internal)
+		})
+	})
+})
@@ -0,0 +1,89 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	watchpb "github.com/rode/grafeas-elasticsearch/proto/v1beta1/watch_go_proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//go:generate counterfeiter -generate
+
+//counterfeiter:generate . Watcher
+
+// Watcher sends occurrences as they're created or updated, which is implemented by storage.ElasticsearchStorage
+type Watcher interface {
+	WatchOccurrences(ctx context.Context, projectId, filter, cursor string, interval time.Duration, send func(occurrences []*pb.Occurrence, cursor string) error) error
+}
+
+// Server implements the Watch service defined in proto/v1beta1/watch.proto, streaming new and updated occurrences to clients.
+// Clients can use the generated watch_go_proto.WatchClient.
+type Server struct {
+	watchpb.UnimplementedWatchServer
+
+	logger   *zap.Logger
+	watcher  Watcher
+	interval time.Duration
+}
+
+// NewServer creates a server that checks for changes to occurrences every interval
+func NewServer(logger *zap.Logger, watcher Watcher, interval time.Duration) *Server {
+	return &Server{
+		logger:   logger,
+		watcher:  watcher,
+		interval: interval,
+	}
+}
+
+// Register adds the watch service to a gRPC server
+func (s *Server) Register(server *grpc.Server) {
+	watchpb.RegisterWatchServer(server, s)
+}
+
+// WatchOccurrences streams occurrences in the request's project until the client disconnects
+func (s *Server) WatchOccurrences(request *watchpb.WatchOccurrencesRequest, stream watchpb.Watch_WatchOccurrencesServer) error {
+	log := s.logger.Named("WatchOccurrences").With(zap.String("parent", request.Parent))
+
+	projectId, err := parseProject(request.Parent)
+	if err != nil {
+		log.Debug("invalid parent")
+		return err
+	}
+
+	log.Debug("starting watch")
+	return s.watcher.WatchOccurrences(stream.Context(), projectId, request.Filter, request.Cursor, s.interval, func(occurrences []*pb.Occurrence, cursor string) error {
+		return stream.Send(&watchpb.WatchOccurrencesResponse{
+			Occurrences: occurrences,
+			Cursor:      cursor,
+		})
+	})
+}
+
+func parseProject(parent string) (string, error) {
+	projectId := strings.TrimPrefix(parent, "projects/")
+	if projectId == parent || projectId == "" || strings.Contains(projectId, "/") {
+		return "", status.Errorf(codes.InvalidArgument, "parent must be in the form projects/{project_id}, got %q", parent)
+	}
+
+	return projectId, nil
+}
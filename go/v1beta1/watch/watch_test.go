@@ -0,0 +1,195 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rode/grafeas-elasticsearch/go/v1beta1/watch"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/watch/watchfakes"
+	watchpb "github.com/rode/grafeas-elasticsearch/proto/v1beta1/watch_go_proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var _ = Describe("watch", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		watcher    *watchfakes.FakeWatcher
+		grpcServer *grpc.Server
+		conn       *grpc.ClientConn
+		client     watchpb.WatchClient
+
+		expectedInterval  time.Duration
+		expectedProjectId string
+		request           *watchpb.WatchOccurrencesRequest
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		watcher = &watchfakes.FakeWatcher{}
+		expectedInterval = time.Duration(fake.Number(1, 10)) * time.Second
+		expectedProjectId = fake.LetterN(10)
+		request = &watchpb.WatchOccurrencesRequest{
+			Parent: fmt.Sprintf("projects/%s", expectedProjectId),
+			Filter: fake.LetterN(10),
+			Cursor: fake.LetterN(10),
+		}
+
+		listener := bufconn.Listen(1024 * 1024)
+		grpcServer = grpc.NewServer()
+		NewServer(logger, watcher, expectedInterval).Register(grpcServer)
+		go grpcServer.Serve(listener)
+
+		var err error
+		conn, err = grpc.DialContext(ctx, "bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		client = watchpb.NewWatchClient(conn)
+	})
+
+	AfterEach(func() {
+		cancel()
+		conn.Close()
+		grpcServer.Stop()
+	})
+
+	// receiveAll reads from the stream until it ends, returning the responses and the error that ended it
+	receiveAll := func() ([]*watchpb.WatchOccurrencesResponse, error) {
+		stream, err := client.WatchOccurrences(ctx, request)
+		Expect(err).ToNot(HaveOccurred())
+
+		var responses []*watchpb.WatchOccurrencesResponse
+		for {
+			response, err := stream.Recv()
+			if err != nil {
+				return responses, err
+			}
+
+			responses = append(responses, response)
+		}
+	}
+
+	It("should stream each batch of occurrences with its cursor", func() {
+		batches := [][]*pb.Occurrence{
+			{{Name: fake.LetterN(10)}, {Name: fake.LetterN(10)}},
+			{{Name: fake.LetterN(10)}},
+		}
+		watcher.WatchOccurrencesCalls(func(_ context.Context, _, _, _ string, _ time.Duration, send func([]*pb.Occurrence, string) error) error {
+			for i, batch := range batches {
+				if err := send(batch, fmt.Sprintf("cursor-%d", i)); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		responses, err := receiveAll()
+
+		Expect(err).To(Equal(io.EOF))
+		Expect(responses).To(HaveLen(2))
+		for i, response := range responses {
+			Expect(response.Cursor).To(Equal(fmt.Sprintf("cursor-%d", i)))
+			Expect(response.Occurrences).To(HaveLen(len(batches[i])))
+			for j, occurrence := range response.Occurrences {
+				Expect(proto.Equal(occurrence, batches[i][j])).To(BeTrue())
+			}
+		}
+	})
+
+	It("should watch the requested project", func() {
+		_, err := receiveAll()
+		Expect(err).To(Equal(io.EOF))
+
+		Expect(watcher.WatchOccurrencesCallCount()).To(Equal(1))
+		_, projectId, filter, cursor, interval, _ := watcher.WatchOccurrencesArgsForCall(0)
+		Expect(projectId).To(Equal(expectedProjectId))
+		Expect(filter).To(Equal(request.Filter))
+		Expect(cursor).To(Equal(request.Cursor))
+		Expect(interval).To(Equal(expectedInterval))
+	})
+
+	It("should stop watching when the client disconnects", func() {
+		watched := make(chan error, 1)
+		watcher.WatchOccurrencesCalls(func(ctx context.Context, _, _, _ string, _ time.Duration, send func([]*pb.Occurrence, string) error) error {
+			if err := send([]*pb.Occurrence{{Name: fake.LetterN(10)}}, fake.LetterN(10)); err != nil {
+				return err
+			}
+
+			<-ctx.Done()
+			watched <- ctx.Err()
+			return nil
+		})
+
+		streamCtx, streamCancel := context.WithCancel(ctx)
+		stream, err := client.WatchOccurrences(streamCtx, request)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = stream.Recv()
+		Expect(err).ToNot(HaveOccurred())
+
+		streamCancel()
+
+		Eventually(watched).Should(Receive(Equal(context.Canceled)))
+	})
+
+	When("the watch fails", func() {
+		It("should return the error to the client", func() {
+			watcher.WatchOccurrencesReturns(status.Error(codes.FailedPrecondition, fake.LetterN(10)))
+
+			_, err := receiveAll()
+
+			Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+		})
+	})
+
+	When("the parent isn't a project", func() {
+		It("should return an InvalidArgument error", func() {
+			for _, parent := range []string{"", expectedProjectId, "projects/", fmt.Sprintf("projects/%s/notes/%s", expectedProjectId, fake.LetterN(10))} {
+				request.Parent = parent
+
+				_, err := receiveAll()
+
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			}
+			Expect(watcher.WatchOccurrencesCallCount()).To(Equal(0))
+		})
+	})
+
+	When("the watch fails for another reason", func() {
+		It("should not send a response", func() {
+			watcher.WatchOccurrencesReturns(errors.New("watch failed"))
+
+			responses, err := receiveAll()
+
+			Expect(err).To(HaveOccurred())
+			Expect(responses).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,124 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package watchfakes
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/rode/grafeas-elasticsearch/go/v1beta1/watch"
+)
+
+type FakeWatcher struct {
+	WatchOccurrencesStub        func(context.Context, string, string, string, time.Duration, func(occurrences []*grafeas_go_proto.Occurrence, cursor string) error) error
+	watchOccurrencesMutex       sync.RWMutex
+	watchOccurrencesArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+		arg4 string
+		arg5 time.Duration
+		arg6 func(occurrences []*grafeas_go_proto.Occurrence, cursor string) error
+	}
+	watchOccurrencesReturns struct {
+		result1 error
+	}
+	watchOccurrencesReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeWatcher) WatchOccurrences(arg1 context.Context, arg2 string, arg3 string, arg4 string, arg5 time.Duration, arg6 func(occurrences []*grafeas_go_proto.Occurrence, cursor string) error) error {
+	fake.watchOccurrencesMutex.Lock()
+	ret, specificReturn := fake.watchOccurrencesReturnsOnCall[len(fake.watchOccurrencesArgsForCall)]
+	fake.watchOccurrencesArgsForCall = append(fake.watchOccurrencesArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+		arg4 string
+		arg5 time.Duration
+		arg6 func(occurrences []*grafeas_go_proto.Occurrence, cursor string) error
+	}{arg1, arg2, arg3, arg4, arg5, arg6})
+	stub := fake.WatchOccurrencesStub
+	fakeReturns := fake.watchOccurrencesReturns
+	fake.recordInvocation("WatchOccurrences", []interface{}{arg1, arg2, arg3, arg4, arg5, arg6})
+	fake.watchOccurrencesMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4, arg5, arg6)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeWatcher) WatchOccurrencesCallCount() int {
+	fake.watchOccurrencesMutex.RLock()
+	defer fake.watchOccurrencesMutex.RUnlock()
+	return len(fake.watchOccurrencesArgsForCall)
+}
+
+func (fake *FakeWatcher) WatchOccurrencesCalls(stub func(context.Context, string, string, string, time.Duration, func(occurrences []*grafeas_go_proto.Occurrence, cursor string) error) error) {
+	fake.watchOccurrencesMutex.Lock()
+	defer fake.watchOccurrencesMutex.Unlock()
+	fake.WatchOccurrencesStub = stub
+}
+
+func (fake *FakeWatcher) WatchOccurrencesArgsForCall(i int) (context.Context, string, string, string, time.Duration, func(occurrences []*grafeas_go_proto.Occurrence, cursor string) error) {
+	fake.watchOccurrencesMutex.RLock()
+	defer fake.watchOccurrencesMutex.RUnlock()
+	argsForCall := fake.watchOccurrencesArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4, argsForCall.arg5, argsForCall.arg6
+}
+
+func (fake *FakeWatcher) WatchOccurrencesReturns(result1 error) {
+	fake.watchOccurrencesMutex.Lock()
+	defer fake.watchOccurrencesMutex.Unlock()
+	fake.WatchOccurrencesStub = nil
+	fake.watchOccurrencesReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeWatcher) WatchOccurrencesReturnsOnCall(i int, result1 error) {
+	fake.watchOccurrencesMutex.Lock()
+	defer fake.watchOccurrencesMutex.Unlock()
+	fake.WatchOccurrencesStub = nil
+	if fake.watchOccurrencesReturnsOnCall == nil {
+		fake.watchOccurrencesReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.watchOccurrencesReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeWatcher) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.watchOccurrencesMutex.RLock()
+	defer fake.watchOccurrencesMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeWatcher) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ watch.Watcher = new(FakeWatcher)
@@ -0,0 +1,132 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package watch_go_proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// WatchClient is the client API for Watch service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WatchClient interface {
+	// Streams batches of new and updated occurrences in a project until the client
+	// disconnects.
+	WatchOccurrences(ctx context.Context, in *WatchOccurrencesRequest, opts ...grpc.CallOption) (Watch_WatchOccurrencesClient, error)
+}
+
+type watchClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWatchClient(cc grpc.ClientConnInterface) WatchClient {
+	return &watchClient{cc}
+}
+
+func (c *watchClient) WatchOccurrences(ctx context.Context, in *WatchOccurrencesRequest, opts ...grpc.CallOption) (Watch_WatchOccurrencesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Watch_ServiceDesc.Streams[0], "/grafeas_elasticsearch.v1beta1.Watch/WatchOccurrences", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &watchWatchOccurrencesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Watch_WatchOccurrencesClient interface {
+	Recv() (*WatchOccurrencesResponse, error)
+	grpc.ClientStream
+}
+
+type watchWatchOccurrencesClient struct {
+	grpc.ClientStream
+}
+
+func (x *watchWatchOccurrencesClient) Recv() (*WatchOccurrencesResponse, error) {
+	m := new(WatchOccurrencesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WatchServer is the server API for Watch service.
+// All implementations must embed UnimplementedWatchServer
+// for forward compatibility
+type WatchServer interface {
+	// Streams batches of new and updated occurrences in a project until the client
+	// disconnects.
+	WatchOccurrences(*WatchOccurrencesRequest, Watch_WatchOccurrencesServer) error
+	mustEmbedUnimplementedWatchServer()
+}
+
+// UnimplementedWatchServer must be embedded to have forward compatible implementations.
+type UnimplementedWatchServer struct {
+}
+
+func (UnimplementedWatchServer) WatchOccurrences(*WatchOccurrencesRequest, Watch_WatchOccurrencesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchOccurrences not implemented")
+}
+func (UnimplementedWatchServer) mustEmbedUnimplementedWatchServer() {}
+
+// UnsafeWatchServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WatchServer will
+// result in compilation errors.
+type UnsafeWatchServer interface {
+	mustEmbedUnimplementedWatchServer()
+}
+
+func RegisterWatchServer(s grpc.ServiceRegistrar, srv WatchServer) {
+	s.RegisterService(&Watch_ServiceDesc, srv)
+}
+
+func _Watch_WatchOccurrences_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchOccurrencesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WatchServer).WatchOccurrences(m, &watchWatchOccurrencesServer{stream})
+}
+
+type Watch_WatchOccurrencesServer interface {
+	Send(*WatchOccurrencesResponse) error
+	grpc.ServerStream
+}
+
+type watchWatchOccurrencesServer struct {
+	grpc.ServerStream
+}
+
+func (x *watchWatchOccurrencesServer) Send(m *WatchOccurrencesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Watch_ServiceDesc is the grpc.ServiceDesc for Watch service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Watch_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grafeas_elasticsearch.v1beta1.Watch",
+	HandlerType: (*WatchServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchOccurrences",
+			Handler:       _Watch_WatchOccurrences_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/v1beta1/watch.proto",
+}
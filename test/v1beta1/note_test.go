@@ -151,6 +151,7 @@ func TestNote(t *testing.T) {
 		// ensure notes have something in common to filter against
 		buildNote.ShortDescription = vulnerabilityNote.ShortDescription
 		vulnerabilityNote.LongDescription = attestationNote.LongDescription
+		secondVulnerabilityNote.LongDescription = "Remote code execution in log4j through JNDI lookups"
 
 		// create
 		batch, err := s.Gc.BatchCreateNotes(s.Ctx, &grafeas_go_proto.BatchCreateNotesRequest{
@@ -230,6 +231,13 @@ func TestNote(t *testing.T) {
 						secondVulnerabilityNote,
 					},
 				},
+				{
+					name:   "full-text search",
+					filter: `search("log4j remote code")`,
+					expected: []*grafeas_go_proto.Note{
+						secondVulnerabilityNote,
+					},
+				},
 			} {
 				// ensure parallel tests are run with correct test case
 				tc := tc